@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/etkecc/postmoogle/internal/config"
+	"github.com/etkecc/postmoogle/internal/dnscheck"
+	"github.com/etkecc/postmoogle/internal/email"
+)
+
+// cliDNSCheck is the name of the CLI subcommand, e.g. `postmoogle dns:check example.com`
+const cliDNSCheck = "dns:check"
+
+// runDNSCheck checks DNS records of the domains (POSTMOOGLE_DOMAINS by default) and exits,
+// DKIM record is checked against the POSTMOOGLE_DKIM_SIGNATURE, if set. Per-domain and rotated keys are stored
+// in the account data, so a missing or different DKIM record is a warning only
+func runDNSCheck(cfg *config.Config, args []string) {
+	if len(cfg.Domains) == 0 {
+		fmt.Fprintln(os.Stderr, "POSTMOOGLE_DOMAINS is not set")
+		os.Exit(1)
+	}
+	domains := cfg.Domains
+	if len(args) > 0 {
+		domains = make([]string, 0, len(args))
+		for _, domain := range args {
+			domains = append(domains, strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), ".")))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	checker := &dnscheck.Checker{
+		Host: cfg.Domains[0],
+		DKIM: func(_ string) []dnscheck.DKIMRecord {
+			if cfg.DKIM.Signature == "" {
+				return nil
+			}
+			return []dnscheck.DKIMRecord{{Selector: email.DKIMDefaultSelector, Record: cfg.DKIM.Signature, Partial: true}}
+		},
+	}
+	results := checker.Check(ctx, domains)
+	fmt.Print(dnscheck.Text(results))
+	if dnscheck.Failed(results) {
+		os.Exit(1)
+	}
+}
@@ -18,10 +18,14 @@ import (
 	"github.com/rs/zerolog"
 	_ "modernc.org/sqlite"
 
+	"github.com/etkecc/postmoogle/internal/api"
 	"github.com/etkecc/postmoogle/internal/bot"
 	mxconfig "github.com/etkecc/postmoogle/internal/bot/config"
 	"github.com/etkecc/postmoogle/internal/bot/queue"
 	"github.com/etkecc/postmoogle/internal/config"
+	"github.com/etkecc/postmoogle/internal/health"
+	"github.com/etkecc/postmoogle/internal/imap"
+	"github.com/etkecc/postmoogle/internal/metrics"
 	"github.com/etkecc/postmoogle/internal/smtp"
 	"github.com/etkecc/postmoogle/internal/utils"
 )
@@ -33,13 +37,29 @@ var (
 	mxb   *bot.Bot
 	cron  *crontab.Crontab
 	smtpm *smtp.Manager
+	srv   *api.Server
+	imapd *imap.Server
+	store *imap.Store
 	log   zerolog.Logger
 )
 
+//	@title						Postmoogle API
+//	@version					1.0
+//	@description				REST API of Postmoogle, enabled with POSTMOOGLE_HTTP_ADDR. Tokens are generated with !pm api:token (one mailbox) and !pm api:admin (all mailboxes and the queue) commands
+//	@BasePath					/api/v1
+//	@securityDefinitions.apikey	BearerAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer token, e.g. "Bearer pm_..."
+
 func main() {
 	quit := make(chan struct{})
 
 	cfg := config.New()
+	if len(os.Args) > 1 && os.Args[1] == cliDNSCheck {
+		runDNSCheck(cfg, os.Args[2:])
+		return
+	}
 	initLog(cfg)
 	utils.SetDomains(cfg.Domains)
 
@@ -52,11 +72,17 @@ func main() {
 	initHealthchecks(cfg)
 	initMatrix(cfg)
 	initSMTP(cfg)
+	mxb.SetDomainsUpdater(smtpm.SetDomains)
+	mxb.SetAntivirus(smtpm.CheckFiles)
+	initHTTP(cfg)
+	initIMAP(cfg)
 	initCron()
 	initShutdown(quit)
 	defer recovery()
 
 	go startBot(cfg.StatusMsg)
+	go startHTTP()
+	go startIMAP()
 
 	if err := smtpm.Start(); err != nil {
 		//nolint:gocritic
@@ -117,33 +143,90 @@ func initMatrix(cfg *config.Config) {
 		log.Fatal().Err(err).Msg("cannot initialize matrix bot")
 	}
 
-	mxc = mxconfig.New(lp, &log, cfg.DKIM.PrivKey, cfg.DKIM.Signature)
+	mxc, err = mxconfig.New(lp, &log, cfg.DB.Dialect, cfg.DKIM.PrivKey, cfg.DKIM.Signature)
+	if err != nil {
+		log.Fatal().Err(err).Msg("cannot initialize config manager")
+	}
 	q = queue.New(lp, mxc, &log)
 	mxb, err = bot.New(q, lp, &log, mxc, cfg.Proxies, cfg.Prefix, cfg.Domains, cfg.Admins, bot.MBXConfig(cfg.Mailboxes))
 	if err != nil {
 		log.Panic().Err(err).Msg("cannot start matrix bot")
 	}
 	log.Debug().Msg("bot has been created")
+
+	if cfg.IMAP.Addr != "" || cfg.IMAP.TLSAddr != "" {
+		store, err = imap.NewStore(db, cfg.DB.Dialect)
+		if err != nil {
+			log.Fatal().Err(err).Msg("cannot initialize IMAP store")
+		}
+		mxb.SetArchive(store, cfg.IMAP.Retention)
+	}
 }
 
 func initSMTP(cfg *config.Config) {
 	smtpm = smtp.NewManager(&smtp.Config{
-		Domains:     cfg.Domains,
-		Port:        cfg.Port,
-		TLSCerts:    cfg.TLS.Certs,
-		TLSKeys:     cfg.TLS.Keys,
-		TLSPort:     cfg.TLS.Port,
-		TLSRequired: cfg.TLS.Required,
-		Logger:      &log,
-		MaxSize:     cfg.MaxSize,
-		Bot:         mxb,
-		Callers:     []smtp.Caller{mxb, q},
+		Domains:       cfg.Domains,
+		Port:          cfg.Port,
+		ProxyProtocol: cfg.ProxyProtocol,
+		LMTPAddr:      cfg.LMTP.Addr,
+		TLSCerts:      cfg.TLS.Certs,
+		TLSKeys:       cfg.TLS.Keys,
+		TLSDir:        cfg.TLS.Dir,
+		TLSPort:       cfg.TLS.Port,
+		TLSRequired:   cfg.TLS.Required,
+		ACME: &smtp.ACMEConfig{
+			Enabled:      cfg.TLS.ACME.Enabled,
+			Email:        cfg.TLS.ACME.Email,
+			DirectoryURL: cfg.TLS.ACME.Directory,
+			Cache:        cfg.TLS.ACME.Cache,
+			HTTPPort:     cfg.TLS.ACME.HTTPPort,
+			TLSPort:      cfg.TLS.ACME.TLSPort,
+		},
+		Logger:  &log,
+		MaxSize: cfg.MaxSize,
+		Bot:     mxb,
+		Callers: []smtp.Caller{mxb, q},
 		Relay: &smtp.RelayConfig{
 			Host:     cfg.Relay.Host,
 			Port:     cfg.Relay.Port,
 			Username: cfg.Relay.Username,
 			Password: cfg.Relay.Password,
 		},
+		Scanner: &smtp.ScannerConfig{
+			Type:     cfg.Scanner.Type,
+			Addr:     cfg.Scanner.Addr,
+			Password: cfg.Scanner.Password,
+			User:     cfg.Scanner.User,
+			Timeout:  time.Duration(cfg.Scanner.Timeout) * time.Second,
+		},
+		ClamAV: smtp.NewClamAV(cfg.ClamAV.Addr, time.Duration(cfg.ClamAV.Timeout)*time.Second),
+	})
+}
+
+func initHTTP(cfg *config.Config) {
+	if cfg.HTTP.Addr == "" {
+		return
+	}
+	srv = api.New(cfg.HTTP.Addr, mxb, &log)
+	srv.Handle("GET /metrics", metrics.Handler())
+
+	checker := health.New(smtpm, mxb, &log)
+	srv.Handle("GET /healthz", checker.Liveness())
+	srv.Handle("GET /readyz", checker.Readiness())
+}
+
+func initIMAP(cfg *config.Config) {
+	if store == nil {
+		return
+	}
+	imapd = imap.New(&imap.Config{
+		Addr:        cfg.IMAP.Addr,
+		TLSAddr:     cfg.IMAP.TLSAddr,
+		TLSConfig:   smtpm.TLSConfig(),
+		TLSRequired: cfg.TLS.Required,
+		Store:       store,
+		Bot:         mxb,
+		Logger:      &log,
 	})
 }
 
@@ -153,6 +236,11 @@ func initCron() {
 	}))
 
 	cron.MustAddJob("* * * * *", q.Process)
+	cron.MustAddJob("*/10 * * * *", mxb.PruneGreylist)
+	cron.MustAddJob("*/10 * * * *", mxb.PruneBanlist)
+	cron.MustAddJob("0 * * * *", mxb.PruneQuarantine)
+	cron.MustAddJob("15 * * * *", mxb.PruneArchive)
+	cron.MustAddJob("30 * * * *", mxb.RotateDKIM)
 	cron.MustAddJob("*/5 * * * *", mxb.SyncRooms)
 }
 
@@ -177,6 +265,24 @@ func startBot(statusMsg string) {
 	}
 }
 
+func startHTTP() {
+	if srv == nil {
+		return
+	}
+	if err := srv.Start(); err != nil {
+		log.Error().Err(err).Msg("HTTP server crashed")
+	}
+}
+
+func startIMAP() {
+	if imapd == nil {
+		return
+	}
+	if err := imapd.Start(); err != nil {
+		log.Error().Err(err).Msg("IMAP server crashed")
+	}
+}
+
 func shutdown() {
 	log.Info().Msg("Shutting down...")
 	cronCtx, cronCancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -184,6 +290,12 @@ func shutdown() {
 		log.Warn().Err(err).Msg("cron shutdown did not drain cleanly")
 	}
 	cronCancel()
+	if srv != nil {
+		srv.Stop()
+	}
+	if imapd != nil {
+		imapd.Stop()
+	}
 	smtpm.Stop()
 	mxb.Stop()
 	if hc != nil {
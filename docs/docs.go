@@ -14,17 +14,492 @@ const docTemplate = `{
     },
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
-    "paths": {}
+    "paths": {
+        "/mailboxes": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Admin tokens get all mailboxes, mailbox tokens get their own mailbox only",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "mailboxes"
+                ],
+                "summary": "List mailboxes",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.Mailbox"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/mailboxes/{mailbox}/options": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Options of the mailbox room, the same as the matrix commands show (SMTP password is never returned)",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "mailboxes"
+                ],
+                "summary": "Get mailbox options",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Mailbox name or email address",
+                        "name": "mailbox",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Changes the given options only, \"reset\" or empty value unsets the option.\nMailbox, aliases, domain, owner, password, relay and sieve can be changed with the matrix commands only",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "mailboxes"
+                ],
+                "summary": "Change mailbox options",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Mailbox name or email address",
+                        "name": "mailbox",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Options to change",
+                        "name": "options",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/mailboxes/{mailbox}/send": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Sends an email from the mailbox to each recipient, signed with the DKIM keys of the mailbox domain.\nEmails failed with a temporary error are queued and retried later. The email is posted to the mailbox room as well, replies to it are threaded as usual",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "mailboxes"
+                ],
+                "summary": "Send email",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Mailbox name or email address",
+                        "name": "mailbox",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Email to send",
+                        "name": "email",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.SendRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.SendResult"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/queue": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Emails failed with a temporary error (e.g. greylisting) that are waiting for re-delivery",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "queue"
+                ],
+                "summary": "List queue",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.QueueItem"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/queue/process": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Starts re-delivery of the queued emails right away, instead of waiting for the next scheduled run",
+                "tags": [
+                    "queue"
+                ],
+                "summary": "Process queue",
+                "responses": {
+                    "202": {
+                        "description": "Accepted"
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/queue/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Removes the email from the queue, it will not be delivered",
+                "tags": [
+                    "queue"
+                ],
+                "summary": "Remove email from queue",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Queue item ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "api.ErrorResponse": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string",
+                    "example": "not found"
+                }
+            }
+        },
+        "api.Mailbox": {
+            "type": "object",
+            "properties": {
+                "aliases": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "help"
+                    ]
+                },
+                "emails": {
+                    "description": "Emails of the mailbox (all domains it's available on)",
+                    "type": "string",
+                    "example": "support@example.com, support@example.org"
+                },
+                "mailbox": {
+                    "description": "Mailbox name, name@domain for mailboxes claimed on one domain only",
+                    "type": "string",
+                    "example": "support"
+                },
+                "owner": {
+                    "type": "string",
+                    "example": "@admin:example.com"
+                },
+                "room_id": {
+                    "type": "string",
+                    "example": "!room:example.com"
+                }
+            }
+        },
+        "api.QueueItem": {
+            "type": "object",
+            "properties": {
+                "attempts": {
+                    "type": "integer",
+                    "example": 3
+                },
+                "from": {
+                    "type": "string",
+                    "example": "support@example.com"
+                },
+                "id": {
+                    "type": "string",
+                    "example": "$event:example.com"
+                },
+                "to": {
+                    "type": "string",
+                    "example": "someone@example.net"
+                }
+            }
+        },
+        "api.SendRequest": {
+            "type": "object",
+            "properties": {
+                "html": {
+                    "description": "HTML body, rendered from the text if empty (unless nohtml option of the mailbox is set)",
+                    "type": "string"
+                },
+                "subject": {
+                    "type": "string",
+                    "example": "Hello"
+                },
+                "text": {
+                    "description": "Text body (markdown), the mailbox signature is appended",
+                    "type": "string",
+                    "example": "Hello, **world**"
+                },
+                "to": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "someone@example.net"
+                    ]
+                }
+            }
+        },
+        "api.SendResult": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "status": {
+                    "description": "Status is one of sent, queued (will be retried), failed",
+                    "type": "string",
+                    "enum": [
+                        "sent",
+                        "queued",
+                        "failed"
+                    ],
+                    "example": "sent"
+                },
+                "to": {
+                    "type": "string",
+                    "example": "someone@example.net"
+                }
+            }
+        }
+    },
+    "securityDefinitions": {
+        "BearerAuth": {
+            "description": "Bearer token, e.g. \"Bearer pm_...\"",
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
+        }
+    }
 }`
 
 // SwaggerInfo holds exported Swagger Info so clients can modify it
 var SwaggerInfo = &swag.Spec{
-	Version:          "",
+	Version:          "1.0",
 	Host:             "",
-	BasePath:         "",
+	BasePath:         "/api/v1",
 	Schemes:          []string{},
-	Title:            "",
-	Description:      "",
+	Title:            "Postmoogle API",
+	Description:      "REST API of Postmoogle, enabled with POSTMOOGLE_HTTP_ADDR. Tokens are generated with !pm api:token (one mailbox) and !pm api:admin (all mailboxes and the queue) commands",
 	InfoInstanceName: "swagger",
 	SwaggerTemplate:  docTemplate,
 	LeftDelim:        "{{",
@@ -0,0 +1,332 @@
+// Package api is the HTTP server of postmoogle: REST API authenticated with bearer tokens
+// (scoped per mailbox or admin), see docs/swagger.yaml
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/etkecc/postmoogle/docs"
+)
+
+// maxBodySize is the max size of a request body, e.g. an email to send
+const maxBodySize = 10 << 20
+
+type matrixbot interface {
+	AuthToken(ctx context.Context, token string) (Scope, bool)
+	MailboxRoom(mailbox string) (id.RoomID, bool)
+	Mailboxes(ctx context.Context) []Mailbox
+	RoomOptions(ctx context.Context, roomID id.RoomID) (map[string]string, error)
+	SetRoomOptions(ctx context.Context, roomID id.RoomID, options map[string]string) (map[string]string, error)
+	SendFromRoom(ctx context.Context, roomID id.RoomID, req *SendRequest) ([]SendResult, error)
+	QueueItems(ctx context.Context) ([]QueueItem, error)
+	CancelQueueItem(ctx context.Context, itemID string) (bool, error)
+	ProcessQueue()
+}
+
+// Server is the HTTP server
+type Server struct {
+	bot matrixbot
+	log *zerolog.Logger
+	mux *http.ServeMux
+	srv *http.Server
+}
+
+// New HTTP server, listening on the addr (host:port)
+func New(addr string, bot matrixbot, log *zerolog.Logger) *Server {
+	s := &Server{
+		bot: bot,
+		log: log,
+		mux: http.NewServeMux(),
+	}
+	s.srv = &http.Server{
+		Addr:              addr,
+		Handler:           s.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	s.mux.HandleFunc("GET /api/swagger.json", s.swagger)
+	s.mux.HandleFunc("GET /api/v1/mailboxes", s.auth(s.listMailboxes))
+	s.mux.HandleFunc("GET /api/v1/mailboxes/{mailbox}/options", s.auth(s.mailbox(s.getOptions)))
+	s.mux.HandleFunc("PATCH /api/v1/mailboxes/{mailbox}/options", s.auth(s.mailbox(s.setOptions)))
+	s.mux.HandleFunc("POST /api/v1/mailboxes/{mailbox}/send", s.auth(s.mailbox(s.send)))
+	s.mux.HandleFunc("GET /api/v1/queue", s.auth(s.admin(s.listQueue)))
+	s.mux.HandleFunc("POST /api/v1/queue/process", s.auth(s.admin(s.processQueue)))
+	s.mux.HandleFunc("DELETE /api/v1/queue/{id}", s.auth(s.admin(s.cancelQueueItem)))
+
+	return s
+}
+
+// Handle registers an additional handler, e.g. metrics
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Start the HTTP server, blocking
+func (s *Server) Start() error {
+	s.log.Info().Str("addr", s.srv.Addr).Msg("starting HTTP server...")
+	if err := s.srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Stop the HTTP server
+func (s *Server) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.srv.Shutdown(ctx); err != nil {
+		s.log.Warn().Err(err).Msg("cannot shutdown HTTP server gracefully")
+	}
+}
+
+type (
+	scopedHandler  func(w http.ResponseWriter, r *http.Request, scope Scope)
+	mailboxHandler func(w http.ResponseWriter, r *http.Request, roomID id.RoomID)
+)
+
+// auth checks the bearer token
+func (s *Server) auth(next scopedHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		token = strings.TrimSpace(token)
+		if !ok || token == "" {
+			s.error(w, http.StatusUnauthorized, ErrUnauthorized)
+			return
+		}
+		scope, ok := s.bot.AuthToken(r.Context(), token)
+		if !ok {
+			s.log.Info().Str("addr", r.RemoteAddr).Msg("API request with invalid token")
+			s.error(w, http.StatusUnauthorized, ErrUnauthorized)
+			return
+		}
+		next(w, r, scope)
+	}
+}
+
+// admin allows admin tokens only
+func (s *Server) admin(next http.HandlerFunc) scopedHandler {
+	return func(w http.ResponseWriter, r *http.Request, scope Scope) {
+		if !scope.Admin {
+			s.error(w, http.StatusForbidden, ErrForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// mailbox resolves the mailbox of the request path and checks the token has access to it
+func (s *Server) mailbox(next mailboxHandler) scopedHandler {
+	return func(w http.ResponseWriter, r *http.Request, scope Scope) {
+		roomID, ok := s.bot.MailboxRoom(r.PathValue("mailbox"))
+		if !ok {
+			s.error(w, http.StatusNotFound, ErrNotFound)
+			return
+		}
+		if !scope.Allowed(roomID) {
+			s.error(w, http.StatusForbidden, ErrForbidden)
+			return
+		}
+		next(w, r, roomID)
+	}
+}
+
+// swagger serves the API spec
+func (s *Server) swagger(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(docs.SwaggerInfo.ReadDoc())) //nolint:errcheck // nothing could be done anyway
+}
+
+// listMailboxes godoc
+//
+//	@Summary		List mailboxes
+//	@Description	Admin tokens get all mailboxes, mailbox tokens get their own mailbox only
+//	@Tags			mailboxes
+//	@Produce		json
+//	@Security		BearerAuth
+//	@Success		200	{array}		Mailbox
+//	@Failure		401	{object}	ErrorResponse
+//	@Router			/mailboxes [get]
+func (s *Server) listMailboxes(w http.ResponseWriter, r *http.Request, scope Scope) {
+	mailboxes := []Mailbox{}
+	for _, mailbox := range s.bot.Mailboxes(r.Context()) {
+		if scope.Allowed(mailbox.RoomID) {
+			mailboxes = append(mailboxes, mailbox)
+		}
+	}
+	s.json(w, http.StatusOK, mailboxes)
+}
+
+// getOptions godoc
+//
+//	@Summary		Get mailbox options
+//	@Description	Options of the mailbox room, the same as the matrix commands show (SMTP password is never returned)
+//	@Tags			mailboxes
+//	@Produce		json
+//	@Security		BearerAuth
+//	@Param			mailbox	path		string	true	"Mailbox name or email address"
+//	@Success		200		{object}	map[string]string
+//	@Failure		401		{object}	ErrorResponse
+//	@Failure		403		{object}	ErrorResponse
+//	@Failure		404		{object}	ErrorResponse
+//	@Router			/mailboxes/{mailbox}/options [get]
+func (s *Server) getOptions(w http.ResponseWriter, r *http.Request, roomID id.RoomID) {
+	options, err := s.bot.RoomOptions(r.Context(), roomID)
+	if err != nil {
+		s.error(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.json(w, http.StatusOK, options)
+}
+
+// setOptions godoc
+//
+//	@Summary		Change mailbox options
+//	@Description	Changes the given options only, "reset" or empty value unsets the option.
+//	@Description	Mailbox, aliases, domain, owner, password, relay and sieve can be changed with the matrix commands only
+//	@Tags			mailboxes
+//	@Accept			json
+//	@Produce		json
+//	@Security		BearerAuth
+//	@Param			mailbox	path		string				true	"Mailbox name or email address"
+//	@Param			options	body		map[string]string	true	"Options to change"
+//	@Success		200		{object}	map[string]string
+//	@Failure		400		{object}	ErrorResponse
+//	@Failure		401		{object}	ErrorResponse
+//	@Failure		403		{object}	ErrorResponse
+//	@Failure		404		{object}	ErrorResponse
+//	@Router			/mailboxes/{mailbox}/options [patch]
+func (s *Server) setOptions(w http.ResponseWriter, r *http.Request, roomID id.RoomID) {
+	var options map[string]string
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&options); err != nil {
+		s.error(w, http.StatusBadRequest, ErrInvalid)
+		return
+	}
+	updated, err := s.bot.SetRoomOptions(r.Context(), roomID, options)
+	if err != nil {
+		s.error(w, statusCode(err), err)
+		return
+	}
+	s.json(w, http.StatusOK, updated)
+}
+
+// send godoc
+//
+//	@Summary		Send email
+//	@Description	Sends an email from the mailbox to each recipient, signed with the DKIM keys of the mailbox domain.
+//	@Description	Emails failed with a temporary error are queued and retried later. The email is posted to the mailbox room as well, replies to it are threaded as usual
+//	@Tags			mailboxes
+//	@Accept			json
+//	@Produce		json
+//	@Security		BearerAuth
+//	@Param			mailbox	path		string		true	"Mailbox name or email address"
+//	@Param			email	body		SendRequest	true	"Email to send"
+//	@Success		200		{array}		SendResult
+//	@Failure		400		{object}	ErrorResponse
+//	@Failure		401		{object}	ErrorResponse
+//	@Failure		403		{object}	ErrorResponse
+//	@Failure		404		{object}	ErrorResponse
+//	@Router			/mailboxes/{mailbox}/send [post]
+func (s *Server) send(w http.ResponseWriter, r *http.Request, roomID id.RoomID) {
+	var req *SendRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&req); err != nil || req == nil {
+		s.error(w, http.StatusBadRequest, ErrInvalid)
+		return
+	}
+	results, err := s.bot.SendFromRoom(r.Context(), roomID, req)
+	if err != nil {
+		s.error(w, statusCode(err), err)
+		return
+	}
+	s.json(w, http.StatusOK, results)
+}
+
+// listQueue godoc
+//
+//	@Summary		List queue
+//	@Description	Emails failed with a temporary error (e.g. greylisting) that are waiting for re-delivery
+//	@Tags			queue
+//	@Produce		json
+//	@Security		BearerAuth
+//	@Success		200	{array}		QueueItem
+//	@Failure		401	{object}	ErrorResponse
+//	@Failure		403	{object}	ErrorResponse
+//	@Router			/queue [get]
+func (s *Server) listQueue(w http.ResponseWriter, r *http.Request) {
+	items, err := s.bot.QueueItems(r.Context())
+	if err != nil {
+		s.error(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.json(w, http.StatusOK, items)
+}
+
+// processQueue godoc
+//
+//	@Summary		Process queue
+//	@Description	Starts re-delivery of the queued emails right away, instead of waiting for the next scheduled run
+//	@Tags			queue
+//	@Security		BearerAuth
+//	@Success		202
+//	@Failure		401	{object}	ErrorResponse
+//	@Failure		403	{object}	ErrorResponse
+//	@Router			/queue/process [post]
+func (s *Server) processQueue(w http.ResponseWriter, _ *http.Request) {
+	go s.bot.ProcessQueue()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// cancelQueueItem godoc
+//
+//	@Summary		Remove email from queue
+//	@Description	Removes the email from the queue, it will not be delivered
+//	@Tags			queue
+//	@Security		BearerAuth
+//	@Param			id	path	string	true	"Queue item ID"
+//	@Success		204
+//	@Failure		401	{object}	ErrorResponse
+//	@Failure		403	{object}	ErrorResponse
+//	@Failure		404	{object}	ErrorResponse
+//	@Router			/queue/{id} [delete]
+func (s *Server) cancelQueueItem(w http.ResponseWriter, r *http.Request) {
+	found, err := s.bot.CancelQueueItem(r.Context(), r.PathValue("id"))
+	if err != nil {
+		s.error(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !found {
+		s.error(w, http.StatusNotFound, ErrNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// statusCode returns HTTP status code of the error
+func statusCode(err error) int {
+	if errors.Is(err, ErrInvalid) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func (s *Server) json(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		s.log.Error().Err(err).Msg("cannot encode API response")
+	}
+}
+
+func (s *Server) error(w http.ResponseWriter, status int, err error) {
+	if status == http.StatusInternalServerError {
+		s.log.Error().Err(err).Msg("API request failed")
+	}
+	s.json(w, status, ErrorResponse{Error: err.Error()})
+}
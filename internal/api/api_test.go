@@ -0,0 +1,255 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	adminToken   = "pm_admin"
+	supportToken = "pm_support"
+	supportRoom  = id.RoomID("!support:example.com")
+	salesRoom    = id.RoomID("!sales:example.com")
+)
+
+type fakebot struct {
+	options   map[string]string
+	sent      []*SendRequest
+	cancelled []string
+}
+
+func (b *fakebot) AuthToken(_ context.Context, token string) (Scope, bool) {
+	switch token {
+	case adminToken:
+		return Scope{Admin: true}, true
+	case supportToken:
+		return Scope{RoomID: supportRoom}, true
+	}
+	return Scope{}, false
+}
+
+func (b *fakebot) MailboxRoom(mailbox string) (id.RoomID, bool) {
+	switch mailbox {
+	case "support", "support@example.com":
+		return supportRoom, true
+	case "sales":
+		return salesRoom, true
+	}
+	return "", false
+}
+
+func (b *fakebot) Mailboxes(_ context.Context) []Mailbox {
+	return []Mailbox{
+		{Mailbox: "sales", Emails: "sales@example.com", RoomID: salesRoom},
+		{Mailbox: "support", Emails: "support@example.com", RoomID: supportRoom},
+	}
+}
+
+func (b *fakebot) RoomOptions(_ context.Context, _ id.RoomID) (map[string]string, error) {
+	return b.options, nil
+}
+
+func (b *fakebot) SetRoomOptions(_ context.Context, _ id.RoomID, options map[string]string) (map[string]string, error) {
+	for key, value := range options {
+		if key == "password" {
+			return nil, fmt.Errorf("%w: `%s` option cannot be changed with the API", ErrInvalid, key)
+		}
+		b.options[key] = value
+	}
+	return b.options, nil
+}
+
+func (b *fakebot) SendFromRoom(_ context.Context, _ id.RoomID, req *SendRequest) ([]SendResult, error) {
+	b.sent = append(b.sent, req)
+	results := []SendResult{}
+	for _, to := range req.To {
+		results = append(results, SendResult{To: to, Status: SendStatusSent})
+	}
+	return results, nil
+}
+
+func (b *fakebot) QueueItems(_ context.Context) ([]QueueItem, error) {
+	return []QueueItem{{ID: "$event", From: "support@example.com", To: "someone@example.net", Attempts: 2}}, nil
+}
+
+func (b *fakebot) CancelQueueItem(_ context.Context, itemID string) (bool, error) {
+	if itemID != "$event" {
+		return false, nil
+	}
+	b.cancelled = append(b.cancelled, itemID)
+	return true, nil
+}
+
+func (b *fakebot) ProcessQueue() {}
+
+func newTestServer(t *testing.T) (*httptest.Server, *fakebot) {
+	t.Helper()
+	log := zerolog.Nop()
+	bot := &fakebot{options: map[string]string{"nosend": "false"}}
+	ts := httptest.NewServer(New("", bot, &log).mux)
+	t.Cleanup(ts.Close)
+	return ts, bot
+}
+
+func request(t *testing.T, ts *httptest.Server, method, path, token, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequestWithContext(context.Background(), method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(data)
+}
+
+func TestAuth(t *testing.T) {
+	ts, _ := newTestServer(t)
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		status int
+	}{
+		{"no token", http.MethodGet, "/api/v1/mailboxes", "", http.StatusUnauthorized},
+		{"invalid token", http.MethodGet, "/api/v1/mailboxes", "pm_invalid", http.StatusUnauthorized},
+		{"mailbox token, own mailbox", http.MethodGet, "/api/v1/mailboxes/support/options", supportToken, http.StatusOK},
+		{"mailbox token, own mailbox address", http.MethodGet, "/api/v1/mailboxes/support@example.com/options", supportToken, http.StatusOK},
+		{"mailbox token, other mailbox", http.MethodGet, "/api/v1/mailboxes/sales/options", supportToken, http.StatusForbidden},
+		{"mailbox token, queue", http.MethodGet, "/api/v1/queue", supportToken, http.StatusForbidden},
+		{"admin token, any mailbox", http.MethodGet, "/api/v1/mailboxes/sales/options", adminToken, http.StatusOK},
+		{"admin token, unknown mailbox", http.MethodGet, "/api/v1/mailboxes/unknown/options", adminToken, http.StatusNotFound},
+		{"admin token, queue", http.MethodGet, "/api/v1/queue", adminToken, http.StatusOK},
+		{"swagger without token", http.MethodGet, "/api/swagger.json", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, body := request(t, ts, test.method, test.path, test.token, "")
+			if status != test.status {
+				t.Errorf("expected %d, got %d: %s", test.status, status, body)
+			}
+		})
+	}
+}
+
+func TestListMailboxes(t *testing.T) {
+	ts, _ := newTestServer(t)
+
+	_, body := request(t, ts, http.MethodGet, "/api/v1/mailboxes", supportToken, "")
+	var mailboxes []Mailbox
+	if err := json.Unmarshal([]byte(body), &mailboxes); err != nil {
+		t.Fatal(err)
+	}
+	if len(mailboxes) != 1 || mailboxes[0].Mailbox != "support" {
+		t.Errorf("mailbox token should list its own mailbox only, got %+v", mailboxes)
+	}
+
+	_, body = request(t, ts, http.MethodGet, "/api/v1/mailboxes", adminToken, "")
+	if err := json.Unmarshal([]byte(body), &mailboxes); err != nil {
+		t.Fatal(err)
+	}
+	if len(mailboxes) != 2 {
+		t.Errorf("admin token should list all mailboxes, got %+v", mailboxes)
+	}
+}
+
+func TestSetOptions(t *testing.T) {
+	ts, bot := newTestServer(t)
+
+	status, body := request(t, ts, http.MethodPatch, "/api/v1/mailboxes/support/options", supportToken, `{"nosend":"true"}`)
+	if status != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", status, body)
+	}
+	if bot.options["nosend"] != "true" {
+		t.Errorf("option has not been changed: %+v", bot.options)
+	}
+
+	status, body = request(t, ts, http.MethodPatch, "/api/v1/mailboxes/support/options", supportToken, `{"password":"secret"}`)
+	if status != http.StatusBadRequest || !strings.Contains(body, "cannot be changed") {
+		t.Errorf("expected 400, got %d: %s", status, body)
+	}
+
+	status, _ = request(t, ts, http.MethodPatch, "/api/v1/mailboxes/support/options", supportToken, `not json`)
+	if status != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", status)
+	}
+}
+
+func TestSend(t *testing.T) {
+	ts, bot := newTestServer(t)
+
+	status, body := request(t, ts, http.MethodPost, "/api/v1/mailboxes/support/send", supportToken,
+		`{"to":["someone@example.net"],"subject":"Hello","text":"Hello, **world**"}`)
+	if status != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", status, body)
+	}
+	var results []SendResult
+	if err := json.Unmarshal([]byte(body), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Status != SendStatusSent {
+		t.Errorf("unexpected results: %+v", results)
+	}
+	if len(bot.sent) != 1 || bot.sent[0].Subject != "Hello" {
+		t.Errorf("unexpected sent emails: %+v", bot.sent)
+	}
+}
+
+func TestQueue(t *testing.T) {
+	ts, bot := newTestServer(t)
+
+	status, body := request(t, ts, http.MethodGet, "/api/v1/queue", adminToken, "")
+	if status != http.StatusOK || !strings.Contains(body, `"attempts":2`) {
+		t.Errorf("unexpected queue: %d %s", status, body)
+	}
+	if strings.Contains(body, "data") {
+		t.Errorf("queue items should not contain email data: %s", body)
+	}
+
+	status, _ = request(t, ts, http.MethodDelete, "/api/v1/queue/$event", adminToken, "")
+	if status != http.StatusNoContent || len(bot.cancelled) != 1 {
+		t.Errorf("expected 204, got %d", status)
+	}
+	status, _ = request(t, ts, http.MethodDelete, "/api/v1/queue/unknown", adminToken, "")
+	if status != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", status)
+	}
+
+	status, _ = request(t, ts, http.MethodPost, "/api/v1/queue/process", adminToken, "")
+	if status != http.StatusAccepted {
+		t.Errorf("expected 202, got %d", status)
+	}
+}
+
+func TestSwagger(t *testing.T) {
+	ts, _ := newTestServer(t)
+
+	status, body := request(t, ts, http.MethodGet, "/api/swagger.json", "", "")
+	var spec map[string]any
+	if err := json.Unmarshal([]byte(body), &spec); err != nil {
+		t.Fatalf("invalid spec (%d): %v", status, err)
+	}
+	paths, ok := spec["paths"].(map[string]any)
+	if !ok || paths["/mailboxes/{mailbox}/send"] == nil {
+		t.Errorf("spec doesn't describe the API: %v", spec["paths"])
+	}
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"errors"
+
+	"maunium.net/go/mautrix/id"
+)
+
+var (
+	// ErrInvalid returned when the request is invalid (e.g. an option cannot be changed)
+	ErrInvalid = errors.New("invalid request")
+	// ErrUnauthorized returned when the bearer token is missing or unknown
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden returned when the token's scope doesn't allow the request
+	ErrForbidden = errors.New("forbidden")
+	// ErrNotFound returned when the mailbox or queue item doesn't exist
+	ErrNotFound = errors.New("not found")
+)
+
+// Scope of the API token
+type Scope struct {
+	// Admin tokens have access to all mailboxes and the queue
+	Admin bool
+	// RoomID of the mailbox the token is scoped to
+	RoomID id.RoomID
+}
+
+// Allowed checks if the scope has access to the mailbox room
+func (s Scope) Allowed(roomID id.RoomID) bool {
+	return s.Admin || (s.RoomID != "" && s.RoomID == roomID)
+}
+
+// Mailbox managed by postmoogle
+type Mailbox struct {
+	// Mailbox name, name@domain for mailboxes claimed on one domain only
+	Mailbox string   `json:"mailbox" example:"support"`
+	Aliases []string `json:"aliases" example:"help"`
+	// Emails of the mailbox (all domains it's available on)
+	Emails string    `json:"emails" example:"support@example.com, support@example.org"`
+	Owner  string    `json:"owner" example:"@admin:example.com"`
+	RoomID id.RoomID `json:"room_id" swaggertype:"string" example:"!room:example.com"`
+}
+
+// SendRequest is an email to send from the mailbox
+type SendRequest struct {
+	To      []string `json:"to" example:"someone@example.net"`
+	Subject string   `json:"subject" example:"Hello"`
+	// Text body (markdown), the mailbox signature is appended. Either text or html is required
+	Text string `json:"text" example:"Hello, **world**"`
+	// HTML body, rendered from the text if empty (unless nohtml option of the mailbox is set), the mailbox signature is appended
+	HTML string `json:"html,omitempty"`
+}
+
+// Send statuses
+const (
+	SendStatusSent   = "sent"
+	SendStatusQueued = "queued"
+	SendStatusFailed = "failed"
+)
+
+// SendResult of a single recipient
+type SendResult struct {
+	To string `json:"to" example:"someone@example.net"`
+	// Status is one of sent, queued (will be retried), failed
+	Status string `json:"status" enums:"sent,queued,failed" example:"sent"`
+	Error  string `json:"error,omitempty"`
+}
+
+// QueueItem is an email waiting for re-delivery
+type QueueItem struct {
+	ID       string `json:"id" example:"$event:example.com"`
+	From     string `json:"from" example:"support@example.com"`
+	To       string `json:"to" example:"someone@example.net"`
+	Attempts int    `json:"attempts" example:"3"`
+}
+
+// ErrorResponse of the API
+type ErrorResponse struct {
+	Error string `json:"error" example:"not found"`
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/raja/argon2pw"
 	"maunium.net/go/mautrix/id"
 
+	"github.com/etkecc/postmoogle/internal/bot/config"
 	"github.com/etkecc/postmoogle/internal/utils"
 )
 
@@ -96,40 +97,191 @@ func (b *Bot) allowReply(ctx context.Context, actorID id.UserID, targetRoomID id
 	return !cfg.NoReplies()
 }
 
-func (b *Bot) isReserved(mailbox string) bool {
+// isReserved checks if the mailbox is reserved on the domain (any domain if empty),
+// reserved mailboxes may be scoped to a domain as well, e.g. `postmaster@example.com`
+func (b *Bot) isReserved(mailbox, domain string) bool {
 	for _, reserved := range b.mbxc.Reserved {
-		if mailbox == reserved {
+		localpart, reservedDomain, scoped := strings.Cut(strings.ToLower(reserved), "@")
+		if localpart == mailbox && (!scoped || domain == "" || reservedDomain == domain) {
 			return true
 		}
 	}
 	return false
 }
 
-// IsGreylisted checks if host is in greylist
-func (b *Bot) IsGreylisted(ctx context.Context, addr net.Addr) bool {
-	if b.cfg.GetBot(ctx).Greylist() == 0 {
+// mailboxTaken checks if the mailbox is reserved or taken by another room on the domain (any domain if empty)
+func (b *Bot) mailboxTaken(roomID id.RoomID, mailbox, domain string) bool {
+	if b.isReserved(mailbox, domain) {
+		return true
+	}
+	var taken bool
+	b.rooms.Range(func(k, v any) bool {
+		key, _ := k.(string)          //nolint:errcheck // keys are always strings
+		keyRoomID, _ := v.(id.RoomID) //nolint:errcheck // values are always room IDs
+		if keyRoomID == roomID {
+			return true
+		}
+		localpart, keyDomain, scoped := strings.Cut(key, "@")
+		if localpart == mailbox && (!scoped || domain == "" || keyDomain == domain) {
+			taken = true
+			return false
+		}
+		return true
+	})
+	return taken
+}
+
+// greylistLock is the mutex key of greylist and its whitelist
+const greylistLock = "greylist"
+
+// IsGreylisted checks if any of the (client network, sender, recipient) triplets is greylisted.
+// Senders that retried successfully are whitelisted automatically for a few days.
+// Authenticated domains (SPF, DKIM) are used to check if the sender domain is exempt
+func (b *Bot) IsGreylisted(ctx context.Context, addr net.Addr, from string, authenticated, tos []string) bool {
+	cfg := b.cfg.GetBot(ctx)
+	if cfg.Greylist() == 0 {
+		return false
+	}
+	return b.greylist(ctx, cfg, addr, from, authenticated, tos, time.Duration(cfg.Greylist())*time.Minute)
+}
+
+// IsSpamGreylisted greylists the triplets of an email the content scanner recommends to greylist,
+// even if automatic greylisting is disabled (in that case, the default duration is used)
+func (b *Bot) IsSpamGreylisted(ctx context.Context, addr net.Addr, from string, authenticated, tos []string) bool {
+	cfg := b.cfg.GetBot(ctx)
+	duration := time.Duration(cfg.Greylist()) * time.Minute
+	if duration == 0 {
+		duration = config.GreylistSpamDefault
+	}
+	return b.greylist(ctx, cfg, addr, from, authenticated, tos, duration)
+}
+
+func (b *Bot) greylist(ctx context.Context, cfg config.Bot, addr net.Addr, from string, authenticated, tos []string, duration time.Duration) bool {
+	log := b.log.With().Str("addr", addr.String()).Str("from", from).Logger()
+	if config.GreylistExempt(addr, from, authenticated, cfg.GreylistExempt()) {
+		log.Debug().Msg("greylisting exempt")
+		return false
+	}
+
+	b.mu.Lock(greylistLock)
+	defer b.mu.Unlock(greylistLock)
+
+	now := time.Now().UTC()
+	sender := config.GreylistSender(addr, from)
+	whitelist := b.cfg.GetGreylistWhitelist(ctx)
+	if seenAt, ok := whitelist.GetTime(sender); ok && seenAt.AddDate(0, 0, cfg.GreylistWhitelist()).After(now) {
+		// update the whitelist once a day at most, to avoid account data updates on each email
+		if now.Sub(seenAt) > 24*time.Hour {
+			whitelist.SetTime(sender, now)
+			if err := b.cfg.SetGreylistWhitelist(ctx, whitelist); err != nil {
+				log.Error().Err(err).Msg("cannot update greylist whitelist")
+			}
+		}
 		return false
 	}
 
 	greylist := b.cfg.GetGreylist(ctx)
-	greylistedAt, ok := greylist.Get(addr)
-	if !ok {
-		b.log.Debug().Str("addr", addr.String()).Msg("greylisting")
-		greylist.Add(addr)
-		err := b.cfg.SetGreylist(ctx, greylist)
-		if err != nil {
-			b.log.Error().Err(err).Str("addr", addr.String()).Msg("cannot update greylist")
+	greylisted, changed := greylistTriplets(greylist, addr, from, tos, now, duration)
+	if greylisted {
+		log.Debug().Strs("to", tos).Msg("greylisting")
+	}
+	if !greylisted {
+		log.Debug().Msg("greylisting passed, whitelisting sender")
+		whitelist.SetTime(sender, now)
+		if err := b.cfg.SetGreylistWhitelist(ctx, whitelist); err != nil {
+			log.Error().Err(err).Msg("cannot update greylist whitelist")
+		}
+	}
+	if changed {
+		if err := b.cfg.SetGreylist(ctx, greylist); err != nil {
+			log.Error().Err(err).Msg("cannot update greylist")
 		}
-		return true
 	}
-	duration := time.Duration(b.cfg.GetBot(ctx).Greylist()) * time.Minute
 
-	return greylistedAt.Add(duration).After(time.Now().UTC())
+	return greylisted
 }
 
+// greylistTriplets checks the triplets against the greylist and updates it:
+// new (or stale) triplets are added, and all triplets are removed once the email passed greylisting.
+// Returns whether the email is greylisted and whether the greylist has been changed
+func greylistTriplets(greylist config.List, addr net.Addr, from string, tos []string, now time.Time, duration time.Duration) (greylisted, changed bool) {
+	triplets := make([]string, 0, len(tos))
+	for _, to := range tos {
+		triplet := config.GreylistTriplet(addr, from, to)
+		triplets = append(triplets, triplet)
+		greylistedAt, ok := greylist.GetTime(triplet)
+		if !ok || now.Sub(greylistedAt) > config.GreylistPendingTTL {
+			greylist.SetTime(triplet, now)
+			greylisted = true
+			changed = true
+			continue
+		}
+		if greylistedAt.Add(duration).After(now) {
+			greylisted = true
+		}
+	}
+	if greylisted {
+		return greylisted, changed
+	}
+
+	for _, triplet := range triplets {
+		delete(greylist, triplet)
+	}
+	return false, true
+}
+
+// PruneGreylist removes stale greylist triplets and expired whitelist entries
+func (b *Bot) PruneGreylist() {
+	ctx := context.Background()
+	b.mu.Lock(greylistLock)
+	defer b.mu.Unlock(greylistLock)
+
+	now := time.Now().UTC()
+	greylist := b.cfg.GetGreylist(ctx)
+	var removed int
+	for key := range greylist {
+		if !config.IsGreylistTriplet(key) { // IP-only entries of older versions
+			delete(greylist, key)
+			removed++
+		}
+	}
+	removed += greylist.Prune(now.Add(-config.GreylistPendingTTL))
+	if removed > 0 {
+		if err := b.cfg.SetGreylist(ctx, greylist); err != nil {
+			b.log.Error().Err(err).Msg("cannot prune greylist")
+		}
+	}
+
+	whitelist := b.cfg.GetGreylistWhitelist(ctx)
+	whitelisted := whitelist.Prune(now.AddDate(0, 0, -b.cfg.GetBot(ctx).GreylistWhitelist()))
+	if whitelisted > 0 {
+		if err := b.cfg.SetGreylistWhitelist(ctx, whitelist); err != nil {
+			b.log.Error().Err(err).Msg("cannot prune greylist whitelist")
+		}
+	}
+	b.log.Debug().Int("greylist", removed).Int("whitelist", whitelisted).Msg("greylist has been pruned")
+}
+
+// banlistLock is the mutex key of banlist and failures counter
+const banlistLock = "banlist"
+
 // IsBanned checks if address is banned
 func (b *Bot) IsBanned(ctx context.Context, addr net.Addr) bool {
-	return b.cfg.GetBanlist(ctx).Has(addr)
+	ip, ok := utils.AddrNetIP(addr)
+	if !ok {
+		return false
+	}
+	if rule, banned := b.policies.match(ip); banned {
+		b.log.Debug().Str("addr", addr.String()).Str("entity", rule.entity).Str("reason", rule.reason).Msg("address is banned by policy list")
+		return true
+	}
+	if !b.cfg.GetBot(ctx).BanlistEnabled() {
+		return false
+	}
+	now := time.Now()
+	// an expired ban must not hide an active broader one until the next prune
+	_, ok = b.getBanset(ctx).MatchFunc(ip, func(ban *config.Ban) bool { return !ban.Expired(now) })
+	return ok
 }
 
 // IsTrusted checks if address is a trusted (proxy)
@@ -147,69 +299,127 @@ func (b *Bot) IsTrusted(addr net.Addr) bool {
 
 // Ban an address automatically
 func (b *Bot) BanAuto(ctx context.Context, addr net.Addr) {
-	if !b.cfg.GetBot(ctx).BanlistEnabled() {
+	cfg := b.cfg.GetBot(ctx)
+	if !cfg.BanlistEnabled() || !cfg.BanlistAuto() {
 		return
 	}
+	b.banFailure(ctx, addr, "automatic: invalid email")
+}
 
-	if !b.cfg.GetBot(ctx).BanlistAuto() {
+// Ban an address for incorrect auth automatically
+func (b *Bot) BanAuth(ctx context.Context, addr net.Addr) {
+	cfg := b.cfg.GetBot(ctx)
+	if !cfg.BanlistEnabled() || !cfg.BanlistAuth() {
 		return
 	}
+	b.banFailure(ctx, addr, "automatic: invalid credentials or failed checks")
+}
 
+// Ban an address manually
+func (b *Bot) BanManually(ctx context.Context, addr net.Addr) {
+	if !b.cfg.GetBot(ctx).BanlistEnabled() {
+		return
+	}
 	if b.IsTrusted(addr) {
 		return
 	}
-	b.log.Debug().Str("addr", addr.String()).Msg("attempting to automatically ban")
+	b.log.Debug().Str("addr", addr.String()).Msg("attempting to manually ban")
+	b.mu.Lock(banlistLock)
+	defer b.mu.Unlock(banlistLock)
+
 	banlist := b.cfg.GetBanlist(ctx)
-	banlist.Add(addr)
-	err := b.cfg.SetBanlist(ctx, banlist)
-	if err != nil {
+	banlist.Add(utils.AddrIP(addr), 0, "manual")
+	if err := b.setBanlist(ctx, banlist); err != nil {
 		b.log.Error().Err(err).Str("addr", addr.String()).Msg("cannot update banlist")
 	}
 }
 
-// Ban an address for incorrect auth automatically
-func (b *Bot) BanAuth(ctx context.Context, addr net.Addr) {
-	if !b.cfg.GetBot(ctx).BanlistEnabled() {
+// banFailure records a failure of the address and bans it when failures threshold is reached within the time window
+func (b *Bot) banFailure(ctx context.Context, addr net.Addr, reason string) {
+	if b.IsTrusted(addr) {
 		return
 	}
+	cfg := b.cfg.GetBot(ctx)
+	ip := utils.AddrIP(addr)
+	log := b.log.With().Str("addr", ip).Logger()
 
-	if !b.cfg.GetBot(ctx).BanlistAuth() {
-		return
-	}
+	b.mu.Lock(banlistLock)
+	defer b.mu.Unlock(banlistLock)
 
-	if b.IsTrusted(addr) {
+	now := time.Now()
+	failures := []time.Time{now}
+	for _, failedAt := range b.banFailures[ip] {
+		if now.Sub(failedAt) < cfg.BanlistWindow() {
+			failures = append(failures, failedAt)
+		}
+	}
+	if len(failures) < cfg.BanlistThreshold() {
+		log.Debug().Int("failures", len(failures)).Int("threshold", cfg.BanlistThreshold()).Msg("failure recorded")
+		b.banFailures[ip] = failures
 		return
 	}
-	b.log.Debug().Str("addr", addr.String()).Msg("attempting to automatically ban")
+	delete(b.banFailures, ip)
+
+	log.Debug().Int("failures", len(failures)).Msg("attempting to automatically ban")
 	banlist := b.cfg.GetBanlist(ctx)
-	banlist.Add(addr)
+	banlist.Add(ip, cfg.BanlistDuration(), reason)
+	if err := b.setBanlist(ctx, banlist); err != nil {
+		log.Error().Err(err).Msg("cannot update banlist")
+	}
+}
+
+// setBanlist saves the banlist and resets the IP matching set, banlist lock must be held
+func (b *Bot) setBanlist(ctx context.Context, banlist config.Banlist) error {
 	err := b.cfg.SetBanlist(ctx, banlist)
-	if err != nil {
-		b.log.Error().Err(err).Str("addr", addr.String()).Msg("cannot update banlist")
+	b.banset.Store(nil)
+	return err
+}
+
+// getBanset returns active bans as a set for fast IP matching
+func (b *Bot) getBanset(ctx context.Context) *utils.PrefixSet[*config.Ban] {
+	if set := b.banset.Load(); set != nil {
+		return set
 	}
+
+	b.mu.Lock(banlistLock)
+	defer b.mu.Unlock(banlistLock)
+	set := b.cfg.GetBanlist(ctx).PrefixSet(time.Now())
+	b.banset.Store(set)
+	return set
 }
 
-// Ban an address manually
-func (b *Bot) BanManually(ctx context.Context, addr net.Addr) {
-	if !b.cfg.GetBot(ctx).BanlistEnabled() {
-		return
+// PruneBanlist removes expired bans and outdated failures
+func (b *Bot) PruneBanlist() {
+	ctx := context.Background()
+	cfg := b.cfg.GetBot(ctx)
+	b.mu.Lock(banlistLock)
+	defer b.mu.Unlock(banlistLock)
+
+	now := time.Now()
+	for ip, failures := range b.banFailures {
+		if len(failures) == 0 || now.Sub(failures[0]) >= cfg.BanlistWindow() {
+			delete(b.banFailures, ip)
+		}
 	}
-	if b.IsTrusted(addr) {
+	if !cfg.BanlistEnabled() {
 		return
 	}
-	b.log.Debug().Str("addr", addr.String()).Msg("attempting to manually ban")
+
 	banlist := b.cfg.GetBanlist(ctx)
-	banlist.Add(addr)
-	err := b.cfg.SetBanlist(ctx, banlist)
-	if err != nil {
-		b.log.Error().Err(err).Str("addr", addr.String()).Msg("cannot update banlist")
+	removed := banlist.Prune(now)
+	if removed == 0 {
+		return
+	}
+	if err := b.setBanlist(ctx, banlist); err != nil {
+		b.log.Error().Err(err).Msg("cannot prune banlist")
 	}
+	b.log.Debug().Int("removed", removed).Msg("banlist has been pruned")
 }
 
 // AllowAuth check if SMTP login (email) and password are valid
 func (b *Bot) AllowAuth(ctx context.Context, email, password string) (id.RoomID, bool) {
 	var suffix bool
-	for _, domain := range b.domains {
+	for _, domain := range utils.Domains() {
 		if strings.HasSuffix(email, "@"+domain) {
 			suffix = true
 			break
@@ -219,7 +429,7 @@ func (b *Bot) AllowAuth(ctx context.Context, email, password string) (id.RoomID,
 		return "", false
 	}
 
-	roomID, ok := b.getMapping(utils.Mailbox(email))
+	roomID, ok := b.getMapping(utils.MailboxAddress(email))
 	if !ok {
 		return "", false
 	}
@@ -0,0 +1,59 @@
+package bot
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/etkecc/postmoogle/internal/bot/config"
+)
+
+func TestGreylistTriplets(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	addr := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 25}
+	sameNetwork := &net.TCPAddr{IP: net.ParseIP("192.0.2.200"), Port: 25}
+	otherNetwork := &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 25}
+	from := "sender@example.com"
+	tos := []string{"a@example.org", "b@example.org"}
+	duration := 5 * time.Minute
+
+	tests := map[string]struct {
+		addr       net.Addr
+		tos        []string
+		seenAt     time.Time // zero = not in the greylist
+		greylisted bool
+		changed    bool
+		remaining  int
+	}{
+		"first attempt":         {addr, tos, time.Time{}, true, true, 2},
+		"retry too early":       {addr, tos, now.Add(-time.Minute), true, false, 2},
+		"retry passed":          {addr, tos, now.Add(-10 * time.Minute), false, true, 0},
+		"same network":          {sameNetwork, tos, now.Add(-10 * time.Minute), false, true, 0},
+		"other network":         {otherNetwork, tos, now.Add(-10 * time.Minute), true, true, 4},
+		"stale attempt":         {addr, tos, now.Add(-config.GreylistPendingTTL - time.Hour), true, true, 2},
+		"new recipient":         {addr, []string{"a@example.org", "c@example.org"}, now.Add(-10 * time.Minute), true, true, 3},
+		"recipient passed only": {addr, []string{"a@example.org"}, now.Add(-10 * time.Minute), false, true, 1},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			greylist := config.List{}
+			if !test.seenAt.IsZero() {
+				for _, to := range tos {
+					greylist.SetTime(config.GreylistTriplet(addr, from, to), test.seenAt)
+				}
+			}
+
+			greylisted, changed := greylistTriplets(greylist, test.addr, from, test.tos, now, duration)
+			if greylisted != test.greylisted {
+				t.Error("greylisted:", test.greylisted, "!=", greylisted)
+			}
+			if changed != test.changed {
+				t.Error("changed:", test.changed, "!=", changed)
+			}
+			if len(greylist) != test.remaining {
+				t.Error("remaining:", test.remaining, "!=", len(greylist))
+			}
+		})
+	}
+}
@@ -0,0 +1,319 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/etkecc/go-linkpearl"
+	"github.com/etkecc/go-secgen"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/etkecc/postmoogle/internal/api"
+	"github.com/etkecc/postmoogle/internal/bot/config"
+	"github.com/etkecc/postmoogle/internal/email"
+	"github.com/etkecc/postmoogle/internal/utils"
+)
+
+const apiTokensLock = "api_tokens"
+
+// apiOptions are the room options that can be changed with the API,
+// the rest (e.g. mailbox, owner, or password) need the matrix commands
+var apiOptions = []string{
+	config.RoomForward,
+	config.RoomAutoreply,
+	config.RoomSignature,
+	config.RoomThreadify,
+	config.RoomStripify,
+	config.RoomNoCC,
+	config.RoomNoFiles,
+	config.RoomNoHTML,
+	config.RoomNoInlines,
+	config.RoomNoRecipient,
+	config.RoomNoReplies,
+	config.RoomNoSend,
+	config.RoomNoSender,
+	config.RoomNoSubject,
+	config.RoomNoThreads,
+	config.RoomSpamcheckDKIM,
+	config.RoomSpamcheckDMARC,
+	config.RoomSpamcheckMX,
+	config.RoomSpamcheckRBL,
+	config.RoomSpamcheckSMTP,
+	config.RoomSpamcheckSPF,
+	config.RoomSpamscoreFlag,
+	config.RoomSpamscoreGreylist,
+	config.RoomSpamscoreQuarantine,
+	config.RoomSpamscoreReject,
+	config.RoomAntivirusStrip,
+	config.RoomQuarantine,
+	config.RoomSpamlist,
+	config.RoomAllowlist,
+}
+
+// AuthToken returns scope of the API token
+func (b *Bot) AuthToken(ctx context.Context, token string) (api.Scope, bool) {
+	scope, ok := b.cfg.GetAPITokens(ctx).Scope(token)
+	if !ok {
+		return api.Scope{}, false
+	}
+	if scope == config.APIScopeAdmin {
+		return api.Scope{Admin: true}, true
+	}
+	return api.Scope{RoomID: id.RoomID(scope)}, true
+}
+
+// MailboxRoom returns room of the mailbox (name or email address)
+func (b *Bot) MailboxRoom(mailbox string) (id.RoomID, bool) {
+	return b.getMapping(utils.MailboxAddress(strings.ToLower(strings.TrimSpace(mailbox))))
+}
+
+// Mailboxes returns all mailboxes, sorted by name
+func (b *Bot) Mailboxes(ctx context.Context) []api.Mailbox {
+	rooms := map[id.RoomID]bool{}
+	b.rooms.Range(func(_, value any) bool {
+		if roomID, ok := value.(id.RoomID); ok {
+			rooms[roomID] = true
+		}
+		return true
+	})
+
+	mailboxes := make([]api.Mailbox, 0, len(rooms))
+	for roomID := range rooms {
+		cfg, err := b.cfg.GetRoom(ctx, roomID)
+		if err != nil || cfg.Mailbox() == "" {
+			continue
+		}
+		mailboxes = append(mailboxes, api.Mailbox{
+			Mailbox: cfg.MailboxKey(cfg.Mailbox()),
+			Aliases: cfg.Aliases(),
+			Emails:  cfg.Emails(cfg.Mailbox()),
+			Owner:   cfg.Owner(),
+			RoomID:  roomID,
+		})
+	}
+	sort.Slice(mailboxes, func(i, j int) bool { return mailboxes[i].Mailbox < mailboxes[j].Mailbox })
+	return mailboxes
+}
+
+// RoomOptions returns options of the mailbox room, without internal options and SMTP password
+func (b *Bot) RoomOptions(ctx context.Context, roomID id.RoomID) (map[string]string, error) {
+	cfg, err := b.cfg.GetRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	options := make(map[string]string, len(cfg))
+	for key, value := range cfg {
+		if strings.HasPrefix(key, ".") || key == config.RoomPassword || value == "" {
+			continue
+		}
+		options[key] = value
+	}
+	if relay := cfg.Relay(); relay != nil {
+		options[config.RoomRelay] = relay.Redacted()
+	}
+	return options, nil
+}
+
+// SetRoomOptions changes options of the mailbox room, the same way as the matrix commands do
+func (b *Bot) SetRoomOptions(ctx context.Context, roomID id.RoomID, options map[string]string) (map[string]string, error) {
+	for key := range options {
+		if !slices.Contains(apiOptions, strings.ToLower(key)) {
+			return nil, fmt.Errorf("%w: `%s` option cannot be changed with the API", api.ErrInvalid, key)
+		}
+	}
+	cfg, err := b.cfg.GetRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range options {
+		key = strings.ToLower(key)
+		if cmd := b.commands.get(key); cmd != nil && cmd.sanitizer != nil {
+			value = cmd.sanitizer(value)
+		}
+		if value == "reset" {
+			value = ""
+		}
+		cfg.Set(key, value)
+	}
+	if err := b.cfg.SetRoom(ctx, roomID, cfg); err != nil {
+		return nil, err
+	}
+	return b.RoomOptions(ctx, roomID)
+}
+
+// SendFromRoom sends the email from the room's mailbox, like the `send` command does.
+// The request is posted to the room as a thread root, so replies to the email are threaded as usual
+func (b *Bot) SendFromRoom(ctx context.Context, roomID id.RoomID, req *api.SendRequest) ([]api.SendResult, error) {
+	cfg, err := b.cfg.GetRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Mailbox() == "" {
+		return nil, fmt.Errorf("%w: mailbox is not configured", api.ErrInvalid)
+	}
+	if cfg.NoSend() {
+		return nil, fmt.Errorf("%w: email sending is disabled in the mailbox", api.ErrInvalid)
+	}
+	if len(req.To) == 0 {
+		return nil, fmt.Errorf("%w: no recipients", api.ErrInvalid)
+	}
+	for _, to := range req.To {
+		if !email.AddressValid(to) {
+			return nil, fmt.Errorf("%w: email address %s is not valid", api.ErrInvalid, to)
+		}
+	}
+
+	body, htmlBody, err := apiSendBody(cfg, req.Text, req.HTML)
+	if err != nil {
+		return nil, err
+	}
+
+	root := format.RenderMarkdown(fmt.Sprintf("Email **%s** to %s has been requested via API", req.Subject, strings.Join(req.To, ", ")), true, true)
+	root.MsgType = event.MsgNotice
+	rootID, err := b.lp.Send(ctx, roomID, &root)
+	if err != nil {
+		return nil, err
+	}
+	ctx = newContext(ctx, &event.Event{
+		ID:      rootID,
+		RoomID:  roomID,
+		Sender:  b.lp.GetClient().UserID,
+		Type:    event.EventMessage,
+		Content: event.Content{Parsed: &root},
+	})
+
+	b.lock(ctx, roomID, rootID)
+	defer b.unlock(ctx, roomID, rootID)
+	results := make([]api.SendResult, 0, len(req.To))
+	for _, to := range req.To {
+		result := api.SendResult{To: to, Status: api.SendStatusSent}
+		queued, err := b.sendFromRoom(ctx, cfg, to, req.Subject, body, htmlBody)
+		switch {
+		case err != nil:
+			b.log.Warn().Err(err).Str("to", to).Msg("cannot send email requested via API")
+			result.Status = api.SendStatusFailed
+			result.Error = err.Error()
+		case queued:
+			result.Status = api.SendStatusQueued
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// apiSendBody returns text and HTML bodies of the email requested via API, with the mailbox signature appended to both
+func apiSendBody(cfg config.Room, text, html string) (body, htmlBody string, err error) {
+	if strings.TrimSpace(text) == "" && strings.TrimSpace(html) == "" {
+		return "", "", fmt.Errorf("%w: %w", api.ErrInvalid, errEmptyBody)
+	}
+	signature := format.RenderMarkdown(cfg.Signature(), true, true)
+	body = text
+	if signature.Body != "" {
+		body += "\n\n---\n" + signature.Body
+	}
+	if html == "" {
+		if cfg.NoHTML() {
+			return body, "", nil
+		}
+		return body, format.RenderMarkdown(body, true, true).FormattedBody, nil
+	}
+	htmlBody = html
+	if signature.FormattedBody != "" {
+		htmlBody += "<br><hr><br>" + signature.FormattedBody
+	}
+	return body, htmlBody, nil
+}
+
+// QueueItems returns queued emails
+func (b *Bot) QueueItems(ctx context.Context) ([]api.QueueItem, error) {
+	queued, err := b.q.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]api.QueueItem, 0, len(queued))
+	for _, item := range queued {
+		items = append(items, api.QueueItem{ID: item.ID, From: item.From, To: item.To, Attempts: item.Attempts})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	return items, nil
+}
+
+// CancelQueueItem removes the email from the queue
+func (b *Bot) CancelQueueItem(ctx context.Context, itemID string) (bool, error) {
+	return b.q.Cancel(ctx, itemID)
+}
+
+// ProcessQueue runs re-delivery of the queued emails
+func (b *Bot) ProcessQueue() {
+	b.q.Process()
+}
+
+// revokeAPIToken removes API token of the scope (room ID or admin), if any
+func (b *Bot) revokeAPIToken(ctx context.Context, scope string) {
+	b.mu.Lock(apiTokensLock)
+	defer b.mu.Unlock(apiTokensLock)
+
+	tokens := b.cfg.GetAPITokens(ctx)
+	if _, ok := tokens[scope]; !ok {
+		return
+	}
+	delete(tokens, scope)
+	if err := b.cfg.SetAPITokens(ctx, tokens); err != nil {
+		b.log.Error().Err(err).Str("scope", scope).Msg("cannot revoke API token")
+	}
+}
+
+// runAPIToken generates a new API token of the scope (room ID or admin), replacing the previous one,
+// `reset` revokes the token
+func (b *Bot) runAPIToken(ctx context.Context, scope string, commandSlice []string) {
+	evt := eventFromContext(ctx)
+	if scope != config.APIScopeAdmin {
+		cfg, err := b.cfg.GetRoom(ctx, evt.RoomID)
+		if err != nil {
+			b.Error(ctx, "failed to retrieve settings: %v", err)
+			return
+		}
+		if cfg.Mailbox() == "" {
+			b.lp.SendNotice(ctx, evt.RoomID, "mailbox is not configured, kupo", linkpearl.RelatesTo(evt.ID))
+			return
+		}
+	}
+	b.mu.Lock(apiTokensLock)
+	defer b.mu.Unlock(apiTokensLock)
+
+	tokens := b.cfg.GetAPITokens(ctx)
+	if len(commandSlice) > 1 && commandSlice[1] == "reset" {
+		delete(tokens, scope)
+		if err := b.cfg.SetAPITokens(ctx, tokens); err != nil {
+			b.Error(ctx, "cannot save API tokens: %v", err)
+			return
+		}
+		b.lp.SendNotice(ctx, evt.RoomID, "API token has been revoked, kupo.", linkpearl.RelatesTo(evt.ID))
+		return
+	}
+
+	token := "pm_" + secgen.HexBytes(32)
+	tokens[scope] = config.HashAPIToken(token)
+	if err := b.cfg.SetAPITokens(ctx, tokens); err != nil {
+		b.Error(ctx, "cannot save API tokens: %v", err)
+		return
+	}
+
+	target := "this mailbox"
+	if scope == config.APIScopeAdmin {
+		target = "all mailboxes and the queue"
+	}
+	b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf(
+		"New API token with access to %s is:\n```\n%s\n```\n"+
+			"Use it as a bearer token (`Authorization: Bearer TOKEN` header). "+
+			"It's stored in a secure hashed manner, so it cannot be shown again. "+
+			"The previous token (if any) has been revoked. To revoke this one, send `%s %s reset`",
+		target, token, b.prefix, commandSlice[0]),
+		linkpearl.RelatesTo(evt.ID),
+	)
+}
@@ -0,0 +1,56 @@
+package bot
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/etkecc/postmoogle/internal/api"
+	"github.com/etkecc/postmoogle/internal/bot/config"
+)
+
+func TestAPISendBody(t *testing.T) {
+	signed := config.Room{config.RoomSignature: "**Best**, test"}
+	tests := map[string]struct {
+		cfg      config.Room
+		text     string
+		html     string
+		body     string
+		htmlBody []string // substrings of the HTML body, nil = no HTML body
+		err      bool
+	}{
+		"plain text":      {config.Room{}, "Hello", "", "Hello", nil, false},
+		"text signed":     {signed, "Hello", "", "Hello\n\n---\n**Best**, test", []string{"Hello", "<strong>Best</strong>, test"}, false},
+		"html signed":     {signed, "Hello", "<p>Hi</p>", "Hello\n\n---\n**Best**, test", []string{"<p>Hi</p><br><hr><br>", "<strong>Best</strong>, test"}, false},
+		"html only":       {config.Room{}, "", "<p>Hi</p>", "", []string{"<p>Hi</p>"}, false},
+		"nohtml":          {config.Room{config.RoomNoHTML: "true"}, "Hello", "", "Hello", nil, false},
+		"empty":           {signed, "", "", "", nil, true},
+		"whitespace only": {config.Room{}, " \n", " ", "", nil, true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			body, htmlBody, err := apiSendBody(test.cfg, test.text, test.html)
+			if test.err {
+				if !errors.Is(err, api.ErrInvalid) {
+					t.Error("expected invalid request error, got", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if body != test.body {
+				t.Errorf("%q != %q", test.body, body)
+			}
+			if test.htmlBody == nil && htmlBody != "" {
+				t.Errorf("unexpected HTML body: %q", htmlBody)
+			}
+			for _, part := range test.htmlBody {
+				if !strings.Contains(htmlBody, part) {
+					t.Errorf("HTML body %q doesn't contain %q", htmlBody, part)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package bot
+
+import (
+	"context"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+
+	"github.com/etkecc/postmoogle/internal/bot/config"
+	"github.com/etkecc/postmoogle/internal/imap"
+)
+
+// SetArchive sets the store of the raw emails, served over IMAP. Nil = emails are not archived.
+// Retention is amount of days to keep the archived emails, 0 = forever
+func (b *Bot) SetArchive(store *imap.Store, retention int) {
+	b.archive = store
+	b.archiveRetention = retention
+}
+
+// archiveEmail saves the raw email into the mailbox's folder, so it will be available over IMAP
+func (b *Bot) archiveEmail(ctx context.Context, roomID id.RoomID, cfg config.Room, folder string, threadID id.EventID, messageID string, raw []byte) {
+	if b.archive == nil || cfg.Mailbox() == "" || len(raw) == 0 {
+		return
+	}
+
+	_, err := b.archive.Add(ctx, &imap.Message{
+		RoomID:    roomID,
+		Folder:    folder,
+		ThreadID:  threadID,
+		MessageID: messageID,
+		Date:      time.Now().UTC(),
+		Raw:       raw,
+	})
+	if err != nil {
+		b.log.Error().Err(err).Str("room_id", roomID.String()).Str("folder", folder).Msg("cannot archive email")
+	}
+}
+
+// removeArchive deletes all archived emails of the room, called when the mailbox is released
+func (b *Bot) removeArchive(ctx context.Context, roomID id.RoomID) {
+	if b.archive == nil {
+		return
+	}
+	removed, err := b.archive.Remove(ctx, roomID)
+	if err != nil {
+		b.log.Error().Err(err).Str("room_id", roomID.String()).Msg("cannot remove archived emails")
+		return
+	}
+	b.log.Debug().Str("room_id", roomID.String()).Int64("removed", removed).Msg("archived emails have been removed")
+}
+
+// PruneArchive deletes archived emails older than the retention period
+func (b *Bot) PruneArchive() {
+	if b.archive == nil || b.archiveRetention <= 0 {
+		return
+	}
+	removed, err := b.archive.Prune(context.Background(), time.Now().UTC().AddDate(0, 0, -b.archiveRetention))
+	if err != nil {
+		b.log.Error().Err(err).Msg("cannot prune archive")
+		return
+	}
+	b.log.Debug().Int64("archive", removed).Msg("archive has been pruned")
+}
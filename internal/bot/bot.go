@@ -6,6 +6,8 @@ import (
 	"net/url"
 	"regexp"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/etkecc/go-kit"
 	"github.com/etkecc/go-linkpearl"
@@ -15,6 +17,10 @@ import (
 
 	"github.com/etkecc/postmoogle/internal/bot/config"
 	"github.com/etkecc/postmoogle/internal/bot/queue"
+	"github.com/etkecc/postmoogle/internal/email"
+	"github.com/etkecc/postmoogle/internal/imap"
+	"github.com/etkecc/postmoogle/internal/utils"
+	"github.com/etkecc/postmoogle/internal/webhook"
 )
 
 // Mailboxes config
@@ -37,12 +43,21 @@ type Bot struct {
 	rooms                   sync.Map
 	proxies                 []string
 	sendmail                func(string, string, string, *url.URL) error
+	antivirus               func(context.Context, *email.Email, id.RoomID) error
+	domainsUpdater          func([]string)
 	cfg                     *config.Manager
 	log                     *zerolog.Logger
 	lp                      *linkpearl.Linkpearl
 	mu                      *kit.Mutex
+	banset                  atomic.Pointer[utils.PrefixSet[*config.Ban]]
+	banFailures             map[string][]time.Time
+	policies                *policyList
 	q                       *queue.Queue
+	webhooks                *webhook.Sender
+	archive                 *imap.Store
+	archiveRetention        int
 	handledMembershipEvents sync.Map
+	lastSync                atomic.Int64 // unix timestamp of the last successful /sync
 }
 
 // New creates a new matrix bot
@@ -58,18 +73,22 @@ func New(
 	mbxc MBXConfig,
 ) (*Bot, error) {
 	b := &Bot{
-		domains:    domains,
-		prefix:     prefix,
-		rooms:      sync.Map{},
-		adminRooms: []id.RoomID{},
-		proxies:    proxies,
-		mbxc:       mbxc,
-		cfg:        cfg,
-		log:        log,
-		lp:         lp,
-		mu:         kit.NewMutex(),
-		q:          q,
+		domains:     domains,
+		prefix:      prefix,
+		rooms:       sync.Map{},
+		adminRooms:  []id.RoomID{},
+		proxies:     proxies,
+		mbxc:        mbxc,
+		cfg:         cfg,
+		log:         log,
+		lp:          lp,
+		mu:          kit.NewMutex(),
+		banFailures: map[string][]time.Time{},
+		policies:    newPolicyList(),
+		q:           q,
+		webhooks:    webhook.New(log, 0, 0),
 	}
+	q.SetResultHandler(b.onQueueResult)
 	users, err := b.initBotUsers(context.Background())
 	if err != nil {
 		return nil, err
@@ -125,11 +144,16 @@ func (b *Bot) Start(statusMsg string) error {
 	if err := b.migrateMautrix015(ctx); err != nil {
 		return err
 	}
+	b.loadDomains(ctx)
 
 	if err := b.syncRooms(ctx); err != nil {
 		return err
 	}
 
+	if err := b.loadPolicies(ctx); err != nil {
+		b.log.Error().Err(err).Msg("cannot load policy lists")
+	}
+
 	b.initSync()
 	b.log.Info().Msg("Postmoogle has been started")
 	return b.lp.Start(ctx, statusMsg)
@@ -137,5 +161,8 @@ func (b *Bot) Start(statusMsg string) error {
 
 // Stop the bot
 func (b *Bot) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookStopTimeout)
+	defer cancel()
+	b.webhooks.Stop(ctx)
 	b.lp.Stop(context.Background())
 }
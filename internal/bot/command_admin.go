@@ -79,25 +79,26 @@ func (b *Bot) runDelete(ctx context.Context, commandSlice []string) {
 		b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("Usage: `%s delete MAILBOX`", b.prefix), linkpearl.RelatesTo(evt.ID))
 		return
 	}
-	mailbox := utils.Mailbox(commandSlice[1])
-
-	v, ok := b.rooms.Load(mailbox)
-	if v == nil || !ok {
+	roomID, ok := b.getMapping(utils.MailboxAddress(commandSlice[1]))
+	if !ok {
 		b.lp.SendNotice(ctx, evt.RoomID, "mailbox does not exists, kupo", linkpearl.RelatesTo(evt.ID))
 		return
 	}
-	roomID, ok := v.(id.RoomID)
-	if !ok {
+	cfg, err := b.cfg.GetRoom(ctx, roomID)
+	if err != nil {
+		b.Error(ctx, "failed to retrieve settings: %v", err)
 		return
 	}
 
-	b.rooms.Delete(mailbox)
-	err := b.cfg.SetRoom(ctx, roomID, config.Room{})
+	b.removeRoom(roomID, cfg)
+	err = b.cfg.SetRoom(ctx, roomID, config.Room{})
 	if err != nil {
 		b.Error(ctx, "cannot update settings: %v", err)
 		return
 	}
 
+	b.removeArchive(ctx, roomID)
+
 	b.lp.SendNotice(ctx, evt.RoomID, "mailbox has been deleted", linkpearl.RelatesTo(evt.ID))
 }
 
@@ -147,6 +148,10 @@ func (b *Bot) runUsers(ctx context.Context, commandSlice []string) {
 func (b *Bot) runDKIM(ctx context.Context, commandSlice []string) {
 	evt := eventFromContext(ctx)
 	cfg := b.cfg.GetBot(ctx)
+	if len(commandSlice) > 1 && commandSlice[1] != "reset" {
+		b.sendDKIMDomain(ctx, commandSlice[1])
+		return
+	}
 	if len(commandSlice) > 1 && commandSlice[1] == "reset" {
 		cfg.Set(config.BotDKIMPrivateKey, "")
 		cfg.Set(config.BotDKIMSignature, "")
@@ -172,11 +177,11 @@ func (b *Bot) runDKIM(ctx context.Context, commandSlice []string) {
 
 	b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf(
 		"DKIM signature is: `%s`.\n"+
-			"You need to add it to DNS records of all domains added to postmoogle (if not already):\n"+
+			"You need to add it to DNS records of all domains added to postmoogle without their own DKIM keys (if not already):\n"+
 			"Add new DNS record with type = `TXT`, key (subdomain/from): `postmoogle._domainkey` and value (to):\n ```\n%s\n```\n"+
 			"Without that record other email servers may reject your emails as spam, kupo.\n"+
-			"To reset the signature, send `%s dkim reset`",
-		signature, signature, b.prefix),
+			"To reset the signature, send `%s dkim reset`. To show DKIM keys of a domain, send `%s dkim DOMAIN`",
+		signature, signature, b.prefix, b.prefix),
 		linkpearl.RelatesTo(evt.ID),
 	)
 }
@@ -205,8 +210,8 @@ func (b *Bot) runCatchAll(ctx context.Context, commandSlice []string) {
 		return
 	}
 
-	mailbox := utils.Mailbox(commandSlice[1])
-	_, ok := b.GetMapping(ctx, mailbox)
+	mailbox := utils.MailboxAddress(commandSlice[1])
+	_, ok := b.getMapping(mailbox)
 	if !ok {
 		b.lp.SendNotice(ctx, evt.RoomID, "mailbox does not exist, kupo.", linkpearl.RelatesTo(evt.ID))
 		return
@@ -258,9 +263,12 @@ func (b *Bot) runAdminRoom(ctx context.Context, commandSlice []string) {
 
 func (b *Bot) printGreylist(ctx context.Context, roomID id.RoomID) {
 	cfg := b.cfg.GetBot(ctx)
-	greylist := b.cfg.GetGreylist(ctx)
+	b.mu.Lock(greylistLock)
+	pending := len(b.cfg.GetGreylist(ctx))
+	whitelisted := len(b.cfg.GetGreylistWhitelist(ctx))
+	b.mu.Unlock(greylistLock)
+
 	var msg strings.Builder
-	size := len(greylist)
 	duration := cfg.Greylist()
 	msg.WriteString("Currently: `")
 	if duration == 0 {
@@ -270,15 +278,23 @@ func (b *Bot) printGreylist(ctx context.Context, roomID id.RoomID) {
 		msg.WriteString("min")
 	}
 	msg.WriteString("`")
-	if size > 0 {
-		msg.WriteString(", total known: ")
-		msg.WriteString(strconv.Itoa(size))
-		msg.WriteString(" hosts (`")
-		msg.WriteString(strings.Join(greylist.Slice(), "`, `"))
-		msg.WriteString("`)\n\n")
+	if pending > 0 || whitelisted > 0 {
+		msg.WriteString(", pending: ")
+		msg.WriteString(strconv.Itoa(pending))
+		msg.WriteString(" (client network, sender, recipient) triplets, whitelisted: ")
+		msg.WriteString(strconv.Itoa(whitelisted))
+		msg.WriteString(" senders for ")
+		msg.WriteString(strconv.Itoa(cfg.GreylistWhitelist()))
+		msg.WriteString(" days")
+	}
+	msg.WriteString("\n\n")
+	if exempt := cfg.GreylistExempt(); len(exempt) > 0 {
+		msg.WriteString("Exempt: `")
+		msg.WriteString(strings.Join(exempt, "`, `"))
+		msg.WriteString("`\n\n")
 	}
 	if duration == 0 {
-		msg.WriteString("\n\nTo enable greylist: `")
+		msg.WriteString("To enable greylist: `")
 		msg.WriteString(b.prefix)
 		msg.WriteString(" greylist MIN`")
 		msg.WriteString("where `MIN` is duration in minutes for automatic greylisting\n")
@@ -303,13 +319,64 @@ func (b *Bot) runGreylist(ctx context.Context, commandSlice []string) {
 	b.lp.SendNotice(ctx, evt.RoomID, "greylist duration has been updated", linkpearl.RelatesTo(evt.ID))
 }
 
+func (b *Bot) runGreylistWhitelist(ctx context.Context, commandSlice []string) {
+	evt := eventFromContext(ctx)
+	if len(commandSlice) < 2 {
+		b.printGreylist(ctx, evt.RoomID)
+		return
+	}
+	cfg := b.cfg.GetBot(ctx)
+	cfg.Set(config.BotGreylistWhitelist, utils.SanitizeIntString(commandSlice[1]))
+	err := b.cfg.SetBot(ctx, cfg)
+	if err != nil {
+		b.Error(ctx, "cannot set bot config: %v", err)
+		return
+	}
+	b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("senders will be whitelisted for %d days after a successful retry", cfg.GreylistWhitelist()), linkpearl.RelatesTo(evt.ID))
+}
+
+func (b *Bot) runGreylistExempt(ctx context.Context, commandSlice []string) {
+	evt := eventFromContext(ctx)
+	if len(commandSlice) < 2 {
+		b.printGreylist(ctx, evt.RoomID)
+		return
+	}
+	items := commandSlice[1:]
+	if len(items) == 1 && items[0] == "reset" {
+		items = nil
+	}
+	for _, item := range items {
+		if strings.Contains(item, "/") {
+			if _, _, err := net.ParseCIDR(item); err != nil {
+				b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("`%s` is not a valid CIDR, kupo", item), linkpearl.RelatesTo(evt.ID))
+				return
+			}
+			continue
+		}
+		if net.ParseIP(item) == nil && !strings.Contains(item, ".") {
+			b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("`%s` is neither domain, nor IP, nor CIDR, kupo", item), linkpearl.RelatesTo(evt.ID))
+			return
+		}
+	}
+
+	cfg := b.cfg.GetBot(ctx)
+	cfg.Set(config.BotGreylistExempt, utils.SliceString(items))
+	err := b.cfg.SetBot(ctx, cfg)
+	if err != nil {
+		b.Error(ctx, "cannot set bot config: %v", err)
+		return
+	}
+	b.lp.SendNotice(ctx, evt.RoomID, "greylist exemptions have been updated, kupo", linkpearl.RelatesTo(evt.ID))
+}
+
 func (b *Bot) runBanlist(ctx context.Context, commandSlice []string) {
 	evt := eventFromContext(ctx)
 	cfg := b.cfg.GetBot(ctx)
 	if len(commandSlice) < 2 {
-		banlist := b.cfg.GetBanlist(ctx)
+		b.mu.Lock(banlistLock)
+		size := len(b.cfg.GetBanlist(ctx))
+		b.mu.Unlock(banlistLock)
 		var msg strings.Builder
-		size := len(banlist)
 		if size > 0 {
 			msg.WriteString("Currently: `")
 			msg.WriteString(cfg.Get(config.BotBanlistEnabled))
@@ -324,8 +391,20 @@ func (b *Bot) runBanlist(ctx context.Context, commandSlice []string) {
 		}
 		msg.WriteString("To ban somebody: `")
 		msg.WriteString(b.prefix)
-		msg.WriteString(" banlist:add IP1 IP2 IP3...`")
-		msg.WriteString("where each ip is IPv4 or IPv6\n\n")
+		msg.WriteString(" banlist:add IP1 CIDR2... DURATION REASON`")
+		msg.WriteString("where each target is IPv4 or IPv6 address or CIDR range, ")
+		msg.WriteString("duration (e.g. `30m`, `12h`, `7d`) and reason are optional (permanent ban by default)\n\n")
+		msg.WriteString("Automatic bans happen after `")
+		msg.WriteString(strconv.Itoa(cfg.BanlistThreshold()))
+		msg.WriteString("` failure(s) within `")
+		msg.WriteString(cfg.BanlistWindow().String())
+		msg.WriteString("` and last `")
+		if duration := cfg.BanlistDuration(); duration > 0 {
+			msg.WriteString(duration.String())
+		} else {
+			msg.WriteString("forever")
+		}
+		msg.WriteString("`\n\n")
 		msg.WriteString("You can find current banlist values below:\n")
 
 		b.lp.SendNotice(ctx, evt.RoomID, msg.String(), linkpearl.RelatesTo(evt.ID))
@@ -343,9 +422,10 @@ func (b *Bot) runBanlist(ctx context.Context, commandSlice []string) {
 
 func (b *Bot) runBanlistTotals(ctx context.Context) {
 	evt := eventFromContext(ctx)
-	banlist := b.cfg.GetBanlist(ctx)
+	b.mu.Lock(banlistLock)
+	size := len(b.cfg.GetBanlist(ctx))
+	b.mu.Unlock(banlistLock)
 	var msg strings.Builder
-	size := len(banlist)
 	if size == 0 {
 		b.lp.SendNotice(ctx, evt.RoomID, "banlist is empty, kupo.", linkpearl.RelatesTo(evt.ID))
 		return
@@ -413,6 +493,35 @@ func (b *Bot) runBanlistAuto(ctx context.Context, commandSlice []string) { //nol
 	b.lp.SendNotice(ctx, evt.RoomID, "auto banning has been updated", linkpearl.RelatesTo(evt.ID))
 }
 
+func (b *Bot) runBanlistOption(ctx context.Context, key string, commandSlice []string) {
+	evt := eventFromContext(ctx)
+	cfg := b.cfg.GetBot(ctx)
+	if len(commandSlice) < 2 {
+		value := cfg.Get(key)
+		if value == "" {
+			value = "default"
+		}
+		b.lp.SendNotice(ctx, evt.RoomID, "Currently: `"+value+"`", linkpearl.RelatesTo(evt.ID))
+		return
+	}
+	value := utils.SanitizeIntString(commandSlice[1])
+	if key != config.BotBanlistThreshold {
+		minutes, err := utils.Minutes(commandSlice[1])
+		if err != nil {
+			b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("cannot parse the value: %v, kupo", err), linkpearl.RelatesTo(evt.ID))
+			return
+		}
+		value = strconv.Itoa(minutes)
+	}
+	cfg.Set(key, value)
+	err := b.cfg.SetBot(ctx, cfg)
+	if err != nil {
+		b.Error(ctx, "cannot set bot config: %v", err)
+		return
+	}
+	b.lp.SendNotice(ctx, evt.RoomID, key+" has been updated, kupo", linkpearl.RelatesTo(evt.ID))
+}
+
 func (b *Bot) runBanlistChange(ctx context.Context, mode string, commandSlice []string) {
 	evt := eventFromContext(ctx)
 	if len(commandSlice) < 2 {
@@ -423,26 +532,31 @@ func (b *Bot) runBanlistChange(ctx context.Context, mode string, commandSlice []
 		b.lp.SendNotice(ctx, evt.RoomID, "banlist is disabled, you have to enable it first, kupo", linkpearl.RelatesTo(evt.ID))
 		return
 	}
-	banlist := b.cfg.GetBanlist(ctx)
 
-	var action func(net.Addr)
-	if mode == "remove" {
-		action = banlist.Remove
-	} else {
-		action = banlist.Add
+	targets, ttl, reason, err := parseBanArgs(commandSlice[1:])
+	if err != nil {
+		b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("cannot parse the command: %v, kupo", err), linkpearl.RelatesTo(evt.ID))
+		return
+	}
+	if reason == "" {
+		reason = "manual"
 	}
 
-	ips := commandSlice[1:]
-	for _, ip := range ips {
-		addr, err := net.ResolveIPAddr("ip", ip)
-		if err != nil {
-			b.Error(ctx, "cannot remove %s from banlist: %v", ip, err)
-			return
+	b.mu.Lock(banlistLock)
+	defer b.mu.Unlock(banlistLock)
+	banlist := b.cfg.GetBanlist(ctx)
+	for _, target := range targets {
+		if mode == "remove" {
+			if !banlist.Remove(target) {
+				b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("`%s` is not banned, kupo", target), linkpearl.RelatesTo(evt.ID))
+				return
+			}
+			continue
 		}
-		action(addr)
+		banlist.Add(target, ttl, reason)
 	}
 
-	err := b.cfg.SetBanlist(ctx, banlist)
+	err = b.setBanlist(ctx, banlist)
 	if err != nil {
 		b.Error(ctx, "cannot set banlist: %v", err)
 		return
@@ -451,28 +565,49 @@ func (b *Bot) runBanlistChange(ctx context.Context, mode string, commandSlice []
 	b.lp.SendNotice(ctx, evt.RoomID, "banlist has been updated, kupo", linkpearl.RelatesTo(evt.ID))
 }
 
+// parseBanArgs parses `IP1 CIDR2... DURATION REASON` into banlist targets, ban duration, and reason
+func parseBanArgs(args []string) (targets []string, ttl time.Duration, reason string, err error) {
+	for i, arg := range args {
+		target, terr := config.BanTarget(arg)
+		if terr == nil {
+			targets = append(targets, target)
+			continue
+		}
+		if len(targets) == 0 {
+			return nil, 0, "", fmt.Errorf("`%s` is neither IP nor CIDR", arg) //nolint:goerr113 // shown to the user
+		}
+		rest := args[i:]
+		if duration, derr := utils.Duration(rest[0]); derr == nil {
+			ttl = duration
+			rest = rest[1:]
+		}
+		return targets, ttl, strings.Join(rest, " "), nil
+	}
+
+	return targets, ttl, reason, nil
+}
+
 func (b *Bot) addBanlistTimeline(ctx context.Context, onlyTotals bool) {
 	evt := eventFromContext(ctx)
-	banlist := b.cfg.GetBanlist(ctx)
-	timeline := map[string][]string{}
-	for ip, ts := range banlist {
+	b.mu.Lock(banlistLock)
+	bans := b.cfg.GetBanlist(ctx).Slice()
+	b.mu.Unlock(banlistLock)
+
+	timeline := map[string][]*config.Ban{}
+	for _, ban := range bans {
 		key := "???"
-		date, _ := time.ParseInLocation(time.RFC1123Z, ts, time.UTC) //nolint:errcheck // stored in that format
-		if !date.IsZero() {
-			key = date.Truncate(24 * time.Hour).Format(time.DateOnly)
-		}
-		if _, ok := timeline[key]; !ok {
-			timeline[key] = []string{}
+		if !ban.BannedAt.IsZero() {
+			key = ban.BannedAt.UTC().Truncate(24 * time.Hour).Format(time.DateOnly)
 		}
-		timeline[key] = append(timeline[key], ip)
+		timeline[key] = append(timeline[key], ban)
 	}
 	keys := utils.MapKeys(timeline)
 
+	now := time.Now()
 	for _, chunk := range utils.Chunks(keys, 7) {
 		var txt strings.Builder
 		for _, day := range chunk {
 			data := timeline[day]
-			sort.Strings(data)
 			txt.WriteString("* `")
 			txt.WriteString(day)
 			if onlyTotals {
@@ -481,9 +616,27 @@ func (b *Bot) addBanlistTimeline(ctx context.Context, onlyTotals bool) {
 				txt.WriteString(" hosts banned\n")
 				continue
 			}
-			txt.WriteString("` `")
-			txt.WriteString(strings.Join(data, "`, `"))
 			txt.WriteString("`\n")
+			for _, ban := range data {
+				txt.WriteString("  * `")
+				txt.WriteString(ban.Target)
+				txt.WriteString("`")
+				if ban.Reason != "" {
+					txt.WriteString(" - ")
+					txt.WriteString(ban.Reason)
+				}
+				switch {
+				case ban.Permanent():
+					txt.WriteString(", permanent")
+				case ban.Expired(now):
+					txt.WriteString(", expired")
+				default:
+					txt.WriteString(", until `")
+					txt.WriteString(ban.Expires.UTC().Format(time.DateTime))
+					txt.WriteString(" UTC`")
+				}
+				txt.WriteString("\n")
+			}
 		}
 		b.lp.SendNotice(ctx, evt.RoomID, txt.String(), linkpearl.RelatesTo(evt.ID))
 	}
@@ -496,7 +649,9 @@ func (b *Bot) runBanlistReset(ctx context.Context) {
 		return
 	}
 
-	err := b.cfg.SetBanlist(ctx, config.List{})
+	b.mu.Lock(banlistLock)
+	defer b.mu.Unlock(banlistLock)
+	err := b.setBanlist(ctx, config.Banlist{})
 	if err != nil {
 		b.Error(ctx, "cannot set banlist: %v", err)
 		return
@@ -504,3 +659,331 @@ func (b *Bot) runBanlistReset(ctx context.Context) {
 
 	b.lp.SendNotice(ctx, evt.RoomID, "banlist has been reset, kupo", linkpearl.RelatesTo(evt.ID))
 }
+
+func (b *Bot) runPolicy(ctx context.Context, commandSlice []string) {
+	evt := eventFromContext(ctx)
+	cfg := b.cfg.GetBot(ctx)
+	if len(commandSlice) < 2 {
+		var msg strings.Builder
+		rooms := cfg.PolicyRooms()
+		if len(rooms) > 0 {
+			bans, patterns := b.policies.stats()
+			msg.WriteString("Currently: `")
+			msg.WriteString(cfg.Get(config.BotPolicyRooms))
+			msg.WriteString("`, banned IPs and CIDRs: ")
+			msg.WriteString(strconv.Itoa(bans))
+			msg.WriteString(", spamlist patterns: ")
+			msg.WriteString(strconv.Itoa(patterns))
+			msg.WriteString("\n\n")
+		}
+		msg.WriteString("Usage: `")
+		msg.WriteString(b.prefix)
+		msg.WriteString(" policy ROOM1 ROOM2...` ")
+		msg.WriteString("where each room is ID or alias of a moderation policy room. ")
+		msg.WriteString("Server rules with IPs and CIDRs ban SMTP clients, ")
+		msg.WriteString("other server rules (e.g. `*.example.com`) and user rules (e.g. `@spam:example.com`) are added to the server-wide spamlist (`*@*.example.com`, `spam@example.com`)\n")
+
+		b.lp.SendNotice(ctx, evt.RoomID, msg.String(), linkpearl.RelatesTo(evt.ID))
+		return
+	}
+
+	rooms := b.parseCommand(evt.Content.AsMessage().Body, false)[1:] // get original values, without forced lower case
+	if len(rooms) == 1 && rooms[0] == "reset" {
+		rooms = nil
+	}
+	roomIDs := make([]string, 0, len(rooms))
+	for _, room := range rooms {
+		if !strings.HasPrefix(room, "#") {
+			roomIDs = append(roomIDs, room)
+			continue
+		}
+		resp, err := b.lp.GetClient().ResolveAlias(ctx, id.RoomAlias(room))
+		if err != nil {
+			b.Error(ctx, "cannot resolve room alias %s: %v", room, err)
+			return
+		}
+		roomIDs = append(roomIDs, resp.RoomID.String())
+	}
+
+	cfg.Set(config.BotPolicyRooms, utils.SliceString(roomIDs))
+	err := b.cfg.SetBot(ctx, cfg)
+	if err != nil {
+		b.Error(ctx, "cannot set bot config: %v", err)
+		return
+	}
+	if err := b.loadPolicies(ctx); err != nil {
+		b.Error(ctx, "cannot load policy lists: %v", err)
+		return
+	}
+
+	bans, patterns := b.policies.stats()
+	b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("policy rooms have been updated, banned IPs and CIDRs: %d, spamlist patterns: %d, kupo", bans, patterns), linkpearl.RelatesTo(evt.ID))
+}
+
+func (b *Bot) runDNSBL(ctx context.Context) {
+	evt := eventFromContext(ctx)
+	dnsbl := b.cfg.GetDNSBL(ctx)
+	threshold := b.cfg.GetBot(ctx).DNSBLThreshold()
+
+	var msg strings.Builder
+	msg.WriteString("Currently used DNS blocklists (`weight`, `signals`):\n\n")
+	for _, name := range dnsbl.Slice() {
+		zone := dnsbl.Get(name)
+		msg.WriteString("* `")
+		msg.WriteString(zone.Name)
+		msg.WriteString("` (`")
+		msg.WriteString(strconv.Itoa(zone.Weight))
+		msg.WriteString("`, `")
+		msg.WriteString(strings.Join(zone.Signals, "`, `"))
+		msg.WriteString("`)\n")
+	}
+	msg.WriteString("\nThreshold: `")
+	if threshold > 0 {
+		msg.WriteString(strconv.Itoa(threshold))
+		msg.WriteString("` - host is rejected if the total weight of zones listing it reaches the threshold\n\n")
+	} else {
+		msg.WriteString("0` - host is rejected if it's listed in more than one zone, or in the most of them\n\n")
+	}
+	msg.WriteString("To add or update a zone: `")
+	msg.WriteString(b.prefix)
+	msg.WriteString(" dnsbl:add ZONE WEIGHT SIGNAL1 SIGNAL2...`, ")
+	msg.WriteString("where weight and signals are optional, each signal is IP or CIDR (default: `")
+	msg.WriteString(config.DNSBLDefaultSignal)
+	msg.WriteString("`)\n\n")
+	msg.WriteString("To change the threshold: `")
+	msg.WriteString(b.prefix)
+	msg.WriteString(" dnsbl:threshold WEIGHT`\n\n")
+	msg.WriteString("DNSBL checks are enabled per mailbox with `")
+	msg.WriteString(b.prefix)
+	msg.WriteString(" spamcheck:rbl true`")
+
+	b.lp.SendNotice(ctx, evt.RoomID, msg.String(), linkpearl.RelatesTo(evt.ID))
+}
+
+func (b *Bot) runDNSBLAdd(ctx context.Context, commandSlice []string) {
+	evt := eventFromContext(ctx)
+	if len(commandSlice) < 2 {
+		b.runDNSBL(ctx)
+		return
+	}
+	zone := strings.ToLower(strings.TrimSpace(commandSlice[1]))
+	if zone == "" || strings.ContainsAny(zone, "/@ ") || !strings.Contains(zone, ".") {
+		b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("`%s` is not a valid DNSBL zone, kupo", commandSlice[1]), linkpearl.RelatesTo(evt.ID))
+		return
+	}
+	weight := config.DNSBLDefaultWeight
+	signals := commandSlice[2:]
+	if len(signals) > 0 {
+		if value, err := strconv.Atoi(signals[0]); err == nil {
+			weight = value
+			signals = signals[1:]
+		}
+	}
+	if weight < 1 {
+		b.lp.SendNotice(ctx, evt.RoomID, "weight must be a positive number, kupo", linkpearl.RelatesTo(evt.ID))
+		return
+	}
+	for _, signal := range signals {
+		if net.ParseIP(signal) != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(signal); err != nil {
+			b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("`%s` is neither IP nor CIDR, kupo", signal), linkpearl.RelatesTo(evt.ID))
+			return
+		}
+	}
+
+	dnsbl := b.cfg.GetDNSBL(ctx)
+	dnsbl.Set(zone, weight, signals)
+	if err := b.cfg.SetDNSBL(ctx, dnsbl); err != nil {
+		b.Error(ctx, "cannot set DNSBL config: %v", err)
+		return
+	}
+
+	b.lp.SendNotice(ctx, evt.RoomID, "DNSBL zone `"+zone+"` has been updated, kupo", linkpearl.RelatesTo(evt.ID))
+}
+
+func (b *Bot) runDNSBLRemove(ctx context.Context, commandSlice []string) {
+	evt := eventFromContext(ctx)
+	if len(commandSlice) < 2 {
+		b.runDNSBL(ctx)
+		return
+	}
+	dnsbl := b.cfg.GetDNSBL(ctx)
+	for _, zone := range commandSlice[1:] {
+		if dnsbl.Get(zone) == nil {
+			b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("DNSBL zone `%s` is not used, kupo", zone), linkpearl.RelatesTo(evt.ID))
+			return
+		}
+		dnsbl.Remove(zone)
+	}
+	if len(dnsbl) == 0 {
+		b.lp.SendNotice(ctx, evt.RoomID, "cannot remove all DNSBL zones (empty list means defaults), disable `spamcheck:rbl` in mailboxes instead, kupo", linkpearl.RelatesTo(evt.ID))
+		return
+	}
+	if err := b.cfg.SetDNSBL(ctx, dnsbl); err != nil {
+		b.Error(ctx, "cannot set DNSBL config: %v", err)
+		return
+	}
+
+	b.lp.SendNotice(ctx, evt.RoomID, "DNSBL zones have been removed, kupo", linkpearl.RelatesTo(evt.ID))
+}
+
+func (b *Bot) runDNSBLThreshold(ctx context.Context, commandSlice []string) {
+	evt := eventFromContext(ctx)
+	if len(commandSlice) < 2 {
+		b.runDNSBL(ctx)
+		return
+	}
+	cfg := b.cfg.GetBot(ctx)
+	cfg.Set(config.BotDNSBLThreshold, utils.SanitizeIntString(commandSlice[1]))
+	if err := b.cfg.SetBot(ctx, cfg); err != nil {
+		b.Error(ctx, "cannot set bot config: %v", err)
+		return
+	}
+
+	b.lp.SendNotice(ctx, evt.RoomID, "DNSBL threshold has been updated, kupo", linkpearl.RelatesTo(evt.ID))
+}
+
+func (b *Bot) runDNSBLReset(ctx context.Context) {
+	evt := eventFromContext(ctx)
+	if err := b.cfg.SetDNSBL(ctx, config.DefaultDNSBL()); err != nil {
+		b.Error(ctx, "cannot set DNSBL config: %v", err)
+		return
+	}
+
+	b.lp.SendNotice(ctx, evt.RoomID, "DNSBL zones have been reset to defaults, kupo", linkpearl.RelatesTo(evt.ID))
+}
+
+func (b *Bot) runGlobalList(ctx context.Context, key string) {
+	evt := eventFromContext(ctx)
+	list := utils.StringSlice(b.cfg.GetBot(ctx).Get(key))
+	if len(list) == 0 {
+		msg := fmt.Sprintf("server-wide %s is empty, kupo.\nTo add items, send a `%s %s:global:add ITEM1 ITEM2...` command.", key, b.prefix, strings.TrimSuffix(key, "list"))
+		b.lp.SendNotice(ctx, evt.RoomID, msg, linkpearl.RelatesTo(evt.ID))
+		return
+	}
+
+	var msg strings.Builder
+	msg.WriteString("Server-wide ")
+	msg.WriteString(key)
+	msg.WriteString(" (applied to all mailboxes):\n```\n")
+	msg.WriteString(strings.Join(list, "\n"))
+	msg.WriteString("\n```")
+	b.lp.SendNotice(ctx, evt.RoomID, msg.String(), linkpearl.RelatesTo(evt.ID))
+}
+
+func (b *Bot) runGlobalListChange(ctx context.Context, key, kind string, commandSlice []string) {
+	evt := eventFromContext(ctx)
+	if len(commandSlice) < 2 {
+		b.runGlobalList(ctx, key)
+		return
+	}
+	cfg := b.cfg.GetBot(ctx)
+	list := utils.StringSlice(cfg.Get(key))
+	var changed bool
+	switch kind {
+	case "add":
+		list, changed = addListItems(list, commandSlice[1:])
+	case "remove":
+		list, changed = removeListItems(list, commandSlice[1:])
+	}
+	if !changed {
+		b.lp.SendNotice(ctx, evt.RoomID, "nothing new, kupo.", linkpearl.RelatesTo(evt.ID))
+		return
+	}
+
+	cfg.Set(key, utils.SliceString(list))
+	err := b.cfg.SetBot(ctx, cfg)
+	if err != nil {
+		b.Error(ctx, "cannot set bot config: %v", err)
+		return
+	}
+	b.lp.SendNotice(ctx, evt.RoomID, "server-wide "+key+" has been updated, kupo", linkpearl.RelatesTo(evt.ID))
+}
+
+func (b *Bot) runGlobalListReset(ctx context.Context, key string) {
+	evt := eventFromContext(ctx)
+	cfg := b.cfg.GetBot(ctx)
+	if cfg.Get(key) == "" {
+		b.lp.SendNotice(ctx, evt.RoomID, "server-wide "+key+" is empty, kupo.", linkpearl.RelatesTo(evt.ID))
+		return
+	}
+
+	cfg.Set(key, "")
+	err := b.cfg.SetBot(ctx, cfg)
+	if err != nil {
+		b.Error(ctx, "cannot set bot config: %v", err)
+		return
+	}
+	b.lp.SendNotice(ctx, evt.RoomID, "server-wide "+key+" has been reset, kupo.", linkpearl.RelatesTo(evt.ID))
+}
+
+func (b *Bot) runQuarantineRoom(ctx context.Context, commandSlice []string) {
+	evt := eventFromContext(ctx)
+	cfg := b.cfg.GetBot(ctx)
+	if len(commandSlice) < 2 {
+		var msg strings.Builder
+		msg.WriteString("Currently: `")
+		if cfg.QuarantineRoom() != "" {
+			msg.WriteString(cfg.QuarantineRoom().String())
+		} else {
+			msg.WriteString("not set")
+		}
+		msg.WriteString("`, quarantined emails: ")
+		b.mu.Lock(quarantineLock)
+		msg.WriteString(strconv.Itoa(len(b.cfg.GetQuarantine(ctx))))
+		b.mu.Unlock(quarantineLock)
+		msg.WriteString("\n\n")
+		msg.WriteString("Usage: `")
+		msg.WriteString(b.prefix)
+		msg.WriteString(" quarantine:room ROOM` ")
+		msg.WriteString("where ROOM is ID or alias of a room to post suspicious emails to (`reset` to disable)\n")
+
+		b.lp.SendNotice(ctx, evt.RoomID, msg.String(), linkpearl.RelatesTo(evt.ID))
+		return
+	}
+
+	room := b.parseCommand(evt.Content.AsMessage().Body, false)[1] // get original value, without forced lower case
+	var roomID id.RoomID
+	switch {
+	case room == "reset":
+	case strings.HasPrefix(room, "#"):
+		resp, err := b.lp.GetClient().ResolveAlias(ctx, id.RoomAlias(room))
+		if err != nil {
+			b.Error(ctx, "cannot resolve room alias %s: %v", room, err)
+			return
+		}
+		roomID = resp.RoomID
+	default:
+		roomID = id.RoomID(room)
+	}
+
+	cfg.Set(config.BotQuarantineRoom, roomID.String())
+	err := b.cfg.SetBot(ctx, cfg)
+	if err != nil {
+		b.Error(ctx, "cannot set bot config: %v", err)
+		return
+	}
+	if roomID == "" {
+		b.lp.SendNotice(ctx, evt.RoomID, "quarantine room has been disabled, kupo", linkpearl.RelatesTo(evt.ID))
+		return
+	}
+	b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("quarantine room is set to: `%s`, kupo", roomID), linkpearl.RelatesTo(evt.ID))
+}
+
+func (b *Bot) runQuarantineRetention(ctx context.Context, commandSlice []string) {
+	evt := eventFromContext(ctx)
+	cfg := b.cfg.GetBot(ctx)
+	if len(commandSlice) < 2 {
+		b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("quarantined emails are kept for %d days", cfg.QuarantineRetention()), linkpearl.RelatesTo(evt.ID))
+		return
+	}
+	cfg.Set(config.BotQuarantineRetention, utils.SanitizeIntString(commandSlice[1]))
+	err := b.cfg.SetBot(ctx, cfg)
+	if err != nil {
+		b.Error(ctx, "cannot set bot config: %v", err)
+		return
+	}
+	b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("quarantined emails will be kept for %d days", cfg.QuarantineRetention()), linkpearl.RelatesTo(evt.ID))
+}
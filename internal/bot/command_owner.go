@@ -28,13 +28,15 @@ func (b *Bot) runStop(ctx context.Context) {
 		return
 	}
 
-	b.rooms.Delete(mailbox)
+	b.removeRoom(evt.RoomID, cfg)
 
 	err = b.cfg.SetRoom(ctx, evt.RoomID, config.Room{})
 	if err != nil {
 		b.Error(ctx, "cannot update settings: %v", err)
 		return
 	}
+	b.revokeAPIToken(ctx, evt.RoomID.String())
+	b.removeArchive(ctx, evt.RoomID)
 
 	b.lp.SendNotice(ctx, evt.RoomID, "mailbox has been disabled", linkpearl.RelatesTo(evt.ID, cfg.NoThreads()))
 }
@@ -51,10 +53,16 @@ func (b *Bot) handleOption(ctx context.Context, cmd []string) {
 		b.setMailbox(ctx, cmd[1])
 	case config.RoomAliases:
 		b.setAliases(ctx, cmd[1])
+	case config.RoomDomain:
+		b.setDomain(ctx, cmd[1])
 	case config.RoomPassword:
 		b.setPassword(ctx)
 	case config.RoomRelay:
 		b.setRelay(ctx)
+	case config.RoomWebhooks:
+		b.setWebhooks(ctx)
+	case config.RoomSieve:
+		b.setSieve(ctx)
 	default:
 		b.setOption(ctx, cmd[0], cmd[1])
 	}
@@ -68,10 +76,7 @@ func (b *Bot) getOption(ctx context.Context, name string) {
 		return
 	}
 
-	if name == commandSpamlist {
-		name = config.RoomSpamlist
-	}
-
+	name = optionKey(name)
 	value := cfg.Get(name)
 	if value == "" {
 		msg := fmt.Sprintf("`%s` is not set, kupo.\n"+
@@ -82,7 +87,7 @@ func (b *Bot) getOption(ctx context.Context, name string) {
 	}
 
 	if name == config.RoomMailbox {
-		value = utils.EmailsList(value, cfg.Domain())
+		value = cfg.Emails(value)
 	}
 
 	msg := fmt.Sprintf("`%s` of this room is:\n```\n%s\n```\n"+
@@ -98,28 +103,44 @@ func (b *Bot) getOption(ctx context.Context, name string) {
 	b.lp.SendNotice(ctx, evt.RoomID, msg, linkpearl.RelatesTo(evt.ID, cfg.NoThreads()))
 }
 
+// setMailbox sets the mailbox of the room, `name@domain` claims the mailbox on that domain only
 func (b *Bot) setMailbox(ctx context.Context, value string) {
 	evt := eventFromContext(ctx)
-	existingID, ok := b.getMapping(value)
-	if (ok && existingID != "" && existingID != evt.RoomID) || b.isReserved(value) {
-		b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("Mailbox `%s` (%s) already taken, kupo", value, utils.EmailsList(value, "")))
-		return
-	}
-
 	cfg, err := b.cfg.GetRoom(ctx, evt.RoomID)
 	if err != nil {
 		b.Error(ctx, "failed to retrieve settings: %v", err)
 		return
 	}
-	old := cfg.Get(config.RoomMailbox)
-	cfg.Set(config.RoomMailbox, value)
+
+	mailbox := utils.Mailbox(value)
+	var domain string
+	if strings.Contains(value, "@") {
+		domain = utils.Hostname(value)
+		if !slices.Contains(utils.Domains(), domain) {
+			b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("Domain `%s` is not served by this bot, kupo", domain), linkpearl.RelatesTo(evt.ID, cfg.NoThreads()))
+			return
+		}
+	}
+	for _, name := range append([]string{mailbox}, cfg.Aliases()...) {
+		if b.mailboxTaken(evt.RoomID, name, domain) {
+			b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("Mailbox `%s` (%s) already taken, kupo", name, utils.EmailsList(name, domain)), linkpearl.RelatesTo(evt.ID, cfg.NoThreads()))
+			return
+		}
+	}
+
+	b.removeRoom(evt.RoomID, cfg)
+	cfg.Set(config.RoomMailbox, mailbox)
 	cfg.Set(config.RoomOwner, evt.Sender.String())
-	if old != "" {
-		b.rooms.Delete(old)
+	cfg.Set(config.RoomDomainScoped, "")
+	if domain != "" {
+		cfg.Set(config.RoomDomain, domain)
+		cfg.Set(config.RoomDomainScoped, "true")
 	}
-	active := b.ActivateMailbox(ctx, evt.Sender, evt.RoomID, value)
+	active := b.ActivateMailbox(ctx, evt.Sender, evt.RoomID, cfg.MailboxKey(mailbox))
 	cfg.Set(config.RoomActive, strconv.FormatBool(active))
-	value = fmt.Sprintf("%s@%s", value, utils.SanitizeDomain(cfg.Domain()))
+	if active {
+		b.addRoom(evt.RoomID, cfg)
+	}
 
 	err = b.cfg.SetRoom(ctx, evt.RoomID, cfg)
 	if err != nil {
@@ -127,39 +148,49 @@ func (b *Bot) setMailbox(ctx context.Context, value string) {
 		return
 	}
 
-	msg := fmt.Sprintf("mailbox of this room set to `%s`", value)
+	msg := fmt.Sprintf("mailbox of this room set to `%s`", cfg.Emails(mailbox))
 	b.lp.SendNotice(ctx, evt.RoomID, msg, linkpearl.RelatesTo(evt.ID, cfg.NoThreads()))
 }
 
 func (b *Bot) setAliases(ctx context.Context, value string) {
 	evt := eventFromContext(ctx)
-	aliases := strings.Split(value, ",")
-	for _, alias := range aliases {
-		existingID, ok := b.getMapping(alias)
-		if (ok && existingID != "" && existingID != evt.RoomID) || b.isReserved(alias) {
-			b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("Mailbox `%s` (%s) already taken, kupo", value, utils.EmailsList(value, "")))
-			return
-		}
-	}
-
 	cfg, err := b.cfg.GetRoom(ctx, evt.RoomID)
 	if err != nil {
 		b.Error(ctx, "failed to retrieve settings: %v", err)
 		return
 	}
-	old := cfg.Get(config.RoomAliases)
-	oldAliases := utils.StringSlice(old)
-	cfg.Set(config.RoomAliases, value)
-	cfg.Set(config.RoomOwner, evt.Sender.String())
-	if old != "" {
-		b.rooms.Delete(old)
+
+	var domain string
+	if cfg.DomainScoped() {
+		domain = cfg.Domain()
+	}
+	aliases := []string{}
+	for _, alias := range utils.StringSlice(value) {
+		alias = utils.Mailbox(alias)
+		if alias == "" || slices.Contains(aliases, alias) {
+			continue
+		}
+		if b.mailboxTaken(evt.RoomID, alias, domain) {
+			b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("Mailbox `%s` (%s) already taken, kupo", alias, utils.EmailsList(alias, domain)), linkpearl.RelatesTo(evt.ID, cfg.NoThreads()))
+			return
+		}
+		aliases = append(aliases, alias)
+	}
+
+	oldAliases := cfg.Aliases()
+	for _, alias := range oldAliases {
+		b.rooms.CompareAndDelete(cfg.MailboxKey(alias), evt.RoomID)
 	}
+	cfg.Set(config.RoomAliases, utils.SliceString(aliases))
+	cfg.Set(config.RoomOwner, evt.Sender.String())
 	valueParts := make([]string, 0, len(aliases))
 	for _, alias := range aliases {
-		if !slices.Contains(oldAliases, alias) {
-			b.ActivateAlias(ctx, evt.Sender, evt.RoomID, value)
+		if slices.Contains(oldAliases, alias) {
+			b.rooms.Store(cfg.MailboxKey(alias), evt.RoomID)
+		} else {
+			b.ActivateAlias(ctx, evt.Sender, evt.RoomID, cfg.MailboxKey(alias))
 		}
-		valueParts = append(valueParts, fmt.Sprintf("%s@%s", alias, utils.SanitizeDomain(cfg.Domain())))
+		valueParts = append(valueParts, cfg.Emails(alias))
 	}
 	value = utils.SliceString(valueParts)
 
@@ -173,6 +204,28 @@ func (b *Bot) setAliases(ctx context.Context, value string) {
 	b.lp.SendNotice(ctx, evt.RoomID, msg, linkpearl.RelatesTo(evt.ID, cfg.NoThreads()))
 }
 
+// setDomain sets the domain of the room, domain-scoped mailboxes are moved to the new domain
+func (b *Bot) setDomain(ctx context.Context, value string) {
+	evt := eventFromContext(ctx)
+	cfg, err := b.cfg.GetRoom(ctx, evt.RoomID)
+	if err != nil {
+		b.Error(ctx, "failed to retrieve settings: %v", err)
+		return
+	}
+	if !cfg.DomainScoped() {
+		b.setOption(ctx, config.RoomDomain, value)
+		return
+	}
+
+	domain := strings.ToLower(strings.TrimSpace(value))
+	if !slices.Contains(utils.Domains(), domain) {
+		b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("Mailbox of this room is claimed on its domain only, the domain must be one of the served domains, kupo.\n"+
+			"To claim the mailbox on all domains, send a `%s %s %s` command.", b.prefix, config.RoomMailbox, cfg.Mailbox()), linkpearl.RelatesTo(evt.ID, cfg.NoThreads()))
+		return
+	}
+	b.setMailbox(ctx, cfg.Mailbox()+"@"+domain)
+}
+
 func (b *Bot) setPassword(ctx context.Context) {
 	evt := eventFromContext(ctx)
 	cfg, err := b.cfg.GetRoom(ctx, evt.RoomID)
@@ -226,6 +279,7 @@ func (b *Bot) setOption(ctx context.Context, name, value string) {
 	if cmd != nil && cmd.sanitizer != nil {
 		value = cmd.sanitizer(value)
 	}
+	name = optionKey(name)
 
 	evt := eventFromContext(ctx)
 	cfg, err := b.cfg.GetRoom(ctx, evt.RoomID)
@@ -260,10 +314,11 @@ func (b *Bot) setOption(ctx context.Context, name, value string) {
 	b.lp.SendNotice(ctx, evt.RoomID, msg, linkpearl.RelatesTo(evt.ID, cfg.NoThreads()))
 }
 
-func (b *Bot) runSpamlistAdd(ctx context.Context, commandSlice []string) {
+// runListAdd adds items to the room's list option (spamlist or allowlist)
+func (b *Bot) runListAdd(ctx context.Context, key string, commandSlice []string) {
 	evt := eventFromContext(ctx)
 	if len(commandSlice) < 2 {
-		b.getOption(ctx, config.RoomSpamlist)
+		b.getOption(ctx, key)
 		return
 	}
 	cfg, err := b.cfg.GetRoom(ctx, evt.RoomID)
@@ -271,16 +326,9 @@ func (b *Bot) runSpamlistAdd(ctx context.Context, commandSlice []string) {
 		b.Error(ctx, "cannot get room settings: %v", err)
 		return
 	}
-	spamlist := utils.StringSlice(cfg[config.RoomSpamlist])
-	for _, newItem := range commandSlice[1:] {
-		newItem = strings.TrimSpace(newItem)
-		if slices.Contains(spamlist, newItem) {
-			continue
-		}
-		spamlist = append(spamlist, newItem)
-	}
+	list, _ := addListItems(utils.StringSlice(cfg[key]), commandSlice[1:])
 
-	cfg.Set(config.RoomSpamlist, utils.SliceString(spamlist))
+	cfg.Set(key, utils.SliceString(list))
 	err = b.cfg.SetRoom(ctx, evt.RoomID, cfg)
 	if err != nil {
 		b.Error(ctx, "cannot store room settings: %v", err)
@@ -292,13 +340,14 @@ func (b *Bot) runSpamlistAdd(ctx context.Context, commandSlice []string) {
 		threadID = evt.ID
 	}
 
-	b.lp.SendNotice(ctx, evt.RoomID, "spamlist has been updated, kupo", linkpearl.RelatesTo(threadID, cfg.NoThreads()))
+	b.lp.SendNotice(ctx, evt.RoomID, key+" has been updated, kupo", linkpearl.RelatesTo(threadID, cfg.NoThreads()))
 }
 
-func (b *Bot) runSpamlistRemove(ctx context.Context, commandSlice []string) {
+// runListRemove removes items from the room's list option (spamlist or allowlist)
+func (b *Bot) runListRemove(ctx context.Context, key string, commandSlice []string) {
 	evt := eventFromContext(ctx)
 	if len(commandSlice) < 2 {
-		b.getOption(ctx, config.RoomSpamlist)
+		b.getOption(ctx, key)
 		return
 	}
 	cfg, err := b.cfg.GetRoom(ctx, evt.RoomID)
@@ -306,58 +355,68 @@ func (b *Bot) runSpamlistRemove(ctx context.Context, commandSlice []string) {
 		b.Error(ctx, "cannot get room settings: %v", err)
 		return
 	}
-	toRemove := map[int]struct{}{}
-	spamlist := utils.StringSlice(cfg[config.RoomSpamlist])
-	for _, item := range commandSlice[1:] {
-		item = strings.TrimSpace(item)
-		idx := slices.Index(spamlist, item)
-		if idx < 0 {
-			continue
-		}
-		toRemove[idx] = struct{}{}
-	}
-	if len(toRemove) == 0 {
+	list, changed := removeListItems(utils.StringSlice(cfg[key]), commandSlice[1:])
+	if !changed {
 		b.lp.SendNotice(ctx, evt.RoomID, "nothing new, kupo.", linkpearl.RelatesTo(evt.ID, cfg.NoThreads()))
 		return
 	}
 
-	updatedSpamlist := []string{}
-	for i, item := range spamlist {
-		if _, ok := toRemove[i]; ok {
-			continue
-		}
-		updatedSpamlist = append(updatedSpamlist, item)
-	}
-
-	cfg.Set(config.RoomSpamlist, utils.SliceString(updatedSpamlist))
+	cfg.Set(key, utils.SliceString(list))
 	err = b.cfg.SetRoom(ctx, evt.RoomID, cfg)
 	if err != nil {
 		b.Error(ctx, "cannot store room settings: %v", err)
 		return
 	}
 
-	b.lp.SendNotice(ctx, evt.RoomID, "spamlist has been updated, kupo", linkpearl.RelatesTo(evt.ID, cfg.NoThreads()))
+	b.lp.SendNotice(ctx, evt.RoomID, key+" has been updated, kupo", linkpearl.RelatesTo(evt.ID, cfg.NoThreads()))
 }
 
-func (b *Bot) runSpamlistReset(ctx context.Context) {
+// runListReset empties the room's list option (spamlist or allowlist)
+func (b *Bot) runListReset(ctx context.Context, key string) {
 	evt := eventFromContext(ctx)
 	cfg, err := b.cfg.GetRoom(ctx, evt.RoomID)
 	if err != nil {
 		b.Error(ctx, "cannot get room settings: %v", err)
 		return
 	}
-	spamlist := utils.StringSlice(cfg[config.RoomSpamlist])
-	if len(spamlist) == 0 {
-		b.lp.SendNotice(ctx, evt.RoomID, "spamlist is empty, kupo.", linkpearl.RelatesTo(evt.ID, cfg.NoThreads()))
+	list := utils.StringSlice(cfg[key])
+	if len(list) == 0 {
+		b.lp.SendNotice(ctx, evt.RoomID, key+" is empty, kupo.", linkpearl.RelatesTo(evt.ID, cfg.NoThreads()))
 		return
 	}
 
-	cfg.Set(config.RoomSpamlist, "")
+	cfg.Set(key, "")
 	err = b.cfg.SetRoom(ctx, evt.RoomID, cfg)
 	if err != nil {
 		b.Error(ctx, "cannot store room settings: %v", err)
 		return
 	}
 
-	b.lp.SendNotice(ctx, evt.RoomID, "spamlist has been reset, kupo.", linkpearl.RelatesTo(evt.ID, cfg.NoThreads()))
+	b.lp.SendNotice(ctx, evt.RoomID, key+" has been reset, kupo.", linkpearl.RelatesTo(evt.ID, cfg.NoThreads()))
+}
+
+// addListItems appends new items to the list, returns the updated list and whether it has been changed
+func addListItems(list, items []string) ([]string, bool) {
+	var changed bool
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" || slices.Contains(list, item) {
+			continue
+		}
+		list = append(list, item)
+		changed = true
+	}
+	return list, changed
+}
+
+// removeListItems removes items from the list, returns the updated list and whether it has been changed
+func removeListItems(list, items []string) ([]string, bool) {
+	updated := make([]string, 0, len(list))
+	for _, item := range list {
+		if slices.ContainsFunc(items, func(toRemove string) bool { return strings.TrimSpace(toRemove) == item }) {
+			continue
+		}
+		updated = append(updated, item)
+	}
+	return updated, len(updated) != len(list)
 }
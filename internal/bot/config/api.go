@@ -0,0 +1,33 @@
+package config
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+)
+
+// account data key
+const acAPITokensKey = "cc.etke.postmoogle.api"
+
+// APIScopeAdmin is the scope of the admin API token, other tokens are scoped to mailbox rooms (room ID)
+const APIScopeAdmin = "admin"
+
+// APITokens config, scope = SHA-256 hash of the token (hex), raw tokens are never stored
+type APITokens map[string]string
+
+// HashAPIToken returns SHA-256 hash of the token
+func HashAPIToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// Scope returns scope of the token
+func (t APITokens) Scope(token string) (string, bool) {
+	hash := []byte(HashAPIToken(token))
+	for scope, stored := range t {
+		if subtle.ConstantTimeCompare(hash, []byte(stored)) == 1 {
+			return scope, true
+		}
+	}
+	return "", false
+}
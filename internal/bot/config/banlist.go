@@ -0,0 +1,131 @@
+package config
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/etkecc/postmoogle/internal/utils"
+)
+
+// BanlistWindowDefault is the default time window to count failures in for automatic bans
+const BanlistWindowDefault = time.Hour
+
+// Ban is a banlist entry
+type Ban struct {
+	// Target is IP or CIDR
+	Target   string
+	BannedAt time.Time
+	// Expires is zero for permanent bans
+	Expires time.Time
+	Reason  string
+}
+
+// Permanent ban
+func (b *Ban) Permanent() bool {
+	return b.Expires.IsZero()
+}
+
+// Expired ban
+func (b *Ban) Expired(now time.Time) bool {
+	return !b.Permanent() && !b.Expires.After(now)
+}
+
+// Banlist config, target (IP or CIDR) = "BANNED_AT;EXPIRES;REASON" (older versions stored BANNED_AT only)
+type Banlist map[string]string
+
+// BanTarget converts IP or CIDR to the banlist key
+func BanTarget(str string) (string, error) {
+	prefix, err := utils.ParsePrefix(str)
+	if err != nil {
+		return "", err
+	}
+	if prefix.IsSingleIP() {
+		return prefix.Addr().String(), nil
+	}
+	return prefix.String(), nil
+}
+
+// Get ban of the target
+func (l Banlist) Get(target string) (*Ban, bool) {
+	value, ok := l[target]
+	if !ok {
+		return nil, false
+	}
+
+	parts := strings.SplitN(value, ";", 3)
+	ban := &Ban{Target: target}
+	ban.BannedAt, _ = time.Parse(time.RFC1123Z, parts[0]) //nolint:errcheck // zero time is ok
+	if len(parts) > 1 && parts[1] != "" {
+		ban.Expires, _ = time.Parse(time.RFC1123Z, parts[1]) //nolint:errcheck // zero time = permanent
+	}
+	if len(parts) > 2 {
+		ban.Reason = parts[2]
+	}
+	return ban, true
+}
+
+// Add (or update) a ban of the target, ttl = 0 means permanent ban
+func (l Banlist) Add(target string, ttl time.Duration, reason string) {
+	now := time.Now().UTC()
+	var expires string
+	if ttl > 0 {
+		expires = now.Add(ttl).Format(time.RFC1123Z)
+	}
+	reason = strings.Join(strings.Fields(reason), " ")
+	l[target] = now.Format(time.RFC1123Z) + ";" + expires + ";" + reason
+}
+
+// Remove a ban of the target
+func (l Banlist) Remove(target string) bool {
+	if _, ok := l[target]; !ok {
+		return false
+	}
+	delete(l, target)
+	return true
+}
+
+// Slice returns bans sorted by target
+func (l Banlist) Slice() []*Ban {
+	targets := make([]string, 0, len(l))
+	for target := range l {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	bans := make([]*Ban, 0, len(targets))
+	for _, target := range targets {
+		ban, _ := l.Get(target)
+		bans = append(bans, ban)
+	}
+	return bans
+}
+
+// Prune removes expired bans, returns count of removed bans
+func (l Banlist) Prune(now time.Time) int {
+	var removed int
+	for target := range l {
+		if ban, _ := l.Get(target); ban.Expired(now) {
+			delete(l, target)
+			removed++
+		}
+	}
+	return removed
+}
+
+// PrefixSet returns active bans as a set for fast IP matching
+func (l Banlist) PrefixSet(now time.Time) *utils.PrefixSet[*Ban] {
+	set := utils.NewPrefixSet[*Ban]()
+	for target := range l {
+		ban, _ := l.Get(target)
+		if ban.Expired(now) {
+			continue
+		}
+		prefix, err := utils.ParsePrefix(target)
+		if err != nil {
+			continue
+		}
+		set.Add(prefix, ban)
+	}
+	return set
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"strings"
+	"time"
 
 	"maunium.net/go/mautrix/id"
 
@@ -18,12 +19,25 @@ const (
 	BotCatchAll            = "catch-all"
 	BotDKIMSignature       = "dkim.pub"
 	BotDKIMPrivateKey      = "dkim.pem"
+	BotDomains             = "domains"
+	BotSRSSecret           = "srs.secret"
 	BotQueueBatch          = "queue:batch"
 	BotQueueRetries        = "queue:retries"
 	BotBanlistEnabled      = "banlist:enabled"
 	BotBanlistAuto         = "banlist:auto"
 	BotBanlistAuth         = "banlist:auth"
+	BotBanlistThreshold    = "banlist:threshold"
+	BotBanlistWindow       = "banlist:window"
+	BotBanlistDuration     = "banlist:duration"
 	BotGreylist            = "greylist"
+	BotGreylistWhitelist   = "greylist:whitelist"
+	BotGreylistExempt      = "greylist:exempt"
+	BotDNSBLThreshold      = "dnsbl:threshold"
+	BotPolicyRooms         = "policy:rooms"
+	BotSpamlist            = "spamlist"
+	BotAllowlist           = "allowlist"
+	BotQuarantineRoom      = "quarantine:room"
+	BotQuarantineRetention = "quarantine:retention"
 	BotMautrix015Migration = "mautrix015migration"
 )
 
@@ -90,11 +104,82 @@ func (s Bot) BanlistAuth() bool {
 	return utils.Bool(s.Get(BotBanlistAuth))
 }
 
+// BanlistThreshold option (failures count to ban automatically)
+func (s Bot) BanlistThreshold() int {
+	threshold := utils.Int(s.Get(BotBanlistThreshold))
+	if threshold <= 0 {
+		return 1
+	}
+	return threshold
+}
+
+// BanlistWindow option (time window to count failures in)
+func (s Bot) BanlistWindow() time.Duration {
+	minutes := utils.Int(s.Get(BotBanlistWindow))
+	if minutes <= 0 {
+		return BanlistWindowDefault
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// BanlistDuration option (duration of automatic bans, 0 - permanent)
+func (s Bot) BanlistDuration() time.Duration {
+	return time.Duration(utils.Int(s.Get(BotBanlistDuration))) * time.Minute
+}
+
 // Greylist option (duration in minutes)
 func (s Bot) Greylist() int {
 	return utils.Int(s.Get(BotGreylist))
 }
 
+// GreylistWhitelist option (days to keep senders automatically whitelisted)
+func (s Bot) GreylistWhitelist() int {
+	days := utils.Int(s.Get(BotGreylistWhitelist))
+	if days <= 0 {
+		return GreylistWhitelistDefault
+	}
+	return days
+}
+
+// GreylistExempt option (domains and CIDRs that skip greylisting)
+func (s Bot) GreylistExempt() []string {
+	return utils.StringSlice(s.Get(BotGreylistExempt))
+}
+
+// PolicyRooms option (moderation policy rooms)
+func (s Bot) PolicyRooms() []id.RoomID {
+	return roomIDs(s.Get(BotPolicyRooms))
+}
+
+// QuarantineRoom option (room to post quarantined emails to)
+func (s Bot) QuarantineRoom() id.RoomID {
+	return id.RoomID(s.Get(BotQuarantineRoom))
+}
+
+// QuarantineRetention option (amount of days to keep quarantined emails)
+func (s Bot) QuarantineRetention() int {
+	days := utils.Int(s.Get(BotQuarantineRetention))
+	if days <= 0 {
+		return QuarantineRetentionDefault
+	}
+	return days
+}
+
+// Spamlist option (server-wide spamlist)
+func (s Bot) Spamlist() []string {
+	return utils.StringSlice(s.Get(BotSpamlist))
+}
+
+// Allowlist option (server-wide allowlist)
+func (s Bot) Allowlist() []string {
+	return utils.StringSlice(s.Get(BotAllowlist))
+}
+
+// DNSBLThreshold option (minimal total weight of DNSBL zones listing a host to block it)
+func (s Bot) DNSBLThreshold() int {
+	return utils.Int(s.Get(BotDNSBLThreshold))
+}
+
 // DKIMSignature (DNS TXT record)
 func (s Bot) DKIMSignature() string {
 	return s.Get(BotDKIMSignature)
@@ -105,6 +190,16 @@ func (s Bot) DKIMPrivateKey() string {
 	return s.Get(BotDKIMPrivateKey)
 }
 
+// Domains added at runtime (in addition to the domains from the config)
+func (s Bot) Domains() []string {
+	return utils.StringSlice(s.Get(BotDomains))
+}
+
+// SRSSecret keep it secret
+func (s Bot) SRSSecret() string {
+	return s.Get(BotSRSSecret)
+}
+
 // QueueBatch option
 func (s Bot) QueueBatch() int {
 	return utils.Int(s.Get(BotQueueBatch))
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/etkecc/postmoogle/internal/email"
+)
+
+// account data key
+const acDKIMKey = "cc.etke.postmoogle.dkim"
+
+// DKIMGracePeriod is the time between publishing DNS records of the new DKIM keys and signing with them,
+// and between retiring the old keys and forgetting them (so emails in flight can still be verified)
+const DKIMGracePeriod = 48 * time.Hour
+
+// DKIMUnpublishedNotifyInterval is the interval between notifications about the pending keys
+// that cannot be promoted, because their DNS records are not published
+const DKIMUnpublishedNotifyInterval = 24 * time.Hour
+
+// DKIMDomain is a set of DKIM keys of the domain
+type DKIMDomain struct {
+	// Active keys are used for signing (e.g. both RSA and ed25519 keys)
+	Active []email.DKIMKey `json:"active"`
+	// Pending keys should be published in DNS, they will be used for signing after the grace period
+	Pending      []email.DKIMKey `json:"pending,omitempty"`
+	PendingSince time.Time       `json:"pending_since"`
+	// PendingNotified is the time of the last notification about the unpublished pending keys
+	PendingNotified time.Time `json:"pending_notified"`
+	// Retired keys are not used for signing anymore, their DNS records can be removed after the grace period
+	Retired      []email.DKIMKey `json:"retired,omitempty"`
+	RetiredSince time.Time       `json:"retired_since"`
+}
+
+// Rotate promotes the pending keys and forgets the retired keys once the grace period has passed.
+// The pending keys are promoted only if DNS records of all of them are published, otherwise the current keys are kept.
+// Returns the promoted, forgotten, and unpublished (due, but not promoted) keys
+func (d *DKIMDomain) Rotate(now time.Time, published func(email.DKIMKey) bool) (promoted, forgotten, unpublished []email.DKIMKey) {
+	if len(d.Retired) > 0 && now.Sub(d.RetiredSince) >= DKIMGracePeriod {
+		forgotten = d.Retired
+		d.Retired = nil
+		d.RetiredSince = time.Time{}
+	}
+	if len(d.Pending) == 0 || now.Sub(d.PendingSince) < DKIMGracePeriod {
+		return promoted, forgotten, unpublished
+	}
+	for _, key := range d.Pending {
+		if !published(key) {
+			unpublished = append(unpublished, key)
+		}
+	}
+	if len(unpublished) > 0 {
+		return promoted, forgotten, unpublished
+	}
+
+	promoted = d.Pending
+	d.Retired = append(d.Retired, d.Active...)
+	d.RetiredSince = now
+	d.Active = d.Pending
+	d.Pending = nil
+	d.PendingSince = time.Time{}
+	d.PendingNotified = time.Time{}
+	return promoted, forgotten, unpublished
+}
+
+// NotifyUnpublished checks if the admins should be notified about the unpublished pending keys (once per DKIMUnpublishedNotifyInterval),
+// and records the notification time
+func (d *DKIMDomain) NotifyUnpublished(now time.Time) bool {
+	if now.Sub(d.PendingNotified) < DKIMUnpublishedNotifyInterval {
+		return false
+	}
+	d.PendingNotified = now
+	return true
+}
+
+// DKIM config, domain = JSON-encoded DKIMDomain
+type DKIM map[string]string
+
+// Domains returns sorted list of domains with DKIM keys
+func (d DKIM) Domains() []string {
+	domains := make([]string, 0, len(d))
+	for domain := range d {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+// Get DKIM keys of the domain, nil if the domain doesn't have its own keys
+func (d DKIM) Get(domain string) *DKIMDomain {
+	value, ok := d[strings.ToLower(strings.TrimSpace(domain))]
+	if !ok {
+		return nil
+	}
+	var keys *DKIMDomain
+	if err := json.Unmarshal([]byte(value), &keys); err != nil {
+		return nil
+	}
+	return keys
+}
+
+// Set DKIM keys of the domain, nil removes them
+func (d DKIM) Set(domain string, keys *DKIMDomain) {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if keys == nil {
+		delete(d, domain)
+		return
+	}
+	value, err := json.Marshal(keys)
+	if err != nil {
+		return
+	}
+	d[domain] = string(value)
+}
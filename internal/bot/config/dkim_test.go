@@ -0,0 +1,86 @@
+package config
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/etkecc/postmoogle/internal/email"
+)
+
+func TestDKIMDomainRotate(t *testing.T) {
+	now := time.Now().UTC()
+	active := email.DKIMKey{Selector: "active"}
+	pending := email.DKIMKey{Selector: "pending"}
+	retired := email.DKIMKey{Selector: "retired"}
+	tests := map[string]struct {
+		domain      DKIMDomain
+		published   bool
+		active      []email.DKIMKey
+		promoted    []email.DKIMKey
+		forgotten   []email.DKIMKey
+		unpublished []email.DKIMKey
+	}{
+		"nothing": {
+			domain:    DKIMDomain{Active: []email.DKIMKey{active}},
+			published: true,
+			active:    []email.DKIMKey{active},
+		},
+		"pending too early": {
+			domain:    DKIMDomain{Active: []email.DKIMKey{active}, Pending: []email.DKIMKey{pending}, PendingSince: now.Add(-time.Hour)},
+			published: true,
+			active:    []email.DKIMKey{active},
+		},
+		"promoted": {
+			domain:    DKIMDomain{Active: []email.DKIMKey{active}, Pending: []email.DKIMKey{pending}, PendingSince: now.Add(-DKIMGracePeriod)},
+			published: true,
+			active:    []email.DKIMKey{pending},
+			promoted:  []email.DKIMKey{pending},
+		},
+		"unpublished": {
+			domain:      DKIMDomain{Active: []email.DKIMKey{active}, Pending: []email.DKIMKey{pending}, PendingSince: now.Add(-DKIMGracePeriod)},
+			published:   false,
+			active:      []email.DKIMKey{active},
+			unpublished: []email.DKIMKey{pending},
+		},
+		"forgotten": {
+			domain:    DKIMDomain{Active: []email.DKIMKey{active}, Retired: []email.DKIMKey{retired}, RetiredSince: now.Add(-DKIMGracePeriod)},
+			published: false,
+			active:    []email.DKIMKey{active},
+			forgotten: []email.DKIMKey{retired},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			domain := test.domain
+			promoted, forgotten, unpublished := domain.Rotate(now, func(email.DKIMKey) bool { return test.published })
+			if !slices.Equal(test.promoted, promoted) {
+				t.Error(test.promoted, "!=", promoted)
+			}
+			if !slices.Equal(test.forgotten, forgotten) {
+				t.Error(test.forgotten, "!=", forgotten)
+			}
+			if !slices.Equal(test.unpublished, unpublished) {
+				t.Error(test.unpublished, "!=", unpublished)
+			}
+			if !slices.Equal(test.active, domain.Active) {
+				t.Error(test.active, "!=", domain.Active)
+			}
+		})
+	}
+}
+
+func TestDKIMDomainNotifyUnpublished(t *testing.T) {
+	now := time.Now().UTC()
+	domain := &DKIMDomain{}
+	if !domain.NotifyUnpublished(now) {
+		t.Error("first notification must be sent")
+	}
+	if domain.NotifyUnpublished(now.Add(time.Hour)) {
+		t.Error("notification must not be sent again within the interval")
+	}
+	if !domain.NotifyUnpublished(now.Add(DKIMUnpublishedNotifyInterval)) {
+		t.Error("notification must be sent after the interval")
+	}
+}
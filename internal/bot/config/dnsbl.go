@@ -0,0 +1,104 @@
+package config
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/etkecc/postmoogle/internal/email"
+)
+
+// account data key
+const acDNSBLKey = "cc.etke.postmoogle.dnsbl"
+
+const (
+	// DNSBLDefaultSignal is the default signal (return code) of DNSBLs
+	DNSBLDefaultSignal = "127.0.0.2"
+	// DNSBLDefaultWeight is the default weight of DNSBL zone
+	DNSBLDefaultWeight = 1
+)
+
+// defaultDNSBLs is a list of Domain Name System Blacklists with list of signals they use,
+// used when DNSBL list is empty
+var defaultDNSBLs = map[string][]string{
+	"b.barracudacentral.org":  {DNSBLDefaultSignal},
+	"bl.spamcop.net":          {DNSBLDefaultSignal, "127.0.0.3"},
+	"ix.dnsbl.manitu.net":     {DNSBLDefaultSignal},
+	"psbl.surriel.com":        {DNSBLDefaultSignal},
+	"rbl.interserver.net":     {DNSBLDefaultSignal},
+	"spam.dnsbl.anonmails.de": {DNSBLDefaultSignal},
+	"zen.spamhaus.org":        {DNSBLDefaultSignal, "127.0.0.3", "127.0.0.4", "127.0.0.5", "127.0.0.6", "127.0.0.7", "127.0.0.9"},
+	"rbl.your-server.de":      {DNSBLDefaultSignal},
+}
+
+// DNSBL config, zone = "WEIGHT SIGNAL1 SIGNAL2..."
+type DNSBL map[string]string
+
+// DefaultDNSBL returns default DNSBL config
+func DefaultDNSBL() DNSBL {
+	dnsbl := make(DNSBL, len(defaultDNSBLs))
+	for zone, signals := range defaultDNSBLs {
+		dnsbl.Set(zone, DNSBLDefaultWeight, signals)
+	}
+	return dnsbl
+}
+
+// Slice returns sorted list of zones
+func (l DNSBL) Slice() []string {
+	slice := make([]string, 0, len(l))
+	for zone := range l {
+		slice = append(slice, zone)
+	}
+	sort.Strings(slice)
+
+	return slice
+}
+
+// Get zone
+func (l DNSBL) Get(zone string) *email.DNSBLZone {
+	zone = strings.ToLower(strings.TrimSpace(zone))
+	value, ok := l[zone]
+	if !ok {
+		return nil
+	}
+
+	parts := strings.Fields(value)
+	dnsblZone := &email.DNSBLZone{Name: zone, Weight: DNSBLDefaultWeight}
+	if len(parts) > 0 {
+		if weight, err := strconv.Atoi(parts[0]); err == nil {
+			dnsblZone.Weight = weight
+		}
+		dnsblZone.Signals = parts[1:]
+	}
+	if len(dnsblZone.Signals) == 0 {
+		dnsblZone.Signals = []string{DNSBLDefaultSignal}
+	}
+	return dnsblZone
+}
+
+// Set zone (add or update)
+func (l DNSBL) Set(zone string, weight int, signals []string) {
+	if len(signals) == 0 {
+		signals = []string{DNSBLDefaultSignal}
+	}
+	zone = strings.ToLower(strings.TrimSpace(zone))
+	l[zone] = strconv.Itoa(weight) + " " + strings.Join(signals, " ")
+}
+
+// Remove zone
+func (l DNSBL) Remove(zone string) {
+	delete(l, strings.ToLower(strings.TrimSpace(zone)))
+}
+
+// Options converts DNSBL config to options used by SMTP server
+func (l DNSBL) Options(threshold int) *email.DNSBLOptions {
+	zones := make([]*email.DNSBLZone, 0, len(l))
+	for _, zone := range l.Slice() {
+		zones = append(zones, l.Get(zone))
+	}
+
+	return &email.DNSBLOptions{
+		Zones:     zones,
+		Threshold: threshold,
+	}
+}
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/etkecc/postmoogle/internal/utils"
+)
+
+// account data key
+const acGreylistWhitelistKey = "cc.etke.postmoogle.greylist.whitelist"
+
+const (
+	// GreylistPendingTTL is how long a greylisted triplet waits for a retry before it is pruned
+	GreylistPendingTTL = 48 * time.Hour
+	// GreylistWhitelistDefault is the default amount of days to keep senders automatically whitelisted
+	GreylistWhitelistDefault = 30
+	// GreylistSpamDefault is the greylisting duration of emails the content scanner recommends to greylist,
+	// used when automatic greylisting is disabled
+	GreylistSpamDefault = 5 * time.Minute
+)
+
+// GreylistTriplet returns greylist key of the (client network, MAIL FROM, RCPT TO) triplet
+func GreylistTriplet(addr net.Addr, from, to string) string {
+	return utils.AddrNetwork(addr) + " " + greylistAddress(from) + " " + greylistAddress(to)
+}
+
+// GreylistSender returns whitelist key of the (client network, sender domain) pair
+func GreylistSender(addr net.Addr, from string) string {
+	domain := utils.Hostname(from)
+	if domain == "" {
+		domain = "<>"
+	}
+	return utils.AddrNetwork(addr) + " " + domain
+}
+
+// IsGreylistTriplet checks if the greylist key is a triplet (older versions used IP only)
+func IsGreylistTriplet(key string) bool {
+	return strings.Count(key, " ") == 2
+}
+
+// GreylistExempt checks if the client address or the sender domain (including subdomains)
+// matches any of the exempt items (domains, IPs, and CIDRs).
+// MAIL FROM can be forged, so the sender domain is exempt only if one of the authenticated domains
+// (MAIL FROM domain with SPF pass, DKIM signing domains) matches the same exempt item
+func GreylistExempt(addr net.Addr, from string, authenticated, exempt []string) bool {
+	ip := net.ParseIP(utils.AddrIP(addr))
+	domain := utils.Hostname(from)
+	for _, item := range exempt {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+		if strings.Contains(item, "/") {
+			if _, network, err := net.ParseCIDR(item); err == nil && ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if itemIP := net.ParseIP(item); itemIP != nil {
+			if itemIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		if domainExempt(domain, item) && slices.ContainsFunc(authenticated, func(authDomain string) bool {
+			return domainExempt(strings.ToLower(authDomain), item)
+		}) {
+			return true
+		}
+	}
+	return false
+}
+
+// domainExempt checks if the domain is the exempt item or its subdomain
+func domainExempt(domain, item string) bool {
+	return domain != "" && (domain == item || strings.HasSuffix(domain, "."+item))
+}
+
+// greylistAddress normalizes email address for greylist keys, null sender is "<>"
+func greylistAddress(address string) string {
+	address = strings.ToLower(strings.TrimSpace(address))
+	if address == "" {
+		return "<>"
+	}
+	return address
+}
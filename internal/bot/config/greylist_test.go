@@ -0,0 +1,37 @@
+package config
+
+import (
+	"net"
+	"testing"
+)
+
+func TestGreylistExempt(t *testing.T) {
+	exempt := []string{"example.com", "192.0.2.1", "198.51.100.0/24", " "}
+	tests := map[string]struct {
+		addr          string
+		from          string
+		authenticated []string
+		expected      bool
+	}{
+		"ip":                       {"192.0.2.1", "test@other.org", nil, true},
+		"cidr":                     {"198.51.100.7", "test@other.org", nil, true},
+		"other ip":                 {"203.0.113.1", "test@other.org", nil, false},
+		"domain authenticated":     {"203.0.113.1", "test@example.com", []string{"example.com"}, true},
+		"subdomain authenticated":  {"203.0.113.1", "test@mail.example.com", []string{"mail.example.com"}, true},
+		"dkim of parent domain":    {"203.0.113.1", "test@mail.example.com", []string{"other.org", "example.com"}, true},
+		"domain not authenticated": {"203.0.113.1", "test@example.com", nil, false},
+		"forged domain":            {"203.0.113.1", "test@example.com", []string{"spammer.org"}, false},
+		"authenticated other":      {"203.0.113.1", "test@other.org", []string{"example.com"}, false},
+		"suffix is not subdomain":  {"203.0.113.1", "test@notexample.com", []string{"notexample.com"}, false},
+		"null sender":              {"203.0.113.1", "", []string{"example.com"}, false},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			addr := &net.TCPAddr{IP: net.ParseIP(test.addr), Port: 25}
+			if actual := GreylistExempt(addr, test.from, test.authenticated, exempt); actual != test.expected {
+				t.Error(test.expected, "!=", actual)
+			}
+		})
+	}
+}
@@ -1,23 +1,21 @@
 package config
 
 import (
-	"net"
 	"sort"
 	"time"
-
-	"github.com/etkecc/postmoogle/internal/utils"
 )
 
 // account data keys
 const (
 	acBanlistKey  = "cc.etke.postmoogle.banlist"
 	acGreylistKey = "cc.etke.postmoogle.greylist"
+	acVacationKey = "cc.etke.postmoogle.vacation"
 )
 
-// List config
+// List config, key = time when it was added
 type List map[string]string
 
-// Slice returns slice of ban- or greylist items
+// Slice returns slice of list items
 func (l List) Slice() []string {
 	slice := make([]string, 0, len(l))
 	for item := range l {
@@ -28,15 +26,9 @@ func (l List) Slice() []string {
 	return slice
 }
 
-// Has addr in ban- or greylist
-func (l List) Has(addr net.Addr) bool {
-	_, ok := l[utils.AddrIP(addr)]
-	return ok
-}
-
-// Get when addr was added in ban- or greylist
-func (l List) Get(addr net.Addr) (time.Time, bool) {
-	from := l[utils.AddrIP(addr)]
+// GetTime returns when the key was added in the list
+func (l List) GetTime(key string) (time.Time, bool) {
+	from := l[key]
 	if from == "" {
 		return time.Time{}, false
 	}
@@ -48,22 +40,22 @@ func (l List) Get(addr net.Addr) (time.Time, bool) {
 	return t, true
 }
 
-// Add an addr to ban- or greylist
-func (l List) Add(addr net.Addr) {
-	key := utils.AddrIP(addr)
-	if _, ok := l[key]; ok {
-		return
-	}
-
-	l[key] = time.Now().UTC().Format(time.RFC1123Z)
+// SetTime sets when the key was added in the list
+func (l List) SetTime(key string, t time.Time) {
+	l[key] = t.UTC().Format(time.RFC1123Z)
 }
 
-// Remove an addr from ban- or greylist
-func (l List) Remove(addr net.Addr) {
-	key := utils.AddrIP(addr)
-	if _, ok := l[key]; !ok {
-		return
+// Prune removes items added before the cutoff time (and items with invalid time), returns count of removed items
+func (l List) Prune(cutoff time.Time) int {
+	var removed int
+	for key := range l {
+		t, ok := l.GetTime(key)
+		if ok && t.After(cutoff) {
+			continue
+		}
+		delete(l, key)
+		removed++
 	}
 
-	delete(l, key)
+	return removed
 }
@@ -2,6 +2,9 @@ package config
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"time"
 
 	"github.com/etkecc/go-linkpearl"
 	"github.com/rs/zerolog"
@@ -12,20 +15,25 @@ import (
 type Manager struct {
 	dkimPrivKey   string
 	dkimSignature string
+	dialect       string
 	log           *zerolog.Logger
 	lp            *linkpearl.Linkpearl
 }
 
-// New config manager
-func New(lp *linkpearl.Linkpearl, log *zerolog.Logger, dkimPrivKey, dkimSignature string) *Manager {
+// New config manager, dialect of the linkpearl's database is either postgres or sqlite
+func New(lp *linkpearl.Linkpearl, log *zerolog.Logger, dialect, dkimPrivKey, dkimSignature string) (*Manager, error) {
 	m := &Manager{
 		lp:            lp,
 		log:           log,
+		dialect:       dialect,
 		dkimPrivKey:   dkimPrivKey,
 		dkimSignature: dkimSignature,
 	}
+	if err := m.migrate(); err != nil {
+		return nil, err
+	}
 
-	return m
+	return m, nil
 }
 
 // GetBot config
@@ -83,10 +91,28 @@ func (m *Manager) SetRoom(ctx context.Context, roomID id.RoomID, cfg Room) error
 	return m.lp.SetRoomAccountData(ctx, roomID, acRoomKey, cfg)
 }
 
+// GetVacation returns Sieve vacation auto-replies sent from the room
+func (m *Manager) GetVacation(ctx context.Context, roomID id.RoomID) List {
+	config, err := m.lp.GetRoomAccountData(ctx, roomID, acVacationKey)
+	if err != nil {
+		m.log.Warn().Err(err).Str("room_id", roomID.String()).Msg("cannot get vacation auto-replies")
+	}
+	if config == nil {
+		config = make(List, 0)
+	}
+
+	return config
+}
+
+// SetVacation sets Sieve vacation auto-replies sent from the room
+func (m *Manager) SetVacation(ctx context.Context, roomID id.RoomID, cfg List) error {
+	return m.lp.SetRoomAccountData(ctx, roomID, acVacationKey, cfg)
+}
+
 // GetBanlist config
-func (m *Manager) GetBanlist(ctx context.Context) List {
+func (m *Manager) GetBanlist(ctx context.Context) Banlist {
 	if !m.GetBot(ctx).BanlistEnabled() {
-		return make(List, 0)
+		return make(Banlist, 0)
 	}
 
 	mu.Lock("manager_banlist")
@@ -96,14 +122,14 @@ func (m *Manager) GetBanlist(ctx context.Context) List {
 		m.log.Error().Err(err).Msg("cannot get banlist")
 	}
 	if config == nil {
-		config = make(List, 0)
+		config = make(Banlist, 0)
 		return config
 	}
 	return config
 }
 
 // SetBanlist config
-func (m *Manager) SetBanlist(ctx context.Context, cfg List) error {
+func (m *Manager) SetBanlist(ctx context.Context, cfg Banlist) error {
 	if !m.GetBot(ctx).BanlistEnabled() {
 		return nil
 	}
@@ -111,17 +137,69 @@ func (m *Manager) SetBanlist(ctx context.Context, cfg List) error {
 	mu.Lock("manager_banlist")
 	defer mu.Unlock("manager_banlist")
 	if cfg == nil {
-		cfg = make(List, 0)
+		cfg = make(Banlist, 0)
 	}
 
 	return m.lp.SetAccountData(ctx, acBanlistKey, cfg)
 }
 
+// GetDNSBL config, defaults are returned if the list is empty
+func (m *Manager) GetDNSBL(ctx context.Context) DNSBL {
+	mu.Lock("manager_dnsbl")
+	defer mu.Unlock("manager_dnsbl")
+	config, err := m.lp.GetAccountData(ctx, acDNSBLKey)
+	if err != nil {
+		m.log.Error().Err(err).Msg("cannot get dnsbl")
+	}
+	if len(config) == 0 {
+		return DefaultDNSBL()
+	}
+
+	return config
+}
+
+// SetDNSBL config
+func (m *Manager) SetDNSBL(ctx context.Context, cfg DNSBL) error {
+	mu.Lock("manager_dnsbl")
+	defer mu.Unlock("manager_dnsbl")
+	if cfg == nil {
+		cfg = make(DNSBL, 0)
+	}
+
+	return m.lp.SetAccountData(ctx, acDNSBLKey, cfg)
+}
+
+// GetDKIM config
+func (m *Manager) GetDKIM(ctx context.Context) DKIM {
+	mu.Lock("manager_dkim")
+	defer mu.Unlock("manager_dkim")
+	config, err := m.lp.GetAccountData(ctx, acDKIMKey)
+	if err != nil {
+		m.log.Error().Err(err).Msg("cannot get dkim")
+	}
+	if config == nil {
+		config = make(DKIM, 0)
+	}
+
+	return config
+}
+
+// SetDKIM config
+func (m *Manager) SetDKIM(ctx context.Context, cfg DKIM) error {
+	mu.Lock("manager_dkim")
+	defer mu.Unlock("manager_dkim")
+	if cfg == nil {
+		cfg = make(DKIM, 0)
+	}
+
+	return m.lp.SetAccountData(ctx, acDKIMKey, cfg)
+}
+
 // GetGreylist config
 func (m *Manager) GetGreylist(ctx context.Context) List {
 	config, err := m.lp.GetAccountData(ctx, acGreylistKey)
 	if err != nil {
-		m.log.Error().Err(err).Msg("cannot get banlist")
+		m.log.Error().Err(err).Msg("cannot get greylist")
 	}
 	if config == nil {
 		config = make(List, 0)
@@ -135,3 +213,106 @@ func (m *Manager) GetGreylist(ctx context.Context) List {
 func (m *Manager) SetGreylist(ctx context.Context, cfg List) error {
 	return m.lp.SetAccountData(ctx, acGreylistKey, cfg)
 }
+
+// GetGreylistWhitelist config
+func (m *Manager) GetGreylistWhitelist(ctx context.Context) List {
+	config, err := m.lp.GetAccountData(ctx, acGreylistWhitelistKey)
+	if err != nil {
+		m.log.Error().Err(err).Msg("cannot get greylist whitelist")
+	}
+	if config == nil {
+		config = make(List, 0)
+		return config
+	}
+
+	return config
+}
+
+// SetGreylistWhitelist config
+func (m *Manager) SetGreylistWhitelist(ctx context.Context, cfg List) error {
+	return m.lp.SetAccountData(ctx, acGreylistWhitelistKey, cfg)
+}
+
+// GetQuarantine index
+func (m *Manager) GetQuarantine(ctx context.Context) Quarantine {
+	config, err := m.lp.GetAccountData(ctx, acQuarantineKey)
+	if err != nil {
+		m.log.Error().Err(err).Msg("cannot get quarantine")
+	}
+	if config == nil {
+		config = make(Quarantine, 0)
+		return config
+	}
+
+	return config
+}
+
+// SetQuarantine index
+func (m *Manager) SetQuarantine(ctx context.Context, cfg Quarantine) error {
+	return m.lp.SetAccountData(ctx, acQuarantineKey, cfg)
+}
+
+// GetQuarantineRaw returns raw quarantined email by the notice event ID, nil if not found
+func (m *Manager) GetQuarantineRaw(ctx context.Context, eventID id.EventID) ([]byte, error) {
+	var raw []byte
+	err := m.lp.GetDB().QueryRowContext(ctx, `SELECT raw FROM postmoogle_quarantine WHERE event_id = $1`, eventID.String()).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return raw, err
+}
+
+// SetQuarantineRaw saves raw quarantined email locally, so it never leaves the server
+func (m *Manager) SetQuarantineRaw(ctx context.Context, eventID id.EventID, raw []byte) error {
+	_, err := m.lp.GetDB().ExecContext(ctx,
+		`INSERT INTO postmoogle_quarantine (event_id, created_at, raw) VALUES ($1, $2, $3)`,
+		eventID.String(), time.Now().Unix(), raw,
+	)
+	return err
+}
+
+// RemoveQuarantineRaw deletes raw quarantined email
+func (m *Manager) RemoveQuarantineRaw(ctx context.Context, eventID id.EventID) error {
+	_, err := m.lp.GetDB().ExecContext(ctx, `DELETE FROM postmoogle_quarantine WHERE event_id = $1`, eventID.String())
+	return err
+}
+
+// GetAPITokens config
+func (m *Manager) GetAPITokens(ctx context.Context) APITokens {
+	mu.Lock("manager_api")
+	defer mu.Unlock("manager_api")
+	config, err := m.lp.GetAccountData(ctx, acAPITokensKey)
+	if err != nil {
+		m.log.Error().Err(err).Msg("cannot get api tokens")
+	}
+	if config == nil {
+		config = make(APITokens, 0)
+	}
+
+	return config
+}
+
+// SetAPITokens config
+func (m *Manager) SetAPITokens(ctx context.Context, cfg APITokens) error {
+	mu.Lock("manager_api")
+	defer mu.Unlock("manager_api")
+	if cfg == nil {
+		cfg = make(APITokens, 0)
+	}
+
+	return m.lp.SetAccountData(ctx, acAPITokensKey, cfg)
+}
+
+// migrate creates tables of the data that doesn't fit into account data
+func (m *Manager) migrate() error {
+	blob := "BLOB"
+	if m.dialect == "postgres" {
+		blob = "BYTEA"
+	}
+	_, err := m.lp.GetDB().Exec(`CREATE TABLE IF NOT EXISTS postmoogle_quarantine (
+		event_id   TEXT PRIMARY KEY,
+		created_at BIGINT NOT NULL,
+		raw        ` + blob + ` NOT NULL
+	)`)
+	return err
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// account data key
+const acQuarantineKey = "cc.etke.postmoogle.quarantine"
+
+// QuarantineRetentionDefault is the default amount of days to keep quarantined emails
+const QuarantineRetentionDefault = 7
+
+// QuarantineItem is a quarantined email
+type QuarantineItem struct {
+	// EventID of the quarantine notice (reactions are expected on it)
+	EventID id.EventID
+	// ContentID is the event ID of the email content, posted in the notice's thread
+	ContentID id.EventID
+	// RoomID of the quarantine room (or the mailbox room, if the mailbox has its own quarantine thread)
+	RoomID id.RoomID
+	// TargetRoomID is the mailbox room
+	TargetRoomID  id.RoomID
+	RcptTo        string
+	QuarantinedAt time.Time
+}
+
+// Quarantine index, notice event ID = "QUARANTINED_AT;ROOM_ID;TARGET_ROOM_ID;RCPT_TO;CONTENT_ID".
+// Raw emails are kept in the database, see Manager.GetQuarantineRaw
+type Quarantine map[string]string
+
+// Get quarantined email by the notice event ID
+func (q Quarantine) Get(eventID id.EventID) (*QuarantineItem, bool) {
+	value, ok := q[eventID.String()]
+	if !ok {
+		return nil, false
+	}
+
+	parts := strings.Split(value, ";")
+	if len(parts) < 5 {
+		return nil, false
+	}
+	item := &QuarantineItem{
+		EventID:      eventID,
+		RoomID:       id.RoomID(parts[1]),
+		TargetRoomID: id.RoomID(parts[2]),
+		RcptTo:       parts[3],
+		ContentID:    id.EventID(parts[4]),
+	}
+	item.QuarantinedAt, _ = time.Parse(time.RFC1123Z, parts[0]) //nolint:errcheck // zero time = expired
+	return item, true
+}
+
+// Add quarantined email
+func (q Quarantine) Add(item *QuarantineItem) {
+	q[item.EventID.String()] = strings.Join([]string{
+		item.QuarantinedAt.UTC().Format(time.RFC1123Z),
+		item.RoomID.String(),
+		item.TargetRoomID.String(),
+		item.RcptTo,
+		item.ContentID.String(),
+	}, ";")
+}
+
+// Remove quarantined email
+func (q Quarantine) Remove(eventID id.EventID) bool {
+	if _, ok := q[eventID.String()]; !ok {
+		return false
+	}
+	delete(q, eventID.String())
+	return true
+}
+
+// Expired returns quarantined emails older than the cutoff, oldest first
+func (q Quarantine) Expired(cutoff time.Time) []*QuarantineItem {
+	items := []*QuarantineItem{}
+	for eventID := range q {
+		item, ok := q.Get(id.EventID(eventID))
+		if !ok {
+			item = &QuarantineItem{EventID: id.EventID(eventID)}
+		}
+		if item.QuarantinedAt.Before(cutoff) {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].QuarantinedAt.Before(items[j].QuarantinedAt)
+	})
+	return items
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+)
+
+func testQuarantineItem(eventID id.EventID, quarantinedAt time.Time) *QuarantineItem {
+	return &QuarantineItem{
+		EventID:       eventID,
+		ContentID:     eventID + "_content",
+		RoomID:        "!quarantine:example.com",
+		TargetRoomID:  "!mailbox:example.com",
+		RcptTo:        "test@example.com",
+		QuarantinedAt: quarantinedAt.UTC().Truncate(time.Second),
+	}
+}
+
+func equalQuarantineItems(a, b *QuarantineItem) bool {
+	aCopy, bCopy := *a, *b
+	aCopy.QuarantinedAt, bCopy.QuarantinedAt = time.Time{}, time.Time{}
+	return aCopy == bCopy && a.QuarantinedAt.Equal(b.QuarantinedAt)
+}
+
+func TestQuarantineGet(t *testing.T) {
+	now := time.Now()
+	tests := map[string]struct {
+		value    string
+		expected *QuarantineItem
+	}{
+		"valid": {
+			now.UTC().Format(time.RFC1123Z) + ";!quarantine:example.com;!mailbox:example.com;test@example.com;$1_content",
+			testQuarantineItem("$1", now),
+		},
+		"invalid time": {
+			"yesterday;!quarantine:example.com;!mailbox:example.com;test@example.com;$1_content",
+			testQuarantineItem("$1", time.Time{}),
+		},
+		"not enough parts": {"yesterday;!quarantine:example.com", nil},
+		"missing":          {"", nil},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			quarantine := Quarantine{}
+			if test.value != "" {
+				quarantine["$1"] = test.value
+			}
+			item, ok := quarantine.Get("$1")
+			if ok != (test.expected != nil) {
+				t.Fatal("found:", test.expected != nil, "!=", ok)
+			}
+			if ok && !equalQuarantineItems(item, test.expected) {
+				t.Errorf("%+v != %+v", test.expected, item)
+			}
+		})
+	}
+}
+
+func TestQuarantineAddRemove(t *testing.T) {
+	quarantine := Quarantine{}
+	item := testQuarantineItem("$1", time.Now())
+	quarantine.Add(item)
+
+	got, ok := quarantine.Get(item.EventID)
+	if !ok || !equalQuarantineItems(got, item) {
+		t.Errorf("%+v != %+v", item, got)
+	}
+	if !quarantine.Remove(item.EventID) {
+		t.Error("item is not removed")
+	}
+	if quarantine.Remove(item.EventID) {
+		t.Error("item is removed twice")
+	}
+	if _, ok := quarantine.Get(item.EventID); ok {
+		t.Error("removed item is found")
+	}
+
+	// release failure puts the same item back
+	quarantine.Add(item)
+	if got, ok := quarantine.Get(item.EventID); !ok || !equalQuarantineItems(got, item) {
+		t.Errorf("%+v != %+v", item, got)
+	}
+}
+
+func TestQuarantineExpired(t *testing.T) {
+	now := time.Now()
+	quarantine := Quarantine{}
+	quarantine.Add(testQuarantineItem("$new", now))
+	quarantine.Add(testQuarantineItem("$old", now.AddDate(0, 0, -10)))
+	quarantine.Add(testQuarantineItem("$older", now.AddDate(0, 0, -20)))
+	quarantine["$invalid"] = "invalid"
+
+	tests := map[string]struct {
+		cutoff   time.Time
+		expected []id.EventID
+	}{
+		"none":       {now.AddDate(0, 0, -30), []id.EventID{"$invalid"}},
+		"retention":  {now.AddDate(0, 0, -7), []id.EventID{"$invalid", "$older", "$old"}},
+		"all":        {now.Add(time.Minute), []id.EventID{"$invalid", "$older", "$old", "$new"}},
+		"older only": {now.AddDate(0, 0, -15), []id.EventID{"$invalid", "$older"}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			eventIDs := []id.EventID{}
+			for _, item := range quarantine.Expired(test.cutoff) {
+				eventIDs = append(eventIDs, item.EventID)
+			}
+			if !reflect.DeepEqual(eventIDs, test.expected) {
+				t.Error(test.expected, "!=", eventIDs)
+			}
+		})
+	}
+
+	t.Run("prune", func(t *testing.T) {
+		pruned := Quarantine{}
+		for k, v := range quarantine {
+			pruned[k] = v
+		}
+		for _, item := range pruned.Expired(now.AddDate(0, 0, -7)) {
+			pruned.Remove(item.EventID)
+		}
+		if len(pruned) != 1 {
+			t.Fatal(1, "!=", len(pruned))
+		}
+		if _, ok := pruned.Get("$new"); !ok {
+			t.Error("fresh item is pruned")
+		}
+	})
+}
@@ -6,6 +6,8 @@ import (
 	"strings"
 
 	"github.com/etkecc/go-healthchecks/v2"
+	"maunium.net/go/mautrix/id"
+
 	"github.com/etkecc/postmoogle/internal/email"
 	"github.com/etkecc/postmoogle/internal/utils"
 )
@@ -26,6 +28,10 @@ const (
 	RoomSignature = "signature"
 	RoomAutoreply = "autoreply"
 	RoomRelay     = "relay"
+	RoomForward   = "forward"
+
+	// RoomDomainScoped is set when the mailbox (and aliases) is claimed on the room's domain only
+	RoomDomainScoped = ".domain:scoped"
 
 	RoomThreadify   = "threadify"
 	RoomStripify    = "stripify"
@@ -40,13 +46,35 @@ const (
 	RoomNoSubject   = "nosubject"
 	RoomNoThreads   = "nothreads"
 
-	RoomSpamcheckRBL  = "spamcheck:rbl"
-	RoomSpamcheckDKIM = "spamcheck:dkim"
-	RoomSpamcheckSMTP = "spamcheck:smtp"
-	RoomSpamcheckSPF  = "spamcheck:spf"
-	RoomSpamcheckMX   = "spamcheck:mx"
+	RoomSpamcheckRBL   = "spamcheck:rbl"
+	RoomSpamcheckDKIM  = "spamcheck:dkim"
+	RoomSpamcheckSMTP  = "spamcheck:smtp"
+	RoomSpamcheckSPF   = "spamcheck:spf"
+	RoomSpamcheckMX    = "spamcheck:mx"
+	RoomSpamcheckDMARC = "spamcheck:dmarc"
+
+	RoomSpamscoreFlag       = "spamscore:flag"
+	RoomSpamscoreGreylist   = "spamscore:greylist"
+	RoomSpamscoreQuarantine = "spamscore:quarantine"
+	RoomSpamscoreReject     = "spamscore:reject"
+
+	RoomAntivirusStrip = "antivirus:strip"
+
+	RoomQuarantine       = "quarantine"
+	RoomQuarantineThread = ".quarantine"
+
+	RoomSieve          = "sieve"
+	RoomSieveTagPrefix = ".sieve:"
+
+	RoomFanout        = ".fanout"
+	RoomFanoutPending = ".fanout:pending"
 
-	RoomSpamlist = "spamlist"
+	RoomSpamlist  = "spamlist"
+	RoomAllowlist = "allowlist"
+
+	RoomWebhooks      = "webhooks"
+	RoomWebhookRaw    = "webhook:raw"
+	RoomWebhookSecret = ".webhook:secret"
 )
 
 // Get option
@@ -71,6 +99,41 @@ func (s Room) Domain() string {
 	return s.Get(RoomDomain)
 }
 
+// DomainScoped returns true if the mailbox (and aliases) belongs to the room's domain only, instead of all domains
+func (s Room) DomainScoped() bool {
+	return utils.Bool(s.Get(RoomDomainScoped))
+}
+
+// MailboxKey returns key of the mailbox (or alias) in the mailboxes mapping:
+// `localpart@domain` for domain-scoped mailboxes, `localpart` otherwise
+func (s Room) MailboxKey(mailbox string) string {
+	if s.DomainScoped() {
+		return mailbox + "@" + s.Domain()
+	}
+	return mailbox
+}
+
+// MailboxKeys returns keys of the mailbox and its aliases in the mailboxes mapping
+func (s Room) MailboxKeys() []string {
+	keys := []string{}
+	for _, mailbox := range append([]string{s.Mailbox()}, s.Aliases()...) {
+		mailbox = strings.ToLower(strings.TrimSpace(mailbox))
+		if mailbox == "" {
+			continue
+		}
+		keys = append(keys, s.MailboxKey(mailbox))
+	}
+	return keys
+}
+
+// Emails returns human-readable list of the mailbox (or alias) emails
+func (s Room) Emails(mailbox string) string {
+	if s.DomainScoped() {
+		return mailbox + "@" + s.Domain()
+	}
+	return utils.EmailsList(mailbox, s.Domain())
+}
+
 func (s Room) Owner() string {
 	return s.Get(RoomOwner)
 }
@@ -93,6 +156,11 @@ func (s Room) Relay() *url.URL {
 	return u
 }
 
+// Forward returns external email addresses to forward incoming emails to
+func (s Room) Forward() []string {
+	return utils.StringSlice(s.Get(RoomForward))
+}
+
 func (s Room) Password() string {
 	return s.Get(RoomPassword)
 }
@@ -173,10 +241,77 @@ func (s Room) SpamcheckMX() bool {
 	return utils.Bool(s.Get(RoomSpamcheckMX))
 }
 
+func (s Room) SpamcheckDMARC() bool {
+	return utils.Bool(s.Get(RoomSpamcheckDMARC))
+}
+
+func (s Room) AntivirusStrip() bool {
+	return utils.Bool(s.Get(RoomAntivirusStrip))
+}
+
+// Quarantine returns true if the mailbox has its own quarantine thread
+func (s Room) Quarantine() bool {
+	return utils.Bool(s.Get(RoomQuarantine))
+}
+
+// QuarantineThread returns event ID of the mailbox's quarantine thread
+func (s Room) QuarantineThread() id.EventID {
+	return id.EventID(s.Get(RoomQuarantineThread))
+}
+
+// Fanout returns secondary rooms the mailbox emails are delivered to
+func (s Room) Fanout() []id.RoomID {
+	return roomIDs(s.Get(RoomFanout))
+}
+
+// FanoutPending returns secondary rooms which haven't accepted the mailbox emails yet
+func (s Room) FanoutPending() []id.RoomID {
+	return roomIDs(s.Get(RoomFanoutPending))
+}
+
+// Sieve returns the Sieve script of the mailbox
+func (s Room) Sieve() string {
+	return s.Get(RoomSieve)
+}
+
+// SieveTagThread returns event ID of the mailbox's thread of the Sieve `fileinto` tag
+func (s Room) SieveTagThread(tag string) id.EventID {
+	return id.EventID(s.Get(RoomSieveTagPrefix + tag))
+}
+
+// SpamThresholds returns content scanner score thresholds of the room
+func (s Room) SpamThresholds() *email.SpamThresholds {
+	return &email.SpamThresholds{
+		Flag:       utils.Float(s.Get(RoomSpamscoreFlag)),
+		Greylist:   utils.Float(s.Get(RoomSpamscoreGreylist)),
+		Quarantine: utils.Float(s.Get(RoomSpamscoreQuarantine)),
+		Reject:     utils.Float(s.Get(RoomSpamscoreReject)),
+	}
+}
+
 func (s Room) Spamlist() []string {
 	return utils.StringSlice(s.Get(RoomSpamlist))
 }
 
+func (s Room) Allowlist() []string {
+	return utils.StringSlice(s.Get(RoomAllowlist))
+}
+
+// Webhooks returns URLs the mail events of the mailbox are posted to
+func (s Room) Webhooks() []string {
+	return utils.StringSlice(s.Get(RoomWebhooks))
+}
+
+// WebhookSecret returns HMAC secret the webhook payloads are signed with
+func (s Room) WebhookSecret() string {
+	return s.Get(RoomWebhookSecret)
+}
+
+// WebhookRaw returns true if the raw MIME message should be included into webhook payloads
+func (s Room) WebhookRaw() bool {
+	return utils.Bool(s.Get(RoomWebhookRaw))
+}
+
 func (s Room) MigrateSpamlistSettings() {
 	uniq := map[string]struct{}{}
 	emails := utils.StringSlice(s.Get("spamlist:emails"))
@@ -234,13 +369,25 @@ func (s Room) ContentOptions() *email.ContentOptions {
 		Stripify:  s.Stripify(),
 		Threadify: s.Threadify(),
 
-		ToKey:         "cc.etke.postmoogle.to",
-		CcKey:         "cc.etke.postmoogle.cc",
-		FromKey:       "cc.etke.postmoogle.from",
-		RcptToKey:     "cc.etke.postmoogle.rcptTo",
-		SubjectKey:    "cc.etke.postmoogle.subject",
-		InReplyToKey:  "cc.etke.postmoogle.inReplyTo",
-		MessageIDKey:  "cc.etke.postmoogle.messageID",
-		ReferencesKey: "cc.etke.postmoogle.references",
+		ToKey:          "cc.etke.postmoogle.to",
+		CcKey:          "cc.etke.postmoogle.cc",
+		FromKey:        "cc.etke.postmoogle.from",
+		RcptToKey:      "cc.etke.postmoogle.rcptTo",
+		SubjectKey:     "cc.etke.postmoogle.subject",
+		InReplyToKey:   "cc.etke.postmoogle.inReplyTo",
+		MessageIDKey:   "cc.etke.postmoogle.messageID",
+		ReferencesKey:  "cc.etke.postmoogle.references",
+		AuthResultsKey: "cc.etke.postmoogle.authenticationResults",
+		SpamKey:        "cc.etke.postmoogle.spamScore",
+	}
+}
+
+// roomIDs parses comma-separated list of room IDs
+func roomIDs(value string) []id.RoomID {
+	rooms := utils.StringSlice(value)
+	ids := make([]id.RoomID, 0, len(rooms))
+	for _, room := range rooms {
+		ids = append(ids, id.RoomID(room))
 	}
+	return ids
 }
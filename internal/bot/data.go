@@ -3,7 +3,6 @@ package bot
 import (
 	"context"
 	"strconv"
-	"strings"
 	"time"
 
 	"maunium.net/go/mautrix/id"
@@ -12,14 +11,15 @@ import (
 )
 
 func (b *Bot) addRoom(roomID id.RoomID, cfg config.Room) {
-	mailbox := strings.ToLower(strings.TrimSpace(cfg.Mailbox()))
-	b.rooms.Store(mailbox, roomID)
-	aliases := cfg.Aliases()
-	if len(aliases) > 0 {
-		for _, alias := range aliases {
-			alias = strings.ToLower(strings.TrimSpace(alias))
-			b.rooms.Store(alias, roomID)
-		}
+	for _, key := range cfg.MailboxKeys() {
+		b.rooms.Store(key, roomID)
+	}
+}
+
+// removeRoom removes the mailbox and aliases of the room from the mapping
+func (b *Bot) removeRoom(roomID id.RoomID, cfg config.Room) {
+	for _, key := range cfg.MailboxKeys() {
+		b.rooms.CompareAndDelete(key, roomID)
 	}
 }
 
@@ -0,0 +1,265 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/etkecc/go-linkpearl"
+	"maunium.net/go/mautrix/format"
+
+	"github.com/etkecc/postmoogle/internal/bot/config"
+	"github.com/etkecc/postmoogle/internal/dnscheck"
+	"github.com/etkecc/postmoogle/internal/email"
+	"github.com/etkecc/postmoogle/internal/utils"
+)
+
+const (
+	dkimLock = "dkim"
+	// dkimDual is the "algorithm" of dual signing with both RSA and ed25519 keys (RFC 8463)
+	dkimDual = "dual"
+)
+
+// GetDKIMKeys returns DKIM keys to sign emails of the domain with,
+// domains without their own keys use the global key
+func (b *Bot) GetDKIMKeys(ctx context.Context, domain string) []email.DKIMKey {
+	if keys := b.cfg.GetDKIM(ctx).Get(domain); keys != nil && len(keys.Active) > 0 {
+		return keys.Active
+	}
+	privkey := b.cfg.GetBot(ctx).DKIMPrivateKey()
+	if privkey == "" {
+		return nil
+	}
+	return []email.DKIMKey{{Selector: email.DKIMDefaultSelector, Algorithm: email.DKIMRSA, PrivateKey: privkey}}
+}
+
+// newDKIMKeys generates DKIM keys of the algorithm (`rsa`, `ed25519`, or `dual`),
+// dual keys get `-rsa` and `-ed25519` suffixes of the selector
+func newDKIMKeys(algorithm, selector string) ([]email.DKIMKey, error) {
+	if selector == "" {
+		selector = "pm" + time.Now().UTC().Format("20060102")
+	}
+	if algorithm != dkimDual {
+		key, err := email.NewDKIMKey(algorithm, selector)
+		if err != nil {
+			return nil, err
+		}
+		return []email.DKIMKey{key}, nil
+	}
+
+	keys := make([]email.DKIMKey, 0, 2)
+	for _, algorithm := range []string{email.DKIMRSA, email.DKIMEd25519} {
+		key, err := email.NewDKIMKey(algorithm, selector+"-"+algorithm)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// dkimRecords returns DKIM DNS records of the keys
+func dkimRecords(domain string, keys []email.DKIMKey) string {
+	var msg strings.Builder
+	for _, key := range keys {
+		msg.WriteString("* `TXT` (DKIM, ")
+		msg.WriteString(key.Algorithm)
+		msg.WriteString(") record of `")
+		msg.WriteString(key.Selector)
+		msg.WriteString("._domainkey.")
+		msg.WriteString(domain)
+		msg.WriteString("`:\n```\n")
+		msg.WriteString(key.Record)
+		msg.WriteString("\n```\n")
+	}
+	return msg.String()
+}
+
+// sendDKIMDomain shows DKIM keys of the domain
+func (b *Bot) sendDKIMDomain(ctx context.Context, domain string) {
+	evt := eventFromContext(ctx)
+	keys := b.cfg.GetDKIM(ctx).Get(domain)
+	if keys == nil {
+		b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("`%s` doesn't have its own DKIM keys and uses the global signature (`%s dkim`), kupo.\n"+
+			"To generate them, send `%s dkim:rotate %s`", domain, b.prefix, b.prefix, domain), linkpearl.RelatesTo(evt.ID))
+		return
+	}
+
+	var msg strings.Builder
+	msg.WriteString("DKIM keys of `")
+	msg.WriteString(domain)
+	msg.WriteString("` used for signing:\n")
+	msg.WriteString(dkimRecords(domain, keys.Active))
+	if len(keys.Pending) > 0 {
+		msg.WriteString("\nNew keys, used for signing after ")
+		msg.WriteString(keys.PendingSince.Add(config.DKIMGracePeriod).UTC().Format(time.RFC1123))
+		msg.WriteString(" (publish them now):\n")
+		msg.WriteString(dkimRecords(domain, keys.Pending))
+	}
+	if len(keys.Retired) > 0 {
+		msg.WriteString("\nRetired keys, their DNS records can be removed after ")
+		msg.WriteString(keys.RetiredSince.Add(config.DKIMGracePeriod).UTC().Format(time.RFC1123))
+		msg.WriteString(":\n")
+		msg.WriteString(dkimRecords(domain, keys.Retired))
+	}
+
+	b.lp.SendNotice(ctx, evt.RoomID, msg.String(), linkpearl.RelatesTo(evt.ID))
+}
+
+// runDKIMRotate starts rotation of the domain's DKIM keys: `dkim:rotate DOMAIN ALGORITHM SELECTOR`
+func (b *Bot) runDKIMRotate(ctx context.Context, commandSlice []string) {
+	evt := eventFromContext(ctx)
+	if len(commandSlice) < 2 {
+		b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("Usage: `%s dkim:rotate DOMAIN ALGORITHM SELECTOR`, "+
+			"where ALGORITHM is `rsa`, `ed25519`, or `dual` (default, both RSA and ed25519), and SELECTOR is optional.\n"+
+			"To cancel the pending rotation, send `%s dkim:rotate DOMAIN cancel`", b.prefix, b.prefix), linkpearl.RelatesTo(evt.ID))
+		return
+	}
+	domain := strings.ToLower(commandSlice[1])
+	if !slices.Contains(utils.Domains(), domain) {
+		b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("`%s` is not served by the bot, kupo", domain), linkpearl.RelatesTo(evt.ID))
+		return
+	}
+	algorithm := dkimDual
+	if len(commandSlice) > 2 {
+		algorithm = commandSlice[2]
+	}
+	var selector string
+	if len(commandSlice) > 3 {
+		selector = commandSlice[3]
+		if !validLabels(selector) {
+			b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("`%s` is not a valid DKIM selector, kupo", selector), linkpearl.RelatesTo(evt.ID))
+			return
+		}
+	}
+
+	b.mu.Lock(dkimLock)
+	defer b.mu.Unlock(dkimLock)
+	dkim := b.cfg.GetDKIM(ctx)
+	keys := dkim.Get(domain)
+	if keys == nil {
+		keys = &config.DKIMDomain{}
+	}
+	if algorithm == "cancel" {
+		if len(keys.Pending) == 0 {
+			b.lp.SendNotice(ctx, evt.RoomID, "nothing changed, kupo.", linkpearl.RelatesTo(evt.ID))
+			return
+		}
+		keys.Pending = nil
+		keys.PendingSince = time.Time{}
+		keys.PendingNotified = time.Time{}
+		b.saveDKIM(ctx, dkim, domain, keys, "DKIM keys rotation has been cancelled, kupo. You can remove DNS records of the new keys")
+		return
+	}
+	if len(keys.Pending) > 0 {
+		b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("DKIM keys rotation of `%s` is in progress already, check `%s dkim %s`, kupo", domain, b.prefix, domain), linkpearl.RelatesTo(evt.ID))
+		return
+	}
+	if algorithm != dkimDual && algorithm != email.DKIMRSA && algorithm != email.DKIMEd25519 {
+		b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("`%s` is not a supported DKIM algorithm, kupo", algorithm), linkpearl.RelatesTo(evt.ID))
+		return
+	}
+
+	pending, err := newDKIMKeys(algorithm, selector)
+	if err != nil {
+		b.Error(ctx, "cannot generate DKIM keys: %v", err)
+		return
+	}
+	for _, key := range slices.Concat(keys.Active, keys.Retired) {
+		if slices.ContainsFunc(pending, func(item email.DKIMKey) bool { return item.Selector == key.Selector }) {
+			b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("selector `%s` is used already, kupo", key.Selector), linkpearl.RelatesTo(evt.ID))
+			return
+		}
+	}
+	keys.Pending = pending
+	keys.PendingSince = time.Now().UTC()
+
+	msg := fmt.Sprintf("New DKIM keys of `%s` have been generated, kupo! Add these DNS records now:\n%s\n"+
+		"The new keys will be used for signing after %s if their DNS records are published, and the current keys will be retired then. "+
+		"To cancel, send `%s dkim:rotate %s cancel`",
+		domain, dkimRecords(domain, pending), keys.PendingSince.Add(config.DKIMGracePeriod).Format(time.RFC1123), b.prefix, domain)
+	b.saveDKIM(ctx, dkim, domain, keys, msg)
+}
+
+// saveDKIM saves DKIM keys of the domain and sends the message
+func (b *Bot) saveDKIM(ctx context.Context, dkim config.DKIM, domain string, keys *config.DKIMDomain, msg string) {
+	evt := eventFromContext(ctx)
+	dkim.Set(domain, keys)
+	if err := b.cfg.SetDKIM(ctx, dkim); err != nil {
+		b.Error(ctx, "cannot save DKIM keys: %v", err)
+		return
+	}
+	b.lp.SendNotice(ctx, evt.RoomID, msg, linkpearl.RelatesTo(evt.ID))
+}
+
+// RotateDKIM promotes pending DKIM keys and forgets retired ones after the grace period, intended to be run by cron.
+// Pending keys without published DNS records are not promoted, the admins are notified about them instead
+func (b *Bot) RotateDKIM() {
+	ctx := context.Background()
+	b.mu.Lock(dkimLock)
+	defer b.mu.Unlock(dkimLock)
+
+	checkCtx, cancel := context.WithTimeout(ctx, dnsCheckTimeout)
+	defer cancel()
+	checker := &dnscheck.Checker{}
+	now := time.Now().UTC()
+	dkim := b.cfg.GetDKIM(ctx)
+	var changed bool
+	var msg strings.Builder
+	for _, domain := range dkim.Domains() {
+		keys := dkim.Get(domain)
+		if keys == nil {
+			continue
+		}
+		promoted, forgotten, unpublished := keys.Rotate(now, func(key email.DKIMKey) bool {
+			return checker.DKIMPublished(checkCtx, domain, dnscheck.DKIMRecord{Selector: key.Selector, Record: key.Record})
+		})
+		notify := len(unpublished) > 0 && keys.NotifyUnpublished(now)
+		if len(promoted) == 0 && len(forgotten) == 0 && !notify {
+			continue
+		}
+		changed = true
+		dkim.Set(domain, keys)
+		if notify {
+			msg.WriteString("New DKIM keys of `")
+			msg.WriteString(domain)
+			msg.WriteString("` are not used for signing, because their DNS records are not published, the current keys are kept. Add these DNS records:\n")
+			msg.WriteString(dkimRecords(domain, unpublished))
+		}
+		if len(promoted) > 0 {
+			msg.WriteString("New DKIM keys of `")
+			msg.WriteString(domain)
+			msg.WriteString("` are used for signing now, the old keys have been retired\n")
+		}
+		for _, key := range forgotten {
+			msg.WriteString("DNS record of the retired DKIM key `")
+			msg.WriteString(key.Selector)
+			msg.WriteString("._domainkey.")
+			msg.WriteString(domain)
+			msg.WriteString("` can be removed now\n")
+		}
+	}
+	if !changed {
+		return
+	}
+	if err := b.cfg.SetDKIM(ctx, dkim); err != nil {
+		b.log.Error().Err(err).Msg("cannot save rotated DKIM keys")
+		return
+	}
+	b.log.Info().Msg(msg.String())
+	b.notifyAdmins(ctx, msg.String())
+}
+
+// notifyAdmins sends the message to the first available admin room
+func (b *Bot) notifyAdmins(ctx context.Context, msg string) {
+	for _, adminRoom := range b.adminRooms {
+		content := format.RenderMarkdown(msg, true, true)
+		if _, err := b.lp.Send(ctx, adminRoom, &content); err != nil {
+			b.log.Info().Str("adminRoom", adminRoom.String()).Msg("cannot send notification to the admin room")
+			continue
+		}
+		break
+	}
+}
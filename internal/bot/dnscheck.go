@@ -0,0 +1,74 @@
+package bot
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/etkecc/go-linkpearl"
+
+	"github.com/etkecc/postmoogle/internal/dnscheck"
+	"github.com/etkecc/postmoogle/internal/email"
+	"github.com/etkecc/postmoogle/internal/utils"
+)
+
+// dnsCheckTimeout is the max duration of the DNS health check of all domains
+const dnsCheckTimeout = 2 * time.Minute
+
+// expectedDKIM returns DKIM records the domain should have: its own keys (including pending ones),
+// or the global DKIM signature
+func (b *Bot) expectedDKIM(ctx context.Context, domain string) []dnscheck.DKIMRecord {
+	if keys := b.cfg.GetDKIM(ctx).Get(domain); keys != nil && len(keys.Active) > 0 {
+		records := make([]dnscheck.DKIMRecord, 0, len(keys.Active)+len(keys.Pending))
+		for _, key := range keys.Active {
+			records = append(records, dnscheck.DKIMRecord{Selector: key.Selector, Record: key.Record})
+		}
+		for _, key := range keys.Pending {
+			records = append(records, dnscheck.DKIMRecord{Selector: key.Selector, Record: key.Record, Pending: true})
+		}
+		return records
+	}
+	signature := b.cfg.GetBot(ctx).DKIMSignature()
+	if signature == "" {
+		return nil
+	}
+	return []dnscheck.DKIMRecord{{Selector: email.DKIMDefaultSelector, Record: signature}}
+}
+
+// runDNSCheck checks DNS records of the served domains: `dns:check DOMAIN1 DOMAIN2...` (all domains by default)
+func (b *Bot) runDNSCheck(ctx context.Context, commandSlice []string) {
+	evt := eventFromContext(ctx)
+	domains := utils.Domains()
+	if len(commandSlice) > 1 {
+		domains = []string{}
+		for _, domain := range commandSlice[1:] {
+			domain = strings.TrimSuffix(strings.TrimSpace(domain), ".")
+			if !slices.Contains(utils.Domains(), domain) {
+				b.lp.SendNotice(ctx, evt.RoomID, "`"+domain+"` is not served by the bot, kupo", linkpearl.RelatesTo(evt.ID))
+				return
+			}
+			domains = append(domains, domain)
+		}
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, dnsCheckTimeout)
+	defer cancel()
+	checker := &dnscheck.Checker{
+		Host: utils.SanitizeDomain(""),
+		DKIM: func(domain string) []dnscheck.DKIMRecord { return b.expectedDKIM(ctx, domain) },
+	}
+	results := checker.Check(checkCtx, domains)
+
+	var msg strings.Builder
+	msg.WriteString("DNS health check of `")
+	msg.WriteString(strings.Join(domains, "`, `"))
+	msg.WriteString("`:\n\n")
+	msg.WriteString(dnscheck.Markdown(results))
+	if dnscheck.Failed(results) {
+		msg.WriteString("\nSome checks have failed, kupo. Add or fix the records from the Fix column, see docs/dns.md for details")
+	} else {
+		msg.WriteString("\nEverything looks good, kupo!")
+	}
+	b.lp.SendNotice(ctx, evt.RoomID, msg.String(), linkpearl.RelatesTo(evt.ID))
+}
@@ -0,0 +1,212 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/etkecc/go-linkpearl"
+
+	"github.com/etkecc/postmoogle/internal/bot/config"
+	"github.com/etkecc/postmoogle/internal/email"
+	"github.com/etkecc/postmoogle/internal/utils"
+)
+
+const domainsLock = "domains"
+
+// SetDomainsUpdater sets the function called when the served domains are changed at runtime (e.g. smtp.Manager.SetDomains)
+func (b *Bot) SetDomainsUpdater(updater func([]string)) {
+	b.domainsUpdater = updater
+}
+
+// loadDomains applies the domains added at runtime on startup
+func (b *Bot) loadDomains(ctx context.Context) {
+	if added := b.cfg.GetBot(ctx).Domains(); len(added) > 0 {
+		b.applyDomains(added)
+	}
+}
+
+// applyDomains updates the served domains: domains from the config go first (the first one is the default domain),
+// followed by the domains added at runtime
+func (b *Bot) applyDomains(added []string) {
+	domains := slices.Clone(b.domains)
+	for _, domain := range added {
+		if !slices.Contains(domains, domain) {
+			domains = append(domains, domain)
+		}
+	}
+	utils.SetDomains(domains)
+	if b.domainsUpdater != nil {
+		b.domainsUpdater(domains)
+	}
+	b.log.Info().Strs("domains", domains).Msg("served domains have been updated")
+}
+
+// validDomain checks if the value looks like a domain name
+func validDomain(domain string) bool {
+	return len(domain) <= 253 && strings.Contains(domain, ".") && validLabels(domain)
+}
+
+// validLabels checks if the value consists of valid DNS labels (e.g. a domain or a DKIM selector)
+func validLabels(value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, label := range strings.Split(value, ".") {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+		for _, r := range label {
+			if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// dnsRecords returns DNS records to publish for the domain
+func (b *Bot) dnsRecords(domain string, keys []email.DKIMKey) string {
+	host := utils.SanitizeDomain("")
+	var msg strings.Builder
+	msg.WriteString("* `MX` record of `")
+	msg.WriteString(domain)
+	msg.WriteString("`: `10 ")
+	msg.WriteString(host)
+	msg.WriteString(".`\n")
+	msg.WriteString("* `TXT` (SPF) record of `")
+	msg.WriteString(domain)
+	msg.WriteString("`: `v=spf1 mx -all`\n")
+	msg.WriteString("* `TXT` (DMARC) record of `_dmarc.")
+	msg.WriteString(domain)
+	msg.WriteString("`: `v=DMARC1; p=quarantine;`\n")
+	msg.WriteString(dkimRecords(domain, keys))
+	return msg.String()
+}
+
+func (b *Bot) runDomains(ctx context.Context) {
+	evt := eventFromContext(ctx)
+	added := b.cfg.GetBot(ctx).Domains()
+
+	var msg strings.Builder
+	msg.WriteString("The following domains are served by the bot:\n")
+	for _, domain := range utils.Domains() {
+		msg.WriteString("* `")
+		msg.WriteString(domain)
+		msg.WriteString("`")
+		if !slices.Contains(added, domain) {
+			msg.WriteString(" (POSTMOOGLE_DOMAINS)")
+		}
+		msg.WriteString("\n")
+	}
+	msg.WriteString("\nUsage: `")
+	msg.WriteString(b.prefix)
+	msg.WriteString(" domains:add DOMAIN1 DOMAIN2...` to add domains, `")
+	msg.WriteString(b.prefix)
+	msg.WriteString(" domains:remove DOMAIN1 DOMAIN2...` to remove domains added that way")
+
+	b.lp.SendNotice(ctx, evt.RoomID, msg.String(), linkpearl.RelatesTo(evt.ID))
+}
+
+func (b *Bot) runDomainsAdd(ctx context.Context, commandSlice []string) {
+	evt := eventFromContext(ctx)
+	if len(commandSlice) < 2 {
+		b.runDomains(ctx)
+		return
+	}
+	b.mu.Lock(domainsLock)
+	defer b.mu.Unlock(domainsLock)
+
+	cfg := b.cfg.GetBot(ctx)
+	served := utils.Domains()
+	added := cfg.Domains()
+	newDomains := []string{}
+	for _, domain := range commandSlice[1:] {
+		domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+		if !validDomain(domain) {
+			b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("`%s` is not a valid domain, kupo", domain), linkpearl.RelatesTo(evt.ID))
+			return
+		}
+		if slices.Contains(served, domain) || slices.Contains(newDomains, domain) {
+			continue
+		}
+		newDomains = append(newDomains, domain)
+	}
+	if len(newDomains) == 0 {
+		b.lp.SendNotice(ctx, evt.RoomID, "nothing changed, kupo.", linkpearl.RelatesTo(evt.ID))
+		return
+	}
+
+	dkim := b.cfg.GetDKIM(ctx)
+	for _, domain := range newDomains {
+		keys, err := newDKIMKeys(dkimDual, "")
+		if err != nil {
+			b.Error(ctx, "cannot generate DKIM keys of the %s domain: %v", domain, err)
+			return
+		}
+		dkim.Set(domain, &config.DKIMDomain{Active: keys})
+	}
+	if err := b.cfg.SetDKIM(ctx, dkim); err != nil {
+		b.Error(ctx, "cannot save DKIM keys: %v", err)
+		return
+	}
+	added = append(added, newDomains...)
+	cfg.Set(config.BotDomains, utils.SliceString(added))
+	if err := b.cfg.SetBot(ctx, cfg); err != nil {
+		b.Error(ctx, "cannot save bot options: %v", err)
+		return
+	}
+	b.applyDomains(added)
+
+	var msg strings.Builder
+	msg.WriteString("The following domains have been added, kupo! Add these DNS records to them:\n\n")
+	for _, domain := range newDomains {
+		msg.WriteString("**")
+		msg.WriteString(domain)
+		msg.WriteString("**\n\n")
+		msg.WriteString(b.dnsRecords(domain, dkim.Get(domain).Active))
+		msg.WriteString("\n")
+	}
+	msg.WriteString("Without these records other email servers may reject your emails as spam, kupo.")
+	b.lp.SendNotice(ctx, evt.RoomID, msg.String(), linkpearl.RelatesTo(evt.ID))
+}
+
+func (b *Bot) runDomainsRemove(ctx context.Context, commandSlice []string) {
+	evt := eventFromContext(ctx)
+	if len(commandSlice) < 2 {
+		b.runDomains(ctx)
+		return
+	}
+	b.mu.Lock(domainsLock)
+	defer b.mu.Unlock(domainsLock)
+
+	cfg := b.cfg.GetBot(ctx)
+	dkim := b.cfg.GetDKIM(ctx)
+	added := cfg.Domains()
+	for _, domain := range commandSlice[1:] {
+		domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+		if slices.Contains(b.domains, domain) {
+			b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("`%s` is set in the POSTMOOGLE_DOMAINS and cannot be removed at runtime, kupo", domain), linkpearl.RelatesTo(evt.ID))
+			return
+		}
+		if !slices.Contains(added, domain) {
+			b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("`%s` is not served by the bot, kupo", domain), linkpearl.RelatesTo(evt.ID))
+			return
+		}
+		added = slices.DeleteFunc(added, func(item string) bool { return item == domain })
+		dkim.Set(domain, nil)
+	}
+	if err := b.cfg.SetDKIM(ctx, dkim); err != nil {
+		b.Error(ctx, "cannot save DKIM keys: %v", err)
+		return
+	}
+	cfg.Set(config.BotDomains, utils.SliceString(added))
+	if err := b.cfg.SetBot(ctx, cfg); err != nil {
+		b.Error(ctx, "cannot save bot options: %v", err)
+		return
+	}
+	b.applyDomains(added)
+
+	b.lp.SendNotice(ctx, evt.RoomID, "domains have been removed, kupo. Mailboxes claimed on these domains only will not receive emails anymore", linkpearl.RelatesTo(evt.ID))
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"slices"
 	"strings"
 	"time"
 
@@ -15,6 +16,8 @@ import (
 
 	"github.com/etkecc/postmoogle/internal/bot/config"
 	"github.com/etkecc/postmoogle/internal/email"
+	"github.com/etkecc/postmoogle/internal/imap"
+	"github.com/etkecc/postmoogle/internal/metrics"
 	"github.com/etkecc/postmoogle/internal/utils"
 )
 
@@ -34,7 +37,11 @@ const (
 	eventCcKey         = "cc.etke.postmoogle.cc"
 )
 
-var ErrNoRoom = errors.New("room not found")
+var (
+	ErrNoRoom = errors.New("room not found")
+	// errEmptyBody returned when the composed email is empty
+	errEmptyBody = errors.New("email body is empty")
+)
 
 // SetSendmail sets mail sending func to the bot
 func (b *Bot) SetSendmail(sendmail func(string, string, string, *url.URL) error) {
@@ -78,9 +85,33 @@ func (b *Bot) Sendmail(ctx context.Context, eventID id.EventID, from, to, data s
 	return false, nil
 }
 
-// GetDKIMprivkey returns DKIM private key
-func (b *Bot) GetDKIMprivkey(ctx context.Context) string {
-	return b.cfg.GetBot(ctx).DKIMPrivateKey()
+// sendmailFromRoom sends the email from the mailbox (see Sendmail), archives it into the Sent folder of the thread,
+// and posts the result to the mailbox webhooks
+func (b *Bot) sendmailFromRoom(ctx context.Context, roomID id.RoomID, cfg config.Room, eventID, threadID id.EventID, eml *email.Email, from, to, data string) (bool, error) {
+	queued, err := b.Sendmail(ctx, eventID, from, to, data, cfg.Relay())
+	if queued || err == nil {
+		b.archiveEmail(ctx, roomID, cfg, imap.FolderSent, threadID, eml.MessageID, []byte(data))
+	}
+	sent := copyEmail(eml)
+	sent.RcptTo = to
+	sent.Raw = []byte(data)
+	b.notifySent(roomID, cfg, sent, queued, err)
+
+	return queued, err
+}
+
+// EmailSent archives the email sent from the mailbox over SMTP and posts the result to the mailbox webhooks
+func (b *Bot) EmailSent(ctx context.Context, roomID id.RoomID, eml *email.Email, err error) {
+	cfg, cerr := b.cfg.GetRoom(ctx, roomID)
+	if cerr != nil {
+		b.log.Error().Err(cerr).Str("room_id", roomID.String()).Msg("cannot get room config")
+		return
+	}
+	if err == nil {
+		threadID := b.getThreadID(ctx, roomID, eml.InReplyTo, eml.References)
+		b.archiveEmail(ctx, roomID, cfg, imap.FolderSent, threadID, eml.MessageID, eml.Raw)
+	}
+	b.notifySent(roomID, cfg, eml, false, err)
 }
 
 // GetRelayConfig returns relay config for specific room (mailbox) if set
@@ -93,8 +124,20 @@ func (b *Bot) GetRelayConfig(ctx context.Context, roomID id.RoomID) *url.URL {
 	return cfg.Relay()
 }
 
-func (b *Bot) getMapping(mailbox string) (id.RoomID, bool) {
-	v, ok := b.rooms.Load(mailbox)
+// getMapping returns room of the mailbox address (`localpart@domain`) or mailbox (`localpart`),
+// domain-scoped mailboxes take precedence over the mailboxes of all domains
+func (b *Bot) getMapping(address string) (id.RoomID, bool) {
+	if roomID, ok := b.loadMapping(address); ok {
+		return roomID, ok
+	}
+	if mailbox, _, ok := strings.Cut(address, "@"); ok {
+		return b.loadMapping(mailbox)
+	}
+	return "", false
+}
+
+func (b *Bot) loadMapping(key string) (id.RoomID, bool) {
+	v, ok := b.rooms.Load(key)
 	if !ok {
 		return "", ok
 	}
@@ -107,35 +150,78 @@ func (b *Bot) getMapping(mailbox string) (id.RoomID, bool) {
 	return roomID, ok
 }
 
-// GetMapping returns mapping of mailbox = room
-func (b *Bot) GetMapping(ctx context.Context, mailbox string) (id.RoomID, bool) {
-	roomID, ok := b.getMapping(mailbox)
+// GetMapping returns mapping of mailbox address (or mailbox) = room, with catch-all fallback
+func (b *Bot) GetMapping(ctx context.Context, address string) (id.RoomID, bool) {
+	roomID, ok := b.getMapping(address)
 	if !ok {
 		catchAll := b.cfg.GetBot(ctx).CatchAll()
 		if catchAll == "" {
 			return roomID, ok
 		}
+		// catch-all mailbox of all domains is looked up on the address domain first
+		if _, domain, found := strings.Cut(address, "@"); found && !strings.Contains(catchAll, "@") {
+			catchAll += "@" + domain
+		}
 		return b.getMapping(catchAll)
 	}
 
 	return roomID, ok
 }
 
+// GetDNSBLOptions returns DNSBL zones and block threshold (server settings)
+func (b *Bot) GetDNSBLOptions(ctx context.Context) *email.DNSBLOptions {
+	return b.cfg.GetDNSBL(ctx).Options(b.cfg.GetBot(ctx).DNSBLThreshold())
+}
+
 // GetIFOptions returns incoming email filtering options (room settings)
 func (b *Bot) GetIFOptions(ctx context.Context, roomID id.RoomID) email.IncomingFilteringOptions {
-	cfg, err := b.cfg.GetRoom(ctx, roomID)
-	if err != nil {
-		b.log.Error().Err(err).Msg("cannot retrieve room settings")
+	cfg := config.Room{}
+	if roomID != "" { // SRS bounces don't belong to any room, so only the server-wide options are applied
+		var err error
+		cfg, err = b.cfg.GetRoom(ctx, roomID)
+		if err != nil {
+			b.log.Error().Err(err).Msg("cannot retrieve room settings")
+		}
+	}
+
+	botcfg := b.cfg.GetBot(ctx)
+	return &incomingFilteringOptions{
+		Room:       cfg,
+		spamlist:   slices.Concat(botcfg.Spamlist(), b.policies.getSpamlist()),
+		allowlist:  botcfg.Allowlist(),
+		quarantine: botcfg.QuarantineRoom() != "",
 	}
+}
+
+// incomingFilteringOptions extends room's options with server-wide spamlist, allowlist and quarantine
+type incomingFilteringOptions struct {
+	config.Room
+	spamlist   []string
+	allowlist  []string
+	quarantine bool
+}
+
+// Spamlist of the room and the server
+func (o *incomingFilteringOptions) Spamlist() []string {
+	return slices.Concat(o.Room.Spamlist(), o.spamlist)
+}
 
-	return cfg
+// Allowlist of the room and the server
+func (o *incomingFilteringOptions) Allowlist() []string {
+	return slices.Concat(o.Room.Allowlist(), o.allowlist)
+}
+
+// Quarantine returns true if the room has its own quarantine thread or the server has a quarantine room
+func (o *incomingFilteringOptions) Quarantine() bool {
+	return o.Room.Quarantine() || o.quarantine
 }
 
 // IncomingEmail sends incoming email to matrix room
-//
-//nolint:gocognit // TODO
 func (b *Bot) IncomingEmail(ctx context.Context, eml *email.Email) error {
-	roomID, ok := b.GetMapping(ctx, eml.Mailbox(true))
+	if original, ok := b.ReverseSRS(ctx, eml.RcptTo); ok {
+		return b.bounceEmail(ctx, original, eml)
+	}
+	roomID, ok := b.GetMapping(ctx, utils.MailboxAddress(eml.RcptTo))
 	if !ok {
 		return ErrNoRoom
 	}
@@ -143,7 +229,30 @@ func (b *Bot) IncomingEmail(ctx context.Context, eml *email.Email) error {
 	if err != nil {
 		b.Error(ctx, "cannot get settings: %v", err)
 	}
+	if len(eml.Quarantine) > 0 {
+		if quarantineRoomID, quarantineThreadID := b.getQuarantine(ctx, roomID, cfg); quarantineRoomID != "" {
+			return b.quarantineEmail(ctx, quarantineRoomID, quarantineThreadID, roomID, cfg, eml)
+		}
+	}
+	if cfg.Sieve() != "" {
+		err = b.filterEmail(ctx, roomID, cfg, eml)
+		b.notifyIncoming(roomID, cfg, eml, err)
+		return err
+	}
+	if err := b.deliverEmail(ctx, roomID, cfg, copyEmail(eml)); err != nil {
+		return err
+	}
+	b.notifyIncoming(roomID, cfg, eml, nil)
+	b.fanoutEmail(ctx, cfg, eml)
+	b.forwardEmail(ctx, cfg, eml)
 
+	return nil
+}
+
+// deliverEmail posts the email into the mailbox room
+//
+//nolint:gocognit // TODO
+func (b *Bot) deliverEmail(ctx context.Context, roomID id.RoomID, cfg config.Room, eml *email.Email) error {
 	b.mu.Lock(roomID.String())
 	defer b.mu.Unlock(roomID.String())
 
@@ -170,7 +279,7 @@ func (b *Bot) IncomingEmail(ctx context.Context, eml *email.Email) error {
 	}
 
 	content := eml.Content(threadID, cfg.ContentOptions())
-	eventID, serr := b.lp.Send(ctx, roomID, content)
+	eventID, serr := b.sendEmailContent(ctx, roomID, content)
 	if serr != nil {
 		if !strings.Contains(serr.Error(), "M_UNKNOWN") { // if it's not an unknown event error
 			return serr
@@ -178,6 +287,9 @@ func (b *Bot) IncomingEmail(ctx context.Context, eml *email.Email) error {
 		threadID = "" // unknown event edge case - remove existing thread ID to avoid complications
 		newThread = true
 	}
+	if serr == nil {
+		metrics.Delivered(utils.Hostname(eml.RcptTo))
+	}
 	if threadID == "" {
 		threadID = eventID
 		ctx = threadIDToContext(ctx, threadID)
@@ -197,7 +309,7 @@ func (b *Bot) IncomingEmail(ctx context.Context, eml *email.Email) error {
 				utils.NewFile("original.md", []byte(content.Parsed.(*event.MessageEventContent).Body)), //nolint:errcheck // that's ok
 			)
 		}
-		_, berr := b.lp.Send(ctx, roomID, eml.ContentBody(threadID, cfg.ContentOptions()))
+		_, berr := b.sendEmailContent(ctx, roomID, eml.ContentBody(threadID, cfg.ContentOptions()))
 		if berr != nil {
 			return berr
 		}
@@ -211,6 +323,13 @@ func (b *Bot) IncomingEmail(ctx context.Context, eml *email.Email) error {
 		b.sendFiles(ctx, roomID, eml.Files, cfg.NoThreads(), threadID)
 	}
 
+	b.archiveEmail(ctx, roomID, cfg, imap.FolderInbox, threadID, eml.MessageID, rawEmail(eml))
+
+	if len(eml.Infected) > 0 {
+		msg := "⚠️ infected files have been removed from the email: `" + strings.Join(eml.Infected, "`, `") + "`"
+		b.lp.SendNotice(ctx, roomID, msg, linkpearl.RelatesTo(threadID, cfg.NoThreads()))
+	}
+
 	if newThread && cfg.Autoreply() != "" {
 		b.sendAutoreply(ctx, roomID, threadID)
 	}
@@ -279,7 +398,7 @@ func (b *Bot) sendAutoreply(ctx context.Context, roomID id.RoomID, threadID id.E
 	meta.References = meta.References + " " + meta.MessageID
 	b.log.Info().Any("meta", meta).Msg("sending automatic reply")
 	eml := email.New(meta.MessageID, meta.InReplyTo, meta.References, meta.Subject, meta.From, meta.To, meta.RcptTo, meta.CC, body, htmlBody, nil, nil)
-	data := eml.Compose(b.cfg.GetBot(ctx).DKIMPrivateKey())
+	data := eml.Compose(b.GetDKIMKeys(ctx, utils.Hostname(eml.From))...)
 	if data == "" {
 		return
 	}
@@ -288,7 +407,7 @@ func (b *Bot) sendAutoreply(ctx context.Context, roomID id.RoomID, threadID id.E
 	ctx = newContext(ctx, threadEvt)
 	recipients := meta.Recipients
 	for _, to := range recipients {
-		queued, err = b.Sendmail(ctx, evt.ID, meta.From, to, data, cfg.Relay())
+		queued, err = b.sendmailFromRoom(ctx, evt.RoomID, cfg, evt.ID, meta.ThreadID, eml, meta.From, to, data)
 		if queued {
 			b.log.Info().Err(err).Str("from", meta.From).Str("to", to).Msg("email has been queued")
 			b.saveSentMetadata(ctx, queued, meta.ThreadID, to, eml, cfg, "Autoreply has been sent to "+to+" (queued)")
@@ -364,7 +483,7 @@ func (b *Bot) SendEmailReply(ctx context.Context) {
 	meta.References = meta.References + " " + meta.MessageID
 	b.log.Info().Any("meta", meta).Msg("sending email reply")
 	eml := email.New(meta.MessageID, meta.InReplyTo, meta.References, meta.Subject, meta.From, meta.To, meta.RcptTo, meta.CC, body, htmlBody, nil, nil)
-	data := eml.Compose(b.cfg.GetBot(ctx).DKIMPrivateKey())
+	data := eml.Compose(b.GetDKIMKeys(ctx, utils.Hostname(eml.From))...)
 	if data == "" {
 		b.lp.SendNotice(ctx, evt.RoomID, "email body is empty", linkpearl.RelatesTo(meta.ThreadID, cfg.NoThreads()))
 		return
@@ -373,7 +492,7 @@ func (b *Bot) SendEmailReply(ctx context.Context) {
 	var queued bool
 	recipients := meta.Recipients
 	for _, to := range recipients {
-		queued, err = b.Sendmail(ctx, evt.ID, meta.From, to, data, cfg.Relay())
+		queued, err = b.sendmailFromRoom(ctx, evt.RoomID, cfg, evt.ID, meta.ThreadID, eml, meta.From, to, data)
 		if queued {
 			b.log.Info().Err(err).Str("from", meta.From).Str("to", to).Msg("email has been queued")
 			b.saveSentMetadata(ctx, queued, meta.ThreadID, to, eml, cfg)
@@ -528,7 +647,7 @@ func (b *Bot) getParentEmail(ctx context.Context, evt *event.Event, newFromMailb
 	parent.RcptTo = email.Address(linkpearl.EventField[string](&parentEvt.Content, eventRcptToKey))
 	parent.InReplyTo = linkpearl.EventField[string](&parentEvt.Content, eventMessageIDkey)
 	parent.References = linkpearl.EventField[string](&parentEvt.Content, eventReferencesKey)
-	senderEmail := parent.fixtofrom(newFromMailbox, b.domains)
+	senderEmail := parent.fixtofrom(newFromMailbox, utils.Domains())
 	parent.calculateRecipients(senderEmail, b.mbxc.Forwarded)
 	parent.MessageID = email.MessageID(parentEvt.ID, parent.FromDomain)
 	if parent.InReplyTo == "" {
@@ -583,10 +702,18 @@ func (b *Bot) saveSentMetadata(ctx context.Context, queued bool, threadID id.Eve
 	b.setLastEventID(ctx, evt.RoomID, threadID, msgID)
 }
 
+// sendEmailContent sends the email content to the room, recording the latency
+func (b *Bot) sendEmailContent(ctx context.Context, roomID id.RoomID, content *event.Content) (id.EventID, error) {
+	defer metrics.MatrixRequest(metrics.MatrixSend, time.Now())
+	return b.lp.Send(ctx, roomID, content)
+}
+
 func (b *Bot) sendFiles(ctx context.Context, roomID id.RoomID, files []*utils.File, noThreads bool, parentID id.EventID) {
 	for _, file := range files {
 		req := file.Convert()
+		start := time.Now()
 		err := b.lp.SendFile(ctx, roomID, req, file.MsgType, linkpearl.RelatesTo(parentID, noThreads))
+		metrics.MatrixRequest(metrics.MatrixUpload, start)
 		if err != nil {
 			b.Error(ctx, "cannot upload file %s: %v", req.FileName, err)
 		}
@@ -0,0 +1,271 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/etkecc/go-linkpearl"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/etkecc/postmoogle/internal/bot/config"
+	"github.com/etkecc/postmoogle/internal/email"
+	"github.com/etkecc/postmoogle/internal/utils"
+)
+
+const fanoutLock = "fanout"
+
+// fanoutEmail delivers the email to the secondary rooms of the mailbox, using their own content options and threads
+func (b *Bot) fanoutEmail(ctx context.Context, cfg config.Room, eml *email.Email) {
+	for _, roomID := range cfg.Fanout() {
+		log := b.log.With().Str("room_id", roomID.String()).Str("mailbox", cfg.Mailbox()).Logger()
+		roomCfg, err := b.cfg.GetRoom(ctx, roomID)
+		if err != nil {
+			log.Error().Err(err).Msg("cannot get secondary room settings")
+			continue
+		}
+		// autoreplies are sent by the primary room only
+		roomCfg = maps.Clone(roomCfg)
+		roomCfg.Set(config.RoomAutoreply, "")
+		if err := b.deliverEmail(ctx, roomID, roomCfg, copyEmail(eml)); err != nil {
+			log.Error().Err(err).Msg("cannot deliver email to the secondary room")
+		}
+	}
+}
+
+// resolveRoom returns room ID of the room ID or alias
+func (b *Bot) resolveRoom(ctx context.Context, room string) (id.RoomID, error) {
+	if !strings.HasPrefix(room, "#") {
+		return id.RoomID(room), nil
+	}
+	resp, err := b.lp.GetClient().ResolveAlias(ctx, id.RoomAlias(room))
+	if err != nil {
+		return "", err
+	}
+	return resp.RoomID, nil
+}
+
+// joinRoomIDs converts room IDs to comma-separated list
+func joinRoomIDs(roomIDs []id.RoomID) string {
+	rooms := make([]string, 0, len(roomIDs))
+	for _, roomID := range roomIDs {
+		rooms = append(rooms, roomID.String())
+	}
+	return strings.Join(rooms, ",")
+}
+
+// runFanout shows secondary rooms of the mailbox
+func (b *Bot) runFanout(ctx context.Context) {
+	evt := eventFromContext(ctx)
+	cfg, err := b.cfg.GetRoom(ctx, evt.RoomID)
+	if err != nil {
+		b.Error(ctx, "failed to retrieve settings: %v", err)
+		return
+	}
+
+	var msg strings.Builder
+	if rooms := cfg.Fanout(); len(rooms) > 0 {
+		msg.WriteString("Emails of this mailbox are delivered to the following rooms as well:\n")
+		for _, roomID := range rooms {
+			msg.WriteString("* `" + roomID.String() + "`\n")
+		}
+		msg.WriteString("\n")
+	}
+	if rooms := cfg.FanoutPending(); len(rooms) > 0 {
+		msg.WriteString("The following rooms haven't accepted emails of this mailbox yet:\n")
+		for _, roomID := range rooms {
+			msg.WriteString("* `" + roomID.String() + "`\n")
+		}
+		msg.WriteString("\n")
+	}
+	if msg.Len() == 0 {
+		msg.WriteString("Emails of this mailbox are delivered to this room only.\n\n")
+	}
+	msg.WriteString("Usage: `")
+	msg.WriteString(b.prefix)
+	msg.WriteString(" fanout:add ROOM` and `")
+	msg.WriteString(b.prefix)
+	msg.WriteString(" fanout:remove ROOM`, where ROOM is ID or alias of a room to deliver emails of this mailbox to as well")
+
+	b.lp.SendNotice(ctx, evt.RoomID, msg.String(), linkpearl.RelatesTo(evt.ID, cfg.NoThreads()))
+}
+
+// runFanoutAdd asks the secondary room to accept emails of the mailbox
+func (b *Bot) runFanoutAdd(ctx context.Context, commandSlice []string) {
+	evt := eventFromContext(ctx)
+	cfg, err := b.cfg.GetRoom(ctx, evt.RoomID)
+	if err != nil {
+		b.Error(ctx, "failed to retrieve settings: %v", err)
+		return
+	}
+	if cfg.Mailbox() == "" {
+		b.lp.SendNotice(ctx, evt.RoomID, "mailbox is not configured, kupo", linkpearl.RelatesTo(evt.ID, cfg.NoThreads()))
+		return
+	}
+	if len(commandSlice) < 2 {
+		b.runFanout(ctx)
+		return
+	}
+	roomID, err := b.resolveRoom(ctx, b.parseCommand(evt.Content.AsMessage().Body, false)[1]) // get original value, without forced lower case
+	if err != nil {
+		b.Error(ctx, "cannot resolve room alias: %v", err)
+		return
+	}
+	if roomID == evt.RoomID || slices.Contains(cfg.Fanout(), roomID) || slices.Contains(cfg.FanoutPending(), roomID) {
+		b.lp.SendNotice(ctx, evt.RoomID, "nothing changed, kupo.", linkpearl.RelatesTo(evt.ID, cfg.NoThreads()))
+		return
+	}
+
+	address := cfg.Emails(cfg.Mailbox())
+	key := cfg.MailboxKey(cfg.Mailbox())
+	request := fmt.Sprintf("📬 The `%s` mailbox wants to deliver its emails to this room as well. "+
+		"To accept, send `%s fanout:accept %s`; to stop receiving them later, send `%s fanout:leave %s`",
+		address, b.prefix, key, b.prefix, key)
+	content := format.RenderMarkdown(request, true, true)
+	content.MsgType = event.MsgNotice
+	if _, err := b.lp.Send(ctx, roomID, &content); err != nil {
+		b.Error(ctx, "cannot post to the %s room, invite the bot there first: %v", roomID, err)
+		return
+	}
+
+	b.mu.Lock(fanoutLock)
+	defer b.mu.Unlock(fanoutLock)
+	cfg, err = b.cfg.GetRoom(ctx, evt.RoomID)
+	if err != nil {
+		b.Error(ctx, "failed to retrieve settings: %v", err)
+		return
+	}
+	cfg.Set(config.RoomFanoutPending, joinRoomIDs(append(cfg.FanoutPending(), roomID)))
+	if err := b.cfg.SetRoom(ctx, evt.RoomID, cfg); err != nil {
+		b.Error(ctx, "cannot update settings: %v", err)
+		return
+	}
+	msg := fmt.Sprintf("the `%s` room has been asked to accept emails of this mailbox, they will be delivered there once accepted, kupo", roomID)
+	b.lp.SendNotice(ctx, evt.RoomID, msg, linkpearl.RelatesTo(evt.ID, cfg.NoThreads()))
+}
+
+// runFanoutRemove stops delivering emails of the mailbox to the secondary room
+func (b *Bot) runFanoutRemove(ctx context.Context, commandSlice []string) {
+	evt := eventFromContext(ctx)
+	if len(commandSlice) < 2 {
+		b.runFanout(ctx)
+		return
+	}
+	roomID, err := b.resolveRoom(ctx, b.parseCommand(evt.Content.AsMessage().Body, false)[1]) // get original value, without forced lower case
+	if err != nil {
+		b.Error(ctx, "cannot resolve room alias: %v", err)
+		return
+	}
+
+	cfg, changed, err := b.removeFanout(ctx, evt.RoomID, roomID)
+	if err != nil {
+		b.Error(ctx, "cannot update settings: %v", err)
+		return
+	}
+	if !changed {
+		b.lp.SendNotice(ctx, evt.RoomID, "nothing changed, kupo.", linkpearl.RelatesTo(evt.ID, cfg.NoThreads()))
+		return
+	}
+	b.lp.SendNotice(ctx, roomID, fmt.Sprintf("📭 emails of the `%s` mailbox will not be delivered to this room anymore", cfg.Emails(cfg.Mailbox())))
+	b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("emails of this mailbox will not be delivered to the `%s` room anymore, kupo", roomID), linkpearl.RelatesTo(evt.ID, cfg.NoThreads()))
+}
+
+// runFanoutAccept accepts emails of the mailbox in the secondary room
+func (b *Bot) runFanoutAccept(ctx context.Context, commandSlice []string) {
+	evt := eventFromContext(ctx)
+	if len(commandSlice) < 2 {
+		b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("Usage: `%s fanout:accept MAILBOX`", b.prefix), linkpearl.RelatesTo(evt.ID))
+		return
+	}
+	mailbox := utils.MailboxAddress(commandSlice[1])
+	primaryID, ok := b.getMapping(mailbox)
+	if !ok {
+		b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("mailbox `%s` doesn't exist, kupo", mailbox), linkpearl.RelatesTo(evt.ID))
+		return
+	}
+
+	b.mu.Lock(fanoutLock)
+	primaryCfg, err := b.cfg.GetRoom(ctx, primaryID)
+	if err != nil {
+		b.mu.Unlock(fanoutLock)
+		b.Error(ctx, "failed to retrieve settings: %v", err)
+		return
+	}
+	pending := primaryCfg.FanoutPending()
+	if !slices.Contains(pending, evt.RoomID) {
+		b.mu.Unlock(fanoutLock)
+		b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("mailbox `%s` didn't ask to deliver its emails to this room, kupo", mailbox), linkpearl.RelatesTo(evt.ID))
+		return
+	}
+	primaryCfg.Set(config.RoomFanoutPending, joinRoomIDs(slices.DeleteFunc(pending, func(roomID id.RoomID) bool { return roomID == evt.RoomID })))
+	primaryCfg.Set(config.RoomFanout, joinRoomIDs(append(primaryCfg.Fanout(), evt.RoomID)))
+	err = b.cfg.SetRoom(ctx, primaryID, primaryCfg)
+	b.mu.Unlock(fanoutLock)
+	if err != nil {
+		b.Error(ctx, "cannot update settings: %v", err)
+		return
+	}
+
+	// the room without a mailbox is owned by the user who accepted the emails, so nobody else can change its options
+	cfg, err := b.cfg.GetRoom(ctx, evt.RoomID)
+	if err == nil && cfg.Owner() == "" {
+		cfg.Set(config.RoomOwner, evt.Sender.String())
+		if err := b.cfg.SetRoom(ctx, evt.RoomID, cfg); err != nil {
+			b.log.Error().Err(err).Str("room_id", evt.RoomID.String()).Msg("cannot set owner of the secondary room")
+		}
+	}
+
+	address := primaryCfg.Emails(primaryCfg.Mailbox())
+	b.lp.SendNotice(ctx, primaryID, fmt.Sprintf("the `%s` room has accepted emails of this mailbox", evt.RoomID))
+	b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("emails of the `%s` mailbox will be delivered to this room as well, kupo", address), linkpearl.RelatesTo(evt.ID))
+}
+
+// runFanoutLeave stops delivering emails of the mailbox to the secondary room, called from the secondary room
+func (b *Bot) runFanoutLeave(ctx context.Context, commandSlice []string) {
+	evt := eventFromContext(ctx)
+	if len(commandSlice) < 2 {
+		b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("Usage: `%s fanout:leave MAILBOX`", b.prefix), linkpearl.RelatesTo(evt.ID))
+		return
+	}
+	mailbox := utils.MailboxAddress(commandSlice[1])
+	primaryID, ok := b.getMapping(mailbox)
+	if !ok {
+		b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("mailbox `%s` doesn't exist, kupo", mailbox), linkpearl.RelatesTo(evt.ID))
+		return
+	}
+
+	primaryCfg, changed, err := b.removeFanout(ctx, primaryID, evt.RoomID)
+	if err != nil {
+		b.Error(ctx, "cannot update settings: %v", err)
+		return
+	}
+	if !changed {
+		b.lp.SendNotice(ctx, evt.RoomID, "nothing changed, kupo.", linkpearl.RelatesTo(evt.ID))
+		return
+	}
+	b.lp.SendNotice(ctx, primaryID, fmt.Sprintf("the `%s` room has stopped receiving emails of this mailbox", evt.RoomID))
+	b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("emails of the `%s` mailbox will not be delivered to this room anymore, kupo", primaryCfg.Emails(primaryCfg.Mailbox())), linkpearl.RelatesTo(evt.ID))
+}
+
+// removeFanout removes the secondary room from the mailbox (both accepted and pending), returns the mailbox settings and true if changed
+func (b *Bot) removeFanout(ctx context.Context, primaryID, roomID id.RoomID) (config.Room, bool, error) {
+	b.mu.Lock(fanoutLock)
+	defer b.mu.Unlock(fanoutLock)
+
+	cfg, err := b.cfg.GetRoom(ctx, primaryID)
+	if err != nil {
+		return cfg, false, err
+	}
+	isRoom := func(item id.RoomID) bool { return item == roomID }
+	fanout, pending := cfg.Fanout(), cfg.FanoutPending()
+	if !slices.ContainsFunc(fanout, isRoom) && !slices.ContainsFunc(pending, isRoom) {
+		return cfg, false, nil
+	}
+	cfg.Set(config.RoomFanout, joinRoomIDs(slices.DeleteFunc(fanout, isRoom)))
+	cfg.Set(config.RoomFanoutPending, joinRoomIDs(slices.DeleteFunc(pending, isRoom)))
+	return cfg, true, b.cfg.SetRoom(ctx, primaryID, cfg)
+}
@@ -0,0 +1,130 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/mail"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+
+	"github.com/etkecc/postmoogle/internal/bot/config"
+	"github.com/etkecc/postmoogle/internal/email"
+	"github.com/etkecc/postmoogle/internal/srs"
+	"github.com/etkecc/postmoogle/internal/utils"
+)
+
+const srsLock = "srs"
+
+// getSRS returns SRS rewriter, the secret is generated on the first use
+func (b *Bot) getSRS(ctx context.Context) *srs.SRS {
+	b.mu.Lock(srsLock)
+	defer b.mu.Unlock(srsLock)
+
+	cfg := b.cfg.GetBot(ctx)
+	secret := cfg.SRSSecret()
+	if secret == "" {
+		buf := make([]byte, 32)
+		rand.Read(buf) //nolint:errcheck // crypto/rand never returns an error
+		secret = hex.EncodeToString(buf)
+		cfg.Set(config.BotSRSSecret, secret)
+		if err := b.cfg.SetBot(ctx, cfg); err != nil {
+			b.log.Error().Err(err).Msg("cannot save SRS secret")
+		}
+	}
+	return srs.New(secret)
+}
+
+// ReverseSRS decodes the SRS address to the original address
+func (b *Bot) ReverseSRS(ctx context.Context, address string) (string, bool) {
+	if !srs.IsSRS(address) {
+		return "", false
+	}
+	original, err := b.getSRS(ctx).Reverse(address)
+	if err != nil {
+		b.log.Debug().Err(err).Str("address", address).Msg("cannot reverse SRS address")
+		return "", false
+	}
+	return original, true
+}
+
+// sanitizeForward keeps only valid external email addresses
+func (b *Bot) sanitizeForward(value string) string {
+	addresses := []string{}
+	for _, address := range utils.StringSlice(value) {
+		address = strings.ToLower(address)
+		if !email.AddressValid(address) || slices.Contains(utils.Domains(), utils.Hostname(address)) || slices.Contains(addresses, address) {
+			continue
+		}
+		addresses = append(addresses, address)
+	}
+	return strings.Join(addresses, ",")
+}
+
+// rawEmail returns the original email, or composes it if the original is not available (e.g. sent from a local mailbox)
+func rawEmail(eml *email.Email) []byte {
+	if len(eml.Raw) == 0 && strings.Contains(eml.From, "@") {
+		return []byte(eml.Compose())
+	}
+	return eml.Raw
+}
+
+// forwardEmail sends the incoming email to the mailbox's forward addresses
+func (b *Bot) forwardEmail(ctx context.Context, cfg config.Room, eml *email.Email) {
+	tos := cfg.Forward()
+	if len(tos) == 0 {
+		return
+	}
+	b.resendEmail(ctx, "forward", cfg, eml, rawEmail(eml), tos)
+}
+
+// resendEmail sends the original email to the addresses (forwarding, Sieve redirect),
+// the envelope sender is rewritten using SRS and the Delivered-To header is used to detect loops
+func (b *Bot) resendEmail(ctx context.Context, kind string, cfg config.Room, eml *email.Email, raw []byte, tos []string) {
+	log := b.log.With().Str("from", eml.From).Str("to", eml.RcptTo).Str("kind", kind).Logger()
+	if len(raw) == 0 {
+		log.Warn().Msg("raw email is not available, resending is skipped")
+		return
+	}
+	if msg, err := mail.ReadMessage(bytes.NewReader(raw)); err == nil {
+		for _, deliveredTo := range msg.Header["Delivered-To"] {
+			if strings.EqualFold(email.Address(deliveredTo), eml.RcptTo) {
+				log.Warn().Msg("mail loop detected, resending is skipped")
+				return
+			}
+		}
+	}
+
+	from := b.getSRS(ctx).Forward(eml.MailFrom, utils.SanitizeDomain(cfg.Domain()))
+	data := "Delivered-To: " + eml.RcptTo + "\r\n" + string(raw)
+	for i, to := range tos {
+		if !email.AddressValid(to) {
+			log.Warn().Str("resend_to", to).Msg("address is not valid")
+			continue
+		}
+		queueID := id.EventID(kind + "." + strconv.FormatInt(time.Now().UnixNano(), 36) + "." + strconv.Itoa(i))
+		queued, err := b.Sendmail(ctx, queueID, from, to, data, cfg.Relay())
+		if err != nil && !queued {
+			log.Error().Err(err).Str("resend_to", to).Msg("cannot resend email")
+		}
+	}
+}
+
+// bounceEmail routes the bounce sent to the SRS address back to the original sender
+func (b *Bot) bounceEmail(ctx context.Context, original string, eml *email.Email) error {
+	raw := rawEmail(eml)
+	if len(raw) == 0 {
+		return ErrNoRoom
+	}
+	queueID := id.EventID("srs." + strconv.FormatInt(time.Now().UnixNano(), 36))
+	queued, err := b.Sendmail(ctx, queueID, "", original, string(raw), nil)
+	if err != nil && !queued {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package bot
+
+import (
+	"context"
+	"time"
+
+	"maunium.net/go/mautrix"
+)
+
+// onSync records the time of the last successful matrix /sync
+func (b *Bot) onSync(_ context.Context, _ *mautrix.RespSync, _ string) bool {
+	b.lastSync.Store(time.Now().Unix())
+	return true
+}
+
+// SyncedAt returns the time of the last successful matrix /sync, zero if there was none yet
+func (b *Bot) SyncedAt() time.Time {
+	ts := b.lastSync.Load()
+	if ts == 0 {
+		return time.Time{}
+	}
+	return time.Unix(ts, 0)
+}
+
+// PingDB checks if the database answers
+func (b *Bot) PingDB(ctx context.Context) error {
+	return b.lp.GetDB().PingContext(ctx)
+}
+
+// QueueStats returns queue depth and age of the oldest queued email
+func (b *Bot) QueueStats(ctx context.Context) (int, time.Duration, error) {
+	return b.q.Stats(ctx)
+}
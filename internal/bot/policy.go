@@ -0,0 +1,244 @@
+package bot
+
+import (
+	"context"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/etkecc/postmoogle/internal/utils"
+)
+
+// policy rule event types, including legacy and unstable ones still used by Mjolnir/Draupnir
+var (
+	policyServerTypes = []event.Type{event.StatePolicyServer, event.StateLegacyPolicyServer, event.StateUnstablePolicyServer}
+	policyUserTypes   = []event.Type{event.StatePolicyUser, event.StateLegacyPolicyUser, event.StateUnstablePolicyUser}
+)
+
+// policyRule is a ban recommendation from a moderation policy list
+type policyRule struct {
+	roomID id.RoomID
+	entity string
+	reason string
+	server bool
+}
+
+// policyList contains ban rules of the moderation policy rooms, applied to SMTP connections and incoming emails
+type policyList struct {
+	mu       sync.RWMutex
+	rules    map[string]*policyRule // key = room ID, event type, and state key
+	banset   *utils.PrefixSet[*policyRule]
+	spamlist []string
+}
+
+func newPolicyList() *policyList {
+	return &policyList{
+		rules:  map[string]*policyRule{},
+		banset: utils.NewPrefixSet[*policyRule](),
+	}
+}
+
+// set (or remove) a rule from the policy room state event
+func (p *policyList) set(evt *event.Event) {
+	if evt.StateKey == nil {
+		return
+	}
+	key := evt.RoomID.String() + " " + evt.Type.Type + " " + *evt.StateKey
+	content := evt.Content.AsModPolicy()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// empty content = rule has been removed, hashed entities can't be applied to emails
+	if content.Entity == "" || !isPolicyBan(content.Recommendation) {
+		delete(p.rules, key)
+		return
+	}
+	p.rules[key] = &policyRule{
+		roomID: evt.RoomID,
+		entity: strings.ToLower(strings.TrimSpace(content.Entity)),
+		reason: content.Reason,
+		server: slices.Contains(policyServerTypes, evt.Type),
+	}
+}
+
+// reset removes rules of the rooms not present in the list
+func (p *policyList) reset(roomIDs []id.RoomID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, rule := range p.rules {
+		if !slices.Contains(roomIDs, rule.roomID) {
+			delete(p.rules, key)
+		}
+	}
+}
+
+// rebuild IP matching set and spamlist patterns from the rules
+func (p *policyList) rebuild() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	banset := utils.NewPrefixSet[*policyRule]()
+	spamlist := []string{}
+	for _, rule := range p.rules {
+		if rule.server {
+			if prefix, err := utils.ParsePrefix(rule.entity); err == nil {
+				banset.Add(prefix, rule)
+				continue
+			}
+		}
+		if pattern := policySpamPattern(rule); pattern != "" && !slices.Contains(spamlist, pattern) {
+			spamlist = append(spamlist, pattern)
+		}
+	}
+	sort.Strings(spamlist)
+	p.banset = banset
+	p.spamlist = spamlist
+}
+
+// match IP against the server rules
+func (p *policyList) match(ip netip.Addr) (*policyRule, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.banset.Match(ip)
+}
+
+// stats returns amount of banned IPs/CIDRs and spamlist patterns
+func (p *policyList) stats() (bans, patterns int) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.banset.Len(), len(p.spamlist)
+}
+
+// getSpamlist returns spamlist patterns of the server and user rules
+func (p *policyList) getSpamlist() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.spamlist
+}
+
+// isPolicyBan checks if the recommendation means ban
+func isPolicyBan(recommendation event.PolicyRecommendation) bool {
+	return recommendation == event.PolicyRecommendationBan ||
+		recommendation == event.PolicyRecommendationUnstableBan ||
+		recommendation == event.PolicyRecommendationUnstableTakedown
+}
+
+// policySpamPattern converts a rule into spamlist pattern:
+// server rule `*.example.com` becomes `*@*.example.com`, user rule `@spam*:example.com` becomes `spam*@example.com`.
+// Rules without a real domain (e.g. `*`) are ignored, because they would block all emails
+func policySpamPattern(rule *policyRule) string {
+	localpart := "*"
+	domain := rule.entity
+	if !rule.server {
+		var ok bool
+		localpart, domain, ok = strings.Cut(strings.TrimPrefix(rule.entity, "@"), ":")
+		if !ok || localpart == "" {
+			return ""
+		}
+	}
+	// the spamlist supports only `*` wildcards
+	localpart = strings.ReplaceAll(localpart, "?", "*")
+	domain = strings.ReplaceAll(domain, "?", "*")
+	if !strings.Contains(strings.Trim(domain, "*."), ".") || strings.ContainsAny(domain, "@/:") {
+		return ""
+	}
+
+	return localpart + "@" + domain
+}
+
+// isPolicyRoom checks if the room is configured as a moderation policy room
+func (b *Bot) isPolicyRoom(ctx context.Context, roomID id.RoomID) bool {
+	return slices.Contains(b.cfg.GetBot(ctx).PolicyRooms(), roomID)
+}
+
+// onSyncPolicies applies policy rule state events of the moderation policy rooms live.
+// Events of the sync response are applied sequentially in their order, and the lists are rebuilt once per response
+func (b *Bot) onSyncPolicies(ctx context.Context, resp *mautrix.RespSync, _ string) bool {
+	var policyRoomIDs []id.RoomID
+	var updated int
+	for roomID, room := range resp.Rooms.Join {
+		events := policyEvents(room)
+		if len(events) == 0 {
+			continue
+		}
+		if policyRoomIDs == nil {
+			policyRoomIDs = b.cfg.GetBot(ctx).PolicyRooms()
+		}
+		if !slices.Contains(policyRoomIDs, roomID) {
+			continue
+		}
+		for _, evt := range events {
+			evt.RoomID = roomID
+			b.log.Debug().Str("roomID", roomID.String()).Str("type", evt.Type.Type).Str("state_key", evt.GetStateKey()).Msg("policy rule has been updated")
+			b.policies.set(evt)
+			updated++
+		}
+	}
+	if updated > 0 {
+		b.policies.rebuild()
+	}
+	return true
+}
+
+// policyEvents returns parsed copies of the policy rule state events of the joined room's sync response, in order.
+// Copies are used, because the sync response is dispatched (and parsed) by the syncer after the sync handlers
+func policyEvents(room *mautrix.SyncJoinedRoom) []*event.Event {
+	events := slices.Concat(room.State.Events, room.Timeline.Events)
+	if room.StateAfter != nil { // state_after is the full state delta, timeline events are already included
+		events = room.StateAfter.Events
+	}
+
+	policyTypes := slices.Concat(policyServerTypes, policyUserTypes)
+	parsed := []*event.Event{}
+	for _, evt := range events {
+		if evt.StateKey == nil {
+			continue
+		}
+		evtType := evt.Type
+		evtType.Class = event.StateEventType
+		if !slices.Contains(policyTypes, evtType) {
+			continue
+		}
+		policyEvt := *evt
+		policyEvt.Type = evtType
+		policyEvt.Content = event.Content{VeryRaw: evt.Content.VeryRaw}
+		if err := policyEvt.Content.ParseRaw(evtType); err != nil {
+			continue
+		}
+		parsed = append(parsed, &policyEvt)
+	}
+	return parsed
+}
+
+// loadPolicies loads full state of the moderation policy rooms, joining them if needed
+func (b *Bot) loadPolicies(ctx context.Context) error {
+	roomIDs := b.cfg.GetBot(ctx).PolicyRooms()
+	b.policies.reset(roomIDs)
+	defer b.policies.rebuild()
+
+	for _, roomID := range roomIDs {
+		if _, err := b.lp.GetClient().JoinRoomByID(ctx, roomID); err != nil {
+			return err
+		}
+		state, err := b.lp.GetClient().State(ctx, roomID)
+		if err != nil {
+			return err
+		}
+		for _, evtType := range slices.Concat(policyServerTypes, policyUserTypes) {
+			for _, evt := range state[evtType] {
+				evt.RoomID = roomID
+				evt.Type.Class = event.StateEventType
+				b.policies.set(evt)
+			}
+		}
+	}
+	bans, patterns := b.policies.stats()
+	b.log.Info().Int("rooms", len(roomIDs)).Int("bans", bans).Int("spamlist", patterns).Msg("policy lists have been loaded")
+	return nil
+}
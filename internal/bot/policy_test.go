@@ -0,0 +1,136 @@
+package bot
+
+import (
+	"encoding/json"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func testPolicyEvent(t *testing.T, evtType event.Type, stateKey, entity string, recommendation event.PolicyRecommendation) *event.Event {
+	t.Helper()
+	content := map[string]any{}
+	if entity != "" {
+		content = map[string]any{"entity": entity, "recommendation": recommendation, "reason": "spam"}
+	}
+	raw, err := json.Marshal(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	evtType.Class = event.UnknownEventType // as received from /sync
+	return &event.Event{Type: evtType, StateKey: &stateKey, Content: event.Content{VeryRaw: raw}}
+}
+
+func TestPolicySpamPattern(t *testing.T) {
+	tests := map[string]struct {
+		entity   string
+		server   bool
+		expected string
+	}{
+		"server":          {"example.com", true, "*@example.com"},
+		"server wildcard": {"*.example.com", true, "*@*.example.com"},
+		"server question": {"spam?.example.com", true, "*@spam*.example.com"},
+		"server all":      {"*", true, ""},
+		"server tld":      {"*.com", true, ""},
+		"server ip":       {"192.0.2.1", true, "*@192.0.2.1"},
+		"server port":     {"example.com:8448", true, ""},
+		"user":            {"@spam:example.com", false, "spam@example.com"},
+		"user wildcard":   {"@spam*:*.example.com", false, "spam*@*.example.com"},
+		"user all":        {"@*:*", false, ""},
+		"user no server":  {"@spam", false, ""},
+		"user no local":   {"@:example.com", false, ""},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			pattern := policySpamPattern(&policyRule{entity: test.entity, server: test.server})
+			if pattern != test.expected {
+				t.Error(test.expected, "!=", pattern)
+			}
+		})
+	}
+}
+
+func TestPolicyList(t *testing.T) {
+	policies := newPolicyList()
+	room := &mautrix.SyncJoinedRoom{}
+	room.State.Events = []*event.Event{
+		testPolicyEvent(t, event.StatePolicyServer, "ip", "192.0.2.0/24", event.PolicyRecommendationBan),
+		testPolicyEvent(t, event.StatePolicyServer, "host", "*.spam.example", event.PolicyRecommendationBan),
+		testPolicyEvent(t, event.StateUnstablePolicyUser, "user", "@spammer:example.org", event.PolicyRecommendationUnstableBan),
+		testPolicyEvent(t, event.StatePolicyUser, "hashed", "", event.PolicyRecommendationBan),
+		testPolicyEvent(t, event.StatePolicyUser, "other", "@friend:example.org", "org.example.follow"),
+	}
+	room.Timeline.Events = []*event.Event{
+		testPolicyEvent(t, event.StatePolicyServer, "ip6", "2001:db8::1", event.PolicyRecommendationBan),
+		{Type: event.EventMessage, Content: event.Content{VeryRaw: json.RawMessage(`{"body":"hello"}`)}},
+	}
+	events := policyEvents(room)
+	if len(events) != 6 {
+		t.Fatal(6, "!=", len(events))
+	}
+	for _, evt := range events {
+		evt.RoomID = "!policy:example.com"
+		policies.set(evt)
+	}
+	policies.rebuild()
+
+	bans, patterns := policies.stats()
+	if bans != 2 || patterns != 2 {
+		t.Error("bans:", 2, "!=", bans, "patterns:", 2, "!=", patterns)
+	}
+	if spamlist := policies.getSpamlist(); !reflect.DeepEqual(spamlist, []string{"*@*.spam.example", "spammer@example.org"}) {
+		t.Error("unexpected spamlist:", spamlist)
+	}
+
+	ipTests := map[string]bool{
+		"192.0.2.1":   true,
+		"192.0.3.1":   false,
+		"2001:db8::1": true,
+		"2001:db8::2": false,
+	}
+	for ip, expected := range ipTests {
+		t.Run(ip, func(t *testing.T) {
+			rule, ok := policies.match(netip.MustParseAddr(ip))
+			if ok != expected {
+				t.Error(expected, "!=", ok)
+			}
+			if ok && (rule.reason != "spam" || rule.roomID != "!policy:example.com") {
+				t.Errorf("unexpected rule: %+v", rule)
+			}
+		})
+	}
+
+	t.Run("remove", func(t *testing.T) {
+		for _, evt := range []*event.Event{
+			testPolicyEvent(t, event.StatePolicyServer, "ip", "", ""),
+			testPolicyEvent(t, event.StateUnstablePolicyUser, "user", "", ""),
+		} {
+			evt.Type.Class = event.StateEventType
+			evt.RoomID = "!policy:example.com"
+			if err := evt.Content.ParseRaw(evt.Type); err != nil {
+				t.Fatal(err)
+			}
+			policies.set(evt)
+		}
+		policies.rebuild()
+		if _, ok := policies.match(netip.MustParseAddr("192.0.2.1")); ok {
+			t.Error("removed ban still matches")
+		}
+		if spamlist := policies.getSpamlist(); !reflect.DeepEqual(spamlist, []string{"*@*.spam.example"}) {
+			t.Error("unexpected spamlist:", spamlist)
+		}
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		policies.reset([]id.RoomID{"!other:example.com"})
+		policies.rebuild()
+		if bans, patterns := policies.stats(); bans != 0 || patterns != 0 {
+			t.Error("rules of the removed room are kept:", bans, patterns)
+		}
+	})
+}
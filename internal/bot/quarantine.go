@@ -0,0 +1,278 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/etkecc/go-linkpearl"
+	"github.com/jhillyerd/enmime/v2"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/etkecc/postmoogle/internal/bot/config"
+	"github.com/etkecc/postmoogle/internal/email"
+)
+
+const (
+	quarantineLock    = "quarantine"
+	quarantineRelease = "release"
+	quarantineDelete  = "delete"
+)
+
+var quarantineReactions = map[string]string{
+	"✅":  quarantineRelease,
+	"✔️": quarantineRelease,
+	"🗑️": quarantineDelete,
+	"🗑":  quarantineDelete,
+}
+
+// SetAntivirus sets the attachments scanner, used to scan quarantined emails again on release
+func (b *Bot) SetAntivirus(antivirus func(context.Context, *email.Email, id.RoomID) error) {
+	b.antivirus = antivirus
+}
+
+// getQuarantine returns quarantine room and thread of the mailbox:
+// mailbox's own quarantine thread (created on demand), server-wide quarantine room, or nothing
+func (b *Bot) getQuarantine(ctx context.Context, roomID id.RoomID, cfg config.Room) (id.RoomID, id.EventID) {
+	if !cfg.Quarantine() {
+		return b.cfg.GetBot(ctx).QuarantineRoom(), ""
+	}
+
+	b.mu.Lock(roomID.String())
+	defer b.mu.Unlock(roomID.String())
+	if threadID := cfg.QuarantineThread(); threadID != "" {
+		return roomID, threadID
+	}
+
+	msg := fmt.Sprintf("🛡️ Quarantine of this mailbox: suspicious emails will be posted in this thread. "+
+		"React with ✅ to release an email, or with 🗑️ to delete it. Quarantined emails are deleted automatically after %d days",
+		b.cfg.GetBot(ctx).QuarantineRetention())
+	content := format.RenderMarkdown(msg, true, true)
+	content.MsgType = event.MsgNotice
+	threadID, err := b.lp.Send(ctx, roomID, &content)
+	if err != nil {
+		b.log.Error().Err(err).Str("room_id", roomID.String()).Msg("cannot create quarantine thread")
+		return b.cfg.GetBot(ctx).QuarantineRoom(), ""
+	}
+	cfg.Set(config.RoomQuarantineThread, threadID.String())
+	if err := b.cfg.SetRoom(ctx, roomID, cfg); err != nil {
+		b.log.Error().Err(err).Str("room_id", roomID.String()).Msg("cannot save quarantine thread")
+	}
+
+	return roomID, threadID
+}
+
+// quarantineEmail posts suspicious email into the quarantine room (or mailbox's quarantine thread) instead of the mailbox room.
+// The raw email is kept in the database to be released later (it never leaves the server), attachments are not uploaded
+func (b *Bot) quarantineEmail(ctx context.Context, quarantineRoomID id.RoomID, threadID id.EventID, roomID id.RoomID, cfg config.Room, eml *email.Email) error {
+	var msg strings.Builder
+	msg.WriteString("🛡️ email from `")
+	msg.WriteString(eml.From)
+	msg.WriteString("` to `")
+	msg.WriteString(eml.RcptTo)
+	msg.WriteString("` (")
+	msg.WriteString(roomID.String())
+	msg.WriteString(") has been quarantined")
+	if eml.Subject != "" {
+		msg.WriteString(": **")
+		msg.WriteString(eml.Subject)
+		msg.WriteString("**")
+	}
+	msg.WriteString("\n\nReasons:\n")
+	for _, reason := range eml.Quarantine {
+		msg.WriteString("* ")
+		msg.WriteString(reason)
+		msg.WriteString("\n")
+	}
+	if eml.Spam != nil && len(eml.Spam.Symbols) > 0 {
+		msg.WriteString("\nSymbols: `")
+		msg.WriteString(strings.Join(eml.Spam.Symbols, "`, `"))
+		msg.WriteString("`\n")
+	}
+	if len(eml.Infected) > 0 {
+		msg.WriteString("\nInfected files (removed): `")
+		msg.WriteString(strings.Join(eml.Infected, "`, `"))
+		msg.WriteString("`\n")
+	}
+	if files := len(eml.Files) + len(eml.InlineFiles); files > 0 {
+		msg.WriteString("\nAttachments (not uploaded): ")
+		msg.WriteString(strconv.Itoa(files))
+		msg.WriteString("\n")
+	}
+	msg.WriteString("\nReact with ✅ to release the email, or with 🗑️ to delete it. ")
+	msg.WriteString(fmt.Sprintf("It will be deleted automatically after %d days", b.cfg.GetBot(ctx).QuarantineRetention()))
+
+	notice := format.RenderMarkdown(msg.String(), true, true)
+	notice.MsgType = event.MsgNotice
+	if threadID != "" {
+		notice.RelatesTo = linkpearl.RelatesTo(threadID)
+	}
+	eventID, err := b.lp.Send(ctx, quarantineRoomID, &notice)
+	if err != nil {
+		return err
+	}
+	if threadID == "" {
+		threadID = eventID
+	}
+	contentOpts := cfg.ContentOptions()
+	contentOpts.Threads = true
+	contentID, err := b.lp.Send(ctx, quarantineRoomID, eml.Content(threadID, contentOpts))
+	if err != nil {
+		return err
+	}
+	if len(eml.Raw) > 0 {
+		if err := b.cfg.SetQuarantineRaw(ctx, eventID, eml.Raw); err != nil {
+			return err
+		}
+	}
+
+	b.mu.Lock(quarantineLock)
+	defer b.mu.Unlock(quarantineLock)
+	quarantine := b.cfg.GetQuarantine(ctx)
+	quarantine.Add(&config.QuarantineItem{
+		EventID:       eventID,
+		ContentID:     contentID,
+		RoomID:        quarantineRoomID,
+		TargetRoomID:  roomID,
+		RcptTo:        eml.RcptTo,
+		QuarantinedAt: time.Now().UTC(),
+	})
+	return b.cfg.SetQuarantine(ctx, quarantine)
+}
+
+// handleQuarantineReaction releases or deletes quarantined email, returns false if the reaction is not related to quarantine
+func (b *Bot) handleQuarantineReaction(ctx context.Context, key string, srcID id.EventID) bool {
+	action, ok := quarantineReactions[key]
+	if !ok {
+		return false
+	}
+
+	evt := eventFromContext(ctx)
+	item, ok, err := b.takeQuarantined(ctx, srcID, evt.RoomID, evt.Sender)
+	if !ok {
+		return false
+	}
+	if item == nil {
+		b.lp.SendNotice(ctx, evt.RoomID, "not allowed to do that, kupo", linkpearl.RelatesTo(srcID))
+		return true
+	}
+	if err != nil {
+		b.Error(ctx, "cannot save quarantine: %v", err)
+		return true
+	}
+
+	// the email is removed from the index already, so it's delivered without holding the quarantine lock
+	if action == quarantineRelease {
+		if err := b.releaseQuarantined(ctx, item); err != nil {
+			b.restoreQuarantined(ctx, item)
+			b.Error(ctx, "cannot release quarantined email: %v", err)
+			return true
+		}
+	}
+	b.removeQuarantined(ctx, item)
+
+	msg := fmt.Sprintf("email to `%s` has been deleted from quarantine, kupo", item.RcptTo)
+	if action == quarantineRelease {
+		msg = fmt.Sprintf("email to `%s` has been released from quarantine, kupo", item.RcptTo)
+	}
+	b.lp.SendNotice(ctx, evt.RoomID, msg)
+	return true
+}
+
+// takeQuarantined removes the quarantined email from the index, so it can't be released or deleted twice.
+// Returns false if the event is not a quarantine notice of the room, and nil item if the sender is not allowed to manage it
+func (b *Bot) takeQuarantined(ctx context.Context, eventID id.EventID, roomID id.RoomID, sender id.UserID) (*config.QuarantineItem, bool, error) {
+	b.mu.Lock(quarantineLock)
+	defer b.mu.Unlock(quarantineLock)
+	quarantine := b.cfg.GetQuarantine(ctx)
+	item, ok := quarantine.Get(eventID)
+	if !ok || item.RoomID != roomID {
+		return nil, false, nil
+	}
+	if !b.allowOwner(ctx, sender, item.TargetRoomID) {
+		return nil, true, nil
+	}
+
+	quarantine.Remove(eventID)
+	return item, true, b.cfg.SetQuarantine(ctx, quarantine)
+}
+
+// restoreQuarantined adds the email back to the index, e.g. when it cannot be released
+func (b *Bot) restoreQuarantined(ctx context.Context, item *config.QuarantineItem) {
+	b.mu.Lock(quarantineLock)
+	defer b.mu.Unlock(quarantineLock)
+	quarantine := b.cfg.GetQuarantine(ctx)
+	quarantine.Add(item)
+	if err := b.cfg.SetQuarantine(ctx, quarantine); err != nil {
+		b.log.Error().Err(err).Str("event_id", item.EventID.String()).Msg("cannot restore quarantined email")
+	}
+}
+
+// releaseQuarantined loads the raw quarantined email and delivers it to the mailbox room
+func (b *Bot) releaseQuarantined(ctx context.Context, item *config.QuarantineItem) error {
+	data, err := b.cfg.GetQuarantineRaw(ctx, item.EventID)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return fmt.Errorf("raw email is not available") //nolint:goerr113 // no need for a sentinel error
+	}
+	envelope, err := enmime.ReadEnvelope(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	eml := email.FromEnvelope(item.RcptTo, envelope)
+	eml.Raw = data
+	// antivirus signatures may be updated since the email has been quarantined
+	if b.antivirus != nil {
+		if err := b.antivirus(ctx, eml, item.TargetRoomID); err != nil {
+			return err
+		}
+	}
+	return b.IncomingEmail(ctx, eml)
+}
+
+// removeQuarantined deletes the raw quarantined email, and redacts quarantine notice and email content
+func (b *Bot) removeQuarantined(ctx context.Context, item *config.QuarantineItem) {
+	if err := b.cfg.RemoveQuarantineRaw(ctx, item.EventID); err != nil {
+		b.log.Warn().Err(err).Str("event_id", item.EventID.String()).Msg("cannot delete raw quarantined email")
+	}
+	if item.RoomID == "" {
+		return
+	}
+	for _, eventID := range []id.EventID{item.ContentID, item.EventID} {
+		if eventID == "" {
+			continue
+		}
+		if _, err := b.lp.GetClient().RedactEvent(ctx, item.RoomID, eventID); err != nil {
+			b.log.Warn().Err(err).Str("event_id", eventID.String()).Msg("cannot redact quarantined email")
+		}
+	}
+}
+
+// PruneQuarantine deletes quarantined emails older than the retention period
+func (b *Bot) PruneQuarantine() {
+	ctx := context.Background()
+	b.mu.Lock(quarantineLock)
+	defer b.mu.Unlock(quarantineLock)
+
+	quarantine := b.cfg.GetQuarantine(ctx)
+	expired := quarantine.Expired(time.Now().UTC().AddDate(0, 0, -b.cfg.GetBot(ctx).QuarantineRetention()))
+	if len(expired) == 0 {
+		return
+	}
+	for _, item := range expired {
+		b.removeQuarantined(ctx, item)
+		quarantine.Remove(item.EventID)
+	}
+	if err := b.cfg.SetQuarantine(ctx, quarantine); err != nil {
+		b.log.Error().Err(err).Msg("cannot prune quarantine")
+		return
+	}
+	b.log.Debug().Int("quarantine", len(expired)).Msg("quarantine has been pruned")
+}
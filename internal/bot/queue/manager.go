@@ -2,6 +2,7 @@ package queue
 
 import (
 	"context"
+	"errors"
 	"net/url"
 
 	"github.com/etkecc/go-kit"
@@ -17,6 +18,9 @@ const (
 	defaultQueueRetries = 100
 )
 
+// ErrMaxRetries is passed to the result handler when the email has been removed from the queue after the max retries
+var ErrMaxRetries = errors.New("max retries reached")
+
 // Queue manager
 type Queue struct {
 	mu       *kit.Mutex
@@ -24,6 +28,7 @@ type Queue struct {
 	cfg      *config.Manager
 	log      *zerolog.Logger
 	sendmail func(string, string, string, *url.URL) error
+	onResult func(ctx context.Context, from, to, data string, err error)
 }
 
 // New queue
@@ -41,6 +46,20 @@ func (q *Queue) SetSendmail(function func(string, string, string, *url.URL) erro
 	q.sendmail = function
 }
 
+// SetResultHandler sets func called when the queued email has been delivered (err is nil)
+// or removed from the queue after the max retries (err is the last delivery error)
+func (q *Queue) SetResultHandler(function func(ctx context.Context, from, to, data string, err error)) {
+	q.onResult = function
+}
+
+// result calls the result handler, if any
+func (q *Queue) result(ctx context.Context, item map[string]string, err error) {
+	if q.onResult == nil {
+		return
+	}
+	q.onResult(ctx, item["from"], item["to"], item["data"], err)
+}
+
 // Process queue
 func (q *Queue) Process() {
 	q.log.Debug().Msg("staring queue processing...")
@@ -59,6 +78,7 @@ func (q *Queue) Process() {
 
 	q.mu.Lock(acQueueKey)
 	defer q.mu.Unlock(acQueueKey)
+	defer q.updateMetrics(ctx)
 	index, err := q.lp.GetAccountData(ctx, acQueueKey)
 	if err != nil {
 		q.log.Error().Err(err).Msg("cannot get queue index")
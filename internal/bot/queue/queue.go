@@ -2,8 +2,12 @@ package queue
 
 import (
 	"context"
+	"fmt"
 	"net/url"
 	"strconv"
+	"time"
+
+	"github.com/etkecc/postmoogle/internal/metrics"
 )
 
 // Add to queue
@@ -20,6 +24,7 @@ func (q *Queue) Add(ctx context.Context, id, from, to, data string, relayOverrid
 		"from":     from,
 		"to":       to,
 		"id":       id,
+		"created":  strconv.FormatInt(time.Now().Unix(), 10),
 	}
 
 	q.mu.Lock(itemkey)
@@ -43,6 +48,7 @@ func (q *Queue) Add(ctx context.Context, id, from, to, data string, relayOverrid
 		q.log.Error().Err(err).Msg("cannot save queue index")
 		return err
 	}
+	metrics.Sent(metrics.SendQueued)
 
 	return nil
 }
@@ -87,6 +93,9 @@ func (q *Queue) try(ctx context.Context, itemkey string, maxRetries int) bool {
 		return false
 	}
 	if attempts > maxRetries {
+		q.log.Warn().Str("id", itemkey).Str("from", item["from"]).Str("to", item["to"]).Msg("max retries reached, email has been removed from the queue")
+		metrics.Sent(metrics.SendExpired)
+		q.result(ctx, item, fmt.Errorf("%w, last error: %s", ErrMaxRetries, item["error"]))
 		return true
 	}
 
@@ -98,12 +107,14 @@ func (q *Queue) try(ctx context.Context, itemkey string, maxRetries int) bool {
 	err = q.sendmail(item["from"], item["to"], item["data"], relayOverride)
 	if err == nil {
 		q.log.Info().Str("id", itemkey).Msg("email from queue was delivered")
+		q.result(ctx, item, nil)
 		return true
 	}
 
 	q.log.Info().Str("id", itemkey).Str("from", item["from"]).Str("to", item["to"]).Err(err).Msg("attempted to deliver email, but it's not ready yet")
 	attempts++
 	item["attempts"] = strconv.Itoa(attempts)
+	item["error"] = err.Error()
 	err = q.lp.SetAccountData(ctx, itemkey, item)
 	if err != nil {
 		q.log.Error().Err(err).Str("id", itemkey).Msg("cannot update attempt count on email")
@@ -111,3 +122,96 @@ func (q *Queue) try(ctx context.Context, itemkey string, maxRetries int) bool {
 
 	return false
 }
+
+// Item of the queue, without the email data
+type Item struct {
+	ID       string
+	From     string
+	To       string
+	Attempts int
+}
+
+// List queued emails
+func (q *Queue) List(ctx context.Context) ([]Item, error) {
+	q.mu.Lock(acQueueKey)
+	defer q.mu.Unlock(acQueueKey)
+	index, err := q.lp.GetAccountData(ctx, acQueueKey)
+	if err != nil {
+		q.log.Error().Err(err).Msg("cannot get queue index")
+		return nil, err
+	}
+
+	items := make([]Item, 0, len(index))
+	for id, itemkey := range index {
+		q.mu.Lock(itemkey)
+		item, err := q.lp.GetAccountData(ctx, itemkey)
+		q.mu.Unlock(itemkey)
+		if err != nil {
+			q.log.Error().Err(err).Str("id", id).Msg("cannot retrieve a queue item")
+			continue
+		}
+		attempts, _ := strconv.Atoi(item["attempts"]) //nolint:errcheck // doesn't matter
+		items = append(items, Item{ID: id, From: item["from"], To: item["to"], Attempts: attempts})
+	}
+	return items, nil
+}
+
+// Cancel removes the email from the queue without delivering it, returns false if it's not queued
+func (q *Queue) Cancel(ctx context.Context, id string) (bool, error) {
+	q.mu.Lock(acQueueKey)
+	defer q.mu.Unlock(acQueueKey)
+	index, err := q.lp.GetAccountData(ctx, acQueueKey)
+	if err != nil {
+		q.log.Error().Err(err).Msg("cannot get queue index")
+		return false, err
+	}
+	if _, ok := index[id]; !ok {
+		return false, nil
+	}
+	return true, q.Remove(ctx, id)
+}
+
+// Stats returns queue depth and age of the oldest queued email
+func (q *Queue) Stats(ctx context.Context) (depth int, oldest time.Duration, err error) {
+	q.mu.Lock(acQueueKey)
+	defer q.mu.Unlock(acQueueKey)
+	return q.stats(ctx)
+}
+
+// stats returns queue depth and age of the oldest queued email, the queue index must be locked by the caller
+func (q *Queue) stats(ctx context.Context) (depth int, oldest time.Duration, err error) {
+	index, err := q.lp.GetAccountData(ctx, acQueueKey)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	now := time.Now()
+	oldestAt := now
+	for _, itemkey := range index {
+		q.mu.Lock(itemkey)
+		item, err := q.lp.GetAccountData(ctx, itemkey)
+		q.mu.Unlock(itemkey)
+		if err != nil {
+			continue
+		}
+		// items queued before the created timestamp was introduced have no age
+		created, err := strconv.ParseInt(item["created"], 10, 64)
+		if err != nil {
+			continue
+		}
+		if createdAt := time.Unix(created, 0); createdAt.Before(oldestAt) {
+			oldestAt = createdAt
+		}
+	}
+	return len(index), now.Sub(oldestAt), nil
+}
+
+// updateMetrics sets queue depth and age of the oldest queued email, the queue index must be locked by the caller
+func (q *Queue) updateMetrics(ctx context.Context) {
+	depth, oldest, err := q.stats(ctx)
+	if err != nil {
+		q.log.Error().Err(err).Msg("cannot get queue stats")
+		return
+	}
+	metrics.Queue(depth, oldest)
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 
 	"github.com/etkecc/go-linkpearl"
+
+	"github.com/etkecc/postmoogle/internal/bot/config"
 )
 
 var supportedReactions = map[string]string{
@@ -16,6 +18,9 @@ var supportedReactions = map[string]string{
 func (b *Bot) handleReaction(ctx context.Context) {
 	evt := eventFromContext(ctx)
 	content := evt.Content.AsReaction()
+	if b.handleQuarantineReaction(ctx, content.GetRelatesTo().Key, content.GetRelatesTo().EventID) {
+		return
+	}
 	action, ok := supportedReactions[content.GetRelatesTo().Key]
 	if !ok { // cannot do anything with it
 		return
@@ -44,6 +49,6 @@ func (b *Bot) handleReaction(ctx context.Context) {
 			b.Error(ctx, "cannot get sender of the email")
 			return
 		}
-		b.runSpamlistAdd(ctx, []string{commandSpamlistAdd, linkpearl.EventField[string](&srcEvt.Content, eventFromKey)})
+		b.runListAdd(ctx, config.RoomSpamlist, []string{commandSpamlistAdd, linkpearl.EventField[string](&srcEvt.Content, eventFromKey)})
 	}
 }
@@ -0,0 +1,287 @@
+package bot
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/etkecc/go-linkpearl"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/etkecc/postmoogle/internal/bot/config"
+	"github.com/etkecc/postmoogle/internal/email"
+	"github.com/etkecc/postmoogle/internal/imap"
+	"github.com/etkecc/postmoogle/internal/metrics"
+	"github.com/etkecc/postmoogle/internal/sieve"
+	"github.com/etkecc/postmoogle/internal/utils"
+)
+
+// filterEmail runs the mailbox's Sieve script against the email and executes the resulting actions
+func (b *Bot) filterEmail(ctx context.Context, roomID id.RoomID, cfg config.Room, eml *email.Email) error {
+	log := b.log.With().Str("room_id", roomID.String()).Str("from", eml.From).Str("to", eml.RcptTo).Logger()
+	script, err := sieve.Parse(cfg.Sieve())
+	if err != nil { // the script is validated when set, so that should never happen
+		log.Error().Err(err).Msg("cannot parse sieve script, delivering as is")
+		return b.deliverEmail(ctx, roomID, cfg, eml)
+	}
+
+	raw := rawEmail(eml)
+	msg := sieve.NewMessage(eml.MailFrom, eml.RcptTo, raw, eml.Text, eml.HTML)
+	result := script.Execute(msg)
+	log.Info().
+		Bool("keep", result.Keep).
+		Strs("fileinto", result.FileInto).
+		Strs("redirect", result.Redirect).
+		Bool("reject", result.Reject).
+		Bool("vacation", result.Vacation != nil).
+		Msg("sieve script has been executed")
+
+	if result.Reject {
+		return &email.RejectError{Reason: result.RejectReason}
+	}
+	if result.Vacation != nil {
+		b.sendVacation(ctx, roomID, cfg, eml, msg, result.Vacation)
+	}
+	if len(result.Redirect) > 0 {
+		b.resendEmail(ctx, "sieve", cfg, eml, raw, result.Redirect)
+	}
+	for _, target := range result.FileInto {
+		if err := b.fileinto(ctx, roomID, cfg, copyEmail(eml), target); err != nil {
+			return err
+		}
+	}
+	if result.Keep {
+		if err := b.deliverEmail(ctx, roomID, cfg, copyEmail(eml)); err != nil {
+			return err
+		}
+		b.fanoutEmail(ctx, cfg, eml)
+		b.forwardEmail(ctx, cfg, eml)
+	}
+	if result.Discarded() {
+		log.Info().Msg("email has been discarded by sieve script")
+	}
+	return nil
+}
+
+// copyEmail returns a shallow copy of the email, so it can be delivered multiple times
+func copyEmail(eml *email.Email) *email.Email {
+	emlCopy := *eml
+	emlCopy.Files = slices.Clone(eml.Files)
+	return &emlCopy
+}
+
+// fileinto delivers the email to another mailbox of the same owner, or into the tag thread of the mailbox
+func (b *Bot) fileinto(ctx context.Context, roomID id.RoomID, cfg config.Room, eml *email.Email, target string) error {
+	if targetRoomID, ok := b.getMapping(utils.MailboxAddress(target)); ok {
+		if targetRoomID == roomID {
+			return b.deliverEmail(ctx, roomID, cfg, eml)
+		}
+		targetCfg, err := b.cfg.GetRoom(ctx, targetRoomID)
+		if err == nil && targetCfg.Owner() == cfg.Owner() {
+			return b.deliverEmail(ctx, targetRoomID, targetCfg, eml)
+		}
+		b.log.Warn().Str("room_id", roomID.String()).Str("target", target).Msg("sieve fileinto target mailbox has another owner, using it as a tag")
+	}
+
+	tag := strings.ToLower(target)
+	threadID, err := b.getSieveTagThread(ctx, roomID, cfg, tag)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock(roomID.String())
+	defer b.mu.Unlock(roomID.String())
+	contentOpts := cfg.ContentOptions()
+	contentOpts.Threadify = false
+	contentOpts.Threads = true
+	content := eml.Content("", contentOpts)
+	content.Parsed.(*event.MessageEventContent).RelatesTo = linkpearl.RelatesTo(threadID) //nolint:forcetypeassert // that's ok
+	eventID, err := b.lp.Send(ctx, roomID, content)
+	if err != nil {
+		return err
+	}
+	metrics.Delivered(utils.Hostname(eml.RcptTo))
+	b.setThreadID(ctx, roomID, eml.MessageID, threadID)
+	b.setLastEventID(ctx, roomID, threadID, eventID)
+	b.archiveEmail(ctx, roomID, cfg, imap.FolderInbox, threadID, eml.MessageID, rawEmail(eml))
+
+	if !cfg.NoInlines() {
+		b.sendFiles(ctx, roomID, eml.InlineFiles, false, threadID)
+	}
+	if !cfg.NoFiles() {
+		b.sendFiles(ctx, roomID, eml.Files, false, threadID)
+	}
+	if len(eml.Infected) > 0 {
+		msg := "⚠️ infected files have been removed from the email: `" + strings.Join(eml.Infected, "`, `") + "`"
+		b.lp.SendNotice(ctx, roomID, msg, linkpearl.RelatesTo(threadID))
+	}
+	return nil
+}
+
+// getSieveTagThread returns the mailbox's thread of the Sieve fileinto tag, creating it on demand
+func (b *Bot) getSieveTagThread(ctx context.Context, roomID id.RoomID, cfg config.Room, tag string) (id.EventID, error) {
+	b.mu.Lock(roomID.String())
+	defer b.mu.Unlock(roomID.String())
+	if threadID := cfg.SieveTagThread(tag); threadID != "" {
+		return threadID, nil
+	}
+
+	content := format.RenderMarkdown("🏷️ `"+tag+"`: emails filed into this tag by the Sieve script will be posted in this thread", true, true)
+	content.MsgType = event.MsgNotice
+	threadID, err := b.lp.Send(ctx, roomID, &content)
+	if err != nil {
+		return "", err
+	}
+	cfg.Set(config.RoomSieveTagPrefix+tag, threadID.String())
+	if err := b.cfg.SetRoom(ctx, roomID, cfg); err != nil {
+		b.log.Error().Err(err).Str("room_id", roomID.String()).Str("tag", tag).Msg("cannot save sieve tag thread")
+	}
+	return threadID, nil
+}
+
+// sendVacation sends the Sieve vacation auto-reply, following the RFC 5230 rules:
+// no replies to automatic emails, mailing lists, emails not addressed to the mailbox directly, and only once per :days per sender
+//
+//nolint:gocognit // that's a lot of rules
+func (b *Bot) sendVacation(ctx context.Context, roomID id.RoomID, cfg config.Room, eml *email.Email, msg *sieve.Message, vacation *sieve.Vacation) {
+	log := b.log.With().Str("room_id", roomID.String()).Str("sender", eml.MailFrom).Logger()
+	sender := strings.ToLower(eml.MailFrom)
+	localpart := utils.Mailbox(sender)
+	if sender == "" || localpart == "mailer-daemon" || localpart == "listserv" || localpart == "majordomo" ||
+		strings.HasPrefix(localpart, "owner-") || strings.HasSuffix(localpart, "-request") {
+		log.Debug().Msg("vacation: sender is empty or automatic")
+		return
+	}
+	if auto := msg.Header.Get("Auto-Submitted"); auto != "" && !strings.EqualFold(auto, "no") {
+		log.Debug().Msg("vacation: email is auto-submitted")
+		return
+	}
+	precedence := strings.ToLower(msg.Header.Get("Precedence"))
+	if precedence == "bulk" || precedence == "list" || precedence == "junk" || msg.Header.Get("List-Id") != "" {
+		log.Debug().Msg("vacation: email is sent to a mailing list")
+		return
+	}
+
+	own, from, ignored := vacationAddresses(cfg, vacation)
+	if len(ignored) > 0 {
+		log.Warn().Strs("addresses", ignored).Msg("vacation: addresses don't belong to the mailbox, ignoring them")
+	}
+	recipients := append(email.AddressList(msg.Header.Get("To")), email.AddressList(msg.Header.Get("Cc"))...)
+	if !slices.ContainsFunc(recipients, func(addr string) bool {
+		return slices.ContainsFunc(own, func(ownAddr string) bool { return strings.EqualFold(addr, ownAddr) })
+	}) {
+		log.Debug().Msg("vacation: the mailbox is not a direct recipient of the email")
+		return
+	}
+
+	handle := vacation.Handle
+	if handle == "" {
+		hash := sha256.Sum256([]byte(vacation.Subject + "\n" + vacation.From + "\n" + vacation.Reason))
+		handle = hex.EncodeToString(hash[:8])
+	}
+	key := sender + " " + handle
+	b.mu.Lock("vacation_" + roomID.String())
+	defer b.mu.Unlock("vacation_" + roomID.String())
+	sent := b.cfg.GetVacation(ctx, roomID)
+	if sentAt, ok := sent.GetTime(key); ok && sentAt.After(time.Now().AddDate(0, 0, -vacation.Days)) {
+		log.Debug().Msg("vacation: auto-reply has been sent already")
+		return
+	}
+
+	domain := utils.SanitizeDomain(cfg.Domain())
+	subject := vacation.Subject
+	if subject == "" {
+		subject = "Auto: " + eml.Subject
+	}
+	eventID := id.EventID("vacation." + strconv.FormatInt(time.Now().UnixNano(), 36))
+	reply := email.New(email.MessageID(eventID, domain), eml.MessageID, strings.TrimSpace(eml.References+" "+eml.MessageID), subject, from, sender, sender, "", vacation.Reason, "", nil, nil)
+	reply.AutoSubmitted = true
+	data := reply.Compose(b.GetDKIMKeys(ctx, utils.Hostname(reply.From))...)
+	if data == "" {
+		log.Warn().Msg("vacation: cannot compose auto-reply")
+		return
+	}
+	threadID := b.getThreadID(ctx, roomID, eml.InReplyTo, eml.References)
+	queued, err := b.sendmailFromRoom(ctx, roomID, cfg, eventID, threadID, reply, email.Address(from), sender, data)
+	if err != nil && !queued {
+		log.Error().Err(err).Msg("vacation: cannot send auto-reply")
+		return
+	}
+
+	sent.Prune(time.Now().AddDate(0, 0, -sieve.VacationDaysMax))
+	sent.SetTime(key, time.Now())
+	if err := b.cfg.SetVacation(ctx, roomID, sent); err != nil {
+		log.Error().Err(err).Msg("vacation: cannot save auto-replies")
+	}
+}
+
+// vacationAddresses returns own addresses of the mailbox (mailbox and aliases on each domain) and the auto-reply sender.
+// Auto-replies are DKIM-signed, so the :from and :addresses of the vacation are accepted only if they are own addresses
+// of the mailbox (RFC 5230 section 4.5), the mailbox address is used as the sender otherwise.
+// The ignored :from and :addresses values are returned as well
+func vacationAddresses(cfg config.Room, vacation *sieve.Vacation) (own []string, from string, ignored []string) {
+	domains := utils.Domains()
+	if cfg.DomainScoped() {
+		domains = []string{cfg.Domain()}
+	}
+	for _, mailbox := range append([]string{cfg.Mailbox()}, cfg.Aliases()...) {
+		for _, domain := range domains {
+			own = append(own, strings.ToLower(mailbox+"@"+domain))
+		}
+	}
+	for _, addr := range vacation.Addresses {
+		if !slices.Contains(own, email.Address(addr)) {
+			ignored = append(ignored, addr)
+		}
+	}
+
+	from = vacation.From
+	if from != "" && !slices.Contains(own, email.Address(from)) {
+		ignored = append(ignored, from)
+		from = ""
+	}
+	if from == "" {
+		from = cfg.Mailbox() + "@" + utils.SanitizeDomain(cfg.Domain())
+	}
+	return own, from, ignored
+}
+
+// setSieve validates and sets the Sieve script of the mailbox, the script may be wrapped in a code block
+func (b *Bot) setSieve(ctx context.Context) {
+	evt := eventFromContext(ctx)
+	cfg, err := b.cfg.GetRoom(ctx, evt.RoomID)
+	if err != nil {
+		b.Error(ctx, "failed to retrieve settings: %v", err)
+		return
+	}
+
+	script := utils.UnwrapCodeBlock(strings.Join(b.parseCommand(evt.Content.AsMessage().Body, false)[1:], " "))
+	if script == "reset" {
+		script = ""
+	}
+	if script != "" {
+		if _, err := sieve.Parse(script); err != nil {
+			b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf("invalid Sieve script, %v, kupo", err), linkpearl.RelatesTo(evt.ID, cfg.NoThreads()))
+			return
+		}
+	}
+
+	cfg.Set(config.RoomSieve, script)
+	if err := b.cfg.SetRoom(ctx, evt.RoomID, cfg); err != nil {
+		b.Error(ctx, "cannot update settings: %v", err)
+		return
+	}
+
+	msg := "Sieve script of this room has been removed, kupo"
+	if script != "" {
+		msg = "Sieve script of this room has been updated, kupo"
+	}
+	b.lp.SendNotice(ctx, evt.RoomID, msg, linkpearl.RelatesTo(evt.ID, cfg.NoThreads()))
+}
@@ -0,0 +1,44 @@
+package bot
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/etkecc/postmoogle/internal/bot/config"
+	"github.com/etkecc/postmoogle/internal/sieve"
+	"github.com/etkecc/postmoogle/internal/utils"
+)
+
+func TestVacationAddresses(t *testing.T) {
+	utils.SetDomains([]string{"example.com", "example.org"})
+	cfg := config.Room{config.RoomMailbox: "test", config.RoomAliases: "alias"}
+	scoped := config.Room{config.RoomMailbox: "test", config.RoomDomain: "example.org", config.RoomDomainScoped: "true"}
+	tests := map[string]struct {
+		cfg      config.Room
+		vacation *sieve.Vacation
+		from     string
+		ignored  []string
+	}{
+		"default":       {cfg, &sieve.Vacation{}, "test@example.com", nil},
+		"own from":      {cfg, &sieve.Vacation{From: "Test <Alias@example.org>"}, "Test <Alias@example.org>", nil},
+		"foreign from":  {cfg, &sieve.Vacation{From: "ceo@example.com"}, "test@example.com", []string{"ceo@example.com"}},
+		"external from": {cfg, &sieve.Vacation{From: "someone@gmail.com"}, "test@example.com", []string{"someone@gmail.com"}},
+		"addresses":     {cfg, &sieve.Vacation{Addresses: []string{"alias@example.com", "other@example.com"}}, "test@example.com", []string{"other@example.com"}},
+		"scoped":        {scoped, &sieve.Vacation{From: "test@example.com"}, "test@example.org", []string{"test@example.com"}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			own, from, ignored := vacationAddresses(test.cfg, test.vacation)
+			if from != test.from {
+				t.Error(test.from, "!=", from)
+			}
+			if !slices.Equal(ignored, test.ignored) {
+				t.Error(test.ignored, "!=", ignored)
+			}
+			if !slices.Contains(own, "test@"+utils.SanitizeDomain(test.cfg.Domain())) {
+				t.Error("mailbox address is not own", own)
+			}
+		})
+	}
+}
@@ -8,6 +8,7 @@ import (
 
 func (b *Bot) initSync() {
 	b.lp.SetJoinPermit(b.joinPermit)
+	b.lp.OnSync(b.onSync)
 
 	b.lp.OnEventType(
 		event.StateMember,
@@ -27,6 +28,7 @@ func (b *Bot) initSync() {
 			go b.onReaction(ctx, evt)
 		},
 	)
+	b.lp.OnSync(b.onSyncPolicies)
 }
 
 // joinPermit is called by linkpearl when processing "invite" events and deciding if rooms should be auto-joined or not
@@ -97,6 +99,10 @@ func (b *Bot) onBotJoin(ctx context.Context) {
 	if err == nil && cfg.Mailbox() != "" {
 		return
 	}
+	// do not send introduction/help messages to moderation policy rooms
+	if b.isPolicyRoom(ctx, evt.RoomID) {
+		return
+	}
 
 	// Workaround for membership=join events which are delivered to us twice,
 	// as described in this bug report: https://github.com/matrix-org/synapse/issues/9768
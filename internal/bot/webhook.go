@@ -0,0 +1,182 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/etkecc/go-linkpearl"
+	"github.com/etkecc/go-secgen"
+	"github.com/jhillyerd/enmime/v2"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/etkecc/postmoogle/internal/bot/config"
+	"github.com/etkecc/postmoogle/internal/email"
+	"github.com/etkecc/postmoogle/internal/utils"
+	"github.com/etkecc/postmoogle/internal/webhook"
+)
+
+// webhookStopTimeout is the max time to wait for pending webhook deliveries on shutdown
+const webhookStopTimeout = 10 * time.Second
+
+// sanitizeWebhooks keeps only valid http(s) URLs, pointing to public hosts
+func sanitizeWebhooks(value string) string {
+	urls := []string{}
+	for _, item := range utils.StringSlice(value) {
+		u, err := url.Parse(item)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" || !webhook.AllowedHost(u.Hostname()) {
+			continue
+		}
+		urls = append(urls, u.String())
+	}
+	return strings.Join(urls, ",")
+}
+
+// setWebhooks sets webhook URLs of the mailbox, the HMAC secret is generated if the mailbox doesn't have one yet
+func (b *Bot) setWebhooks(ctx context.Context) {
+	evt := eventFromContext(ctx)
+	cfg, err := b.cfg.GetRoom(ctx, evt.RoomID)
+	if err != nil {
+		b.Error(ctx, "failed to retrieve settings: %v", err)
+		return
+	}
+
+	value := strings.Join(b.parseCommand(evt.Content.AsMessage().Body, false)[1:], " ") // get original value, without forced lower case
+	if value == "reset" {
+		value = ""
+	} else if value = sanitizeWebhooks(value); value == "" {
+		b.lp.SendNotice(ctx, evt.RoomID, "no valid http(s) URLs of public hosts provided, kupo", linkpearl.RelatesTo(evt.ID, cfg.NoThreads()))
+		return
+	}
+
+	cfg.Set(config.RoomWebhooks, value)
+	var secret string
+	if value != "" && cfg.WebhookSecret() == "" {
+		secret = secgen.HexBytes(32)
+		cfg.Set(config.RoomWebhookSecret, secret)
+	}
+	err = b.cfg.SetRoom(ctx, evt.RoomID, cfg)
+	if err != nil {
+		b.Error(ctx, "cannot update settings: %v", err)
+		return
+	}
+
+	if value == "" {
+		b.lp.SendNotice(ctx, evt.RoomID, "Webhooks have been disabled", linkpearl.RelatesTo(evt.ID, cfg.NoThreads()))
+		return
+	}
+	msg := fmt.Sprintf("`%s` of this room set to:\n```\n%s\n```", config.RoomWebhooks, value)
+	if secret != "" {
+		msg += fmt.Sprintf("\n\nPayloads are signed with the following secret:\n```\n%s\n```\n"+
+			"To rotate it, send `%s %s`", secret, b.prefix, commandWebhookSecret)
+	}
+	b.lp.SendNotice(ctx, evt.RoomID, msg, linkpearl.RelatesTo(evt.ID, cfg.NoThreads()))
+}
+
+// runWebhookSecret generates a new HMAC secret of the mailbox webhooks, replacing the previous one
+func (b *Bot) runWebhookSecret(ctx context.Context) {
+	evt := eventFromContext(ctx)
+	cfg, err := b.cfg.GetRoom(ctx, evt.RoomID)
+	if err != nil {
+		b.Error(ctx, "failed to retrieve settings: %v", err)
+		return
+	}
+
+	secret := secgen.HexBytes(32)
+	cfg.Set(config.RoomWebhookSecret, secret)
+	err = b.cfg.SetRoom(ctx, evt.RoomID, cfg)
+	if err != nil {
+		b.Error(ctx, "cannot update settings: %v", err)
+		return
+	}
+
+	b.lp.SendNotice(ctx, evt.RoomID, fmt.Sprintf(
+		"New webhook secret is:\n```\n%s\n```\nThe previous secret (if any) is not valid anymore.", secret),
+		linkpearl.RelatesTo(evt.ID, cfg.NoThreads()),
+	)
+}
+
+// notifyWebhooks posts the event to the mailbox webhooks, if any
+func (b *Bot) notifyWebhooks(roomID id.RoomID, cfg config.Room, event string, eml *email.Email, err error) {
+	urls := cfg.Webhooks()
+	if len(urls) == 0 || eml == nil {
+		return
+	}
+	payload := &webhook.Payload{
+		Event:   event,
+		Mailbox: cfg.Mailbox(),
+		RoomID:  roomID,
+		Email:   webhook.NewEmail(eml, cfg.WebhookRaw()),
+	}
+	if err != nil {
+		payload.Error = err.Error()
+	}
+	b.webhooks.Send(urls, cfg.WebhookSecret(), payload)
+}
+
+// notifyIncoming posts the result of the incoming email processing to the mailbox webhooks:
+// received if it has been accepted, rejected if it has been rejected (e.g., by Sieve), nothing otherwise
+func (b *Bot) notifyIncoming(roomID id.RoomID, cfg config.Room, eml *email.Email, err error) {
+	var rejectErr *email.RejectError
+	switch {
+	case err == nil:
+		b.notifyWebhooks(roomID, cfg, webhook.EventReceived, eml, nil)
+	case errors.As(err, &rejectErr):
+		b.notifyWebhooks(roomID, cfg, webhook.EventRejected, eml, err)
+	}
+}
+
+// notifySent posts the result of the email sent from the mailbox to the mailbox webhooks:
+// queued if it has been queued for re-delivery, failed if it hasn't been sent, sent otherwise
+func (b *Bot) notifySent(roomID id.RoomID, cfg config.Room, eml *email.Email, queued bool, err error) {
+	event := webhook.EventSent
+	switch {
+	case queued:
+		event = webhook.EventQueued
+	case err != nil:
+		event = webhook.EventFailed
+	}
+	b.notifyWebhooks(roomID, cfg, event, eml, err)
+}
+
+// EmailRejected posts the incoming email rejected by the SMTP server to the recipient mailbox webhooks
+func (b *Bot) EmailRejected(ctx context.Context, eml *email.Email, err error) {
+	roomID, ok := b.GetMapping(ctx, utils.MailboxAddress(eml.RcptTo))
+	if !ok {
+		return
+	}
+	cfg, cerr := b.cfg.GetRoom(ctx, roomID)
+	if cerr != nil {
+		b.log.Error().Err(cerr).Str("room_id", roomID.String()).Msg("cannot get room config")
+		return
+	}
+	b.notifyWebhooks(roomID, cfg, webhook.EventRejected, eml, err)
+}
+
+// onQueueResult posts the result of the queued email to the sender mailbox webhooks:
+// sent if it has been delivered, failed if it has been removed from the queue after the max retries
+func (b *Bot) onQueueResult(ctx context.Context, from, to, data string, err error) {
+	roomID, ok := b.getMapping(utils.MailboxAddress(from))
+	if !ok { // bounces and resent emails (SRS sender) don't belong to a mailbox
+		return
+	}
+	cfg, cerr := b.cfg.GetRoom(ctx, roomID)
+	if cerr != nil || len(cfg.Webhooks()) == 0 {
+		return
+	}
+	envelope, perr := enmime.ReadEnvelope(strings.NewReader(data))
+	if perr != nil {
+		b.log.Warn().Err(perr).Str("from", from).Str("to", to).Msg("cannot parse queued email")
+		return
+	}
+	eml := email.FromEnvelope(to, envelope)
+	eml.Raw = []byte(data)
+	event := webhook.EventSent
+	if err != nil {
+		event = webhook.EventFailed
+	}
+	b.notifyWebhooks(roomID, cfg, event, eml, err)
+}
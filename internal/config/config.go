@@ -1,6 +1,8 @@
 package config
 
 import (
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/etkecc/go-env"
@@ -13,14 +15,15 @@ func New() *Config {
 	env.SetPrefix(prefix)
 
 	cfg := &Config{
-		Homeserver:   env.String("homeserver", defaultConfig.Homeserver),
-		Login:        env.String("login", defaultConfig.Login),
-		Password:     env.String("password", defaultConfig.Password),
-		SharedSecret: env.String("sharedsecret", defaultConfig.SharedSecret),
-		Prefix:       env.String("prefix", defaultConfig.Prefix),
-		Domains:      migrateDomains("domain", "domains"),
-		Port:         env.String("port", defaultConfig.Port),
-		Proxies:      env.Slice("proxies"),
+		Homeserver:    env.String("homeserver", defaultConfig.Homeserver),
+		Login:         env.String("login", defaultConfig.Login),
+		Password:      env.String("password", defaultConfig.Password),
+		SharedSecret:  env.String("sharedsecret", defaultConfig.SharedSecret),
+		Prefix:        env.String("prefix", defaultConfig.Prefix),
+		Domains:       migrateDomains("domain", "domains"),
+		Port:          env.String("port", defaultConfig.Port),
+		Proxies:       env.Slice("proxies"),
+		ProxyProtocol: env.Bool("proxy.protocol"),
 		DKIM: DKIM{
 			PrivKey:   env.String("dkim.privkey", defaultConfig.DKIM.PrivKey),
 			Signature: env.String("dkim.signature", defaultConfig.DKIM.Signature),
@@ -37,8 +40,39 @@ func New() *Config {
 		TLS: TLS{
 			Certs:    env.Slice("tls.cert"),
 			Keys:     env.Slice("tls.key"),
+			Dir:      env.String("tls.dir", defaultConfig.TLS.Dir),
 			Required: env.Bool("tls.required"),
 			Port:     env.String("tls.port", defaultConfig.TLS.Port),
+			ACME: ACME{
+				Enabled:   env.Bool("tls.acme"),
+				Email:     env.String("tls.acme.email", defaultConfig.TLS.ACME.Email),
+				Directory: env.String("tls.acme.directory", defaultConfig.TLS.ACME.Directory),
+				Cache:     env.String("tls.acme.cache", defaultConfig.TLS.ACME.Cache),
+				HTTPPort:  acmePort(env.String("tls.acme.httpport", defaultConfig.TLS.ACME.HTTPPort)),
+				TLSPort:   acmePort(env.String("tls.acme.tlsport", defaultConfig.TLS.ACME.TLSPort)),
+			},
+		},
+		LMTP: LMTP{
+			Addr: env.String("lmtp.addr", defaultConfig.LMTP.Addr),
+		},
+		HTTP: HTTP{
+			Addr: env.String("http.addr", defaultConfig.HTTP.Addr),
+		},
+		IMAP: IMAP{
+			Addr:      env.String("imap.addr", defaultConfig.IMAP.Addr),
+			TLSAddr:   env.String("imap.tls.addr", defaultConfig.IMAP.TLSAddr),
+			Retention: env.Int("imap.retention", defaultConfig.IMAP.Retention),
+		},
+		Scanner: Scanner{
+			Type:     env.String("scanner.type", defaultConfig.Scanner.Type),
+			Addr:     env.String("scanner.addr", defaultConfig.Scanner.Addr),
+			Password: env.String("scanner.password", defaultConfig.Scanner.Password),
+			User:     env.String("scanner.user", defaultConfig.Scanner.User),
+			Timeout:  env.Int("scanner.timeout", defaultConfig.Scanner.Timeout),
+		},
+		ClamAV: ClamAV{
+			Addr:    env.String("clamav.addr", defaultConfig.ClamAV.Addr),
+			Timeout: env.Int("clamav.timeout", defaultConfig.ClamAV.Timeout),
 		},
 		Monitoring: Monitoring{
 			SentryDSN:            env.String("monitoring.sentry.dsn", env.String("sentry.dsn", "")),
@@ -59,10 +93,35 @@ func New() *Config {
 			Password: env.String("relay.password", defaultConfig.Relay.Password),
 		},
 	}
+	if cfg.TLS.ACME.Cache == "" {
+		cfg.TLS.ACME.Cache = acmeCache(cfg.DB)
+	}
 
 	return cfg
 }
 
+// acmeCache returns the default ACME cache directory: "acme" in the data directory (next to the SQLite database),
+// or in the working directory if the database is not a local file
+func acmeCache(db DB) string {
+	if !strings.HasPrefix(db.Dialect, "sqlite") {
+		return "acme"
+	}
+	path, _, _ := strings.Cut(strings.TrimPrefix(db.DSN, "file:"), "?")
+	if path == "" || strings.HasPrefix(path, ":memory:") {
+		return "acme"
+	}
+	return filepath.Join(filepath.Dir(path), "acme")
+}
+
+// acmePort returns the ACME challenge port, "off" or "0" disables the challenge (empty value means default port)
+func acmePort(port string) string {
+	port = strings.TrimSpace(port)
+	if strings.EqualFold(port, "off") || port == "0" {
+		return ""
+	}
+	return port
+}
+
 func migrateDomains(oldKey, newKey string) []string {
 	domains := []string{}
 	old := env.String(oldKey, "")
@@ -14,6 +14,13 @@ var defaultConfig = &Config{
 		DSN:     "local.db",
 		Dialect: "sqlite3",
 	},
+	Scanner: Scanner{
+		Type:    "rspamd",
+		Timeout: 30,
+	},
+	ClamAV: ClamAV{
+		Timeout: 60,
+	},
 	Monitoring: Monitoring{
 		SentrySampleRate:     20,
 		HealthchecksURL:      "https://hc-ping.com",
@@ -21,5 +28,10 @@ var defaultConfig = &Config{
 	},
 	TLS: TLS{
 		Port: "587",
+		ACME: ACME{
+			Directory: "https://acme-v02.api.letsencrypt.org/directory",
+			HTTPPort:  "80",
+			TLSPort:   "443",
+		},
 	},
 }
@@ -18,6 +18,8 @@ type Config struct {
 	Port string
 	// Proxies is list of trusted SMTP proxies
 	Proxies []string
+	// ProxyProtocol enables PROXY protocol (v1 and v2) for connections from trusted proxies
+	ProxyProtocol bool
 	// RoomID of the admin room
 	LogLevel string
 	// DataSecret is account data secret key (password) to encrypt all account data values
@@ -41,6 +43,21 @@ type Config struct {
 	// TLS config
 	TLS TLS
 
+	// LMTP config
+	LMTP LMTP
+
+	// HTTP config
+	HTTP HTTP
+
+	// IMAP config
+	IMAP IMAP
+
+	// Scanner config
+	Scanner Scanner
+
+	// ClamAV config
+	ClamAV ClamAV
+
 	// Monitoring config
 	Monitoring Monitoring
 
@@ -65,8 +82,64 @@ type DB struct {
 type TLS struct {
 	Certs    []string
 	Keys     []string
+	Dir      string
 	Port     string
 	Required bool
+	ACME     ACME
+}
+
+// LMTP config
+type LMTP struct {
+	// Addr is a unix socket path or TCP address (host:port)
+	Addr string
+}
+
+// HTTP config
+type HTTP struct {
+	// Addr of the HTTP server (REST API), host:port. Empty = disabled
+	Addr string
+}
+
+// IMAP config
+type IMAP struct {
+	// Addr of the IMAP server (STARTTLS), host:port. Empty = disabled
+	Addr string
+	// TLSAddr of the IMAPS server (implicit TLS), host:port. Empty = disabled
+	TLSAddr string
+	// Retention is amount of days to keep emails in the IMAP archive, 0 = forever
+	Retention int
+}
+
+// Scanner config (content scanner of incoming emails)
+type Scanner struct {
+	// Type of the scanner, rspamd or spamd
+	Type string
+	// Addr is rspamd HTTP URL, or spamd unix socket path or TCP address (host:port)
+	Addr string
+	// Password of rspamd (optional)
+	Password string
+	// User of spamd (optional)
+	User string
+	// Timeout of scan requests, in seconds
+	Timeout int
+}
+
+// ClamAV config (antivirus scanner of attachments)
+type ClamAV struct {
+	// Addr of clamd, a unix socket path or TCP address (host:port)
+	Addr string
+	// Timeout of scan requests, in seconds
+	Timeout int
+}
+
+// ACME config
+type ACME struct {
+	Enabled   bool
+	Email     string
+	Directory string
+	Cache     string
+	HTTPPort  string
+	TLSPort   string
 }
 
 // Monitoring config
@@ -0,0 +1,406 @@
+// Package dnscheck checks DNS records of the served domains (MX, SPF, DKIM, DMARC, rDNS, MTA-STS, TLS-RPT),
+// following the docs/dns.md, and suggests the exact records to fix
+package dnscheck
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"blitiri.com.ar/go/spf"
+	"github.com/emersion/go-msgauth/dmarc"
+)
+
+// Status of the check
+type Status string
+
+// Statuses
+const (
+	Pass Status = "pass"
+	Warn Status = "warn"
+	Fail Status = "fail"
+)
+
+// Resolver is used for DNS lookups, *net.Resolver implements it
+type Resolver interface {
+	spf.DNSResolver
+}
+
+// DKIMRecord is the expected DKIM record of the domain
+type DKIMRecord struct {
+	Selector string
+	Record   string
+	// Pending records belong to the keys that are not used for signing yet (e.g. during rotation)
+	Pending bool
+	// Partial records may be not the only keys of the domain (e.g. CLI doesn't see per-domain and rotated keys),
+	// so missing or different records are reported as warnings
+	Partial bool
+}
+
+// Checker checks DNS records of the domains
+type Checker struct {
+	// Resolver for DNS lookups, net.DefaultResolver if nil
+	Resolver Resolver
+	// Host is the mail server hostname (MX target), e.g. the first of the served domains
+	Host string
+	// IPs of the mail server, resolved from the Host if empty
+	IPs []net.IP
+	// DKIM returns the expected DKIM records of the domain, nil if unknown
+	DKIM func(domain string) []DKIMRecord
+}
+
+// Result of a single check
+type Result struct {
+	Domain  string
+	Check   string
+	Status  Status
+	Message string
+	// Fix is the exact record to add or fix, if applicable
+	Fix string
+}
+
+// Check runs all checks for the domains, the reverse DNS check is run once for the mail server's IPs
+func (c *Checker) Check(ctx context.Context, domains []string) []Result {
+	if c.Resolver == nil {
+		c.Resolver = net.DefaultResolver
+	}
+	results := []Result{}
+	ips, err := c.ips(ctx)
+	if err != nil {
+		results = append(results, Result{
+			Domain:  c.Host,
+			Check:   "A/AAAA",
+			Status:  Fail,
+			Message: fmt.Sprintf("cannot resolve IP addresses of the mail server: %v", err),
+			Fix:     c.Host + ". A SERVER_IP4",
+		})
+	}
+
+	for _, ip := range ips {
+		results = append(results, c.checkRDNS(ctx, ip))
+	}
+	for _, domain := range domains {
+		results = append(results,
+			c.checkMX(ctx, domain, ips),
+			c.checkSPF(ctx, domain, ips),
+		)
+		results = append(results, c.checkDKIM(ctx, domain)...)
+		results = append(results, c.checkDMARC(ctx, domain))
+		if result, ok := c.checkMTASTS(ctx, domain); ok {
+			results = append(results, result)
+		}
+		if result, ok := c.checkTLSRPT(ctx, domain); ok {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// ips returns IPs of the mail server
+func (c *Checker) ips(ctx context.Context) ([]net.IP, error) {
+	if len(c.IPs) > 0 {
+		return c.IPs, nil
+	}
+	addrs, err := c.Resolver.LookupIPAddr(ctx, c.Host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP)
+	}
+	return ips, nil
+}
+
+// lookupTXT returns TXT records of the name with the prefix (case-insensitive), e.g. "v=spf1"
+func (c *Checker) lookupTXT(ctx context.Context, name, prefix string) []string {
+	txts, err := c.Resolver.LookupTXT(ctx, name)
+	if err != nil {
+		return nil
+	}
+	records := []string{}
+	for _, txt := range txts {
+		txt = strings.TrimSpace(txt)
+		lower := strings.ToLower(txt)
+		if lower == prefix || strings.HasPrefix(lower, prefix+" ") || strings.HasPrefix(lower, prefix+";") {
+			records = append(records, txt)
+		}
+	}
+	return records
+}
+
+func (c *Checker) checkMX(ctx context.Context, domain string, ips []net.IP) Result {
+	result := Result{Domain: domain, Check: "MX", Fix: domain + ". MX 10 " + c.Host + "."}
+	mxs, err := c.Resolver.LookupMX(ctx, domain)
+	if err != nil || len(mxs) == 0 {
+		result.Status = Fail
+		result.Message = "no MX records"
+		return result
+	}
+
+	hosts := make([]string, 0, len(mxs))
+	for _, mx := range mxs {
+		host := strings.ToLower(strings.TrimSuffix(mx.Host, "."))
+		if host == c.Host {
+			result.Status = Pass
+			result.Message = "MX points to " + host
+			result.Fix = ""
+			return result
+		}
+		addrs, err := c.Resolver.LookupIPAddr(ctx, host)
+		if err == nil && slices.ContainsFunc(addrs, func(addr net.IPAddr) bool { return containsIP(ips, addr.IP) }) {
+			result.Status = Pass
+			result.Message = "MX points to " + host + ", resolving to the mail server"
+			result.Fix = ""
+			return result
+		}
+		hosts = append(hosts, host)
+	}
+	result.Status = Fail
+	result.Message = "MX points to " + strings.Join(hosts, ", ") + ", not to the mail server"
+	return result
+}
+
+func (c *Checker) checkSPF(ctx context.Context, domain string, ips []net.IP) Result {
+	result := Result{Domain: domain, Check: "SPF", Fix: domain + `. TXT "` + spfRecord(ips) + `"`}
+	records := c.lookupTXT(ctx, domain, "v=spf1")
+	switch len(records) {
+	case 0:
+		result.Status = Fail
+		result.Message = "no SPF record"
+		return result
+	case 1:
+	default:
+		result.Status = Fail
+		result.Message = "multiple SPF records, only one is allowed"
+		return result
+	}
+
+	for _, ip := range ips {
+		res, _ := spf.CheckHostWithSender(ip, c.Host, "postmaster@"+domain, spf.WithContext(ctx), spf.WithResolver(c.Resolver))
+		if res != spf.Pass {
+			result.Status = Fail
+			result.Message = fmt.Sprintf("SPF result of %s is %s: %s", ip, res, records[0])
+			return result
+		}
+	}
+	result.Status = Pass
+	result.Message = records[0]
+	result.Fix = ""
+	return result
+}
+
+func (c *Checker) checkDKIM(ctx context.Context, domain string) []Result {
+	var expected []DKIMRecord
+	if c.DKIM != nil {
+		expected = c.DKIM(domain)
+	}
+	if len(expected) == 0 {
+		return []Result{{Domain: domain, Check: "DKIM", Status: Warn, Message: "expected DKIM record is unknown, DKIM key is not configured"}}
+	}
+
+	results := make([]Result, 0, len(expected))
+	for _, record := range expected {
+		name := record.Selector + "._domainkey." + domain
+		result := Result{Domain: domain, Check: "DKIM " + record.Selector, Fix: name + `. TXT "` + record.Record + `"`}
+		published := c.lookupTXT(ctx, name, "v=dkim1")
+		switch {
+		case dkimPublished(published, record):
+			result.Status = Pass
+			result.Message = "DKIM record matches the key"
+			result.Fix = ""
+		case record.Pending:
+			result.Status = Warn
+			result.Message = "DKIM record of the new key is not published yet"
+		case record.Partial:
+			result.Status = Warn
+			result.Message = "DKIM record doesn't match the configured key, per-domain keys are not visible from CLI"
+		case len(published) == 0:
+			result.Status = Fail
+			result.Message = "no DKIM record"
+		default:
+			result.Status = Fail
+			result.Message = "DKIM record doesn't match the key"
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func (c *Checker) checkDMARC(ctx context.Context, domain string) Result {
+	name := "_dmarc." + domain
+	result := Result{Domain: domain, Check: "DMARC", Fix: name + `. TXT "v=DMARC1; p=quarantine;"`}
+	records := c.lookupTXT(ctx, name, "v=dmarc1")
+	if len(records) != 1 {
+		result.Status = Fail
+		result.Message = "no DMARC record"
+		if len(records) > 1 {
+			result.Message = "multiple DMARC records, only one is allowed"
+		}
+		return result
+	}
+	record, err := dmarc.Parse(records[0])
+	if err != nil {
+		result.Status = Fail
+		result.Message = fmt.Sprintf("invalid DMARC record: %v", err)
+		return result
+	}
+	result.Message = records[0]
+	if record.Policy == dmarc.PolicyNone {
+		result.Status = Warn
+		result.Message = "DMARC policy is `none` (monitoring only): " + records[0]
+		return result
+	}
+	result.Status = Pass
+	result.Fix = ""
+	return result
+}
+
+// checkMTASTS checks MTA-STS record (RFC 8461), if present
+func (c *Checker) checkMTASTS(ctx context.Context, domain string) (Result, bool) {
+	name := "_mta-sts." + domain
+	records := c.lookupTXT(ctx, name, "v=stsv1")
+	if len(records) == 0 {
+		return Result{}, false
+	}
+	result := Result{Domain: domain, Check: "MTA-STS", Status: Pass, Message: records[0]}
+	if len(records) > 1 || !hasTag(records[0], "id") {
+		result.Status = Fail
+		result.Message = "MTA-STS record must be single and have an `id`: " + strings.Join(records, ", ")
+		result.Fix = name + `. TXT "v=STSv1; id=20260101000000;"`
+	}
+	return result, true
+}
+
+// checkTLSRPT checks SMTP TLS reporting record (RFC 8460), if present
+func (c *Checker) checkTLSRPT(ctx context.Context, domain string) (Result, bool) {
+	name := "_smtp._tls." + domain
+	records := c.lookupTXT(ctx, name, "v=tlsrptv1")
+	if len(records) == 0 {
+		return Result{}, false
+	}
+	result := Result{Domain: domain, Check: "TLS-RPT", Status: Pass, Message: records[0]}
+	if len(records) > 1 || !hasTag(records[0], "rua") {
+		result.Status = Fail
+		result.Message = "TLS-RPT record must be single and have a `rua`: " + strings.Join(records, ", ")
+		result.Fix = name + `. TXT "v=TLSRPTv1; rua=mailto:postmaster@` + domain + `"`
+	}
+	return result, true
+}
+
+// checkRDNS checks reverse DNS of the IP and that the PTR name resolves back to it (FCrDNS)
+func (c *Checker) checkRDNS(ctx context.Context, ip net.IP) Result {
+	result := Result{Domain: ip.String(), Check: "rDNS", Fix: "PTR of " + ip.String() + " -> " + c.Host + ". (configured by your hosting provider)"}
+	names, err := c.Resolver.LookupAddr(ctx, ip.String())
+	if err != nil || len(names) == 0 {
+		result.Status = Fail
+		result.Message = "no PTR record"
+		return result
+	}
+	for _, name := range names {
+		addrs, err := c.Resolver.LookupIPAddr(ctx, name)
+		if err == nil && slices.ContainsFunc(addrs, func(addr net.IPAddr) bool { return addr.IP.Equal(ip) }) {
+			result.Status = Pass
+			result.Message = "PTR " + strings.TrimSuffix(name, ".") + " resolves back to the IP (FCrDNS)"
+			result.Fix = ""
+			return result
+		}
+	}
+	result.Status = Warn
+	result.Message = "PTR " + strings.TrimSuffix(names[0], ".") + " doesn't resolve back to the IP (FCrDNS)"
+	result.Fix = strings.TrimSuffix(names[0], ".") + ". A " + ip.String()
+	return result
+}
+
+// spfRecord returns SPF record allowing the IPs
+func spfRecord(ips []net.IP) string {
+	mechanisms := []string{"v=spf1"}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			mechanisms = append(mechanisms, "ip4:"+ip.String())
+			continue
+		}
+		mechanisms = append(mechanisms, "ip6:"+ip.String())
+	}
+	if len(ips) == 0 {
+		mechanisms = append(mechanisms, "mx")
+	}
+	return strings.Join(append(mechanisms, "-all"), " ")
+}
+
+// DKIMPublished checks if the DKIM record of the domain is published in DNS (the public key matches)
+func (c *Checker) DKIMPublished(ctx context.Context, domain string, record DKIMRecord) bool {
+	if c.Resolver == nil {
+		c.Resolver = net.DefaultResolver
+	}
+	return dkimPublished(c.lookupTXT(ctx, record.Selector+"._domainkey."+domain, "v=dkim1"), record)
+}
+
+// dkimPublished checks if any of the published TXT records has the public key of the DKIM record
+func dkimPublished(published []string, record DKIMRecord) bool {
+	return slices.ContainsFunc(published, func(txt string) bool { return dkimKey(txt) == dkimKey(record.Record) })
+}
+
+// dkimKey returns the public key (p= tag) of the DKIM record
+func dkimKey(record string) string {
+	for _, tag := range strings.Split(record, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(tag), "=")
+		if strings.TrimSpace(key) == "p" {
+			return strings.Join(strings.Fields(value), "")
+		}
+	}
+	return ""
+}
+
+// hasTag checks if the tag-value record (e.g. MTA-STS) has a non-empty tag
+func hasTag(record, name string) bool {
+	for _, tag := range strings.Split(record, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(tag), "=")
+		if strings.EqualFold(strings.TrimSpace(key), name) && strings.TrimSpace(value) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	return slices.ContainsFunc(ips, func(item net.IP) bool { return item.Equal(ip) })
+}
+
+// Failed checks if any of the results failed
+func Failed(results []Result) bool {
+	return slices.ContainsFunc(results, func(result Result) bool { return result.Status == Fail })
+}
+
+var statusIcons = map[Status]string{Pass: "✅", Warn: "⚠️", Fail: "❌"}
+
+// Markdown returns the results as a markdown table
+func Markdown(results []Result) string {
+	var msg strings.Builder
+	msg.WriteString("| | Domain | Check | Result | Fix |\n")
+	msg.WriteString("|---|---|---|---|---|\n")
+	for _, result := range results {
+		fix := ""
+		if result.Fix != "" {
+			fix = "`" + result.Fix + "`"
+		}
+		fmt.Fprintf(&msg, "| %s | %s | %s | %s | %s |\n",
+			statusIcons[result.Status], result.Domain, result.Check, strings.ReplaceAll(result.Message, "|", "\\|"), fix)
+	}
+	return msg.String()
+}
+
+// Text returns the results as a plain text table (e.g. for CLI)
+func Text(results []Result) string {
+	var msg strings.Builder
+	w := tabwriter.NewWriter(&msg, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STATUS\tDOMAIN\tCHECK\tRESULT\tFIX")
+	for _, result := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", strings.ToUpper(string(result.Status)), result.Domain, result.Check, result.Message, result.Fix)
+	}
+	w.Flush()
+	return msg.String()
+}
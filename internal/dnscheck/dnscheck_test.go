@@ -0,0 +1,236 @@
+package dnscheck
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+)
+
+type fakeResolver struct {
+	txt  map[string][]string
+	mx   map[string][]*net.MX
+	ip   map[string][]net.IPAddr
+	addr map[string][]string
+}
+
+func (r *fakeResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	if txt, ok := r.txt[strings.TrimSuffix(name, ".")]; ok {
+		return txt, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (r *fakeResolver) LookupMX(_ context.Context, name string) ([]*net.MX, error) {
+	if mx, ok := r.mx[strings.TrimSuffix(name, ".")]; ok {
+		return mx, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (r *fakeResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	if ip, ok := r.ip[strings.TrimSuffix(host, ".")]; ok {
+		return ip, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func (r *fakeResolver) LookupAddr(_ context.Context, addr string) ([]string, error) {
+	if names, ok := r.addr[addr]; ok {
+		return names, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+}
+
+const testDKIM = "v=DKIM1; k=ed25519; p=dGVzdA=="
+
+func newTestResolver() *fakeResolver {
+	return &fakeResolver{
+		txt: map[string][]string{
+			"example.com":                       {"v=spf1 ip4:192.0.2.1 -all", "google-site-verification=test"},
+			"pm-ed25519._domainkey.example.com": {testDKIM},
+			"_dmarc.example.com":                {"v=DMARC1; p=quarantine;"},
+			"_mta-sts.example.com":              {"v=STSv1; id=20260101;"},
+			"_smtp._tls.example.com":            {"v=TLSRPTv1; rua=mailto:tls@example.com"},
+			"example.org":                       {"v=spf1 ip4:198.51.100.1 -all"},
+			"_dmarc.example.org":                {"v=DMARC1; p=none;"},
+			"_mta-sts.example.org":              {"v=STSv1;"},
+		},
+		mx: map[string][]*net.MX{
+			"example.com": {{Host: "mail.example.com.", Pref: 10}},
+			"example.org": {{Host: "mx.example.net.", Pref: 10}},
+		},
+		ip: map[string][]net.IPAddr{
+			"mail.example.com": {{IP: net.ParseIP("192.0.2.1")}},
+			"mx.example.net":   {{IP: net.ParseIP("198.51.100.1")}},
+		},
+		addr: map[string][]string{
+			"192.0.2.1": {"mail.example.com."},
+		},
+	}
+}
+
+func find(t *testing.T, results []Result, domain, check string) Result {
+	t.Helper()
+	for _, result := range results {
+		if result.Domain == domain && result.Check == check {
+			return result
+		}
+	}
+	t.Fatalf("no %s result of %s in %+v", check, domain, results)
+	return Result{}
+}
+
+func TestCheck(t *testing.T) {
+	checker := &Checker{
+		Resolver: newTestResolver(),
+		Host:     "mail.example.com",
+		DKIM: func(domain string) []DKIMRecord {
+			if domain != "example.com" {
+				return nil
+			}
+			return []DKIMRecord{
+				{Selector: "pm-ed25519", Record: "v=DKIM1;k=ed25519;p=dGVz dA=="},
+				{Selector: "pm2-ed25519", Record: "v=DKIM1; k=ed25519; p=bmV3", Pending: true},
+			}
+		},
+	}
+	results := checker.Check(context.Background(), []string{"example.com", "example.org"})
+
+	tests := []struct {
+		domain string
+		check  string
+		status Status
+		fix    string
+	}{
+		{"192.0.2.1", "rDNS", Pass, ""},
+		{"example.com", "MX", Pass, ""},
+		{"example.com", "SPF", Pass, ""},
+		{"example.com", "DKIM pm-ed25519", Pass, ""},
+		{"example.com", "DKIM pm2-ed25519", Warn, `pm2-ed25519._domainkey.example.com. TXT "v=DKIM1; k=ed25519; p=bmV3"`},
+		{"example.com", "DMARC", Pass, ""},
+		{"example.com", "MTA-STS", Pass, ""},
+		{"example.com", "TLS-RPT", Pass, ""},
+		{"example.org", "MX", Fail, "example.org. MX 10 mail.example.com."},
+		{"example.org", "SPF", Fail, `example.org. TXT "v=spf1 ip4:192.0.2.1 -all"`},
+		{"example.org", "DKIM", Warn, ""},
+		{"example.org", "DMARC", Warn, `_dmarc.example.org. TXT "v=DMARC1; p=quarantine;"`},
+		{"example.org", "MTA-STS", Fail, `_mta-sts.example.org. TXT "v=STSv1; id=20260101000000;"`},
+	}
+	for _, test := range tests {
+		result := find(t, results, test.domain, test.check)
+		if result.Status != test.status {
+			t.Errorf("%s %s: expected %s, got %s (%s)", test.domain, test.check, test.status, result.Status, result.Message)
+		}
+		if result.Fix != test.fix {
+			t.Errorf("%s %s: expected fix %q, got %q", test.domain, test.check, test.fix, result.Fix)
+		}
+	}
+	for _, result := range results {
+		if result.Domain == "example.org" && result.Check == "TLS-RPT" {
+			t.Error("TLS-RPT is checked only if present")
+		}
+	}
+	if !Failed(results) {
+		t.Error("results should be failed")
+	}
+}
+
+func TestCheckNoRecords(t *testing.T) {
+	checker := &Checker{
+		Resolver: newTestResolver(),
+		Host:     "mail.example.net",
+		IPs:      []net.IP{net.ParseIP("203.0.113.1"), net.ParseIP("2001:db8::1")},
+	}
+	results := checker.Check(context.Background(), []string{"example.net"})
+
+	for _, check := range []string{"MX", "SPF", "DMARC"} {
+		if result := find(t, results, "example.net", check); result.Status != Fail {
+			t.Errorf("%s: expected fail, got %s", check, result.Status)
+		}
+	}
+	if result := find(t, results, "example.net", "SPF"); result.Fix != `example.net. TXT "v=spf1 ip4:203.0.113.1 ip6:2001:db8::1 -all"` {
+		t.Errorf("unexpected SPF fix: %s", result.Fix)
+	}
+	if result := find(t, results, "203.0.113.1", "rDNS"); result.Status != Fail {
+		t.Errorf("rDNS: expected fail, got %s", result.Status)
+	}
+}
+
+func TestCheckNoHostIPs(t *testing.T) {
+	checker := &Checker{Resolver: newTestResolver(), Host: "unknown.example.com"}
+	results := checker.Check(context.Background(), nil)
+
+	if result := find(t, results, "unknown.example.com", "A/AAAA"); result.Status != Fail {
+		t.Errorf("expected fail, got %s", result.Status)
+	}
+}
+
+func TestCheckPartialDKIM(t *testing.T) {
+	tests := map[string]struct {
+		domain string
+		record string
+		status Status
+	}{
+		"matches":   {"example.com", "v=DKIM1; k=ed25519; p=dGVzdA==", Pass},
+		"different": {"example.com", "v=DKIM1; k=ed25519; p=b3RoZXI=", Warn},
+		"missing":   {"example.org", "v=DKIM1; k=ed25519; p=dGVzdA==", Warn},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			checker := &Checker{
+				Resolver: newTestResolver(),
+				Host:     "mail.example.com",
+				DKIM: func(_ string) []DKIMRecord {
+					return []DKIMRecord{{Selector: "pm-ed25519", Record: test.record, Partial: true}}
+				},
+			}
+			result := find(t, checker.Check(context.Background(), []string{test.domain}), test.domain, "DKIM pm-ed25519")
+			if result.Status != test.status {
+				t.Error(test.status, "!=", result.Status, result.Message)
+			}
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	results := []Result{
+		{Domain: "example.com", Check: "MX", Status: Fail, Message: "no MX records", Fix: "example.com. MX 10 mail.example.com."},
+		{Domain: "example.com", Check: "DMARC", Status: Pass, Message: "a|b"},
+	}
+
+	markdown := Markdown(results)
+	if !strings.Contains(markdown, "| ❌ | example.com | MX | no MX records | `example.com. MX 10 mail.example.com.` |") {
+		t.Errorf("unexpected markdown: %s", markdown)
+	}
+	if !strings.Contains(markdown, `a\|b`) {
+		t.Errorf("pipes are not escaped: %s", markdown)
+	}
+	text := Text(results)
+	if !strings.Contains(text, "FAIL") || !strings.Contains(text, "example.com. MX 10 mail.example.com.") {
+		t.Errorf("unexpected text: %s", text)
+	}
+}
+
+func TestDKIMPublished(t *testing.T) {
+	tests := map[string]struct {
+		domain   string
+		record   DKIMRecord
+		expected bool
+	}{
+		"published":   {"example.com", DKIMRecord{Selector: "pm-ed25519", Record: testDKIM}, true},
+		"different":   {"example.com", DKIMRecord{Selector: "pm-ed25519", Record: "v=DKIM1; k=ed25519; p=b3RoZXI="}, false},
+		"no selector": {"example.com", DKIMRecord{Selector: "other", Record: testDKIM}, false},
+		"no domain":   {"example.org", DKIMRecord{Selector: "pm-ed25519", Record: testDKIM}, false},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			checker := &Checker{Resolver: newTestResolver()}
+			if actual := checker.DKIMPublished(context.Background(), test.domain, test.record); actual != test.expected {
+				t.Error(test.expected, "!=", actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package email
+
+import "strings"
+
+// AuthResults are results of the incoming email authentication (SPF, DKIM, DMARC)
+type AuthResults struct {
+	// Header is the Authentication-Results header value (RFC 8601)
+	Header string
+	// SPF result, e.g. pass, fail, softfail, none
+	SPF string
+	// DKIM result, pass if at least one signature is valid
+	DKIM string
+	// DMARC result, e.g. pass, fail, none
+	DMARC string
+	// Policy of the sender domain, applied when DMARC failed (none, quarantine, reject)
+	Policy string
+}
+
+// Summary returns compact pass/fail summary, e.g.: SPF ✅ · DKIM ✅ · DMARC ❌
+func (a *AuthResults) Summary() string {
+	if a == nil {
+		return ""
+	}
+
+	var summary strings.Builder
+	summary.WriteString("SPF ")
+	summary.WriteString(authResultEmoji(a.SPF))
+	summary.WriteString(" · DKIM ")
+	summary.WriteString(authResultEmoji(a.DKIM))
+	summary.WriteString(" · DMARC ")
+	summary.WriteString(authResultEmoji(a.DMARC))
+	if a.DMARC == "fail" && a.Policy != "" && a.Policy != "none" {
+		summary.WriteString(" (")
+		summary.WriteString(a.Policy)
+		summary.WriteString(")")
+	}
+	return summary.String()
+}
+
+func authResultEmoji(result string) string {
+	switch result {
+	case "pass":
+		return "✅"
+	case "fail", "permerror":
+		return "❌"
+	case "", "none":
+		return "➖"
+	default:
+		return "⚠️"
+	}
+}
@@ -0,0 +1,108 @@
+package email
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"strings"
+
+	"github.com/emersion/go-msgauth/dkim"
+	"github.com/etkecc/go-secgen"
+)
+
+// DKIM key algorithms
+const (
+	DKIMRSA     = "rsa"
+	DKIMEd25519 = "ed25519"
+	// DKIMDefaultSelector is used by the keys without selector (e.g. the key from POSTMOOGLE_DKIM_PRIVKEY)
+	DKIMDefaultSelector = "postmoogle"
+)
+
+// ErrDKIMAlgorithm returned when the DKIM key algorithm is not supported
+var ErrDKIMAlgorithm = errors.New("unsupported DKIM key algorithm")
+
+// DKIMKey is a DKIM signing key with its selector
+type DKIMKey struct {
+	Selector  string `json:"selector"`
+	Algorithm string `json:"algorithm"`
+	// PrivateKey is PEM-encoded PKCS #8 private key
+	PrivateKey string `json:"private_key"`
+	// Record is the value of the DNS TXT record (SELECTOR._domainkey.DOMAIN)
+	Record string `json:"record"`
+}
+
+// NewDKIMKey generates a new DKIM key, ed25519 keys follow RFC 8463
+func NewDKIMKey(algorithm, selector string) (DKIMKey, error) {
+	key := DKIMKey{Selector: selector, Algorithm: algorithm}
+	switch algorithm {
+	case DKIMRSA:
+		record, private, err := secgen.DKIM()
+		if err != nil {
+			return key, err
+		}
+		key.Record = record
+		key.PrivateKey = private
+	case DKIMEd25519:
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return key, err
+		}
+		pkcs8, err := x509.MarshalPKCS8PrivateKey(private)
+		if err != nil {
+			return key, err
+		}
+		key.PrivateKey = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}))
+		// RFC 8463: the public key is not wrapped in SubjectPublicKeyInfo
+		key.Record = "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(public)
+	default:
+		return key, ErrDKIMAlgorithm
+	}
+	return key, nil
+}
+
+// signer parses the private key
+func (k DKIMKey) signer() (crypto.Signer, bool) {
+	pemblock, _ := pem.Decode([]byte(k.PrivateKey))
+	if pemblock == nil {
+		return nil, false
+	}
+	parsedkey, err := x509.ParsePKCS8PrivateKey(pemblock.Bytes)
+	if err != nil {
+		return nil, false
+	}
+	signer, ok := parsedkey.(crypto.Signer)
+	return signer, ok
+}
+
+// dkimSign signs the data with all the keys (e.g. both RSA and ed25519 keys), independently of each other,
+// keys that cannot be used are skipped
+func dkimSign(domain, data string, keys []DKIMKey) string {
+	var signatures strings.Builder
+	for _, key := range keys {
+		signer, ok := key.signer()
+		if !ok {
+			continue
+		}
+		selector := key.Selector
+		if selector == "" {
+			selector = DKIMDefaultSelector
+		}
+
+		var msg strings.Builder
+		err := dkim.Sign(&msg, strings.NewReader(data), &dkim.SignOptions{
+			Domain:   domain,
+			Selector: selector,
+			Signer:   signer,
+		})
+		if err != nil {
+			continue
+		}
+		// the signed message is the DKIM-Signature header followed by the original data
+		signatures.WriteString(strings.TrimSuffix(msg.String(), data))
+	}
+	return signatures.String() + data
+}
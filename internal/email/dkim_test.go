@@ -0,0 +1,54 @@
+package email
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+func TestComposeDKIMDualSign(t *testing.T) {
+	keys := []DKIMKey{}
+	records := map[string]string{}
+	for _, algorithm := range []string{DKIMRSA, DKIMEd25519} {
+		key, err := NewDKIMKey(algorithm, "test-"+algorithm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+		records[key.Selector+"._domainkey.example.com"] = key.Record
+	}
+
+	eml := New("<test@example.com>", "", "", "Subject", "alice@example.com", "bob@example.org", "bob@example.org", "", "Hello", "", nil, nil)
+	data := eml.Compose(keys...)
+	if strings.Count(data, "DKIM-Signature: a=") != 2 {
+		t.Fatal("email is not dual-signed", data)
+	}
+
+	verifications, err := dkim.VerifyWithOptions(strings.NewReader(data), &dkim.VerifyOptions{
+		LookupTXT: func(domain string) ([]string, error) {
+			if record, ok := records[domain]; ok {
+				return []string{record}, nil
+			}
+			return nil, errors.New("no such record")
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(verifications) != 2 {
+		t.Fatal("unexpected verifications count", len(verifications))
+	}
+	for _, verification := range verifications {
+		if verification.Err != nil {
+			t.Error(verification.Err)
+		}
+	}
+}
+
+func TestNewDKIMKeyUnsupported(t *testing.T) {
+	if _, err := NewDKIMKey("dsa", "test"); !errors.Is(err, ErrDKIMAlgorithm) {
+		t.Error(ErrDKIMAlgorithm, "!=", err)
+	}
+}
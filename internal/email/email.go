@@ -1,12 +1,8 @@
 package email
 
 import (
-	"crypto"
-	"crypto/x509"
-	"encoding/pem"
 	"strings"
 
-	"github.com/emersion/go-msgauth/dkim"
 	"github.com/etkecc/go-linkpearl"
 	"github.com/jhillyerd/enmime/v2"
 	"github.com/kvannotten/mailstrip"
@@ -32,6 +28,29 @@ type Email struct {
 	HTML        string
 	Files       []*utils.File
 	InlineFiles []*utils.File
+	// Auth results of incoming email, if available
+	Auth *AuthResults
+	// Spam scan result of incoming email, if available
+	Spam *SpamResult
+	// Infected files removed from the email, in a `name (virus)` form
+	Infected []string
+	// Quarantine reasons of suspicious incoming email, empty = not suspicious
+	Quarantine []string
+	// Raw MIME message of incoming email, if available
+	Raw []byte
+	// MailFrom is the envelope sender (MAIL FROM) of incoming email, if available
+	MailFrom string
+	// AutoSubmitted marks automatic replies with the `Auto-Submitted: auto-replied` header (RFC 3834)
+	AutoSubmitted bool
+}
+
+// RejectError is returned by the bot to reject incoming email with the reason (e.g., Sieve `reject` action)
+type RejectError struct {
+	Reason string
+}
+
+func (e *RejectError) Error() string {
+	return "email has been rejected: " + e.Reason
 }
 
 // New constructs Email object
@@ -129,7 +148,19 @@ func (e *Email) contentHeader(threadID id.EventID, text *strings.Builder, option
 		text.WriteString("\ncc: ")
 		text.WriteString(strings.Join(e.CC, ", "))
 	}
-	if options.Sender || options.Recipient || options.CC {
+	if e.Auth != nil {
+		if options.Sender || options.Recipient || (options.CC && len(e.CC) > 0) {
+			text.WriteString("\n")
+		}
+		text.WriteString(e.Auth.Summary())
+	}
+	if e.Spam.Spam() {
+		if options.Sender || options.Recipient || (options.CC && len(e.CC) > 0) || e.Auth != nil {
+			text.WriteString("\n")
+		}
+		text.WriteString(e.Spam.Summary())
+	}
+	if options.Sender || options.Recipient || options.CC || e.Auth != nil || e.Spam.Spam() {
 		text.WriteString("\n\n")
 	}
 	if options.Subject && threadID == "" {
@@ -185,6 +216,12 @@ func (e *Email) Content(threadID id.EventID, options *ContentOptions) *event.Con
 		},
 		Parsed: &parsed,
 	}
+	if e.Auth != nil && options.AuthResultsKey != "" {
+		content.Raw[options.AuthResultsKey] = e.Auth.Header
+	}
+	if e.Spam.Spam() && options.SpamKey != "" {
+		content.Raw[options.SpamKey] = e.Spam.Score
+	}
 	return &content
 }
 
@@ -214,8 +251,8 @@ func (e *Email) ContentBody(threadID id.EventID, options *ContentOptions) *event
 	return &content
 }
 
-// Compose converts the email object to a string (to be used for delivery via SMTP) and possibly DKIM-signs it
-func (e *Email) Compose(privkey string) string {
+// Compose converts the email object to a string (to be used for delivery via SMTP) and DKIM-signs it with the keys (if any)
+func (e *Email) Compose(keys ...DKIMKey) string {
 	textSize := len(e.Text)
 	htmlSize := len(e.HTML)
 	if textSize == 0 && htmlSize == 0 {
@@ -245,6 +282,9 @@ func (e *Email) Compose(privkey string) string {
 			mail = mail.CC("", addr)
 		}
 	}
+	if e.AutoSubmitted {
+		mail = mail.Header("Auto-Submitted", "auto-replied")
+	}
 
 	root, err := mail.Build()
 	if err != nil {
@@ -257,37 +297,5 @@ func (e *Email) Compose(privkey string) string {
 	}
 
 	domain := strings.SplitN(e.From, "@", 2)[1]
-	return e.sign(domain, privkey, data)
-}
-
-func (e *Email) sign(domain, privkey string, data strings.Builder) string {
-	if privkey == "" {
-		return data.String()
-	}
-	pemblock, _ := pem.Decode([]byte(privkey))
-	if pemblock == nil {
-		return data.String()
-	}
-	parsedkey, err := x509.ParsePKCS8PrivateKey(pemblock.Bytes)
-	if err != nil {
-		return data.String()
-	}
-	signer, ok := parsedkey.(crypto.Signer)
-	if !ok {
-		return data.String()
-	}
-
-	options := &dkim.SignOptions{
-		Domain:   domain,
-		Selector: "postmoogle",
-		Signer:   signer,
-	}
-
-	var msg strings.Builder
-	err = dkim.Sign(&msg, strings.NewReader(data.String()), options)
-	if err != nil {
-		return data.String()
-	}
-
-	return msg.String()
+	return dkimSign(domain, data.String(), keys)
 }
@@ -7,7 +7,12 @@ type IncomingFilteringOptions interface {
 	SpamcheckSPF() bool
 	SpamcheckRBL() bool
 	SpamcheckMX() bool
+	SpamcheckDMARC() bool
 	Spamlist() []string
+	Allowlist() []string
+	SpamThresholds() *SpamThresholds
+	AntivirusStrip() bool
+	Quarantine() bool
 }
 
 // ContentOptions represents settings that specify how an email is to be converted to a Matrix message
@@ -23,12 +28,33 @@ type ContentOptions struct {
 	Stripify  bool
 
 	// Keys
-	MessageIDKey  string
-	InReplyToKey  string
-	ReferencesKey string
-	SubjectKey    string
-	FromKey       string
-	ToKey         string
-	CcKey         string
-	RcptToKey     string
+	MessageIDKey   string
+	InReplyToKey   string
+	ReferencesKey  string
+	SubjectKey     string
+	FromKey        string
+	ToKey          string
+	CcKey          string
+	RcptToKey      string
+	AuthResultsKey string
+	SpamKey        string
+}
+
+// DNSBLOptions for incoming mail (server settings)
+type DNSBLOptions struct {
+	// Zones of DNS blocklists
+	Zones []*DNSBLZone
+	// Threshold is the minimal total weight of zones listing a host to block it.
+	// 0 = block if listed in more than one zone, or in the most of responded zones
+	Threshold int
+}
+
+// DNSBLZone is a DNS blocklist zone
+type DNSBLZone struct {
+	// Name of the zone, e.g. zen.spamhaus.org
+	Name string
+	// Signals are return codes (IPs or CIDRs) that mean "listed", e.g. 127.0.0.2
+	Signals []string
+	// Weight of the zone in the block decision
+	Weight int
 }
@@ -0,0 +1,91 @@
+package email
+
+import (
+	"strconv"
+	"strings"
+)
+
+// actions applied to incoming emails based on the content scanner score
+const (
+	SpamActionNone       = ""
+	SpamActionFlag       = "flag"
+	SpamActionGreylist   = "greylist"
+	SpamActionQuarantine = "quarantine"
+	SpamActionReject     = "reject"
+)
+
+// SpamResult is the result of the incoming email content scan (rspamd, spamd)
+type SpamResult struct {
+	// Scanner name, e.g. rspamd or spamd
+	Scanner string
+	// Score of the email
+	Score float64
+	// Required score to consider the email as spam, as reported by the scanner
+	Required float64
+	// Symbols (rules) matched by the scanner
+	Symbols []string
+	// Action applied to the email, one of SpamAction* constants
+	Action string
+}
+
+// SpamThresholds are minimal content scanner scores to apply actions, 0 = action is disabled
+type SpamThresholds struct {
+	Flag       float64
+	Greylist   float64
+	Quarantine float64
+	Reject     float64
+}
+
+// Action returns the most severe action with threshold reached by the score.
+// If the flag threshold is not set, the scanner's own required score is used instead
+func (t *SpamThresholds) Action(result *SpamResult) string {
+	if result == nil {
+		return SpamActionNone
+	}
+	if t == nil {
+		t = &SpamThresholds{}
+	}
+	flag := t.Flag
+	if flag == 0 {
+		flag = result.Required
+	}
+
+	for _, threshold := range []struct {
+		score  float64
+		action string
+	}{
+		{t.Reject, SpamActionReject},
+		{t.Quarantine, SpamActionQuarantine},
+		{t.Greylist, SpamActionGreylist},
+		{flag, SpamActionFlag},
+	} {
+		if threshold.score > 0 && result.Score >= threshold.score {
+			return threshold.action
+		}
+	}
+	return SpamActionNone
+}
+
+// Spam checks if the email has been flagged (or quarantined) as spam
+func (s *SpamResult) Spam() bool {
+	return s != nil && (s.Action == SpamActionFlag || s.Action == SpamActionQuarantine)
+}
+
+// Summary returns compact scan summary, e.g.: ⚠️ spam (rspamd: 7.5 / 5)
+func (s *SpamResult) Summary() string {
+	if !s.Spam() {
+		return ""
+	}
+
+	var summary strings.Builder
+	summary.WriteString("⚠️ spam (")
+	summary.WriteString(s.Scanner)
+	summary.WriteString(": ")
+	summary.WriteString(strconv.FormatFloat(s.Score, 'f', -1, 64))
+	if s.Required > 0 {
+		summary.WriteString(" / ")
+		summary.WriteString(strconv.FormatFloat(s.Required, 'f', -1, 64))
+	}
+	summary.WriteString(")")
+	return summary.String()
+}
@@ -0,0 +1,70 @@
+package email
+
+import (
+	"bytes"
+
+	"github.com/jhillyerd/enmime/v2"
+
+	"github.com/etkecc/postmoogle/internal/utils"
+)
+
+// StrippedNotice replaces the body of single-part email, if the part is removed
+const StrippedNotice = "The attached file has been removed, because it is infected."
+
+// StripFiles removes MIME parts of the files (e.g., infected attachments) from the raw email and re-encodes it
+func StripFiles(raw []byte, files []*utils.File) ([]byte, error) {
+	envelope, err := enmime.ReadEnvelope(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	remove := func(part *enmime.Part) bool {
+		for _, file := range files {
+			if part.FileName == file.Name && bytes.Equal(part.Content, file.Content) {
+				return true
+			}
+		}
+		return false
+	}
+	// single-part email, the root part is the file itself
+	if envelope.Root.FirstChild == nil && remove(envelope.Root) {
+		replaceWithNotice(envelope.Root)
+	}
+	removeParts(envelope.Root, remove)
+
+	var buf bytes.Buffer
+	if err := envelope.Root.Encode(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// replaceWithNotice replaces content of the part with the plain text StrippedNotice, keeping the email headers
+func replaceWithNotice(part *enmime.Part) {
+	part.Header.Del("Content-Disposition")
+	part.Header.Del("Content-Id")
+	part.ContentType = "text/plain"
+	part.ContentTypeParams = map[string]string{}
+	part.Charset = "utf-8"
+	part.Disposition = ""
+	part.FileName = ""
+	part.ContentID = ""
+	part.ContentReader = nil
+	part.Content = []byte(StrippedNotice)
+}
+
+// removeParts removes children of the part (recursively) matching the predicate
+func removeParts(parent *enmime.Part, remove func(*enmime.Part) bool) {
+	var prev *enmime.Part
+	for child := parent.FirstChild; child != nil; child = child.NextSibling {
+		if remove(child) {
+			if prev == nil {
+				parent.FirstChild = child.NextSibling
+			} else {
+				prev.NextSibling = child.NextSibling
+			}
+			continue
+		}
+		removeParts(child, remove)
+		prev = child
+	}
+}
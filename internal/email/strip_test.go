@@ -0,0 +1,59 @@
+package email
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/jhillyerd/enmime/v2"
+
+	"github.com/etkecc/postmoogle/internal/utils"
+)
+
+func TestStripFiles(t *testing.T) {
+	raw := []byte("From: alice@example.org\r\nTo: bob@example.com\r\nSubject: test\r\nMessage-Id: <test@example.org>\r\n" +
+		"MIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=BOUNDARY\r\n\r\n" +
+		"--BOUNDARY\r\nContent-Type: text/plain\r\n\r\nHello\r\n" +
+		"--BOUNDARY\r\nContent-Type: text/plain\r\nContent-Disposition: attachment; filename=clean.txt\r\n\r\nclean\r\n" +
+		"--BOUNDARY\r\nContent-Type: application/octet-stream\r\nContent-Disposition: attachment; filename=virus.com\r\n\r\nvirus\r\n" +
+		"--BOUNDARY--\r\n")
+
+	stripped, err := StripFiles(raw, []*utils.File{utils.NewFile("virus.com", []byte("virus"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, err := enmime.ReadEnvelope(bytes.NewReader(stripped))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(envelope.Attachments) != 1 || envelope.Attachments[0].FileName != "clean.txt" {
+		t.Errorf("unexpected attachments: %v", envelope.Attachments)
+	}
+	if strings.TrimSpace(envelope.Text) != "Hello" || envelope.GetHeader("Message-Id") != "<test@example.org>" {
+		t.Errorf("email is changed: %s", stripped)
+	}
+	if bytes.Contains(stripped, []byte("virus.com")) {
+		t.Errorf("infected file is not removed: %s", stripped)
+	}
+}
+
+func TestStripFilesRoot(t *testing.T) {
+	raw := []byte("From: alice@example.org\r\nTo: bob@example.com\r\nSubject: test\r\nMessage-Id: <test@example.org>\r\n" +
+		"MIME-Version: 1.0\r\nContent-Type: application/octet-stream; name=virus.com\r\n" +
+		"Content-Disposition: attachment; filename=virus.com\r\nContent-Transfer-Encoding: base64\r\n\r\ndmlydXM=\r\n")
+
+	stripped, err := StripFiles(raw, []*utils.File{utils.NewFile("virus.com", []byte("virus"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, err := enmime.ReadEnvelope(bytes.NewReader(stripped))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(envelope.Attachments) != 0 || bytes.Contains(stripped, []byte("virus.com")) || bytes.Contains(stripped, []byte("dmlydXM=")) {
+		t.Errorf("infected file is not removed: %s", stripped)
+	}
+	if strings.TrimSpace(envelope.Text) != StrippedNotice || envelope.GetHeader("Message-Id") != "<test@example.org>" {
+		t.Errorf("unexpected email: %s", stripped)
+	}
+}
@@ -0,0 +1,189 @@
+// Package health provides pull-based liveness (/healthz) and readiness (/readyz) HTTP endpoints,
+// reflecting state of the SMTP listeners, matrix sync, and database
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// SyncTimeout is how long the matrix sync may stay silent before postmoogle is considered not ready,
+	// long-polling /sync returns at least every 30 seconds
+	SyncTimeout = 2 * time.Minute
+	// CheckTimeout of the database ping and queue stats
+	CheckTimeout = 5 * time.Second
+)
+
+// Checks
+const (
+	CheckMatrix = "matrix"
+	CheckDB     = "db"
+)
+
+type smtpServer interface {
+	Listening() map[string]bool
+	CertExpiry(ctx context.Context) map[string]time.Time
+}
+
+type matrixbot interface {
+	SyncedAt() time.Time
+	PingDB(ctx context.Context) error
+	QueueStats(ctx context.Context) (int, time.Duration, error)
+}
+
+// Checker of postmoogle's health
+type Checker struct {
+	smtp smtpServer
+	bot  matrixbot
+	log  *zerolog.Logger
+}
+
+// Status of postmoogle, returned by the readiness endpoint
+type Status struct {
+	Ready bool `json:"ready"`
+	// Checks by name: SMTP listeners (smtp, smtps, lmtp), matrix sync, and database
+	Checks map[string]*Check `json:"checks"`
+	// TLS certificates of the served domains
+	TLS []*Cert `json:"tls"`
+	// Queue backlog, omitted if it cannot be retrieved
+	Queue *Queue `json:"queue,omitempty"`
+}
+
+// Check result
+type Check struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// Cert is expiry of the domain's TLS certificate
+type Cert struct {
+	Domain   string    `json:"domain"`
+	Expires  time.Time `json:"expires"`
+	DaysLeft int       `json:"days_left"`
+}
+
+// Queue backlog
+type Queue struct {
+	Depth            int   `json:"depth"`
+	OldestAgeSeconds int64 `json:"oldest_age_seconds"`
+}
+
+// New health checker
+func New(smtp smtpServer, bot matrixbot, log *zerolog.Logger) *Checker {
+	return &Checker{
+		smtp: smtp,
+		bot:  bot,
+		log:  log,
+	}
+}
+
+// Liveness returns HTTP handler of the /healthz endpoint, it answers as long as the process is running
+func (c *Checker) Liveness() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		c.write(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+}
+
+// Readiness returns HTTP handler of the /readyz endpoint, it responds with 503 if any check failed
+func (c *Checker) Readiness() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := c.Status(r.Context())
+		code := http.StatusOK
+		if !status.Ready {
+			code = http.StatusServiceUnavailable
+		}
+		c.write(w, code, status)
+	})
+}
+
+// Status runs the checks
+func (c *Checker) Status(ctx context.Context) *Status {
+	ctx, cancel := context.WithTimeout(ctx, CheckTimeout)
+	defer cancel()
+
+	status := &Status{
+		Checks: c.checkSMTP(),
+		TLS:    c.certs(ctx),
+	}
+	status.Checks[CheckMatrix] = c.checkMatrix()
+	status.Checks[CheckDB] = c.checkDB(ctx)
+
+	status.Ready = true
+	for _, check := range status.Checks {
+		status.Ready = status.Ready && check.OK
+	}
+
+	depth, oldest, err := c.bot.QueueStats(ctx)
+	if err != nil {
+		c.log.Warn().Err(err).Msg("cannot get queue stats")
+		return status
+	}
+	status.Queue = &Queue{Depth: depth, OldestAgeSeconds: int64(oldest.Seconds())}
+	return status
+}
+
+// checkSMTP returns check of each listener, the smtp listener is expected at least
+func (c *Checker) checkSMTP() map[string]*Check {
+	listening := c.smtp.Listening()
+	checks := make(map[string]*Check, len(listening)+2)
+	if len(listening) == 0 {
+		checks["smtp"] = &Check{Error: "SMTP server is not started"}
+		return checks
+	}
+	for name, ok := range listening {
+		check := &Check{OK: ok}
+		if !ok {
+			check.Error = "listener is not bound"
+		}
+		checks[name] = check
+	}
+	return checks
+}
+
+func (c *Checker) checkMatrix() *Check {
+	syncedAt := c.bot.SyncedAt()
+	if syncedAt.IsZero() {
+		return &Check{Error: "matrix sync is not started"}
+	}
+	if since := time.Since(syncedAt); since > SyncTimeout {
+		return &Check{Error: "last successful matrix sync was " + since.Truncate(time.Second).String() + " ago"}
+	}
+	return &Check{OK: true}
+}
+
+func (c *Checker) checkDB(ctx context.Context) *Check {
+	if err := c.bot.PingDB(ctx); err != nil {
+		return &Check{Error: err.Error()}
+	}
+	return &Check{OK: true}
+}
+
+func (c *Checker) certs(ctx context.Context) []*Cert {
+	now := time.Now()
+	expiry := c.smtp.CertExpiry(ctx)
+	certs := make([]*Cert, 0, len(expiry))
+	for domain, expires := range expiry {
+		certs = append(certs, &Cert{
+			Domain:   domain,
+			Expires:  expires.UTC(),
+			DaysLeft: int(expires.Sub(now).Hours() / 24),
+		})
+	}
+	sort.Slice(certs, func(i, j int) bool { return certs[i].Domain < certs[j].Domain })
+	return certs
+}
+
+func (c *Checker) write(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		c.log.Warn().Err(err).Msg("cannot write health response")
+	}
+}
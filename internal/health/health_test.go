@@ -0,0 +1,122 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type fakesmtp struct {
+	listening map[string]bool
+	expiry    map[string]time.Time
+}
+
+func (s *fakesmtp) Listening() map[string]bool {
+	return s.listening
+}
+
+func (s *fakesmtp) CertExpiry(_ context.Context) map[string]time.Time {
+	return s.expiry
+}
+
+type fakebot struct {
+	syncedAt time.Time
+	dbErr    error
+}
+
+func (b *fakebot) SyncedAt() time.Time {
+	return b.syncedAt
+}
+
+func (b *fakebot) PingDB(_ context.Context) error {
+	return b.dbErr
+}
+
+func (b *fakebot) QueueStats(_ context.Context) (int, time.Duration, error) {
+	return 2, 90 * time.Second, nil
+}
+
+func newReady() (*fakesmtp, *fakebot) {
+	smtp := &fakesmtp{
+		listening: map[string]bool{"smtp": true, "smtps": true},
+		expiry:    map[string]time.Time{"example.com": time.Now().Add(30*24*time.Hour + time.Hour)},
+	}
+	bot := &fakebot{syncedAt: time.Now()}
+	return smtp, bot
+}
+
+func readyz(t *testing.T, smtp *fakesmtp, bot *fakebot) (int, *Status) {
+	t.Helper()
+	log := zerolog.Nop()
+	rr := httptest.NewRecorder()
+	New(smtp, bot, &log).Readiness().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", http.NoBody))
+
+	var status *Status
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	return rr.Code, status
+}
+
+func TestLiveness(t *testing.T) {
+	log := zerolog.Nop()
+	smtp, bot := newReady()
+	smtp.listening = map[string]bool{}
+	rr := httptest.NewRecorder()
+	New(smtp, bot, &log).Liveness().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", http.NoBody))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", rr.Code)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	smtp, bot := newReady()
+	code, status := readyz(t, smtp, bot)
+	if code != http.StatusOK || !status.Ready {
+		t.Fatalf("expected ready, got %d %+v", code, status.Checks)
+	}
+	for _, name := range []string{"smtp", "smtps", CheckMatrix, CheckDB} {
+		if check := status.Checks[name]; check == nil || !check.OK {
+			t.Errorf("check %s: %+v", name, check)
+		}
+	}
+	if len(status.TLS) != 1 || status.TLS[0].Domain != "example.com" || status.TLS[0].DaysLeft != 30 {
+		t.Errorf("unexpected TLS: %+v", status.TLS)
+	}
+	if status.Queue == nil || status.Queue.Depth != 2 || status.Queue.OldestAgeSeconds != 90 {
+		t.Errorf("unexpected queue: %+v", status.Queue)
+	}
+}
+
+func TestReadinessFailed(t *testing.T) {
+	tests := []struct {
+		name   string
+		check  string
+		modify func(*fakesmtp, *fakebot)
+	}{
+		{"smtp not started", "smtp", func(s *fakesmtp, _ *fakebot) { s.listening = map[string]bool{} }},
+		{"smtps not bound", "smtps", func(s *fakesmtp, _ *fakebot) { s.listening["smtps"] = false }},
+		{"sync not started", CheckMatrix, func(_ *fakesmtp, b *fakebot) { b.syncedAt = time.Time{} }},
+		{"sync stale", CheckMatrix, func(_ *fakesmtp, b *fakebot) { b.syncedAt = time.Now().Add(-SyncTimeout - time.Minute) }},
+		{"db down", CheckDB, func(_ *fakesmtp, b *fakebot) { b.dbErr = errors.New("connection refused") }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			smtp, bot := newReady()
+			test.modify(smtp, bot)
+			code, status := readyz(t, smtp, bot)
+			if code != http.StatusServiceUnavailable || status.Ready {
+				t.Errorf("expected not ready, got %d", code)
+			}
+			if check := status.Checks[test.check]; check == nil || check.OK || check.Error == "" {
+				t.Errorf("check %s should fail: %+v", test.check, check)
+			}
+		})
+	}
+}
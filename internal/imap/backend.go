@@ -0,0 +1,482 @@
+package imap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	sortthread "github.com/emersion/go-imap-sortthread"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/backend/backendutil"
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/textproto"
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/id"
+)
+
+// Delimiter of the folders hierarchy
+const Delimiter = "/"
+
+// uidValidity is constant, because UIDs are never reused
+const uidValidity = 1
+
+var (
+	// ErrReadOnly returned on any attempt to change the mailbox
+	ErrReadOnly = errors.New("mailbox is read-only")
+	// ErrBanned returned when the host is banned
+	ErrBanned = errors.New("please, don't bother me anymore, kupo")
+)
+
+// flags of all messages, the mailbox is read-only, so the emails are considered seen in matrix already
+var flags = []string{imap.SeenFlag}
+
+type matrixbot interface {
+	AllowAuth(context.Context, string, string) (id.RoomID, bool)
+	IsBanned(context.Context, net.Addr) bool
+	BanAuth(context.Context, net.Addr)
+}
+
+// Backend of the IMAP server, it serves emails of the mailbox with the same credentials as SMTP
+type Backend struct {
+	bot   matrixbot
+	store *Store
+	log   *zerolog.Logger
+}
+
+// NewBackend creates IMAP backend
+func NewBackend(bot matrixbot, store *Store, log *zerolog.Logger) *Backend {
+	return &Backend{bot: bot, store: store, log: log}
+}
+
+// Login authenticates the mailbox, the same way as SMTP does
+func (b *Backend) Login(conn *imap.ConnInfo, username, password string) (backend.User, error) {
+	ctx := context.Background()
+	if b.bot.IsBanned(ctx, conn.RemoteAddr) {
+		b.log.Info().Str("addr", conn.RemoteAddr.String()).Msg("rejected IMAP login from banned host")
+		return nil, ErrBanned
+	}
+	roomID, ok := b.bot.AllowAuth(ctx, username, password)
+	if !ok {
+		b.log.Info().Str("addr", conn.RemoteAddr.String()).Str("username", username).Msg("IMAP login failed")
+		b.bot.BanAuth(ctx, conn.RemoteAddr)
+		return nil, backend.ErrInvalidCredentials
+	}
+	return &user{roomID: roomID, store: b.store}, nil
+}
+
+// Updates returns IDLE updates
+func (b *Backend) Updates() <-chan backend.Update {
+	return b.store.Updates()
+}
+
+// SupportedThreadAlgorithms returns THREAD algorithms, matrix threads are used for all of them
+func (b *Backend) SupportedThreadAlgorithms() []sortthread.ThreadAlgorithm {
+	return []sortthread.ThreadAlgorithm{sortthread.References}
+}
+
+// user is the authenticated mailbox
+type user struct {
+	roomID id.RoomID
+	store  *Store
+}
+
+// Username returns room ID, because IDLE updates are sent per room
+func (u *user) Username() string {
+	return u.roomID.String()
+}
+
+func (u *user) ListMailboxes(_ bool) ([]backend.Mailbox, error) {
+	return []backend.Mailbox{u.mailbox(FolderInbox), u.mailbox(FolderSent)}, nil
+}
+
+func (u *user) GetMailbox(name string) (backend.Mailbox, error) {
+	switch {
+	case strings.EqualFold(name, FolderInbox):
+		return u.mailbox(FolderInbox), nil
+	case name == FolderSent:
+		return u.mailbox(FolderSent), nil
+	default:
+		return nil, backend.ErrNoSuchMailbox
+	}
+}
+
+func (u *user) CreateMailbox(_ string) error {
+	return ErrReadOnly
+}
+
+func (u *user) DeleteMailbox(_ string) error {
+	return ErrReadOnly
+}
+
+func (u *user) RenameMailbox(_, _ string) error {
+	return ErrReadOnly
+}
+
+func (u *user) Logout() error {
+	return nil
+}
+
+func (u *user) mailbox(name string) *mailbox {
+	return &mailbox{name: name, roomID: u.roomID, store: u.store}
+}
+
+// mailbox is the folder of the mailbox, messages are loaded from the store on each command
+type mailbox struct {
+	name   string
+	roomID id.RoomID
+	store  *Store
+}
+
+func (m *mailbox) Name() string {
+	return m.name
+}
+
+func (m *mailbox) Info() (*imap.MailboxInfo, error) {
+	info := &imap.MailboxInfo{
+		Attributes: []string{imap.NoInferiorsAttr},
+		Delimiter:  Delimiter,
+		Name:       m.name,
+	}
+	if m.name == FolderSent {
+		info.Attributes = append(info.Attributes, imap.SentAttr)
+	}
+	return info, nil
+}
+
+func (m *mailbox) Status(items []imap.StatusItem) (*imap.MailboxStatus, error) {
+	ctx := context.Background()
+	messages, err := m.store.List(ctx, m.roomID, m.name)
+	if err != nil {
+		return nil, err
+	}
+
+	status := imap.NewMailboxStatus(m.name, items)
+	status.ReadOnly = true
+	status.Flags = flags
+	status.PermanentFlags = []string{}
+	for _, item := range items {
+		switch item {
+		case imap.StatusMessages:
+			status.Messages = uint32(len(messages)) //nolint:gosec // IMAP sequence numbers are 32-bit
+		case imap.StatusUidNext:
+			status.UidNext, err = m.store.UIDNext(ctx)
+			if err != nil {
+				return nil, err
+			}
+		case imap.StatusUidValidity:
+			status.UidValidity = uidValidity
+		case imap.StatusRecent, imap.StatusUnseen:
+			// all messages are seen
+		}
+	}
+	return status, nil
+}
+
+func (m *mailbox) SetSubscribed(_ bool) error {
+	return nil
+}
+
+func (m *mailbox) Check() error {
+	return nil
+}
+
+func (m *mailbox) ListMessages(uid bool, seqSet *imap.SeqSet, items []imap.FetchItem, ch chan<- *imap.Message) error {
+	defer close(ch)
+
+	ctx := context.Background()
+	messages, err := m.store.List(ctx, m.roomID, m.name)
+	if err != nil {
+		return err
+	}
+	for i, msg := range messages {
+		seqNum := uint32(i + 1) //nolint:gosec // IMAP sequence numbers are 32-bit
+		if !seqSet.Contains(msgID(uid, seqNum, msg)) {
+			continue
+		}
+		fetched, err := m.fetch(ctx, seqNum, msg, items)
+		if err != nil {
+			return err
+		}
+		ch <- fetched
+	}
+	return nil
+}
+
+func (m *mailbox) SearchMessages(uid bool, criteria *imap.SearchCriteria) ([]uint32, error) {
+	messages, err := m.search(context.Background(), criteria)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uint32, 0, len(messages))
+	for _, msg := range messages {
+		ids = append(ids, msgID(uid, msg.seqNum, msg.Message))
+	}
+	return ids, nil
+}
+
+// Thread returns matrix threads of the messages matching the criteria, the thread's messages are chained in order
+func (m *mailbox) Thread(uid bool, _ sortthread.ThreadAlgorithm, criteria *imap.SearchCriteria) ([]*sortthread.Thread, error) {
+	messages, err := m.search(context.Background(), criteria)
+	if err != nil {
+		return nil, err
+	}
+	threads := []*sortthread.Thread{}
+	last := map[id.EventID]*sortthread.Thread{}
+	for _, msg := range messages {
+		thread := &sortthread.Thread{Id: msgID(uid, msg.seqNum, msg.Message)}
+		parent, ok := last[msg.ThreadID]
+		switch {
+		case msg.ThreadID == "" || !ok:
+			threads = append(threads, thread)
+		default:
+			parent.Children = append(parent.Children, thread)
+		}
+		if msg.ThreadID != "" {
+			last[msg.ThreadID] = thread
+		}
+	}
+	return threads, nil
+}
+
+func (m *mailbox) CreateMessage(_ []string, _ time.Time, _ imap.Literal) error {
+	return ErrReadOnly
+}
+
+func (m *mailbox) UpdateMessagesFlags(_ bool, _ *imap.SeqSet, _ imap.FlagsOp, _ []string) error {
+	return ErrReadOnly
+}
+
+func (m *mailbox) CopyMessages(_ bool, _ *imap.SeqSet, _ string) error {
+	return ErrReadOnly
+}
+
+func (m *mailbox) Expunge() error {
+	return ErrReadOnly
+}
+
+// searchResult is the message matching the search criteria, with its sequence number
+type searchResult struct {
+	*Message
+	seqNum uint32
+}
+
+// search matches the folder's messages against the criteria. UIDs, sequence numbers, internal date, size, and flags
+// are matched against the columns, headers are loaded only for header criteria, and raw MIME only for body criteria
+func (m *mailbox) search(ctx context.Context, criteria *imap.SearchCriteria) ([]*searchResult, error) {
+	messages, err := m.store.List(ctx, m.roomID, m.name)
+	if err != nil {
+		return nil, err
+	}
+	var headers map[uint32][]byte
+	body := searchBody(criteria)
+	if !body && searchHeader(criteria) {
+		if headers, err = m.store.Headers(ctx, m.roomID, m.name); err != nil {
+			return nil, err
+		}
+	}
+
+	results := []*searchResult{}
+	for i, msg := range messages {
+		result := &searchResult{Message: msg, seqNum: uint32(i + 1)} //nolint:gosec // IMAP sequence numbers are 32-bit
+		var entity *message.Entity
+		load := func() (*message.Entity, error) {
+			if entity != nil {
+				return entity, nil
+			}
+			raw := headers[msg.UID]
+			if body {
+				var rerr error
+				if raw, rerr = m.store.Raw(ctx, m.roomID, msg.UID); rerr != nil {
+					return nil, rerr
+				}
+			}
+			parsed, perr := message.Read(bytes.NewReader(raw))
+			if perr != nil && !message.IsUnknownCharset(perr) {
+				return nil, perr
+			}
+			entity = parsed
+			return entity, nil
+		}
+		ok, err := match(result, criteria, load)
+		if err != nil || !ok {
+			continue
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// match checks the message against the criteria, the message is loaded only if the criteria can't be matched against the columns
+func match(msg *searchResult, criteria *imap.SearchCriteria, load func() (*message.Entity, error)) (bool, error) {
+	if !matchColumns(msg, criteria) {
+		return false, nil
+	}
+	contentCriteria := &imap.SearchCriteria{
+		SentBefore: criteria.SentBefore,
+		SentSince:  criteria.SentSince,
+		Header:     criteria.Header,
+		Body:       criteria.Body,
+		Text:       criteria.Text,
+	}
+	if searchHeader(contentCriteria) {
+		entity, err := load()
+		if err != nil {
+			return false, err
+		}
+		if ok, err := backendutil.Match(entity, msg.seqNum, msg.UID, msg.Date, flags, contentCriteria); err != nil || !ok {
+			return false, err
+		}
+	}
+	for _, not := range criteria.Not {
+		ok, err := match(msg, not, load)
+		if err != nil || ok {
+			return false, err
+		}
+	}
+	for _, or := range criteria.Or {
+		ok1, err := match(msg, or[0], load)
+		if err != nil {
+			return false, err
+		}
+		ok2, err := match(msg, or[1], load)
+		if err != nil || (!ok1 && !ok2) {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// matchColumns matches the criteria available without loading the message: sequence number, UID, internal date, size, and flags
+func matchColumns(msg *searchResult, criteria *imap.SearchCriteria) bool {
+	if criteria.SeqNum != nil && !criteria.SeqNum.Contains(msg.seqNum) {
+		return false
+	}
+	if criteria.Uid != nil && !criteria.Uid.Contains(msg.UID) {
+		return false
+	}
+	if criteria.Larger > 0 && msg.Size <= criteria.Larger {
+		return false
+	}
+	if criteria.Smaller > 0 && msg.Size >= criteria.Smaller {
+		return false
+	}
+	// RFC 3501 requires zone unaware date comparison
+	date := time.Date(msg.Date.Year(), msg.Date.Month(), msg.Date.Day(), 0, 0, 0, 0, time.UTC)
+	if !criteria.Since.IsZero() && date.Before(criteria.Since) {
+		return false
+	}
+	if !criteria.Before.IsZero() && !date.Before(criteria.Before) {
+		return false
+	}
+	for _, flag := range criteria.WithFlags {
+		if !slices.Contains(flags, flag) {
+			return false
+		}
+	}
+	for _, flag := range criteria.WithoutFlags {
+		if slices.Contains(flags, flag) {
+			return false
+		}
+	}
+	return true
+}
+
+// searchHeader checks if the criteria (or any of the nested criteria) needs the message headers
+func searchHeader(criteria *imap.SearchCriteria) bool {
+	if !criteria.SentBefore.IsZero() || !criteria.SentSince.IsZero() || len(criteria.Header) > 0 {
+		return true
+	}
+	return searchNested(criteria, searchHeader) || searchBody(criteria)
+}
+
+// searchBody checks if the criteria (or any of the nested criteria) needs the message body
+func searchBody(criteria *imap.SearchCriteria) bool {
+	if len(criteria.Body) > 0 || len(criteria.Text) > 0 {
+		return true
+	}
+	return searchNested(criteria, searchBody)
+}
+
+// searchNested checks if any of the nested (NOT, OR) criteria satisfies the check
+func searchNested(criteria *imap.SearchCriteria, check func(*imap.SearchCriteria) bool) bool {
+	for _, not := range criteria.Not {
+		if check(not) {
+			return true
+		}
+	}
+	for _, or := range criteria.Or {
+		if check(or[0]) || check(or[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetch the message items, raw MIME is loaded only if needed
+func (m *mailbox) fetch(ctx context.Context, seqNum uint32, msg *Message, items []imap.FetchItem) (*imap.Message, error) {
+	fetched := imap.NewMessage(seqNum, items)
+	var raw []byte
+	var err error
+	for _, item := range items {
+		switch item {
+		case imap.FetchFlags:
+			fetched.Flags = flags
+		case imap.FetchInternalDate:
+			fetched.InternalDate = msg.Date
+		case imap.FetchRFC822Size:
+			fetched.Size = msg.Size
+		case imap.FetchUid:
+			fetched.Uid = msg.UID
+		default:
+			if raw == nil {
+				if raw, err = m.store.Raw(ctx, m.roomID, msg.UID); err != nil {
+					return nil, err
+				}
+			}
+			if err := fetchItem(fetched, raw, item); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return fetched, nil
+}
+
+// fetchItem fetches the item which needs raw MIME: envelope, body structure, or body section
+func fetchItem(fetched *imap.Message, raw []byte, item imap.FetchItem) error {
+	body := bufio.NewReader(bytes.NewReader(raw))
+	header, err := textproto.ReadHeader(body)
+	if err != nil {
+		return err
+	}
+	switch item {
+	case imap.FetchEnvelope:
+		fetched.Envelope, err = backendutil.FetchEnvelope(header)
+	case imap.FetchBody, imap.FetchBodyStructure:
+		fetched.BodyStructure, err = backendutil.FetchBodyStructure(header, body, item == imap.FetchBodyStructure)
+	default:
+		section, perr := imap.ParseBodySectionName(item)
+		if perr != nil {
+			return nil //nolint:nilerr // unknown items are ignored
+		}
+		literal, ferr := backendutil.FetchBodySection(header, body, section)
+		if ferr != nil {
+			literal = bytes.NewReader(nil) // the section doesn't exist, RFC 3501 requires an empty string
+		}
+		fetched.Body[section] = literal
+	}
+	return err
+}
+
+// msgID returns UID or sequence number of the message
+func msgID(uid bool, seqNum uint32, msg *Message) uint32 {
+	if uid {
+		return msg.UID
+	}
+	return seqNum
+}
@@ -0,0 +1,119 @@
+// Package imap provides read-only IMAP4rev1 access to the mailboxes' history: emails received and sent by postmoogle
+package imap
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	sortthread "github.com/emersion/go-imap-sortthread"
+	"github.com/emersion/go-imap/server"
+	"github.com/rs/zerolog"
+)
+
+// AutoLogout of the idle clients, RFC 3501 requires at least 30 minutes
+const AutoLogout = 30 * time.Minute
+
+// Config of the IMAP server
+type Config struct {
+	// Addr of the IMAP listener (with STARTTLS), host:port. Empty = disabled
+	Addr string
+	// TLSAddr of the IMAPS listener (implicit TLS), host:port. Empty = disabled
+	TLSAddr string
+	// TLSConfig is shared with the SMTP server, nil = TLS is not available
+	TLSConfig *tls.Config
+	// TLSRequired forbids authentication over unencrypted connections
+	TLSRequired bool
+	Store       *Store
+	Bot         matrixbot
+	Logger      *zerolog.Logger
+}
+
+// Server is the IMAP server
+type Server struct {
+	cfg *Config
+	log *zerolog.Logger
+	srv *server.Server
+}
+
+// New IMAP server
+func New(cfg *Config) *Server {
+	srv := server.New(NewBackend(cfg.Bot, cfg.Store, cfg.Logger))
+	srv.TLSConfig = cfg.TLSConfig
+	srv.AllowInsecureAuth = !cfg.TLSRequired
+	srv.AutoLogout = AutoLogout
+	srv.ErrorLog = &errorLog{cfg.Logger}
+	srv.Enable(sortthread.NewThreadExtension())
+
+	return &Server{
+		cfg: cfg,
+		log: cfg.Logger,
+		srv: srv,
+	}
+}
+
+// Start the listeners, it blocks until the server is stopped
+func (s *Server) Start() error {
+	errs := make(chan error, 2)
+	listeners := 0
+	if s.cfg.Addr != "" {
+		listeners++
+		go s.listen(s.cfg.Addr, nil, errs)
+	}
+	if s.cfg.TLSAddr != "" {
+		if s.cfg.TLSConfig == nil {
+			return errors.New("IMAPS listener requires TLS certificates") //nolint:goerr113 // configuration error
+		}
+		listeners++
+		go s.listen(s.cfg.TLSAddr, s.cfg.TLSConfig, errs)
+	}
+
+	for i := 0; i < listeners; i++ {
+		if err := <-errs; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) listen(addr string, tlsConfig *tls.Config, errs chan<- error) {
+	var l net.Listener
+	var err error
+	if tlsConfig == nil {
+		l, err = net.Listen("tcp", addr)
+	} else {
+		l, err = tls.Listen("tcp", addr, tlsConfig)
+	}
+	if err != nil {
+		errs <- fmt.Errorf("cannot start IMAP listener on %s: %w", addr, err)
+		return
+	}
+	s.log.Info().Str("addr", addr).Bool("tls", tlsConfig != nil).Msg("IMAP server is listening")
+	err = s.srv.Serve(l)
+	if errors.Is(err, net.ErrClosed) {
+		err = nil
+	}
+	errs <- err
+}
+
+// Stop the server and close all connections
+func (s *Server) Stop() {
+	if err := s.srv.Close(); err != nil {
+		s.log.Error().Err(err).Msg("cannot stop IMAP server")
+	}
+}
+
+// errorLog adapts zerolog to the go-imap logger
+type errorLog struct {
+	log *zerolog.Logger
+}
+
+func (l *errorLog) Printf(format string, v ...any) {
+	l.log.Warn().Msgf("imap: "+format, v...)
+}
+
+func (l *errorLog) Println(v ...any) {
+	l.log.Warn().Msg("imap: " + fmt.Sprintln(v...))
+}
@@ -0,0 +1,292 @@
+package imap
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	sortthread "github.com/emersion/go-imap-sortthread"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-message"
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/id"
+)
+
+type fakebot struct {
+	banned bool
+	bans   int
+}
+
+func (b *fakebot) AllowAuth(_ context.Context, email, password string) (id.RoomID, bool) {
+	if email == "test@example.com" && password == "secret" {
+		return "!room:example.com", true
+	}
+	return "", false
+}
+
+func (b *fakebot) IsBanned(_ context.Context, _ net.Addr) bool {
+	return b.banned
+}
+
+func (b *fakebot) BanAuth(_ context.Context, _ net.Addr) {
+	b.bans++
+}
+
+func testEmail(messageID, inReplyTo, subject string) []byte {
+	headers := "From: sender@example.org\r\n" +
+		"To: test@example.com\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Message-Id: " + messageID + "\r\n"
+	if inReplyTo != "" {
+		headers += "In-Reply-To: " + inReplyTo + "\r\n"
+	}
+	return []byte(headers + "Content-Type: text/plain\r\n\r\nHello, " + subject + "\r\n")
+}
+
+func newTestServer(t *testing.T, bot *fakebot) (*Store, string) {
+	t.Helper()
+	log := zerolog.Nop()
+	store := newTestStore(t)
+	srv := New(&Config{Store: store, Bot: bot, Logger: &log})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.srv.Serve(l) //nolint:errcheck // closed on cleanup
+	t.Cleanup(srv.Stop)
+
+	return store, l.Addr().String()
+}
+
+func newTestClient(t *testing.T, addr string) *client.Client {
+	t.Helper()
+	c, err := client.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Timeout = 5 * time.Second
+	t.Cleanup(func() { c.Logout() }) //nolint:errcheck // that's ok
+	return c
+}
+
+func TestServerLogin(t *testing.T) {
+	bot := &fakebot{}
+	_, addr := newTestServer(t, bot)
+	c := newTestClient(t, addr)
+
+	if err := c.Login("test@example.com", "wrong"); err == nil {
+		t.Error("login with the wrong password succeeded")
+	}
+	if bot.bans != 1 {
+		t.Errorf("failed login is not reported, bans: %d", bot.bans)
+	}
+	bot.banned = true
+	if err := c.Login("test@example.com", "secret"); err == nil {
+		t.Error("login from the banned host succeeded")
+	}
+	bot.banned = false
+	if err := c.Login("test@example.com", "secret"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestServerMailbox(t *testing.T) {
+	ctx := context.Background()
+	store, addr := newTestServer(t, &fakebot{})
+	for _, msg := range []*Message{
+		{RoomID: "!room:example.com", Folder: FolderInbox, ThreadID: "$1", MessageID: "<1@example.org>", Raw: testEmail("<1@example.org>", "", "first")},
+		{RoomID: "!room:example.com", Folder: FolderInbox, ThreadID: "$2", MessageID: "<2@example.org>", Raw: testEmail("<2@example.org>", "", "second")},
+		{RoomID: "!room:example.com", Folder: FolderInbox, ThreadID: "$1", MessageID: "<3@example.org>", Raw: testEmail("<3@example.org>", "<1@example.org>", "Re: first")},
+		{RoomID: "!other:example.com", Folder: FolderInbox, MessageID: "<4@example.org>", Raw: testEmail("<4@example.org>", "", "other")},
+	} {
+		if _, err := store.Add(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := newTestClient(t, addr)
+	if err := c.Login("test@example.com", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := c.Select("inbox", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.ReadOnly || status.Messages != 3 {
+		t.Errorf("unexpected status: read-only=%t, messages=%d", status.ReadOnly, status.Messages)
+	}
+	if _, err = c.Select("Drafts", false); err == nil {
+		t.Error("unknown folder is selected")
+	}
+	if _, err = c.Select(FolderInbox, true); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("fetch", func(t *testing.T) {
+		section := &imap.BodySectionName{}
+		seqSet, _ := imap.ParseSeqSet("1:*")
+		messages := make(chan *imap.Message, 10)
+		if err := c.Fetch(seqSet, []imap.FetchItem{imap.FetchEnvelope, imap.FetchFlags, imap.FetchUid, section.FetchItem()}, messages); err != nil {
+			t.Fatal(err)
+		}
+		subjects := []string{}
+		for msg := range messages {
+			subjects = append(subjects, msg.Envelope.Subject)
+			if len(msg.Flags) != 1 || msg.Flags[0] != imap.SeenFlag {
+				t.Errorf("unexpected flags: %v", msg.Flags)
+			}
+			body, err := io.ReadAll(msg.GetBody(section))
+			if err != nil || !strings.Contains(string(body), "Hello, "+msg.Envelope.Subject) {
+				t.Errorf("unexpected body: %q (%v)", body, err)
+			}
+		}
+		if strings.Join(subjects, ",") != "first,second,Re: first" {
+			t.Errorf("unexpected messages: %v", subjects)
+		}
+	})
+
+	t.Run("search", func(t *testing.T) {
+		subject := imap.NewSearchCriteria()
+		subject.Header.Add("Subject", "first")
+		notSubject := imap.NewSearchCriteria()
+		notSubject.Not = []*imap.SearchCriteria{subject}
+		body := imap.NewSearchCriteria()
+		body.Body = []string{"hello, second"}
+		text := imap.NewSearchCriteria()
+		text.Text = []string{"Re: first"}
+		or := imap.NewSearchCriteria()
+		or.Or = [][2]*imap.SearchCriteria{{body, text}}
+		since := imap.NewSearchCriteria()
+		since.Since = time.Now().UTC().Truncate(24 * time.Hour)
+		before := imap.NewSearchCriteria()
+		before.Before = time.Now().UTC().Truncate(24 * time.Hour)
+		larger := imap.NewSearchCriteria()
+		larger.Larger = uint32(len(testEmail("<2@example.org>", "", "second")))
+
+		tests := map[string]struct {
+			criteria *imap.SearchCriteria
+			expected []uint32
+		}{
+			"header": {subject, []uint32{1, 3}},
+			"not":    {notSubject, []uint32{2}},
+			"body":   {body, []uint32{2}},
+			"or":     {or, []uint32{2, 3}},
+			"since":  {since, []uint32{1, 2, 3}},
+			"before": {before, []uint32{}},
+			"larger": {larger, []uint32{3}},
+		}
+		for name, test := range tests {
+			t.Run(name, func(t *testing.T) {
+				seqNums, err := c.Search(test.criteria)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Equal(seqNums, test.expected) {
+					t.Error(test.expected, "!=", seqNums)
+				}
+			})
+		}
+	})
+
+	t.Run("thread", func(t *testing.T) {
+		sc := sortthread.NewThreadClient(c)
+		threads, err := sc.Thread(sortthread.References, imap.NewSearchCriteria())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(threads) != 2 || threads[0].Id != 1 || len(threads[0].Children) != 1 || threads[0].Children[0].Id != 3 || threads[1].Id != 2 {
+			t.Errorf("unexpected threads: %+v", threads)
+		}
+	})
+
+	t.Run("read-only", func(t *testing.T) {
+		if err := c.Create("Archive"); err == nil {
+			t.Error("folder is created")
+		}
+		if err := c.Append(FolderInbox, nil, time.Now(), imap.Literal(strings.NewReader("test"))); err == nil {
+			t.Error("message is appended")
+		}
+	})
+}
+
+func TestBackendUpdates(t *testing.T) {
+	store := newTestStore(t)
+	bkd := NewBackend(&fakebot{}, store, nil)
+
+	if _, err := store.Add(context.Background(), &Message{RoomID: "!room:example.com", Folder: FolderSent, Raw: []byte("raw")}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case update := <-bkd.Updates():
+		if update.Username() != "!room:example.com" || update.Mailbox() != FolderSent {
+			t.Errorf("unexpected update: %s %s", update.Username(), update.Mailbox())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("update is not sent")
+	}
+}
+
+func TestSearchMatch(t *testing.T) {
+	raw := testEmail("<1@example.org>", "", "first")
+	msg := &searchResult{Message: &Message{UID: 10, Date: time.Now(), Size: uint32(len(raw))}, seqNum: 1}
+	uids, _ := imap.ParseSeqSet("5:7")
+	otherUID := imap.NewSearchCriteria()
+	otherUID.Uid = uids
+	subject := imap.NewSearchCriteria()
+	subject.Header.Add("Subject", "first")
+	otherUIDSubject := imap.NewSearchCriteria()
+	otherUIDSubject.Uid = uids
+	otherUIDSubject.Header.Add("Subject", "first")
+	body := imap.NewSearchCriteria()
+	body.Body = []string{"hello"}
+	notBody := imap.NewSearchCriteria()
+	notBody.Not = []*imap.SearchCriteria{body}
+	smaller := imap.NewSearchCriteria()
+	smaller.Smaller = 10
+
+	tests := map[string]struct {
+		criteria *imap.SearchCriteria
+		expected bool
+		loaded   bool
+		body     bool
+	}{
+		"all":                   {imap.NewSearchCriteria(), true, false, false},
+		"other uid":             {otherUID, false, false, false},
+		"size":                  {smaller, false, false, false},
+		"header":                {subject, true, true, false},
+		"columns before header": {otherUIDSubject, false, false, false},
+		"body":                  {body, true, true, true},
+		"nested body":           {notBody, false, true, true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var loaded bool
+			load := func() (*message.Entity, error) {
+				loaded = true
+				return message.Read(bytes.NewReader(raw))
+			}
+			ok, err := match(msg, test.criteria, load)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != test.expected {
+				t.Error("match:", test.expected, "!=", ok)
+			}
+			if loaded != test.loaded {
+				t.Error("loaded:", test.loaded, "!=", loaded)
+			}
+			if body := searchBody(test.criteria); body != test.body {
+				t.Error("body:", test.body, "!=", body)
+			}
+		})
+	}
+}
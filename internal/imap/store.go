@@ -0,0 +1,292 @@
+package imap
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"maunium.net/go/mautrix/id"
+)
+
+// Folders of the mailbox
+const (
+	// FolderInbox contains emails received by the mailbox
+	FolderInbox = "INBOX"
+	// FolderSent contains emails sent from the mailbox
+	FolderSent = "Sent"
+)
+
+// updatesBuffer is the size of the IDLE updates buffer, updates are dropped when it's full
+const updatesBuffer = 100
+
+// Message is an email stored for the IMAP access
+type Message struct {
+	UID       uint32
+	RoomID    id.RoomID
+	Folder    string
+	ThreadID  id.EventID
+	MessageID string
+	Date      time.Time
+	Size      uint32
+	// Raw MIME message, loaded on demand
+	Raw []byte
+}
+
+// Store keeps raw MIME messages of the mailboxes in the database
+type Store struct {
+	db      *sql.DB
+	dialect string
+	updates chan backend.Update
+}
+
+// NewStore creates the store and its table, if not exists yet. Dialect is either postgres or sqlite
+func NewStore(db *sql.DB, dialect string) (*Store, error) {
+	s := &Store{
+		db:      db,
+		dialect: dialect,
+		updates: make(chan backend.Update, updatesBuffer),
+	}
+	if err := s.migrate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) migrate() error {
+	uid, blob := "INTEGER PRIMARY KEY AUTOINCREMENT", "BLOB"
+	if s.dialect == "postgres" {
+		uid, blob = "BIGSERIAL PRIMARY KEY", "BYTEA"
+	}
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS postmoogle_imap_messages (
+			uid        ` + uid + `,
+			room_id    TEXT NOT NULL,
+			folder     TEXT NOT NULL,
+			thread_id  TEXT NOT NULL DEFAULT '',
+			message_id TEXT NOT NULL DEFAULT '',
+			created_at BIGINT NOT NULL,
+			size       BIGINT NOT NULL,
+			header     ` + blob + ` NOT NULL,
+			raw        ` + blob + ` NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS postmoogle_imap_messages_folder ON postmoogle_imap_messages (room_id, folder, uid)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS postmoogle_imap_messages_message_id ON postmoogle_imap_messages (room_id, folder, message_id) WHERE message_id != ''`,
+		`CREATE INDEX IF NOT EXISTS postmoogle_imap_messages_created_at ON postmoogle_imap_messages (created_at)`,
+	}
+	for _, query := range queries {
+		if _, err := s.db.Exec(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add the message, returns false if the folder has the message with the same Message-ID already
+func (s *Store) Add(ctx context.Context, msg *Message) (bool, error) {
+	if msg.Date.IsZero() {
+		msg.Date = time.Now()
+	}
+	messageID := strings.TrimSpace(msg.MessageID)
+	result, err := s.db.ExecContext(ctx,
+		`INSERT INTO postmoogle_imap_messages (room_id, folder, thread_id, message_id, created_at, size, header, raw)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT DO NOTHING`,
+		msg.RoomID.String(), msg.Folder, msg.ThreadID.String(), messageID, msg.Date.Unix(), len(msg.Raw), rawHeader(msg.Raw), msg.Raw,
+	)
+	if err != nil {
+		return false, err
+	}
+	if affected, aerr := result.RowsAffected(); aerr == nil && affected == 0 {
+		return false, nil
+	}
+	s.notify(ctx, msg.RoomID, msg.Folder)
+	return true, nil
+}
+
+// notify connected IDLE clients about the new message, without blocking
+func (s *Store) notify(ctx context.Context, roomID id.RoomID, folder string) {
+	var count uint32
+	err := s.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM postmoogle_imap_messages WHERE room_id = $1 AND folder = $2`,
+		roomID.String(), folder,
+	).Scan(&count)
+	if err != nil {
+		return
+	}
+	status := imap.NewMailboxStatus(folder, []imap.StatusItem{imap.StatusMessages})
+	status.Messages = count
+	select {
+	case s.updates <- &backend.MailboxUpdate{Update: backend.NewUpdate(roomID.String(), folder), MailboxStatus: status}:
+	default:
+	}
+}
+
+// Updates returns channel of the IDLE updates
+func (s *Store) Updates() <-chan backend.Update {
+	return s.updates
+}
+
+// List messages of the folder (without raw MIME), ordered by UID
+func (s *Store) List(ctx context.Context, roomID id.RoomID, folder string) ([]*Message, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT uid, thread_id, message_id, created_at, size FROM postmoogle_imap_messages
+		WHERE room_id = $1 AND folder = $2 ORDER BY uid`,
+		roomID.String(), folder,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []*Message{}
+	for rows.Next() {
+		var createdAt, size int64
+		msg := &Message{RoomID: roomID, Folder: folder}
+		if err := rows.Scan(&msg.UID, &msg.ThreadID, &msg.MessageID, &createdAt, &size); err != nil {
+			return nil, err
+		}
+		msg.Date = time.Unix(createdAt, 0)
+		msg.Size = uint32(size) //nolint:gosec // email size is limited by POSTMOOGLE_MAXSIZE
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// Raw returns raw MIME of the room's message
+func (s *Store) Raw(ctx context.Context, roomID id.RoomID, uid uint32) ([]byte, error) {
+	var raw []byte
+	err := s.db.QueryRowContext(ctx,
+		`SELECT raw FROM postmoogle_imap_messages WHERE uid = $1 AND room_id = $2`,
+		uid, roomID.String(),
+	).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return raw, err
+}
+
+// Remove all messages of the room (e.g., the mailbox has been deleted), returns amount of removed messages
+func (s *Store) Remove(ctx context.Context, roomID id.RoomID) (int64, error) {
+	return s.remove(ctx, `room_id = $1`, roomID.String())
+}
+
+// Prune removes messages added before the cutoff time, returns amount of removed messages
+func (s *Store) Prune(ctx context.Context, cutoff time.Time) (int64, error) {
+	return s.remove(ctx, `created_at < $1`, cutoff.Unix())
+}
+
+// remove deletes messages matching the condition and sends EXPUNGE of them to the connected clients,
+// so their sequence numbers don't shift silently
+func (s *Store) remove(ctx context.Context, condition string, args ...any) (int64, error) {
+	expunged, err := s.expunged(ctx, condition, args...)
+	if err != nil {
+		return 0, err
+	}
+	result, err := s.db.ExecContext(ctx, `DELETE FROM postmoogle_imap_messages WHERE `+condition, args...)
+	if err != nil {
+		return 0, err
+	}
+	for mailbox, seqNums := range expunged {
+		// descending order, so each sequence number is still valid when the client applies it
+		for i := len(seqNums) - 1; i >= 0; i-- {
+			select {
+			case s.updates <- &backend.ExpungeUpdate{Update: backend.NewUpdate(mailbox.roomID, mailbox.folder), SeqNum: seqNums[i]}:
+			case <-ctx.Done():
+				return result.RowsAffected()
+			}
+		}
+	}
+	return result.RowsAffected()
+}
+
+// expungedMailbox is a folder of the room affected by removal
+type expungedMailbox struct {
+	roomID string
+	folder string
+}
+
+// expunged returns ascending sequence numbers of the messages matching the condition, grouped by mailbox
+func (s *Store) expunged(ctx context.Context, condition string, args ...any) (map[expungedMailbox][]uint32, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT room_id, folder, CASE WHEN `+condition+` THEN 1 ELSE 0 END FROM postmoogle_imap_messages
+		WHERE (room_id, folder) IN (SELECT room_id, folder FROM postmoogle_imap_messages WHERE `+condition+`)
+		ORDER BY room_id, folder, uid`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	expunged := map[expungedMailbox][]uint32{}
+	var current expungedMailbox
+	var seqNum uint32
+	for rows.Next() {
+		var mailbox expungedMailbox
+		var matched int
+		if err := rows.Scan(&mailbox.roomID, &mailbox.folder, &matched); err != nil {
+			return nil, err
+		}
+		if mailbox != current {
+			current, seqNum = mailbox, 0
+		}
+		seqNum++
+		if matched == 1 {
+			expunged[mailbox] = append(expunged[mailbox], seqNum)
+		}
+	}
+	return expunged, rows.Err()
+}
+
+// Headers returns raw MIME headers of the folder's messages by UID, so the headers can be searched without loading the whole messages
+func (s *Store) Headers(ctx context.Context, roomID id.RoomID, folder string) (map[uint32][]byte, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT uid, header FROM postmoogle_imap_messages WHERE room_id = $1 AND folder = $2`,
+		roomID.String(), folder,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	headers := map[uint32][]byte{}
+	for rows.Next() {
+		var uid uint32
+		var header []byte
+		if err := rows.Scan(&uid, &header); err != nil {
+			return nil, err
+		}
+		headers[uid] = header
+	}
+	return headers, rows.Err()
+}
+
+// UIDNext returns the next UID, UIDs are unique across all folders.
+// The value is read from the UID sequence, so it never goes backwards when the newest messages are removed
+func (s *Store) UIDNext(ctx context.Context) (uint32, error) {
+	query := `SELECT seq FROM sqlite_sequence WHERE name = 'postmoogle_imap_messages'`
+	if s.dialect == "postgres" {
+		query = `SELECT last_value FROM pg_sequences WHERE sequencename = 'postmoogle_imap_messages_uid_seq'`
+	}
+	var uid sql.NullInt64
+	err := s.db.QueryRowContext(ctx, query).Scan(&uid)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	return uint32(uid.Int64) + 1, nil //nolint:gosec // IMAP UIDs are 32-bit
+}
+
+// rawHeader returns the header section of the raw MIME message, including the blank line separating it from the body
+func rawHeader(raw []byte) []byte {
+	end := len(raw)
+	for _, separator := range [][]byte{[]byte("\r\n\r\n"), []byte("\n\n")} {
+		if idx := bytes.Index(raw, separator); idx >= 0 && idx+len(separator) < end {
+			end = idx + len(separator)
+		}
+	}
+	return raw[:end]
+}
@@ -0,0 +1,183 @@
+package imap
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/backend"
+	"maunium.net/go/mautrix/id"
+	_ "modernc.org/sqlite"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	store, err := NewStore(db, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+
+	added, err := store.Add(ctx, &Message{RoomID: "!room:example.com", Folder: FolderInbox, ThreadID: "$thread", MessageID: "<1@example.com>", Raw: []byte("raw 1")})
+	if err != nil || !added {
+		t.Fatalf("message is not added: %v", err)
+	}
+	added, err = store.Add(ctx, &Message{RoomID: "!room:example.com", Folder: FolderInbox, MessageID: "<1@example.com>", Raw: []byte("raw 1")})
+	if err != nil || added {
+		t.Fatalf("duplicate message is added: %v", err)
+	}
+	// the same message in another folder or mailbox is not a duplicate
+	for _, msg := range []*Message{
+		{RoomID: "!room:example.com", Folder: FolderSent, MessageID: "<1@example.com>", Raw: []byte("raw 1")},
+		{RoomID: "!other:example.com", Folder: FolderInbox, MessageID: "<1@example.com>", Raw: []byte("raw 1")},
+		{RoomID: "!room:example.com", Folder: FolderInbox, Raw: []byte("raw 2")},
+		{RoomID: "!room:example.com", Folder: FolderInbox, Raw: []byte("raw 3")},
+	} {
+		if added, err = store.Add(ctx, msg); err != nil || !added {
+			t.Fatalf("message %+v is not added: %v", msg, err)
+		}
+	}
+
+	messages, err := store.List(ctx, "!room:example.com", FolderInbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(messages))
+	}
+	if messages[0].UID != 1 || messages[0].ThreadID != "$thread" || messages[0].MessageID != "<1@example.com>" || messages[0].Size != 5 {
+		t.Errorf("unexpected message: %+v", messages[0])
+	}
+	if messages[1].UID <= messages[0].UID || messages[2].UID <= messages[1].UID {
+		t.Error("messages are not ordered by UID")
+	}
+
+	raw, err := store.Raw(ctx, "!room:example.com", messages[2].UID)
+	if err != nil || string(raw) != "raw 3" {
+		t.Errorf("unexpected raw: %q (%v)", raw, err)
+	}
+	raw, err = store.Raw(ctx, "!other:example.com", messages[2].UID)
+	if err != nil || raw != nil {
+		t.Errorf("raw of another mailbox is returned: %q (%v)", raw, err)
+	}
+
+	uidNext, err := store.UIDNext(ctx)
+	if err != nil || uidNext != messages[2].UID+1 {
+		t.Errorf("expected UIDNEXT %d, got %d (%v)", messages[2].UID+1, uidNext, err)
+	}
+	if len(store.Updates()) != 5 {
+		t.Errorf("expected 5 updates, got %d", len(store.Updates()))
+	}
+}
+
+func TestStoreRemovePrune(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	now := time.Now()
+	for _, msg := range []*Message{
+		{RoomID: "!room:example.com", Folder: FolderInbox, Date: now.AddDate(0, 0, -40), Raw: []byte("old")},
+		{RoomID: "!room:example.com", Folder: FolderSent, Date: now.AddDate(0, 0, -10), Raw: []byte("recent")},
+		{RoomID: "!other:example.com", Folder: FolderInbox, Date: now.AddDate(0, 0, -40), Raw: []byte("other old")},
+		{RoomID: "!other:example.com", Folder: FolderInbox, Date: now, Raw: []byte("other new")},
+		// the newest UID, removed with the mailbox
+		{RoomID: "!room:example.com", Folder: FolderInbox, Date: now, Raw: []byte("new")},
+	} {
+		if _, err := store.Add(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for len(store.Updates()) > 0 {
+		<-store.Updates()
+	}
+	uidNext, err := store.UIDNext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := store.Prune(ctx, now.AddDate(0, 0, -30))
+	if err != nil || removed != 2 {
+		t.Errorf("expected 2 pruned messages, got %d (%v)", removed, err)
+	}
+	removed, err = store.Remove(ctx, "!room:example.com")
+	if err != nil || removed != 2 {
+		t.Errorf("expected 2 removed messages, got %d (%v)", removed, err)
+	}
+	if actual, err := store.UIDNext(ctx); err != nil || actual != uidNext {
+		t.Errorf("UIDNEXT went from %d to %d after removal (%v)", uidNext, actual, err)
+	}
+
+	// the old inbox messages are first in their folders, the rest shift to the sequence number 1 after pruning
+	expected := map[string]int{
+		"!room:example.com INBOX 1":  2,
+		"!room:example.com Sent 1":   1,
+		"!other:example.com INBOX 1": 1,
+	}
+	expunged := map[string]int{}
+	for len(store.Updates()) > 0 {
+		update, ok := (<-store.Updates()).(*backend.ExpungeUpdate)
+		if !ok {
+			t.Fatalf("unexpected update: %+v", update)
+		}
+		expunged[fmt.Sprintf("%s %s %d", update.Username(), update.Mailbox(), update.SeqNum)]++
+	}
+	if !reflect.DeepEqual(expected, expunged) {
+		t.Error(expected, "!=", expunged)
+	}
+
+	tests := map[string]struct {
+		roomID   id.RoomID
+		folder   string
+		expected int
+	}{
+		"removed inbox": {"!room:example.com", FolderInbox, 0},
+		"removed sent":  {"!room:example.com", FolderSent, 0},
+		"other mailbox": {"!other:example.com", FolderInbox, 1},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			messages, err := store.List(ctx, test.roomID, test.folder)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(messages) != test.expected {
+				t.Error(test.expected, "!=", len(messages))
+			}
+		})
+	}
+}
+
+func TestRawHeader(t *testing.T) {
+	tests := map[string]struct {
+		raw      string
+		expected string
+	}{
+		"crlf":         {"Subject: test\r\nFrom: a@example.com\r\n\r\nbody\r\n\r\nmore", "Subject: test\r\nFrom: a@example.com\r\n\r\n"},
+		"lf":           {"Subject: test\n\nbody\r\n\r\nmore", "Subject: test\n\n"},
+		"no body":      {"Subject: test\r\n", "Subject: test\r\n"},
+		"empty header": {"\r\n\r\nbody", "\r\n\r\n"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if actual := string(rawHeader([]byte(test.raw))); actual != test.expected {
+				t.Errorf("%q != %q", test.expected, actual)
+			}
+		})
+	}
+}
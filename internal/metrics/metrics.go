@@ -0,0 +1,138 @@
+// Package metrics collects prometheus metrics of postmoogle
+package metrics
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// SMTP rejection reasons
+const (
+	// ReasonBanned - the host is banned (already, or during the session)
+	ReasonBanned = "banned"
+	// ReasonAuth - authentication failed or required
+	ReasonAuth = "auth"
+	// ReasonGreylisted - the host is greylisted (by the greylist itself, or by the spam score)
+	ReasonGreylisted = "greylisted"
+	// ReasonRBL - the host is listed in DNS blocklists
+	ReasonRBL = "rbl"
+	// ReasonSpamlist - the sender matches the spamlist
+	ReasonSpamlist = "spamlist"
+	// ReasonMX - the sender domain has no MX records
+	ReasonMX = "mx"
+	// ReasonSPF - SPF check of the sender failed
+	ReasonSPF = "spf"
+	// ReasonSMTP - the sender address doesn't exist according to its SMTP server
+	ReasonSMTP = "smtp"
+	// ReasonSender - the sender address is invalid
+	ReasonSender = "sender"
+	// ReasonDKIM - DKIM verification failed
+	ReasonDKIM = "dkim"
+	// ReasonDMARC - DMARC check failed and the sender domain's policy is reject
+	ReasonDMARC = "dmarc"
+	// ReasonSpam - content scanner score reached the reject threshold
+	ReasonSpam = "spam"
+	// ReasonVirus - the email contains infected files
+	ReasonVirus = "virus"
+	// ReasonAntivirus - the attachments cannot be scanned, the email is rejected temporarily
+	ReasonAntivirus = "antivirus"
+	// ReasonTooLarge - the attachments exceed the antivirus size limit, the email is rejected permanently
+	ReasonTooLarge = "toolarge"
+	// ReasonNoUser - no such mailbox
+	ReasonNoUser = "nouser"
+)
+
+// Outbound send results
+const (
+	// SendSent - the email has been sent
+	SendSent = "sent"
+	// SendFailed - the send attempt failed
+	SendFailed = "failed"
+	// SendQueued - the email has been queued for re-delivery
+	SendQueued = "queued"
+	// SendExpired - the email has been removed from the queue after the max retries
+	SendExpired = "expired"
+)
+
+// Webhook delivery results
+const (
+	// WebhookDelivered - the webhook has been delivered
+	WebhookDelivered = "delivered"
+	// WebhookFailed - the webhook cannot be delivered (permanently, or after the max retries)
+	WebhookFailed = "failed"
+	// WebhookDropped - the webhook has been dropped, because the queue is full or the sender is stopped
+	WebhookDropped = "dropped"
+)
+
+// Matrix operations
+const (
+	// MatrixSend - sending an event
+	MatrixSend = "send"
+	// MatrixUpload - uploading a file
+	MatrixUpload = "upload"
+)
+
+var (
+	smtpAccepted = metrics.NewCounter("postmoogle_smtp_connections_accepted_total")
+	queueDepth   = metrics.NewGauge("postmoogle_queue_depth", nil)
+	queueAge     = metrics.NewGauge("postmoogle_queue_oldest_age_seconds", nil)
+
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+// label formats the label as `name="value"`
+func label(name, value string) string {
+	return name + `="` + labelEscaper.Replace(value) + `"`
+}
+
+// Handler returns HTTP handler of the /metrics endpoint
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		metrics.WritePrometheus(w, true)
+	})
+}
+
+// SMTPAccepted counts accepted SMTP connection
+func SMTPAccepted() {
+	smtpAccepted.Inc()
+}
+
+// SMTPRejected counts SMTP rejection by reason
+func SMTPRejected(reason string) {
+	metrics.GetOrCreateCounter("postmoogle_smtp_rejected_total{" + label("reason", reason) + "}").Inc()
+}
+
+// Delivered counts email posted to a matrix room, by recipient domain
+func Delivered(domain string) {
+	metrics.GetOrCreateCounter("postmoogle_emails_delivered_total{" + label("domain", domain) + "}").Inc()
+}
+
+// Sent counts outbound send by result
+func Sent(result string) {
+	metrics.GetOrCreateCounter("postmoogle_emails_sent_total{" + label("result", result) + "}").Inc()
+}
+
+// Webhook counts webhook delivery by result
+func Webhook(result string) {
+	metrics.GetOrCreateCounter("postmoogle_webhook_deliveries_total{" + label("result", result) + "}").Inc()
+}
+
+// Queue sets queue depth and age of the oldest queued email
+func Queue(depth int, oldest time.Duration) {
+	queueDepth.Set(float64(depth))
+	queueAge.Set(oldest.Seconds())
+}
+
+// DNSBLLookup records latency of the DNSBL lookup, started at the given time
+func DNSBLLookup(zone string, start time.Time) {
+	metrics.GetOrCreatePrometheusHistogram("postmoogle_dnsbl_lookup_duration_seconds{" + label("zone", zone) + "}").UpdateDuration(start)
+}
+
+// MatrixRequest records latency of the matrix request (send or upload), started at the given time
+func MatrixRequest(op string, start time.Time) {
+	metrics.GetOrCreatePrometheusHistogram("postmoogle_matrix_request_duration_seconds{" + label("op", op) + "}").UpdateDuration(start)
+}
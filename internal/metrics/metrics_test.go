@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func scrape(t *testing.T) string {
+	t.Helper()
+	ts := httptest.NewServer(Handler())
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestHandler(t *testing.T) {
+	SMTPAccepted()
+	SMTPRejected(ReasonGreylisted)
+	SMTPRejected(ReasonGreylisted)
+	Delivered("example.com")
+	Sent(SendQueued)
+	Queue(3, 90*time.Second)
+	DNSBLLookup("zen.spamhaus.org", time.Now().Add(-50*time.Millisecond))
+	MatrixRequest(MatrixUpload, time.Now())
+
+	body := scrape(t)
+	expected := []string{
+		"postmoogle_smtp_connections_accepted_total 1",
+		`postmoogle_smtp_rejected_total{reason="greylisted"} 2`,
+		`postmoogle_emails_delivered_total{domain="example.com"} 1`,
+		`postmoogle_emails_sent_total{result="queued"} 1`,
+		"postmoogle_queue_depth 3",
+		"postmoogle_queue_oldest_age_seconds 90",
+		`postmoogle_dnsbl_lookup_duration_seconds_bucket{zone="zen.spamhaus.org",le="0.1"} 1`,
+		`postmoogle_matrix_request_duration_seconds_count{op="upload"} 1`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metric %q not found", line)
+		}
+	}
+}
+
+func TestLabel(t *testing.T) {
+	if got := label("domain", `ex"am\ple`); got != `domain="ex\"am\\ple"` {
+		t.Errorf("label is not escaped: %s", got)
+	}
+}
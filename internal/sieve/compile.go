@@ -0,0 +1,469 @@
+package sieve
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// supported extensions, see RFC 5228 section 3.2
+var extensions = []string{ExtFileinto, ExtReject, ExtEnvelope, ExtBody, ExtVacation, ExtCopy}
+
+// extension required by commands and tests
+var requiredExtensions = map[string]string{
+	"fileinto": ExtFileinto,
+	"reject":   ExtReject,
+	"envelope": ExtEnvelope,
+	"body":     ExtBody,
+	"vacation": ExtVacation,
+}
+
+const (
+	comparatorCasemap = "i;ascii-casemap"
+	comparatorOctet   = "i;octet"
+
+	matchIs       = "is"
+	matchContains = "contains"
+	matchMatches  = "matches"
+
+	partAll       = "all"
+	partLocalpart = "localpart"
+	partDomain    = "domain"
+
+	transformText    = "text"
+	transformRaw     = "raw"
+	transformContent = "content"
+)
+
+type matcher struct {
+	comparator   string
+	matchType    string
+	addressPart  string
+	transform    string
+	contentTypes []string
+	keys         []string
+	patterns     []*regexp.Regexp
+}
+
+type test struct {
+	name string
+	matcher
+	// headers are header names, or envelope parts
+	headers []string
+	tests   []*test
+	over    bool
+	limit   int
+}
+
+type branch struct {
+	test  *test // nil for else
+	block []*command
+}
+
+type command struct {
+	name     string
+	branches []branch // if, elsif, else
+	target   string   // fileinto, redirect
+	copy     bool     // fileinto, redirect
+	reason   string   // reject
+	vacation *Vacation
+}
+
+type compiler struct {
+	required []string
+}
+
+func (c *compiler) require(n *node) error {
+	ext, ok := requiredExtensions[n.name]
+	if !ok || slices.Contains(c.required, ext) {
+		return nil
+	}
+	return errorf(n.line, "`%s` requires `require \"%s\";`", n.name, ext)
+}
+
+// commands validates and compiles commands of the block
+//
+//nolint:gocognit // that's a compiler
+func (c *compiler) commands(nodes []*node, top bool) ([]*command, error) {
+	commands := []*command{}
+	requireAllowed := top
+	for _, n := range nodes {
+		if n.name != "require" {
+			requireAllowed = false
+		}
+		if n.name != "if" && n.name != "elsif" && n.name != "else" && n.hasBlock {
+			return nil, errorf(n.line, "`%s` cannot have a block", n.name)
+		}
+		if n.name != "if" && n.name != "elsif" && len(n.tests) > 0 {
+			return nil, errorf(n.line, "`%s` does not accept tests", n.name)
+		}
+
+		switch n.name {
+		case "require":
+			if !requireAllowed {
+				return nil, errorf(n.line, "`require` must be at the beginning of the script")
+			}
+			exts, err := stringsArg(n, "extensions")
+			if err != nil {
+				return nil, err
+			}
+			for _, ext := range exts {
+				if !slices.Contains(extensions, ext) {
+					return nil, errorf(n.line, "unsupported extension %q", ext)
+				}
+				c.required = append(c.required, ext)
+			}
+		case "if", "elsif", "else":
+			cmd, err := c.conditional(n, commands)
+			if err != nil {
+				return nil, err
+			}
+			if cmd != nil {
+				commands = append(commands, cmd)
+			}
+		case "stop", "keep", "discard":
+			if len(n.args) > 0 {
+				return nil, errorf(n.line, "`%s` does not accept arguments", n.name)
+			}
+			commands = append(commands, &command{name: n.name})
+		case "fileinto", "redirect":
+			if err := c.require(n); err != nil {
+				return nil, err
+			}
+			cmd, err := c.delivery(n)
+			if err != nil {
+				return nil, err
+			}
+			commands = append(commands, cmd)
+		case "reject":
+			if err := c.require(n); err != nil {
+				return nil, err
+			}
+			reason, err := stringArg(n, "reason")
+			if err != nil {
+				return nil, err
+			}
+			commands = append(commands, &command{name: n.name, reason: reason})
+		case "vacation":
+			if err := c.require(n); err != nil {
+				return nil, err
+			}
+			vacation, err := c.vacation(n)
+			if err != nil {
+				return nil, err
+			}
+			commands = append(commands, &command{name: n.name, vacation: vacation})
+		default:
+			return nil, errorf(n.line, "unknown command `%s`", n.name)
+		}
+	}
+	return commands, nil
+}
+
+// conditional compiles if/elsif/else, elsif and else are attached to the previous if command
+func (c *compiler) conditional(n *node, commands []*command) (*command, error) {
+	if !n.hasBlock {
+		return nil, errorf(n.line, "`%s` requires a block", n.name)
+	}
+	if len(n.args) > 0 {
+		return nil, errorf(n.line, "`%s` does not accept arguments", n.name)
+	}
+	var current branch
+	if n.name == "else" {
+		if len(n.tests) > 0 {
+			return nil, errorf(n.line, "`else` does not accept tests")
+		}
+	} else {
+		if len(n.tests) != 1 {
+			return nil, errorf(n.line, "`%s` requires a single test", n.name)
+		}
+		t, err := c.test(n.tests[0])
+		if err != nil {
+			return nil, err
+		}
+		current.test = t
+	}
+	block, err := c.commands(n.block, false)
+	if err != nil {
+		return nil, err
+	}
+	current.block = block
+
+	if n.name == "if" {
+		return &command{name: n.name, branches: []branch{current}}, nil
+	}
+	var prev *command
+	if len(commands) > 0 {
+		prev = commands[len(commands)-1]
+	}
+	if prev == nil || prev.name != "if" || prev.branches[len(prev.branches)-1].test == nil {
+		return nil, errorf(n.line, "`%s` without `if`", n.name)
+	}
+	prev.branches = append(prev.branches, current)
+	return nil, nil
+}
+
+func (c *compiler) delivery(n *node) (*command, error) {
+	cmd := &command{name: n.name}
+	args := []argument{}
+	for _, arg := range n.args {
+		if !arg.isTag() {
+			args = append(args, arg)
+			continue
+		}
+		if arg.tag != "copy" || !slices.Contains(c.required, ExtCopy) {
+			return nil, errorf(arg.line, "unexpected tag `:%s` of `%s`", arg.tag, n.name)
+		}
+		cmd.copy = true
+	}
+	if len(args) != 1 || !args[0].isStrings() || len(args[0].strings) != 1 || strings.TrimSpace(args[0].strings[0]) == "" {
+		return nil, errorf(n.line, "`%s` requires a single non-empty string", n.name)
+	}
+	cmd.target = strings.TrimSpace(args[0].strings[0])
+	return cmd, nil
+}
+
+//nolint:gocognit // that's a lot of tags
+func (c *compiler) vacation(n *node) (*Vacation, error) {
+	vacation := &Vacation{Days: VacationDaysDefault}
+	var reason []string
+	for i := 0; i < len(n.args); i++ {
+		arg := n.args[i]
+		if !arg.isTag() {
+			if reason != nil || !arg.isStrings() || len(arg.strings) != 1 {
+				return nil, errorf(arg.line, "`vacation` requires a single reason string")
+			}
+			reason = arg.strings
+			continue
+		}
+		switch arg.tag {
+		case "mime":
+			return nil, errorf(arg.line, "`:mime` of `vacation` is not supported")
+		case "days", "addresses", "subject", "from", "handle":
+		default:
+			return nil, errorf(arg.line, "unexpected tag `:%s` of `vacation`", arg.tag)
+		}
+		if i+1 >= len(n.args) || n.args[i+1].isTag() {
+			return nil, errorf(arg.line, "`:%s` of `vacation` requires a value", arg.tag)
+		}
+		i++
+		value := n.args[i]
+		switch arg.tag {
+		case "days":
+			if !value.isNum {
+				return nil, errorf(value.line, "`:days` of `vacation` requires a number")
+			}
+			vacation.Days = min(max(value.num, VacationDaysMin), VacationDaysMax)
+		case "addresses":
+			if !value.isStrings() {
+				return nil, errorf(value.line, "`:addresses` of `vacation` requires a string list")
+			}
+			vacation.Addresses = value.strings
+		default:
+			if !value.isStrings() || len(value.strings) != 1 {
+				return nil, errorf(value.line, "`:%s` of `vacation` requires a string", arg.tag)
+			}
+			switch arg.tag {
+			case "subject":
+				vacation.Subject = value.strings[0]
+			case "from":
+				vacation.From = value.strings[0]
+			case "handle":
+				vacation.Handle = value.strings[0]
+			}
+		}
+	}
+	if reason == nil {
+		return nil, errorf(n.line, "`vacation` requires a reason")
+	}
+	vacation.Reason = reason[0]
+	return vacation, nil
+}
+
+//nolint:gocognit // that's a lot of tests
+func (c *compiler) test(n *node) (*test, error) {
+	if err := c.require(n); err != nil {
+		return nil, err
+	}
+	t := &test{name: n.name}
+	switch n.name {
+	case "true", "false":
+		if len(n.args) > 0 || len(n.tests) > 0 {
+			return nil, errorf(n.line, "`%s` does not accept arguments", n.name)
+		}
+	case "not", "anyof", "allof":
+		if len(n.args) > 0 {
+			return nil, errorf(n.line, "`%s` does not accept arguments", n.name)
+		}
+		if len(n.tests) == 0 || (n.name == "not" && len(n.tests) != 1) {
+			return nil, errorf(n.line, "`%s` requires tests", n.name)
+		}
+		for _, sub := range n.tests {
+			subtest, err := c.test(sub)
+			if err != nil {
+				return nil, err
+			}
+			t.tests = append(t.tests, subtest)
+		}
+		return t, nil
+	case "exists":
+		headers, err := stringsArg(n, "header names")
+		if err != nil {
+			return nil, err
+		}
+		t.headers = headers
+	case "size":
+		if len(n.args) != 2 || !n.args[0].isTag() || !n.args[1].isNum || (n.args[0].tag != "over" && n.args[0].tag != "under") {
+			return nil, errorf(n.line, "`size` requires `:over` or `:under` and a number")
+		}
+		t.over = n.args[0].tag == "over"
+		t.limit = n.args[1].num
+	case "header", "address", "envelope", "body":
+		args, err := c.matcher(n, &t.matcher)
+		if err != nil {
+			return nil, err
+		}
+		expected := 2
+		if n.name == "body" {
+			expected = 1
+		}
+		if len(args) != expected || !args[0].isStrings() || !args[len(args)-1].isStrings() {
+			if n.name == "body" {
+				return nil, errorf(n.line, "`body` requires a key list")
+			}
+			return nil, errorf(n.line, "`%s` requires a header list and a key list", n.name)
+		}
+		if n.name != "body" {
+			t.headers = args[0].strings
+		}
+		t.keys = args[len(args)-1].strings
+		if n.name == "envelope" {
+			for _, part := range t.headers {
+				if part = strings.ToLower(part); part != "from" && part != "to" {
+					return nil, errorf(n.line, "unsupported envelope part %q", part)
+				}
+			}
+		}
+		t.compile()
+	default:
+		return nil, errorf(n.line, "unknown test `%s`", n.name)
+	}
+	if len(n.tests) > 0 {
+		return nil, errorf(n.line, "`%s` does not accept tests", n.name)
+	}
+	return t, nil
+}
+
+// matcher parses comparator, match type, address part and body transform tags, returns positional arguments
+//
+//nolint:gocognit // that's a lot of tags
+func (c *compiler) matcher(n *node, m *matcher) ([]argument, error) {
+	m.comparator = comparatorCasemap
+	args := []argument{}
+	for i := 0; i < len(n.args); i++ {
+		arg := n.args[i]
+		if !arg.isTag() {
+			args = append(args, arg)
+			continue
+		}
+		switch arg.tag {
+		case "comparator":
+			if i+1 >= len(n.args) || !n.args[i+1].isStrings() || len(n.args[i+1].strings) != 1 {
+				return nil, errorf(arg.line, "`:comparator` requires a string")
+			}
+			i++
+			m.comparator = n.args[i].strings[0]
+			if m.comparator != comparatorCasemap && m.comparator != comparatorOctet {
+				return nil, errorf(arg.line, "unsupported comparator %q", m.comparator)
+			}
+		case matchIs, matchContains, matchMatches:
+			if m.matchType != "" {
+				return nil, errorf(arg.line, "multiple match types of `%s`", n.name)
+			}
+			m.matchType = arg.tag
+		case partAll, partLocalpart, partDomain:
+			if n.name != "address" && n.name != "envelope" {
+				return nil, errorf(arg.line, "unexpected tag `:%s` of `%s`", arg.tag, n.name)
+			}
+			if m.addressPart != "" {
+				return nil, errorf(arg.line, "multiple address parts of `%s`", n.name)
+			}
+			m.addressPart = arg.tag
+		case transformText, transformRaw, transformContent:
+			if n.name != "body" {
+				return nil, errorf(arg.line, "unexpected tag `:%s` of `%s`", arg.tag, n.name)
+			}
+			if m.transform != "" {
+				return nil, errorf(arg.line, "multiple body transforms")
+			}
+			m.transform = arg.tag
+			if arg.tag == transformContent {
+				if i+1 >= len(n.args) || !n.args[i+1].isStrings() {
+					return nil, errorf(arg.line, "`:content` requires a content type list")
+				}
+				i++
+				m.contentTypes = n.args[i].strings
+			}
+		default:
+			return nil, errorf(arg.line, "unexpected tag `:%s` of `%s`", arg.tag, n.name)
+		}
+	}
+	if m.matchType == "" {
+		m.matchType = matchIs
+	}
+	if m.addressPart == "" {
+		m.addressPart = partAll
+	}
+	if m.transform == "" {
+		m.transform = transformText
+	}
+	return args, nil
+}
+
+// compile converts `:matches` wildcards to regular expressions
+func (m *matcher) compile() {
+	if m.matchType != matchMatches {
+		return
+	}
+	m.patterns = make([]*regexp.Regexp, 0, len(m.keys))
+	for _, key := range m.keys {
+		var expr strings.Builder
+		expr.WriteString("(?s)^")
+		escaped := false
+		for _, r := range m.fold(key) {
+			switch {
+			case escaped:
+				expr.WriteString(regexp.QuoteMeta(string(r)))
+				escaped = false
+			case r == '\\':
+				escaped = true
+			case r == '*':
+				expr.WriteString(".*")
+			case r == '?':
+				expr.WriteString(".")
+			default:
+				expr.WriteString(regexp.QuoteMeta(string(r)))
+			}
+		}
+		expr.WriteString("$")
+		m.patterns = append(m.patterns, regexp.MustCompile(expr.String()))
+	}
+}
+
+func stringsArg(n *node, name string) ([]string, error) {
+	if len(n.args) != 1 || !n.args[0].isStrings() {
+		return nil, errorf(n.line, "`%s` requires %s", n.name, name)
+	}
+	return n.args[0].strings, nil
+}
+
+func stringArg(n *node, name string) (string, error) {
+	list, err := stringsArg(n, "a "+name)
+	if err != nil {
+		return "", err
+	}
+	if len(list) != 1 {
+		return "", errorf(n.line, "`%s` requires a single %s", n.name, name)
+	}
+	return list[0], nil
+}
@@ -0,0 +1,181 @@
+package sieve
+
+import (
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdentifier
+	tokenTag
+	tokenNumber
+	tokenString
+	tokenPunct
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	num   int
+	line  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of script"
+	case tokenTag:
+		return "`:" + t.value + "`"
+	case tokenString:
+		return "string " + strconv.Quote(t.value)
+	default:
+		return "`" + t.value + "`"
+	}
+}
+
+// lex splits the script into tokens, see RFC 5228 section 8.1
+//
+//nolint:gocognit // that's a lexer
+func lex(src string) ([]token, error) {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	tokens := []token{}
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end == -1 {
+				return nil, errorf(line, "unterminated comment")
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+		case strings.ContainsRune("[](),;{}", rune(c)):
+			tokens = append(tokens, token{kind: tokenPunct, value: string(c), line: line})
+			i++
+		case c == '"':
+			start := line
+			var value strings.Builder
+			i++
+			for {
+				if i >= len(src) {
+					return nil, errorf(start, "unterminated string")
+				}
+				if src[i] == '"' {
+					i++
+					break
+				}
+				if src[i] == '\\' && i+1 < len(src) {
+					i++
+				}
+				if src[i] == '\n' {
+					line++
+				}
+				value.WriteByte(src[i])
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenString, value: value.String(), line: start})
+		case c == ':':
+			name := readIdentifier(src[i+1:])
+			if name == "" {
+				return nil, errorf(line, "invalid tag")
+			}
+			tokens = append(tokens, token{kind: tokenTag, value: strings.ToLower(name), line: line})
+			i += len(name) + 1
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(src) && src[i] >= '0' && src[i] <= '9' {
+				i++
+			}
+			num, err := strconv.Atoi(src[start:i])
+			if err != nil {
+				return nil, errorf(line, "invalid number %s", src[start:i])
+			}
+			if i < len(src) {
+				switch src[i] {
+				case 'K', 'k':
+					num *= 1024
+					i++
+				case 'M', 'm':
+					num *= 1024 * 1024
+					i++
+				case 'G', 'g':
+					num *= 1024 * 1024 * 1024
+					i++
+				}
+			}
+			tokens = append(tokens, token{kind: tokenNumber, value: src[start:i], num: num, line: line})
+		default:
+			name := readIdentifier(src[i:])
+			if name == "" {
+				return nil, errorf(line, "unexpected character %q", c)
+			}
+			i += len(name)
+			if strings.EqualFold(name, "text") && i < len(src) && src[i] == ':' {
+				start := line
+				value, consumed, lines, ok := readMultiline(src[i+1:])
+				if !ok {
+					return nil, errorf(start, "unterminated multi-line string")
+				}
+				tokens = append(tokens, token{kind: tokenString, value: value, line: start})
+				i += consumed + 1
+				line += lines
+				continue
+			}
+			tokens = append(tokens, token{kind: tokenIdentifier, value: strings.ToLower(name), line: line})
+		}
+	}
+	tokens = append(tokens, token{kind: tokenEOF, line: line})
+	return tokens, nil
+}
+
+func readIdentifier(src string) string {
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9') {
+			continue
+		}
+		return src[:i]
+	}
+	return src
+}
+
+// readMultiline reads `text:` string body, terminated by a line with a single dot,
+// returns the value, amount of consumed bytes and lines
+func readMultiline(src string) (value string, consumed, lines int, ok bool) {
+	eol := strings.IndexByte(src, '\n')
+	if eol == -1 {
+		return "", 0, 0, false
+	}
+	consumed = eol + 1
+	lines = 1
+	var result strings.Builder
+	for consumed < len(src) {
+		end := strings.IndexByte(src[consumed:], '\n')
+		next := len(src)
+		if end != -1 {
+			next = consumed + end + 1
+		}
+		textLine := strings.TrimSuffix(src[consumed:next], "\n")
+		consumed = next
+		lines++
+		if textLine == "." {
+			return result.String(), consumed, lines, true
+		}
+		result.WriteString(strings.TrimPrefix(textLine, "."))
+		result.WriteString("\n")
+	}
+	return "", 0, 0, false
+}
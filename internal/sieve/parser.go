@@ -0,0 +1,213 @@
+package sieve
+
+// node is a generic command or test of the script, see RFC 5228 section 8.2
+type node struct {
+	name  string
+	line  int
+	args  []argument
+	tests []*node
+	block []*node
+	// hasBlock is true if the command is followed by a block (even an empty one)
+	hasBlock bool
+}
+
+// argument is either a tag, a number, or a string list (a single string is a list of one string)
+type argument struct {
+	tag     string
+	num     int
+	strings []string
+	isNum   bool
+	line    int
+}
+
+func (a argument) isTag() bool {
+	return a.tag != ""
+}
+
+func (a argument) isStrings() bool {
+	return !a.isTag() && !a.isNum
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isPunct(value string) bool {
+	t := p.peek()
+	return t.kind == tokenPunct && t.value == value
+}
+
+func (p *parser) expect(value string) error {
+	t := p.next()
+	if t.kind != tokenPunct || t.value != value {
+		return errorf(t.line, "expected `%s`, got %s", value, t)
+	}
+	return nil
+}
+
+// commands parses commands until the end of the script or the closing brace
+func (p *parser) commands(nested bool) ([]*node, error) {
+	commands := []*node{}
+	for {
+		t := p.peek()
+		if t.kind == tokenEOF {
+			if nested {
+				return nil, errorf(t.line, "missing `}`")
+			}
+			return commands, nil
+		}
+		if nested && p.isPunct("}") {
+			p.next()
+			return commands, nil
+		}
+		cmd, err := p.command()
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, cmd)
+	}
+}
+
+func (p *parser) command() (*node, error) {
+	t := p.next()
+	if t.kind != tokenIdentifier {
+		return nil, errorf(t.line, "expected command, got %s", t)
+	}
+	cmd := &node{name: t.value, line: t.line}
+	if err := p.arguments(cmd); err != nil {
+		return nil, err
+	}
+
+	end := p.next()
+	switch {
+	case end.kind == tokenPunct && end.value == ";":
+		return cmd, nil
+	case end.kind == tokenPunct && end.value == "{":
+		block, err := p.commands(true)
+		if err != nil {
+			return nil, err
+		}
+		cmd.block = block
+		cmd.hasBlock = true
+		return cmd, nil
+	default:
+		return nil, errorf(end.line, "expected `;` or `{` after `%s`, got %s", cmd.name, end)
+	}
+}
+
+// arguments parses arguments of the command or test, including its tests
+func (p *parser) arguments(n *node) error {
+	for {
+		t := p.peek()
+		switch {
+		case t.kind == tokenTag:
+			p.next()
+			n.args = append(n.args, argument{tag: t.value, line: t.line})
+		case t.kind == tokenNumber:
+			p.next()
+			n.args = append(n.args, argument{num: t.num, isNum: true, line: t.line})
+		case t.kind == tokenString:
+			p.next()
+			n.args = append(n.args, argument{strings: []string{t.value}, line: t.line})
+		case p.isPunct("["):
+			list, err := p.stringList()
+			if err != nil {
+				return err
+			}
+			n.args = append(n.args, argument{strings: list, line: t.line})
+		case t.kind == tokenIdentifier:
+			test, err := p.test()
+			if err != nil {
+				return err
+			}
+			n.tests = []*node{test}
+			return nil
+		case p.isPunct("("):
+			tests, err := p.testList()
+			if err != nil {
+				return err
+			}
+			n.tests = tests
+			return nil
+		default:
+			return nil
+		}
+	}
+}
+
+func (p *parser) stringList() ([]string, error) {
+	if err := p.expect("["); err != nil {
+		return nil, err
+	}
+	list := []string{}
+	for {
+		t := p.next()
+		if t.kind != tokenString {
+			return nil, errorf(t.line, "expected string, got %s", t)
+		}
+		list = append(list, t.value)
+		if p.isPunct("]") {
+			p.next()
+			return list, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) test() (*node, error) {
+	t := p.next()
+	if t.kind != tokenIdentifier {
+		return nil, errorf(t.line, "expected test, got %s", t)
+	}
+	test := &node{name: t.value, line: t.line}
+	if err := p.arguments(test); err != nil {
+		return nil, err
+	}
+	return test, nil
+}
+
+func (p *parser) testList() ([]*node, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	tests := []*node{}
+	for {
+		test, err := p.test()
+		if err != nil {
+			return nil, err
+		}
+		tests = append(tests, test)
+		if p.isPunct(")") {
+			p.next()
+			return tests, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parse parses the script into the generic tree
+func parse(src string) ([]*node, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	return p.commands(false)
+}
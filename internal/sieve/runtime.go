@@ -0,0 +1,267 @@
+package sieve
+
+import (
+	"bytes"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"slices"
+	"strings"
+)
+
+// Message is the email the script runs against
+type Message struct {
+	// MailFrom is the envelope sender (MAIL FROM)
+	MailFrom string
+	// RcptTo is the envelope recipient (RCPT TO)
+	RcptTo string
+	Header mail.Header
+	// Text and HTML are decoded body parts
+	Text string
+	HTML string
+	// Body is the raw (undecoded) body
+	Body []byte
+	Size int
+}
+
+// NewMessage constructs message from the raw MIME email and its decoded text and HTML parts
+func NewMessage(mailFrom, rcptTo string, raw []byte, text, html string) *Message {
+	msg := &Message{
+		MailFrom: mailFrom,
+		RcptTo:   rcptTo,
+		Header:   mail.Header{},
+		Text:     text,
+		HTML:     html,
+		Size:     len(raw),
+	}
+	parsed, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return msg
+	}
+	msg.Header = parsed.Header
+	if idx := bytes.Index(raw, []byte("\r\n\r\n")); idx != -1 {
+		msg.Body = raw[idx+4:]
+	} else if idx := bytes.Index(raw, []byte("\n\n")); idx != -1 {
+		msg.Body = raw[idx+2:]
+	}
+	return msg
+}
+
+var wordDecoder = &mime.WordDecoder{}
+
+// headers returns decoded values of the header
+func (m *Message) headers(name string) []string {
+	values := m.Header[textproto.CanonicalMIMEHeaderKey(name)]
+	decoded := make([]string, 0, len(values))
+	for _, value := range values {
+		if dvalue, err := wordDecoder.DecodeHeader(value); err == nil {
+			value = dvalue
+		}
+		decoded = append(decoded, strings.TrimSpace(value))
+	}
+	return decoded
+}
+
+// addresses returns addresses of the header, the raw value is used if it cannot be parsed
+func (m *Message) addresses(name string) []string {
+	addresses := []string{}
+	for _, value := range m.headers(name) {
+		list, err := mail.ParseAddressList(value)
+		if err != nil {
+			addresses = append(addresses, value)
+			continue
+		}
+		for _, addr := range list {
+			addresses = append(addresses, addr.Address)
+		}
+	}
+	return addresses
+}
+
+// envelope returns envelope part (from or to)
+func (m *Message) envelope(part string) []string {
+	if strings.EqualFold(part, "from") {
+		return []string{m.MailFrom}
+	}
+	return []string{m.RcptTo}
+}
+
+// bodies returns body parts for the body test transform
+func (m *Message) bodies(transform string, contentTypes []string) []string {
+	switch transform {
+	case transformRaw:
+		return []string{string(m.Body)}
+	case transformContent:
+		bodies := []string{}
+		for _, ctype := range contentTypes {
+			ctype = strings.ToLower(strings.TrimSpace(ctype))
+			if ctype == "" || ctype == "text" || ctype == "text/plain" {
+				bodies = append(bodies, m.Text)
+			}
+			if ctype == "" || ctype == "text" || ctype == "text/html" {
+				bodies = append(bodies, m.HTML)
+			}
+		}
+		return bodies
+	default:
+		return []string{m.Text}
+	}
+}
+
+type runtime struct {
+	msg          *Message
+	result       *Result
+	canceled     bool
+	explicitKeep bool
+	stopped      bool
+}
+
+func (r *runtime) run(commands []*command) {
+	for _, cmd := range commands {
+		if r.stopped {
+			return
+		}
+		switch cmd.name {
+		case "if":
+			for _, b := range cmd.branches {
+				if b.test == nil || r.test(b.test) {
+					r.run(b.block)
+					break
+				}
+			}
+		case "stop":
+			r.stopped = true
+		case "keep":
+			r.explicitKeep = true
+		case "discard":
+			r.canceled = true
+		case "fileinto":
+			r.canceled = r.canceled || !cmd.copy
+			if !slices.Contains(r.result.FileInto, cmd.target) {
+				r.result.FileInto = append(r.result.FileInto, cmd.target)
+			}
+		case "redirect":
+			r.canceled = r.canceled || !cmd.copy
+			if !slices.Contains(r.result.Redirect, cmd.target) {
+				r.result.Redirect = append(r.result.Redirect, cmd.target)
+			}
+		case "reject":
+			r.canceled = true
+			r.result.Reject = true
+			r.result.RejectReason = cmd.reason
+		case "vacation":
+			r.result.Vacation = cmd.vacation
+		}
+	}
+}
+
+//nolint:gocognit // that's a lot of tests
+func (r *runtime) test(t *test) bool {
+	switch t.name {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "not":
+		return !r.test(t.tests[0])
+	case "anyof":
+		return slices.ContainsFunc(t.tests, r.test)
+	case "allof":
+		for _, sub := range t.tests {
+			if !r.test(sub) {
+				return false
+			}
+		}
+		return true
+	case "exists":
+		for _, name := range t.headers {
+			if len(r.msg.headers(name)) == 0 {
+				return false
+			}
+		}
+		return true
+	case "size":
+		if t.over {
+			return r.msg.Size > t.limit
+		}
+		return r.msg.Size < t.limit
+	case "header":
+		for _, name := range t.headers {
+			if t.matchAny(r.msg.headers(name)) {
+				return true
+			}
+		}
+	case "address":
+		for _, name := range t.headers {
+			if t.matchAny(t.parts(r.msg.addresses(name))) {
+				return true
+			}
+		}
+	case "envelope":
+		for _, part := range t.headers {
+			if t.matchAny(t.parts(r.msg.envelope(part))) {
+				return true
+			}
+		}
+	case "body":
+		return t.matchAny(r.msg.bodies(t.transform, t.contentTypes))
+	}
+	return false
+}
+
+// parts returns the address parts
+func (m *matcher) parts(addresses []string) []string {
+	parts := make([]string, 0, len(addresses))
+	for _, addr := range addresses {
+		localpart, domain := addr, ""
+		if idx := strings.LastIndex(addr, "@"); idx != -1 {
+			localpart, domain = addr[:idx], addr[idx+1:]
+		}
+		switch m.addressPart {
+		case partLocalpart:
+			parts = append(parts, localpart)
+		case partDomain:
+			parts = append(parts, domain)
+		default:
+			parts = append(parts, addr)
+		}
+	}
+	return parts
+}
+
+// matchAny checks if any of the values matches any of the keys
+func (m *matcher) matchAny(values []string) bool {
+	for _, value := range values {
+		value = m.fold(value)
+		for i, key := range m.keys {
+			switch m.matchType {
+			case matchContains:
+				if strings.Contains(value, m.fold(key)) {
+					return true
+				}
+			case matchMatches:
+				if m.patterns[i].MatchString(value) {
+					return true
+				}
+			default:
+				if value == m.fold(key) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// fold applies the comparator to the value
+func (m *matcher) fold(value string) string {
+	if m.comparator == comparatorOctet {
+		return value
+	}
+	return strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' {
+			return r + ('a' - 'A')
+		}
+		return r
+	}, value)
+}
@@ -0,0 +1,94 @@
+// Package sieve implements a subset of RFC 5228 Sieve email filtering language
+// with fileinto, reject (RFC 5429), envelope, body (RFC 5173), vacation (RFC 5230) and copy (RFC 3894) extensions
+package sieve
+
+import (
+	"fmt"
+)
+
+// supported extensions
+const (
+	ExtFileinto = "fileinto"
+	ExtReject   = "reject"
+	ExtEnvelope = "envelope"
+	ExtBody     = "body"
+	ExtVacation = "vacation"
+	ExtCopy     = "copy"
+)
+
+// vacation days limits, see RFC 5230 section 4.1
+const (
+	VacationDaysDefault = 7
+	VacationDaysMin     = 1
+	VacationDaysMax     = 365
+)
+
+// Error is a script validation error
+type Error struct {
+	Line    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+func errorf(line int, format string, args ...any) *Error {
+	return &Error{Line: line, Message: fmt.Sprintf(format, args...)}
+}
+
+// Script is a parsed and validated Sieve script
+type Script struct {
+	commands []*command
+}
+
+// Parse parses and validates the script, returns *Error with line number if the script is invalid
+func Parse(src string) (*Script, error) {
+	nodes, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+	commands, err := (&compiler{}).commands(nodes, true)
+	if err != nil {
+		return nil, err
+	}
+	return &Script{commands: commands}, nil
+}
+
+// Vacation auto-reply, see RFC 5230
+type Vacation struct {
+	Reason    string
+	Subject   string
+	From      string
+	Addresses []string
+	Handle    string
+	Days      int
+}
+
+// Result of the script execution
+type Result struct {
+	// Keep the email in the mailbox (implicit or explicit keep)
+	Keep bool
+	// FileInto targets: mailboxes or tags
+	FileInto []string
+	// Redirect email addresses
+	Redirect []string
+	// Reject the email
+	Reject       bool
+	RejectReason string
+	// Vacation auto-reply, if any
+	Vacation *Vacation
+}
+
+// Discarded returns true if the email should not be delivered anywhere
+func (r *Result) Discarded() bool {
+	return !r.Keep && !r.Reject && len(r.FileInto) == 0 && len(r.Redirect) == 0
+}
+
+// Execute runs the script against the message
+func (s *Script) Execute(msg *Message) *Result {
+	r := &runtime{msg: msg, result: &Result{}}
+	r.run(s.commands)
+	r.result.Keep = r.explicitKeep || !r.canceled
+	return r.result
+}
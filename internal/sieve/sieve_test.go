@@ -0,0 +1,148 @@
+package sieve
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+const testEmail = "From: \"Alice\" <Alice@Example.com>\r\n" +
+	"To: bob@example.org, carol@example.org\r\n" +
+	"Subject: =?utf-8?q?Invoice_=E2=84=9642?=\r\n" +
+	"List-Id: <news.example.com>\r\n" +
+	"\r\n" +
+	"Please, pay the invoice."
+
+func testMessage() *Message {
+	return NewMessage("bounces@lists.example.com", "bob@example.org", []byte(testEmail), "Please, pay the invoice.", "<p>Please, pay the <b>invoice</b>.</p>")
+}
+
+func TestExecute(t *testing.T) {
+	tests := map[string]struct {
+		script   string
+		keep     bool
+		fileinto []string
+		redirect []string
+		reject   string
+	}{
+		"empty":                 {script: "", keep: true},
+		"comments only":         {script: "# nothing\n/* at\nall */", keep: true},
+		"discard":               {script: "discard;", keep: false},
+		"keep after discard":    {script: "discard; keep;", keep: true},
+		"header is":             {script: `require "fileinto"; if header :is "subject" "invoice №42" { fileinto "bills"; }`, fileinto: []string{"bills"}},
+		"header contains":       {script: `require "fileinto"; if header :contains ["X-Spam", "Subject"] "INVOICE" { fileinto "bills"; }`, fileinto: []string{"bills"}},
+		"header octet":          {script: `require "fileinto"; if header :contains :comparator "i;octet" "subject" "INVOICE" { fileinto "bills"; }`, keep: true},
+		"header matches":        {script: `require "fileinto"; if header :matches "subject" "invoice*4?" { fileinto "bills"; }`, fileinto: []string{"bills"}},
+		"header matches escape": {script: `require "fileinto"; if header :matches "subject" "invoice\\*" { fileinto "bills"; }`, keep: true},
+		"address domain":        {script: `if address :domain :is "from" "example.com" { discard; }`, keep: false},
+		"address localpart":     {script: `if address :localpart "to" "carol" { discard; }`, keep: false},
+		"address all":           {script: `if address "from" "alice@example.com" { discard; }`, keep: false},
+		"envelope from":         {script: `require "envelope"; if envelope :domain :matches "from" "*.example.com" { discard; }`, keep: false},
+		"envelope to":           {script: `require "envelope"; if envelope "to" "carol@example.org" { discard; }`, keep: true},
+		"body text":             {script: `require ["body", "fileinto"]; if body :contains "pay the invoice" { fileinto "bills"; }`, fileinto: []string{"bills"}},
+		"body content html":     {script: `require ["body", "fileinto"]; if body :content "text/html" :contains "<b>" { fileinto "bills"; }`, fileinto: []string{"bills"}},
+		"body raw":              {script: `require ["body", "fileinto"]; if body :raw :contains "<b>" { fileinto "bills"; }`, keep: true},
+		"exists":                {script: `if exists ["List-Id", "From"] { discard; }`, keep: false},
+		"not exists":            {script: `if exists "X-Missing" { discard; }`, keep: true},
+		"size":                  {script: `if size :over 1K { discard; } elsif size :under 100 { discard; }`, keep: true},
+		"anyof allof not":       {script: `if anyof (false, allof (true, not header "subject" "x")) { discard; }`, keep: false},
+		"elsif else":            {script: "require \"fileinto\";\nif false { discard; }\nelsif false { discard; }\nelse { fileinto \"other\"; }", fileinto: []string{"other"}},
+		"stop":                  {script: "stop; discard;", keep: true},
+		"redirect":              {script: `redirect "carol@example.org"; redirect "carol@example.org";`, redirect: []string{"carol@example.org"}},
+		"redirect copy":         {script: `require "copy"; redirect :copy "carol@example.org";`, keep: true, redirect: []string{"carol@example.org"}},
+		"fileinto copy":         {script: `require ["fileinto", "copy"]; fileinto :copy "archive"; fileinto "bills";`, fileinto: []string{"archive", "bills"}},
+		"reject":                {script: "require \"reject\";\nreject text:\nno invoices, please\n..\n.\n;", reject: "no invoices, please\n.\n"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			script, err := Parse(test.script)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := script.Execute(testMessage())
+			if result.Keep != test.keep {
+				t.Error("keep:", test.keep, "!=", result.Keep)
+			}
+			if !slices.Equal(test.fileinto, result.FileInto) {
+				t.Error(test.fileinto, "!=", result.FileInto)
+			}
+			if !slices.Equal(test.redirect, result.Redirect) {
+				t.Error(test.redirect, "!=", result.Redirect)
+			}
+			if result.RejectReason != test.reject {
+				t.Error(test.reject, "!=", result.RejectReason)
+			}
+		})
+	}
+}
+
+func TestExecuteVacation(t *testing.T) {
+	script, err := Parse(`require "vacation";
+vacation :days 500 :subject "Out of office" :from "bob@example.org" :addresses ["bob@example.net"] :handle "ooo"
+"I'm on vacation";`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := script.Execute(testMessage())
+	if !result.Keep {
+		t.Error("vacation must not cancel implicit keep")
+	}
+	vacation := result.Vacation
+	if vacation == nil {
+		t.Fatal("vacation is nil")
+	}
+	if vacation.Days != VacationDaysMax {
+		t.Error(VacationDaysMax, "!=", vacation.Days)
+	}
+	if vacation.Subject != "Out of office" || vacation.From != "bob@example.org" || vacation.Handle != "ooo" || vacation.Reason != "I'm on vacation" {
+		t.Error("unexpected vacation", vacation)
+	}
+	if !slices.Equal([]string{"bob@example.net"}, vacation.Addresses) {
+		t.Error([]string{"bob@example.net"}, "!=", vacation.Addresses)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]struct {
+		script string
+		line   int
+	}{
+		"unknown command":        {script: "keep;\nfilein \"x\";", line: 2},
+		"missing require":        {script: "\n\nfileinto \"x\";", line: 3},
+		"unsupported extension":  {script: `require "imap4flags";`, line: 1},
+		"late require":           {script: "keep;\nrequire \"fileinto\";", line: 2},
+		"missing semicolon":      {script: "keep;\nstop", line: 2},
+		"unterminated string":    {script: "keep;\nredirect \"x;", line: 2},
+		"unterminated block":     {script: "if true {\nkeep;\n", line: 3},
+		"unterminated text":      {script: "require \"reject\";\nreject text:\nfoo\n", line: 2},
+		"unterminated comment":   {script: "keep;\n/* foo", line: 2},
+		"unknown test":           {script: "if\nheaders \"subject\" \"x\" { keep; }", line: 2},
+		"test arguments":         {script: "if header \"subject\" { keep; }", line: 1},
+		"unknown tag":            {script: "if header :regex \"subject\" \"x\" { keep; }", line: 1},
+		"copy without require":   {script: "redirect :copy \"x@example.com\";", line: 1},
+		"elsif without if":       {script: "keep;\nelsif true { keep; }", line: 2},
+		"else after else":        {script: "if true { keep; } else { keep; }\nelse { keep; }", line: 2},
+		"if without block":       {script: "if true;", line: 1},
+		"block of keep":          {script: "keep { discard; }", line: 1},
+		"comparator":             {script: "if header :comparator \"i;ascii-numeric\" \"x\" \"1\" { keep; }", line: 1},
+		"envelope part":          {script: "require \"envelope\";\nif envelope \"subject\" \"x\" { keep; }", line: 2},
+		"vacation mime":          {script: "require \"vacation\";\nvacation :mime \"x\";", line: 2},
+		"vacation reason":        {script: "require \"vacation\";\nvacation :days 3;", line: 2},
+		"size":                   {script: "if size 100 { keep; }", line: 1},
+		"address part of header": {script: "if header :domain \"from\" \"x\" { keep; }", line: 1},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse(test.script)
+			var serr *Error
+			if !errors.As(err, &serr) {
+				t.Fatal("expected *Error, got", err)
+			}
+			if serr.Line != test.line {
+				t.Error(test.line, "!=", serr.Line, err)
+			}
+		})
+	}
+}
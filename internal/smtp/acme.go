@@ -0,0 +1,181 @@
+package smtp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACMEConfig is a configuration of the built-in ACME client
+type ACMEConfig struct {
+	// Enabled toggles automatic certificates
+	Enabled bool
+	// Email of the ACME account
+	Email string
+	// DirectoryURL of the ACME server, Let's Encrypt by default
+	DirectoryURL string
+	// Cache is a path to a directory where certificates and account keys are stored
+	Cache string
+	// HTTPPort for HTTP-01 challenges (empty = disabled)
+	HTTPPort string
+	// TLSPort for TLS-ALPN-01 challenges (empty = disabled)
+	TLSPort string
+}
+
+// ErrACMEHost returned when the certificate is requested for a domain that is not served
+var ErrACMEHost = errors.New("acme: host is not one of the served domains")
+
+// acmeManager issues and renews certificates for all domains
+type acmeManager struct {
+	cfg     *ACMEConfig
+	domains *domainList
+	m       *autocert.Manager
+	http    *http.Server
+	alpn    net.Listener
+}
+
+func newACMEManager(cfg *ACMEConfig, domains *domainList) *acmeManager {
+	client := &acme.Client{DirectoryURL: cfg.DirectoryURL}
+	if client.DirectoryURL == "" {
+		client.DirectoryURL = autocert.DefaultACMEDirectory
+	}
+
+	a := &acmeManager{
+		cfg:     cfg,
+		domains: domains,
+	}
+	a.m = &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cfg.Cache),
+		HostPolicy: a.hostPolicy,
+		Email:      cfg.Email,
+		Client:     client,
+	}
+	return a
+}
+
+// hostPolicy allows certificates of the served domains only, the domains may be changed at runtime
+func (a *acmeManager) hostPolicy(_ context.Context, host string) error {
+	if !a.domains.has(strings.ToLower(host)) {
+		return fmt.Errorf("%w: %s", ErrACMEHost, host)
+	}
+	return nil
+}
+
+// GetCertificate returns certificate for the SNI (or the default domain, if SNI is not provided)
+func (a *acmeManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if domains := a.domains.get(); hello.ServerName == "" && len(domains) > 0 {
+		withSNI := *hello
+		withSNI.ServerName = domains[0]
+		return a.m.GetCertificate(&withSNI)
+	}
+	return a.m.GetCertificate(hello)
+}
+
+// start challenge servers and obtain (or load from cache) certificates for all domains
+func (a *acmeManager) start(errs chan<- error, onIssue func(domain string, err error)) {
+	if a.cfg.HTTPPort != "" {
+		a.http = &http.Server{
+			Addr:              ":" + a.cfg.HTTPPort,
+			Handler:           a.m.HTTPHandler(nil),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			if err := a.http.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errs <- err
+			}
+		}()
+	}
+
+	if a.cfg.TLSPort != "" {
+		alpn, err := tls.Listen("tcp", ":"+a.cfg.TLSPort, &tls.Config{
+			GetCertificate: a.m.GetCertificate,
+			NextProtos:     []string{acme.ALPNProto},
+		})
+		if err != nil {
+			errs <- err
+			return
+		}
+		a.alpn = alpn
+		go a.serveALPN()
+	}
+
+	go a.issue(a.domains.get(), onIssue)
+}
+
+// serveALPN completes TLS handshakes of TLS-ALPN-01 challenges, nothing else is served on that port
+func (a *acmeManager) serveALPN() {
+	for {
+		conn, err := a.alpn.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			tlsConn, ok := conn.(*tls.Conn)
+			if !ok {
+				return
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			tlsConn.HandshakeContext(ctx) //nolint:errcheck // validation server will report problems
+		}(conn)
+	}
+}
+
+// issue certificates for the domains in advance, instead of doing that during the first TLS handshake.
+// Renewal is handled by autocert automatically after that
+func (a *acmeManager) issue(domains []string, onIssue func(domain string, err error)) {
+	for _, domain := range domains {
+		_, err := a.m.GetCertificate(&tls.ClientHelloInfo{
+			ServerName:       domain,
+			CipherSuites:     []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+			SupportedCurves:  []tls.CurveID{tls.CurveP256},
+		})
+		onIssue(domain, err)
+	}
+}
+
+// expiry returns expiration time of the domain's certificate from the cache,
+// without issuing it (unlike GetCertificate)
+func (a *acmeManager) expiry(ctx context.Context, domain string) (time.Time, bool) {
+	data, err := a.m.Cache.Get(ctx, domain)
+	if err != nil {
+		return time.Time{}, false
+	}
+	// the cached item is the private key followed by the certificate chain, leaf first
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		leaf, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return leaf.NotAfter, true
+	}
+	return time.Time{}, false
+}
+
+// stop challenge servers
+func (a *acmeManager) stop() error {
+	var errs []error
+	if a.http != nil {
+		errs = append(errs, a.http.Close())
+	}
+	if a.alpn != nil {
+		errs = append(errs, a.alpn.Close())
+	}
+	return errors.Join(errs...)
+}
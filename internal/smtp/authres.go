@@ -0,0 +1,257 @@
+package smtp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"blitiri.com.ar/go/spf"
+	"github.com/emersion/go-msgauth/authres"
+	"github.com/emersion/go-msgauth/dkim"
+	"github.com/emersion/go-msgauth/dmarc"
+	"golang.org/x/net/publicsuffix"
+
+	"github.com/etkecc/postmoogle/internal/email"
+	"github.com/etkecc/postmoogle/internal/utils"
+)
+
+// AuthTimeout limits time spent on SPF and DMARC lookups of an incoming email
+const AuthTimeout = 15 * time.Second
+
+// authInput is everything known about the incoming email, required to authenticate it
+type authInput struct {
+	ip         net.IP
+	helo       string
+	mailFrom   string
+	headerFrom string
+	dkim       []*dkim.Verification
+	// spf result, if evaluated already
+	spf spf.Result
+}
+
+// authenticated returns domains authenticated by SPF (MAIL FROM domain) and DKIM (signing domains)
+func (in *authInput) authenticated() []string {
+	domains := []string{}
+	if in.spf == spf.Pass {
+		if domain := utils.Hostname(in.mailFrom); domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	for _, verification := range in.dkim {
+		if verification.Err == nil && verification.Domain != "" {
+			domains = append(domains, strings.ToLower(verification.Domain))
+		}
+	}
+	return domains
+}
+
+// authenticate evaluates SPF, DKIM and DMARC of the incoming email.
+// The resolver is used for SPF and DMARC lookups (nil = default resolver), authservID is used as authserv-id of the Authentication-Results header
+func authenticate(ctx context.Context, resolver spf.DNSResolver, authservID string, in *authInput) *email.AuthResults {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	fromDomain := utils.Hostname(in.headerFrom)
+	spfDomain := utils.Hostname(in.mailFrom)
+	if spfDomain == "" {
+		spfDomain = strings.ToLower(in.helo)
+	}
+
+	spfResult := in.spf
+	if spfResult == "" {
+		spfResult = checkSPF(ctx, resolver, in)
+	}
+	dkimResults, dkimResult := checkDKIM(in.dkim)
+	dmarcResult, policy := checkDMARC(ctx, resolver, fromDomain, spfDomain, spfResult, in.dkim)
+
+	results := []authres.Result{
+		&authres.SPFResult{Value: authres.ResultValue(spfResult), From: in.mailFrom, Helo: in.helo},
+	}
+	results = append(results, dkimResults...)
+	results = append(results, &authres.DMARCResult{Value: authres.ResultValue(dmarcResult), From: fromDomain})
+
+	return &email.AuthResults{
+		Header: authres.Format(authservID, results),
+		SPF:    string(spfResult),
+		DKIM:   dkimResult,
+		DMARC:  dmarcResult,
+		Policy: string(policy),
+	}
+}
+
+func checkSPF(ctx context.Context, resolver spf.DNSResolver, in *authInput) spf.Result {
+	if in.ip == nil || (in.mailFrom == "" && in.helo == "") {
+		return spf.None
+	}
+	result, _ := spf.CheckHostWithSender(in.ip, in.helo, in.mailFrom, spf.WithContext(ctx), spf.WithResolver(resolver)) //nolint:errcheck // error is reflected in the result
+	return result
+}
+
+// checkDKIM converts DKIM verifications to auth results, and returns the overall result
+func checkDKIM(verifications []*dkim.Verification) (results []authres.Result, overall string) {
+	if len(verifications) == 0 {
+		return []authres.Result{&authres.DKIMResult{Value: authres.ResultNone}}, string(authres.ResultNone)
+	}
+
+	overall = string(authres.ResultFail)
+	results = make([]authres.Result, 0, len(verifications))
+	for _, verification := range verifications {
+		var value authres.ResultValue = authres.ResultPass
+		switch {
+		case verification.Err == nil:
+			overall = string(authres.ResultPass)
+		case dkim.IsTempFail(verification.Err):
+			value = authres.ResultTempError
+		case dkim.IsPermFail(verification.Err):
+			value = authres.ResultPermError
+		default:
+			value = authres.ResultFail
+		}
+		results = append(results, &authres.DKIMResult{Value: value, Domain: verification.Domain, Identifier: verification.Identifier})
+	}
+	return results, overall
+}
+
+// checkDMARC evaluates DMARC alignment (RFC 7489) and returns the result and the policy to apply on failure
+func checkDMARC(ctx context.Context, resolver spf.DNSResolver, fromDomain, spfDomain string, spfResult spf.Result, verifications []*dkim.Verification) (result string, policy dmarc.Policy) {
+	if fromDomain == "" {
+		return string(authres.ResultNone), ""
+	}
+
+	record, err := lookupDMARC(ctx, resolver, fromDomain)
+	if err != nil {
+		switch {
+		case errors.Is(err, dmarc.ErrNoPolicy):
+			return string(authres.ResultNone), ""
+		case dmarc.IsTempFail(err):
+			return string(authres.ResultTempError), ""
+		default:
+			return string(authres.ResultPermError), ""
+		}
+	}
+
+	if spfResult == spf.Pass && dmarcAligned(spfDomain, fromDomain, record.SPFAlignment) {
+		return string(authres.ResultPass), ""
+	}
+	for _, verification := range verifications {
+		if verification.Err == nil && dmarcAligned(verification.Domain, fromDomain, record.DKIMAlignment) {
+			return string(authres.ResultPass), ""
+		}
+	}
+
+	return string(authres.ResultFail), record.Policy
+}
+
+// lookupDMARC finds DMARC record of the domain, falling back to the organizational domain.
+// Returned record's policy is replaced with subdomain policy, if applicable. The "pct" tag is taken into account
+func lookupDMARC(ctx context.Context, resolver spf.DNSResolver, domain string) (*dmarc.Record, error) {
+	options := &dmarc.LookupOptions{
+		LookupTXT: func(name string) ([]string, error) {
+			return resolver.LookupTXT(ctx, name)
+		},
+	}
+	record, err := dmarc.LookupWithOptions(domain, options)
+	orgDomain := organizationalDomain(domain)
+	if errors.Is(err, dmarc.ErrNoPolicy) && orgDomain != domain {
+		record, err = dmarc.LookupWithOptions(orgDomain, options)
+		if err == nil && record.SubdomainPolicy != "" {
+			record.Policy = record.SubdomainPolicy
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// "pct" - policy is applied to that percent of messages only, the rest get a policy one step lower (RFC 7489 section 6.6.4)
+	if record.Percent != nil && rand.IntN(100) >= *record.Percent { //nolint:gosec // that's not a security feature
+		switch record.Policy {
+		case dmarc.PolicyReject:
+			record.Policy = dmarc.PolicyQuarantine
+		case dmarc.PolicyQuarantine:
+			record.Policy = dmarc.PolicyNone
+		}
+	}
+	return record, nil
+}
+
+// dmarcAligned checks if the authenticated domain is aligned with the From header domain
+func dmarcAligned(domain, fromDomain string, mode dmarc.AlignmentMode) bool {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if domain == "" {
+		return false
+	}
+	if domain == fromDomain {
+		return true
+	}
+	if mode == dmarc.AlignmentStrict {
+		return false
+	}
+	return organizationalDomain(domain) == organizationalDomain(fromDomain)
+}
+
+// organizationalDomain returns the registered domain, e.g. mail.example.co.uk -> example.co.uk
+func organizationalDomain(domain string) string {
+	org, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	if err != nil {
+		return domain
+	}
+	return org
+}
+
+// stripAuthResults removes Authentication-Results headers with any of the authserv-ids from the raw email header,
+// so the sender cannot forge the results under our name (RFC 8601 section 5)
+func stripAuthResults(raw []byte, authservIDs []string) []byte {
+	out := make([]byte, 0, len(raw))
+	var field []byte // current header field, including continuation lines
+	flush := func() {
+		if !forgedAuthResults(field, authservIDs) {
+			out = append(out, field...)
+		}
+		field = nil
+	}
+	for rest := raw; len(rest) > 0; {
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line = rest[:i+1]
+		}
+		rest = rest[len(line):]
+		if len(bytes.TrimRight(line, "\r\n")) == 0 { // end of the header
+			flush()
+			return append(append(out, line...), rest...)
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			flush()
+		}
+		field = append(field, line...)
+	}
+	flush()
+	return out
+}
+
+// forgedAuthResults checks if the header field is Authentication-Results with any of the authserv-ids
+func forgedAuthResults(field []byte, authservIDs []string) bool {
+	name, value, ok := strings.Cut(string(field), ":")
+	if !ok || !strings.EqualFold(strings.TrimSpace(name), "Authentication-Results") {
+		return false
+	}
+	value, _, _ = strings.Cut(value, ";")
+	for strings.Contains(value, "(") { // comments
+		start := strings.Index(value, "(")
+		end := strings.Index(value[start:], ")")
+		if end < 0 {
+			value = value[:start]
+			break
+		}
+		value = value[:start] + " " + value[start+end+1:]
+	}
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return false
+	}
+	return slices.ContainsFunc(authservIDs, func(authservID string) bool { return strings.EqualFold(fields[0], authservID) })
+}
@@ -0,0 +1,221 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+
+	"blitiri.com.ar/go/spf"
+	"github.com/emersion/go-msgauth/dkim"
+	"github.com/emersion/go-msgauth/dmarc"
+)
+
+// fakeResolver is an offline DNS resolver with TXT records only
+type fakeResolver map[string][]string
+
+func (r fakeResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	txts, ok := r[strings.TrimSuffix(name, ".")]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return txts, nil
+}
+
+func (r fakeResolver) LookupMX(_ context.Context, name string) ([]*net.MX, error) {
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (r fakeResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func (r fakeResolver) LookupAddr(_ context.Context, addr string) ([]string, error) {
+	return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+}
+
+func TestAuthenticate(t *testing.T) {
+	resolver := fakeResolver{
+		"example.com":             {"v=spf1 ip4:192.0.2.1 -all"},
+		"mail.example.com":        {"v=spf1 ip4:192.0.2.2 -all"},
+		"_dmarc.example.com":      {"v=DMARC1; p=reject; sp=quarantine"},
+		"strict.org":              {"v=spf1 ip4:192.0.2.3 -all"},
+		"mail.strict.org":         {"v=spf1 ip4:192.0.2.3 -all"},
+		"_dmarc.strict.org":       {"v=DMARC1; p=quarantine; aspf=s"},
+		"nodmarc.net":             {"v=spf1 -all"},
+		"_dmarc.invalid.example":  {"v=DMARC1; p=unknown"},
+		"invalid.example":         {"v=spf1 -all"},
+		"_dmarc.not-dmarc.com":    {"some other record"},
+		"not-dmarc.com":           {"v=spf1 -all"},
+		"_dmarc.mail.example.com": {"v=DMARC1; p=none"},
+	}
+	okSig := []*dkim.Verification{{Domain: "example.com"}}
+	badSig := []*dkim.Verification{{Domain: "example.com", Err: errors.New("signature mismatch")}}
+
+	tests := map[string]struct {
+		in     *authInput
+		spf    string
+		dkim   string
+		dmarc  string
+		policy string
+	}{
+		"aligned spf": {
+			in:  &authInput{ip: net.ParseIP("192.0.2.1"), mailFrom: "alice@example.com", headerFrom: "alice@example.com"},
+			spf: "pass", dkim: "none", dmarc: "pass",
+		},
+		"failed spf, reject": {
+			in:  &authInput{ip: net.ParseIP("198.51.100.1"), mailFrom: "alice@example.com", headerFrom: "alice@example.com"},
+			spf: "fail", dkim: "none", dmarc: "fail", policy: "reject",
+		},
+		"failed spf, aligned dkim": {
+			in:  &authInput{ip: net.ParseIP("198.51.100.1"), mailFrom: "alice@example.com", headerFrom: "alice@example.com", dkim: okSig},
+			spf: "fail", dkim: "pass", dmarc: "pass",
+		},
+		"failed spf, invalid dkim": {
+			in:  &authInput{ip: net.ParseIP("198.51.100.1"), mailFrom: "alice@example.com", headerFrom: "alice@example.com", dkim: badSig},
+			spf: "fail", dkim: "fail", dmarc: "fail", policy: "reject",
+		},
+		"relaxed alignment": {
+			in:  &authInput{ip: net.ParseIP("192.0.2.2"), mailFrom: "bounce@mail.example.com", headerFrom: "alice@example.com"},
+			spf: "pass", dkim: "none", dmarc: "pass",
+		},
+		"strict alignment": {
+			in:  &authInput{ip: net.ParseIP("192.0.2.3"), mailFrom: "bounce@mail.strict.org", headerFrom: "bob@strict.org"},
+			spf: "pass", dkim: "none", dmarc: "fail", policy: "quarantine",
+		},
+		"subdomain policy": {
+			in:  &authInput{ip: net.ParseIP("198.51.100.1"), mailFrom: "news@news.example.com", headerFrom: "news@news.example.com"},
+			spf: "none", dkim: "none", dmarc: "fail", policy: "quarantine",
+		},
+		"subdomain own record": {
+			in:  &authInput{ip: net.ParseIP("198.51.100.1"), mailFrom: "bounce@mail.example.com", headerFrom: "alice@mail.example.com"},
+			spf: "fail", dkim: "none", dmarc: "fail", policy: "none",
+		},
+		"no dmarc record": {
+			in:  &authInput{ip: net.ParseIP("192.0.2.1"), mailFrom: "carol@nodmarc.net", headerFrom: "carol@nodmarc.net"},
+			spf: "fail", dkim: "none", dmarc: "none",
+		},
+		"not a dmarc record": {
+			in:  &authInput{ip: net.ParseIP("192.0.2.1"), mailFrom: "carol@not-dmarc.com", headerFrom: "carol@not-dmarc.com"},
+			spf: "fail", dkim: "none", dmarc: "none",
+		},
+		"invalid dmarc record": {
+			in:  &authInput{ip: net.ParseIP("192.0.2.1"), mailFrom: "dave@invalid.example", headerFrom: "dave@invalid.example"},
+			spf: "fail", dkim: "none", dmarc: "permerror",
+		},
+		"null sender": {
+			in:  &authInput{ip: net.ParseIP("192.0.2.2"), helo: "mail.example.com", headerFrom: "mailer-daemon@example.com"},
+			spf: "pass", dkim: "none", dmarc: "pass",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			actual := authenticate(context.Background(), resolver, "example.com", test.in)
+			if actual.SPF != test.spf {
+				t.Error("spf:", test.spf, "!=", actual.SPF)
+			}
+			if actual.DKIM != test.dkim {
+				t.Error("dkim:", test.dkim, "!=", actual.DKIM)
+			}
+			if actual.DMARC != test.dmarc {
+				t.Error("dmarc:", test.dmarc, "!=", actual.DMARC)
+			}
+			if actual.Policy != test.policy {
+				t.Error("policy:", test.policy, "!=", actual.Policy)
+			}
+			for _, expected := range []string{"example.com;", "spf=" + test.spf, "dkim=" + test.dkim, "dmarc=" + test.dmarc} {
+				if !strings.Contains(actual.Header, expected) {
+					t.Errorf("header %q doesn't contain %q", actual.Header, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestDMARCAligned(t *testing.T) {
+	tests := map[string]struct {
+		domain string
+		from   string
+		strict bool
+		result bool
+	}{
+		"same":              {"example.com", "example.com", true, true},
+		"subdomain relaxed": {"mail.example.com", "example.com", false, true},
+		"subdomain strict":  {"mail.example.com", "example.com", true, false},
+		"psl relaxed":       {"a.example.co.uk", "b.example.co.uk", false, true},
+		"different psl org": {"example.co.uk", "other.co.uk", false, false},
+		"other domain":      {"example.org", "example.com", false, false},
+		"empty":             {"", "example.com", false, false},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var mode dmarc.AlignmentMode = dmarc.AlignmentRelaxed
+			if test.strict {
+				mode = dmarc.AlignmentStrict
+			}
+			if actual := dmarcAligned(test.domain, test.from, mode); actual != test.result {
+				t.Error(test.result, "!=", actual)
+			}
+		})
+	}
+}
+
+func TestAuthInputAuthenticated(t *testing.T) {
+	tests := map[string]struct {
+		in       *authInput
+		expected []string
+	}{
+		"spf pass":  {&authInput{mailFrom: "test@Example.com", spf: spf.Pass}, []string{"example.com"}},
+		"spf fail":  {&authInput{mailFrom: "test@example.com", spf: spf.Fail}, []string{}},
+		"null path": {&authInput{helo: "mail.example.com", spf: spf.Pass}, []string{}},
+		"dkim": {
+			&authInput{mailFrom: "test@example.com", spf: spf.SoftFail, dkim: []*dkim.Verification{
+				{Domain: "Example.org"},
+				{Domain: "example.net", Err: errors.New("signature mismatch")},
+			}},
+			[]string{"example.org"},
+		},
+		"both": {
+			&authInput{mailFrom: "test@example.com", spf: spf.Pass, dkim: []*dkim.Verification{{Domain: "example.org"}}},
+			[]string{"example.com", "example.org"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if actual := test.in.authenticated(); !slices.Equal(actual, test.expected) {
+				t.Error(test.expected, "!=", actual)
+			}
+		})
+	}
+}
+
+func TestStripAuthResults(t *testing.T) {
+	body := "Subject: test\r\n\r\nAuthentication-Results: example.com; dkim=pass\r\n"
+	tests := map[string]struct {
+		raw      string
+		expected string
+	}{
+		"none":    {"From: alice@example.org\r\n" + body, "From: alice@example.org\r\n" + body},
+		"forged":  {"Authentication-Results: example.com; dkim=pass header.d=example.org\r\n" + body, body},
+		"case":    {"authentication-results:  Example.COM (forged) 1; dmarc=pass\r\n" + body, body},
+		"folded":  {"Authentication-Results: example.com;\r\n\tdkim=pass;\r\n dmarc=pass\r\nFrom: alice@example.org\r\n" + body, "From: alice@example.org\r\n" + body},
+		"comment": {"Authentication-Results: (comment) example.net; spf=pass\r\n" + body, body},
+		"other":   {"Authentication-Results: mx.example.org; spf=pass\r\n" + body, "Authentication-Results: mx.example.org; spf=pass\r\n" + body},
+		"lf":      {"Authentication-Results: example.com; spf=pass\nSubject: test\n\nbody\n", "Subject: test\n\nbody\n"},
+		"no body": {"Authentication-Results: example.com; spf=pass\r\nSubject: test\r\n", "Subject: test\r\n"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			actual := string(stripAuthResults([]byte(test.raw), []string{"example.com", "example.net"}))
+			if actual != test.expected {
+				t.Errorf("%q != %q", test.expected, actual)
+			}
+		})
+	}
+}
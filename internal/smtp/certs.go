@@ -0,0 +1,176 @@
+package smtp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// TLSDirCertExt is the extension of certificate files in the TLS directory
+	TLSDirCertExt = ".crt"
+	// TLSDirKeyExt is the extension of private key files in the TLS directory
+	TLSDirKeyExt = ".key"
+)
+
+// ErrNoCertificate returned when there is no certificate at all
+var ErrNoCertificate = errors.New("no TLS certificate available")
+
+// certStore holds TLS certificates indexed by DNS names, to select them by SNI
+type certStore struct {
+	mu       sync.RWMutex
+	names    map[string]*tls.Certificate
+	fallback *tls.Certificate
+}
+
+// set replaces all certificates in the store.
+// The certificate of the default domain (or the first one) is used when client doesn't send SNI
+func (s *certStore) set(certificates []*tls.Certificate, defaultDomain string) {
+	names := make(map[string]*tls.Certificate, len(certificates))
+	var fallback *tls.Certificate
+	for _, cert := range certificates {
+		for _, name := range certNames(cert) {
+			if _, ok := names[name]; !ok {
+				names[name] = cert
+			}
+		}
+		if fallback == nil {
+			fallback = cert
+		}
+	}
+	if cert := lookupCert(names, defaultDomain); cert != nil {
+		fallback = cert
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.names = names
+	s.fallback = fallback
+}
+
+// has returns true if there is a certificate for the domain
+func (s *certStore) has(domain string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return lookupCert(s.names, domain) != nil
+}
+
+// expiry returns expiration time of the domain's certificate, if any
+func (s *certStore) expiry(domain string) (time.Time, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cert := lookupCert(s.names, domain)
+	if cert == nil || cert.Leaf == nil {
+		return time.Time{}, false
+	}
+	return cert.Leaf.NotAfter, true
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (s *certStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if cert := lookupCert(s.names, hello.ServerName); cert != nil {
+		return cert, nil
+	}
+	if s.fallback == nil {
+		return nil, ErrNoCertificate
+	}
+	return s.fallback, nil
+}
+
+// lookupCert finds certificate by exact name or wildcard
+func lookupCert(names map[string]*tls.Certificate, name string) *tls.Certificate {
+	name = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), "."))
+	if name == "" {
+		return nil
+	}
+	if cert, ok := names[name]; ok {
+		return cert
+	}
+
+	idx := strings.IndexByte(name, '.')
+	if idx == -1 {
+		return nil
+	}
+	return names["*"+name[idx:]]
+}
+
+// certNames returns DNS names of the certificate
+func certNames(cert *tls.Certificate) []string {
+	leaf := cert.Leaf
+	if leaf == nil {
+		if len(cert.Certificate) == 0 {
+			return nil
+		}
+		var err error
+		leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil
+		}
+		cert.Leaf = leaf
+	}
+
+	names := make([]string, 0, len(leaf.DNSNames)+1)
+	for _, name := range leaf.DNSNames {
+		names = append(names, strings.ToLower(name))
+	}
+	if len(names) == 0 && leaf.Subject.CommonName != "" {
+		names = append(names, strings.ToLower(leaf.Subject.CommonName))
+	}
+	return names
+}
+
+// tlsDirPairs returns paths of <domain>.crt and <domain>.key pairs found in the directory
+func tlsDirPairs(dir string) (certs, keys []string, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), TLSDirCertExt) {
+			continue
+		}
+		certPath := filepath.Join(dir, entry.Name())
+		keyPath := strings.TrimSuffix(certPath, TLSDirCertExt) + TLSDirKeyExt
+		if _, serr := os.Stat(keyPath); serr != nil {
+			continue
+		}
+		certs = append(certs, certPath)
+		keys = append(keys, keyPath)
+	}
+
+	return certs, keys, nil
+}
+
+// tlsDirWatchlist returns the list of files in the TLS directory that should be watched for changes,
+// including not-yet-existing files of the configured domains
+func tlsDirWatchlist(dir string, domains []string) []string {
+	if dir == "" {
+		return nil
+	}
+	uniq := map[string]struct{}{}
+	for _, domain := range domains {
+		uniq[filepath.Join(dir, domain+TLSDirCertExt)] = struct{}{}
+		uniq[filepath.Join(dir, domain+TLSDirKeyExt)] = struct{}{}
+	}
+	certs, keys, _ := tlsDirPairs(dir) //nolint:errcheck // not a problem if the dir doesn't exist yet
+	for _, path := range append(certs, keys...) {
+		uniq[path] = struct{}{}
+	}
+
+	list := make([]string, 0, len(uniq))
+	for path := range uniq {
+		list = append(list, path)
+	}
+	return list
+}
@@ -0,0 +1,149 @@
+package smtp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// newTestCert generates self-signed certificate for the given DNS names
+func newTestCert(t *testing.T, names ...string) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("cannot create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("cannot marshal key: %v", err)
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM
+}
+
+func newTestTLSCert(t *testing.T, names ...string) *tls.Certificate {
+	t.Helper()
+	certPEM, keyPEM := newTestCert(t, names...)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("cannot load certificate: %v", err)
+	}
+	return &cert
+}
+
+func TestCertStoreGetCertificate(t *testing.T) {
+	first := newTestTLSCert(t, "example.org")
+	exact := newTestTLSCert(t, "example.com", "mail.example.com")
+	wildcard := newTestTLSCert(t, "*.example.net")
+	store := &certStore{}
+	store.set([]*tls.Certificate{first, exact, wildcard}, "example.com")
+
+	tests := map[string]*tls.Certificate{
+		"example.org":      first,
+		"EXAMPLE.COM":      exact,
+		"mail.example.com": exact,
+		"mx.example.net":   wildcard,
+		"example.net":      exact, // fallback to the default domain
+		"":                 exact,
+	}
+
+	for sni, expected := range tests {
+		t.Run(sni, func(t *testing.T) {
+			actual, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: sni})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual != expected {
+				t.Errorf("unexpected certificate for %q: %v", sni, actual.Leaf.DNSNames)
+			}
+		})
+	}
+}
+
+func TestCertStoreEmpty(t *testing.T) {
+	store := &certStore{}
+	if _, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"}); err == nil {
+		t.Fatal("expected error for empty store")
+	}
+	if store.has("example.com") {
+		t.Fatal("empty store must not have certificates")
+	}
+	if _, ok := store.expiry("example.com"); ok {
+		t.Fatal("empty store must not have certificates expiry")
+	}
+}
+
+func TestCertStoreExpiry(t *testing.T) {
+	cert := newTestTLSCert(t, "*.example.com")
+	store := &certStore{}
+	store.set([]*tls.Certificate{cert}, "example.com")
+
+	expires, ok := store.expiry("mail.example.com")
+	if !ok || !expires.Equal(cert.Leaf.NotAfter) {
+		t.Errorf("unexpected expiry: %v %v", expires, ok)
+	}
+	if _, ok := store.expiry("example.org"); ok {
+		t.Error("there is no certificate for example.org")
+	}
+}
+
+func TestTLSDirPairs(t *testing.T) {
+	dir := t.TempDir()
+	for _, domain := range []string{"example.com", "example.org"} {
+		certPEM, keyPEM := newTestCert(t, domain)
+		if err := os.WriteFile(filepath.Join(dir, domain+TLSDirCertExt), certPEM, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, domain+TLSDirKeyExt), keyPEM, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// certificate without key must be ignored
+	certPEM, _ := newTestCert(t, "example.net")
+	if err := os.WriteFile(filepath.Join(dir, "example.net"+TLSDirCertExt), certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	certs, keys, err := tlsDirPairs(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedCerts := []string{filepath.Join(dir, "example.com.crt"), filepath.Join(dir, "example.org.crt")}
+	expectedKeys := []string{filepath.Join(dir, "example.com.key"), filepath.Join(dir, "example.org.key")}
+	if !slices.Equal(certs, expectedCerts) {
+		t.Errorf("unexpected certs: %v", certs)
+	}
+	if !slices.Equal(keys, expectedKeys) {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+
+	watchlist := tlsDirWatchlist(dir, []string{"example.com", "example.info"})
+	for _, expected := range []string{filepath.Join(dir, "example.info.crt"), filepath.Join(dir, "example.org.key")} {
+		if !slices.Contains(watchlist, expected) {
+			t.Errorf("%s is not in the watchlist", expected)
+		}
+	}
+}
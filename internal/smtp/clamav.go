@@ -0,0 +1,115 @@
+package smtp
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/etkecc/postmoogle/internal/utils"
+)
+
+const (
+	// ClamAVTimeout is the default timeout of clamd requests
+	ClamAVTimeout = 60 * time.Second
+	// clamavChunkSize is the size of INSTREAM chunks, must be less than clamd's StreamMaxLength
+	clamavChunkSize = 64 * 1024
+)
+
+// ErrClamAVSizeLimit returned when the file exceeds clamd's StreamMaxLength, so it cannot be scanned at all
+var ErrClamAVSizeLimit = errors.New("clamd size limit exceeded")
+
+// ClamAV scans files using clamd INSTREAM command, see https://docs.clamav.net/manual/Usage/Scanning.html#clamd
+type ClamAV struct {
+	addr    string
+	timeout time.Duration
+}
+
+// NewClamAV creates clamd client, returns nil if the address is empty.
+// The address is either a unix socket path (starting with /) or TCP address (host:port)
+func NewClamAV(addr string, timeout time.Duration) *ClamAV {
+	if addr == "" {
+		return nil
+	}
+	if timeout <= 0 {
+		timeout = ClamAVTimeout
+	}
+	return &ClamAV{addr: addr, timeout: timeout}
+}
+
+// Scan sends the content to clamd and returns the virus name, if found
+func (c *ClamAV) Scan(ctx context.Context, content []byte) (string, error) {
+	dialer := &net.Dialer{Timeout: c.timeout}
+	conn, err := dialer.DialContext(ctx, socketNetwork(c.addr), c.addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(c.timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline) //nolint:errcheck // the request will fail anyway
+
+	writer := bufio.NewWriter(conn)
+	writer.WriteString("zINSTREAM\x00") //nolint:errcheck // checked on flush
+	size := make([]byte, 4)
+	for start := 0; start < len(content); start += clamavChunkSize {
+		chunk := content[start:min(start+clamavChunkSize, len(content))]
+		binary.BigEndian.PutUint32(size, uint32(len(chunk))) //nolint:gosec // chunk size is limited
+		writer.Write(size)                                   //nolint:errcheck // checked on flush
+		writer.Write(chunk)                                  //nolint:errcheck // checked on flush
+	}
+	writer.Write([]byte{0, 0, 0, 0}) //nolint:errcheck // checked on flush
+	if err = writer.Flush(); err != nil {
+		return "", err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return "", err
+	}
+	return parseClamAVReply(reply)
+}
+
+// parseClamAVReply parses INSTREAM reply, e.g.: `stream: OK` or `stream: Eicar-Signature FOUND`
+func parseClamAVReply(reply string) (string, error) {
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	if strings.Contains(reply, "size limit exceeded") {
+		return "", ErrClamAVSizeLimit
+	}
+	_, result, ok := strings.Cut(reply, ": ")
+	if !ok {
+		return "", fmt.Errorf("invalid clamd reply: %q", reply) //nolint:goerr113 // no need for a sentinel error
+	}
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	default:
+		return "", fmt.Errorf("clamd error: %s", result) //nolint:goerr113 // no need for a sentinel error
+	}
+}
+
+// ScanFiles scans the files and returns the infected ones in a `name (virus)` form along with the clean files
+func (c *ClamAV) ScanFiles(ctx context.Context, files []*utils.File) ([]*utils.File, []string, error) {
+	clean := make([]*utils.File, 0, len(files))
+	var infected []string
+	for _, file := range files {
+		virus, err := c.Scan(ctx, file.Content)
+		if err != nil {
+			return nil, nil, err
+		}
+		if virus != "" {
+			infected = append(infected, file.Name+" ("+virus+")")
+			continue
+		}
+		clean = append(clean, file)
+	}
+	return clean, infected, nil
+}
@@ -0,0 +1,316 @@
+package smtp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jhillyerd/enmime/v2"
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/etkecc/postmoogle/internal/email"
+	"github.com/etkecc/postmoogle/internal/utils"
+)
+
+const testTooLarge = "TEST-FILE-EXCEEDING-STREAM-MAX-LENGTH"
+
+const testVirus = "X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*"
+
+// serveClamAV runs a stub clamd server, detecting EICAR test signature in INSTREAM data,
+// and replying with the size limit error to the data containing testTooLarge
+func serveClamAV(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			handleClamAV(t, conn)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func handleClamAV(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	command, err := reader.ReadString(0)
+	if err != nil || command != "zINSTREAM\x00" {
+		t.Error("zINSTREAM\\x00", "!=", command)
+		return
+	}
+
+	var data bytes.Buffer
+	size := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(reader, size); err != nil {
+			t.Error(err)
+			return
+		}
+		length := binary.BigEndian.Uint32(size)
+		if length == 0 {
+			break
+		}
+		if _, err := io.CopyN(&data, reader, int64(length)); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	reply := "stream: OK\x00"
+	if strings.Contains(data.String(), "EICAR-STANDARD-ANTIVIRUS-TEST-FILE") {
+		reply = "stream: Win.Test.EICAR_HDB-1 FOUND\x00"
+	}
+	if strings.Contains(data.String(), testTooLarge) {
+		reply = "INSTREAM size limit exceeded. ERROR\x00"
+	}
+	conn.Write([]byte(reply)) //nolint:errcheck // that's a test
+}
+
+func TestClamAVScan(t *testing.T) {
+	clamav := NewClamAV(serveClamAV(t), 0)
+	tests := map[string]struct {
+		content []byte
+		virus   string
+	}{
+		"clean":       {content: []byte("hello world"), virus: ""},
+		"eicar":       {content: []byte(testVirus), virus: "Win.Test.EICAR_HDB-1"},
+		"empty":       {content: []byte{}, virus: ""},
+		"multi-chunk": {content: append(bytes.Repeat([]byte("a"), clamavChunkSize+10), testVirus...), virus: "Win.Test.EICAR_HDB-1"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			virus, err := clamav.Scan(context.Background(), test.content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if virus != test.virus {
+				t.Error(test.virus, "!=", virus)
+			}
+		})
+	}
+}
+
+func TestParseClamAVReply(t *testing.T) {
+	tests := map[string]struct {
+		reply     string
+		virus     string
+		err       bool
+		sizeLimit bool
+	}{
+		"ok":      {reply: "stream: OK\x00"},
+		"found":   {reply: "stream: Eicar-Signature FOUND\x00", virus: "Eicar-Signature"},
+		"error":   {reply: "INSTREAM size limit exceeded. ERROR\x00", err: true, sizeLimit: true},
+		"failure": {reply: "stream: Can't allocate memory ERROR\x00", err: true},
+		"invalid": {reply: "", err: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			virus, err := parseClamAVReply(test.reply)
+			if test.err {
+				if err == nil {
+					t.Error("expected error, got nil")
+				}
+				if errors.Is(err, ErrClamAVSizeLimit) != test.sizeLimit {
+					t.Error(test.sizeLimit, "!=", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if virus != test.virus {
+				t.Error(test.virus, "!=", virus)
+			}
+		})
+	}
+}
+
+func TestOutgoingDataVirus(t *testing.T) {
+	body := "From: alice@example.com\r\nTo: bob@example.com\r\nSubject: test\r\n" +
+		"MIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=BOUNDARY\r\n\r\n" +
+		"--BOUNDARY\r\nContent-Type: text/plain\r\n\r\nHello\r\n" +
+		"--BOUNDARY\r\nContent-Type: text/plain\r\nContent-Disposition: attachment; filename=clean.txt\r\n\r\nclean\r\n" +
+		"--BOUNDARY\r\nContent-Type: application/octet-stream\r\nContent-Disposition: attachment; filename=eicar.com\r\n\r\n" + testVirus + "\r\n" +
+		"--BOUNDARY--\r\n"
+	addr := serveClamAV(t)
+
+	tests := map[string]struct {
+		strip    bool
+		err      error
+		files    []string
+		infected []string
+	}{
+		"reject": {strip: false, err: ErrVirus},
+		"strip":  {strip: true, files: []string{"clean.txt"}, infected: []string{"eicar.com (Win.Test.EICAR_HDB-1)"}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var delivered *email.Email
+			bot := &fakebot{
+				getIFOptions: func(context.Context, id.RoomID) email.IncomingFilteringOptions {
+					return &fakeIFOptions{antivirusStrip: test.strip}
+				},
+				incomingEmail: func(_ context.Context, eml *email.Email) error {
+					delivered = eml
+					return nil
+				},
+			}
+			s := newTestSession(bot, []string{"example.com"}, Outgoing)
+			s.clamav = NewClamAV(addr, 0)
+			s.from = "alice@example.com"
+			s.tos = []string{"bob@example.com"}
+
+			err := s.outgoingData(strings.NewReader(body))
+			if !errors.Is(err, test.err) {
+				t.Fatal(test.err, "!=", err)
+			}
+			if test.err != nil {
+				return
+			}
+			files := make([]string, 0, len(delivered.Files))
+			for _, file := range delivered.Files {
+				files = append(files, file.Name)
+			}
+			if !slices.Equal(test.files, files) {
+				t.Error(test.files, "!=", files)
+			}
+			if !slices.Equal(test.infected, delivered.Infected) {
+				t.Error(test.infected, "!=", delivered.Infected)
+			}
+		})
+	}
+}
+
+func TestClamAVScanFilesUnavailable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	clamav := NewClamAV(addr, 0)
+	if _, _, err := clamav.ScanFiles(context.Background(), []*utils.File{utils.NewFile("test.txt", []byte("test"))}); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+func TestCheckFilesUnavailable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	s := newTestSession(&fakebot{}, []string{"example.com"}, "")
+	s.clamav = NewClamAV(addr, 0)
+	eml := &email.Email{Files: []*utils.File{utils.NewFile("test.txt", []byte("test"))}}
+	if err := s.checkFiles(eml, "!room:example.com"); !errors.Is(err, ErrAntivirusUnavailable) {
+		t.Error(ErrAntivirusUnavailable, "!=", err)
+	}
+	if err := s.checkFiles(&email.Email{}, "!room:example.com"); err != nil {
+		t.Error("email without files must not be scanned, got", err)
+	}
+}
+
+func TestCheckFilesStripRaw(t *testing.T) {
+	body := "From: alice@example.org\r\nTo: bob@example.com\r\nSubject: test\r\n" +
+		"MIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=BOUNDARY\r\n\r\n" +
+		"--BOUNDARY\r\nContent-Type: text/plain\r\n\r\nHello\r\n" +
+		"--BOUNDARY\r\nContent-Type: text/plain\r\nContent-Disposition: attachment; filename=clean.txt\r\n\r\nclean\r\n" +
+		"--BOUNDARY\r\nContent-Type: application/octet-stream\r\nContent-Disposition: attachment; filename=eicar.com\r\n\r\n" + testVirus + "\r\n" +
+		"--BOUNDARY--\r\n"
+	envelope, err := enmime.ReadEnvelope(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	eml := email.FromEnvelope("bob@example.com", envelope)
+	eml.Raw = []byte(body)
+
+	bot := &fakebot{
+		getIFOptions: func(context.Context, id.RoomID) email.IncomingFilteringOptions {
+			return &fakeIFOptions{antivirusStrip: true}
+		},
+	}
+	s := newTestSession(bot, []string{"example.com"}, "")
+	s.clamav = NewClamAV(serveClamAV(t), 0)
+	if err := s.checkFiles(eml, "!room:example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(eml.Raw), "eicar.com") || !strings.Contains(string(eml.Raw), "clean.txt") {
+		t.Errorf("infected file is not removed from raw email: %s", eml.Raw)
+	}
+}
+
+func TestManagerCheckFiles(t *testing.T) {
+	log := zerolog.Nop()
+	eml := &email.Email{Files: []*utils.File{utils.NewFile("eicar.com", []byte(testVirus))}}
+	bot := &fakebot{
+		getIFOptions: func(context.Context, id.RoomID) email.IncomingFilteringOptions {
+			return &fakeIFOptions{}
+		},
+	}
+
+	m := &Manager{log: &log, bot: bot}
+	if err := m.CheckFiles(context.Background(), eml, "!room:example.com"); err != nil {
+		t.Error("files must not be scanned without ClamAV, got", err)
+	}
+	m.clamav = NewClamAV(serveClamAV(t), 0)
+	if err := m.CheckFiles(context.Background(), eml, "!room:example.com"); !errors.Is(err, ErrVirus) {
+		t.Error(ErrVirus, "!=", err)
+	}
+}
+
+func TestCheckFilesTooLarge(t *testing.T) {
+	addr := serveClamAV(t)
+	tests := map[string]struct {
+		dir        string
+		quarantine bool
+		err        error
+	}{
+		"rejected":    {dir: Incoming, err: ErrTooLargeToScan},
+		"quarantined": {dir: Incoming, quarantine: true},
+		"outgoing":    {dir: Outgoing, quarantine: true, err: ErrTooLargeToScan},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			bot := &fakebot{
+				getIFOptions: func(context.Context, id.RoomID) email.IncomingFilteringOptions {
+					return &fakeIFOptions{quarantine: test.quarantine}
+				},
+			}
+			s := newTestSession(bot, []string{"example.com"}, test.dir)
+			s.roomID = "!room:example.com"
+			s.clamav = NewClamAV(addr, 0)
+			eml := &email.Email{Files: []*utils.File{utils.NewFile("large.bin", []byte(testTooLarge))}}
+			if err := s.checkFiles(eml, s.roomID); !errors.Is(err, test.err) {
+				t.Error(test.err, "!=", err)
+			}
+			if test.err == nil && len(s.quarantine) != 1 {
+				t.Error("quarantine reason is not recorded", s.quarantine)
+			}
+		})
+	}
+}
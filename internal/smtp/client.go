@@ -10,6 +10,8 @@ import (
 	"strings"
 
 	"github.com/rs/zerolog"
+
+	"github.com/etkecc/postmoogle/internal/metrics"
 )
 
 type MailSender interface {
@@ -20,17 +22,28 @@ type MailSender interface {
 type Client struct {
 	config *RelayConfig
 	log    *zerolog.Logger
+	// hostname is used in HELO of emails with null sender (bounces)
+	hostname string
 }
 
 var errNoSMTP = fmt.Errorf("cannot connect to any SMTP server")
 
-func newClient(cfg *RelayConfig, log *zerolog.Logger) *Client {
+func newClient(cfg *RelayConfig, hostname string, log *zerolog.Logger) *Client {
 	return &Client{
-		config: cfg,
-		log:    log,
+		config:   cfg,
+		log:      log,
+		hostname: hostname,
 	}
 }
 
+// localname returns the HELO name for the sender
+func (c *Client) localname(from string) string {
+	if _, localname, ok := strings.Cut(from, "@"); ok {
+		return localname
+	}
+	return c.hostname
+}
+
 // relayFromURL creates a RelayConfig from a URL
 func relayFromURL(relayURL *url.URL) *RelayConfig {
 	if relayURL == nil {
@@ -47,6 +60,16 @@ func relayFromURL(relayURL *url.URL) *RelayConfig {
 
 // Send email
 func (c Client) Send(from, to, data string, relayOverride *url.URL) error {
+	err := c.send(from, to, data, relayOverride)
+	if err != nil {
+		metrics.Sent(metrics.SendFailed)
+		return err
+	}
+	metrics.Sent(metrics.SendSent)
+	return nil
+}
+
+func (c Client) send(from, to, data string, relayOverride *url.URL) error {
 	log := c.log.With().Str("from", from).Str("to", to).Logger()
 	log.Debug().Msg("sending email")
 
@@ -94,7 +117,7 @@ func (c Client) Send(from, to, data string, relayOverride *url.URL) error {
 
 // createRelayClientconnects directly to the provided smtp host
 func (c *Client) createRelayClient(config *RelayConfig, from, to string) (*smtp.Client, error) {
-	localname := strings.SplitN(from, "@", 2)[1]
+	localname := c.localname(from)
 	target := config.Host + ":" + config.Port
 	conn, err := smtp.Dial(target)
 	if err != nil {
@@ -135,7 +158,7 @@ func (c *Client) createRelayClient(config *RelayConfig, from, to string) (*smtp.
 }
 
 func (c *Client) createDirectClient(from, to string) (*smtp.Client, error) {
-	localname := strings.SplitN(from, "@", 2)[1]
+	localname := c.localname(from)
 	hostname := strings.SplitN(to, "@", 2)[1]
 	client, cerr := c.trySMTP(localname, hostname)
 	if client == nil {
@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"net"
-	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -12,34 +11,48 @@ import (
 
 	"github.com/rs/zerolog"
 
+	"github.com/etkecc/postmoogle/internal/email"
+	"github.com/etkecc/postmoogle/internal/metrics"
 	"github.com/etkecc/postmoogle/internal/utils"
 )
 
 const (
 	// DNSBLTimeout is the timeout for DNSBL requests
 	DNSBLTimeout = 5 * time.Second
-	// DNSBLDefaultSignal is the default signal for DNSBLs
-	DNSBLDefaultSignal = "127.0.0.2"
+	// DNSBLCacheTTL is how long DNSBL results are cached per IP and zone
+	DNSBLCacheTTL = 30 * time.Minute
 )
 
-// DNSBLs is a list of Domain Name System Blacklists with list of signals they use
-var DNSBLs = map[string][]string{
-	"b.barracudacentral.org":  {DNSBLDefaultSignal},
-	"bl.spamcop.net":          {DNSBLDefaultSignal, "127.0.0.3"},
-	"ix.dnsbl.manitu.net":     {DNSBLDefaultSignal},
-	"psbl.surriel.com":        {DNSBLDefaultSignal},
-	"rbl.interserver.net":     {DNSBLDefaultSignal},
-	"spam.dnsbl.anonmails.de": {DNSBLDefaultSignal},
-	"zen.spamhaus.org":        {DNSBLDefaultSignal, "127.0.0.3", "127.0.0.4", "127.0.0.5", "127.0.0.6", "127.0.0.7", "127.0.0.9"},
-	"rbl.your-server.de":      {DNSBLDefaultSignal},
+// dnsblResolver is used for DNSBL lookups, *net.Resolver implements it
+type dnsblResolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// DNSBLChecker checks if IP addresses are listed in DNSBLs, caching the results
+type DNSBLChecker struct {
+	resolver dnsblResolver
+	timeout  time.Duration
+	ttl      time.Duration
+
+	mu        sync.Mutex
+	cache     map[string]*dnsblCacheItem
+	lastPrune time.Time
+}
+
+type dnsblCacheItem struct {
+	result  *DNSBLResult
+	expires time.Time
 }
 
 // DNSBLRequest is a request to check if an IP address is listed in any of the DNSBLs
 type DNSBLRequest struct {
 	ctx     context.Context //nolint:containedctx // this is a request struct
 	log     *zerolog.Logger
+	checker *DNSBLChecker
 	addr    net.Addr
-	ttl     time.Duration
+	zones   []*email.DNSBLZone
+	mu      sync.Mutex
 	results []*DNSBLResult
 	wg      *sync.WaitGroup
 }
@@ -51,25 +64,43 @@ type DNSBLResult struct {
 	Error   bool
 }
 
-// CheckDNSBLs checks if the given IP address is listed in any of the DNSBLs, and returns a decision, based on the results
-func CheckDNSBLs(ctx context.Context, log *zerolog.Logger, addr net.Addr, optionalTimeout ...time.Duration) (blocked bool, reasons []string) {
-	ttl := DNSBLTimeout
+// NewDNSBLChecker creates new DNSBL checker with results cache shared across sessions
+func NewDNSBLChecker(ttl time.Duration, optionalTimeout ...time.Duration) *DNSBLChecker {
+	timeout := DNSBLTimeout
 	if len(optionalTimeout) > 0 {
-		ttl = optionalTimeout[0]
+		timeout = optionalTimeout[0]
+	}
+	return &DNSBLChecker{
+		resolver: net.DefaultResolver,
+		timeout:  timeout,
+		ttl:      ttl,
+		cache:    map[string]*dnsblCacheItem{},
+	}
+}
+
+// Check checks if the given IP address is listed in any of the DNSBLs, and returns a decision, based on the results
+func (c *DNSBLChecker) Check(ctx context.Context, log *zerolog.Logger, addr net.Addr, options *email.DNSBLOptions) (blocked bool, reasons []string) {
+	if options == nil || len(options.Zones) == 0 {
+		return false, nil
 	}
 	logger := log.With().Str("addr", addr.String()).Logger()
 
 	req := &DNSBLRequest{
 		ctx:     ctx,
 		log:     &logger,
+		checker: c,
 		addr:    addr,
-		ttl:     ttl,
-		results: make([]*DNSBLResult, 0, len(DNSBLs)),
+		zones:   options.Zones,
+		results: make([]*DNSBLResult, 0, len(options.Zones)),
 		wg:      &sync.WaitGroup{},
 	}
 	req.check()
+	weights := make(map[string]int, len(options.Zones))
+	for _, zone := range options.Zones {
+		weights[zone.Name] = zone.Weight
+	}
 	total := len(req.results)
-	var listed, unlisted int
+	var listed, unlisted, weight int
 	var listedRBLs, unlistedRBLs, failedRBLs []string
 	for _, r := range req.results {
 		if r.Error {
@@ -78,7 +109,10 @@ func CheckDNSBLs(ctx context.Context, log *zerolog.Logger, addr net.Addr, option
 		}
 		if r.Listed {
 			listed++
-			reasons = append(reasons, r.Reasons)
+			weight += weights[r.RBL]
+			if r.Reasons != "" {
+				reasons = append(reasons, r.Reasons)
+			}
 			listedRBLs = append(listedRBLs, r.RBL)
 		} else {
 			unlisted++
@@ -86,34 +120,86 @@ func CheckDNSBLs(ctx context.Context, log *zerolog.Logger, addr net.Addr, option
 		}
 	}
 
-	decision := req.decision(total, listed, unlisted)
+	decision := req.decision(total, listed, unlisted, weight, options.Threshold)
 	logger.Info().
 		Int("listed_in", listed).
 		Strs("listed_rbls", listedRBLs).
+		Int("listed_weight", weight).
 		Int("unlisted_in", unlisted).
 		Strs("unlisted_rbls", unlistedRBLs).
 		Strs("failed_rbls", failedRBLs).
 		Int("total", total).
+		Int("threshold", options.Threshold).
 		Bool("blocked", decision).
 		Msg("DNSBL results")
 
 	return decision, reasons
 }
 
+// get cached result
+func (c *DNSBLChecker) get(ip, rbl string) *DNSBLResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, ok := c.cache[ip+" "+rbl]
+	if !ok || time.Now().After(item.expires) {
+		return nil
+	}
+	return item.result
+}
+
+// set result to the cache, errors are not cached
+func (c *DNSBLChecker) set(ip string, result *DNSBLResult) {
+	if result.Error || c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.cache[ip+" "+result.RBL] = &dnsblCacheItem{result: result, expires: now.Add(c.ttl)}
+	if now.Sub(c.lastPrune) < c.ttl {
+		return
+	}
+	c.lastPrune = now
+	for key, item := range c.cache {
+		if now.After(item.expires) {
+			delete(c.cache, key)
+		}
+	}
+}
+
 // check checks if the given IP address is listed in any of the DNSBLs
 func (req *DNSBLRequest) check() {
-	req.wg.Add(len(DNSBLs))
+	req.wg.Add(len(req.zones))
 	defer req.wg.Wait()
 
-	for rbl, signals := range DNSBLs {
-		go req.checkRBL(rbl, signals)
+	for _, zone := range req.zones {
+		go req.checkRBL(zone.Name, zone.Signals)
 	}
 }
 
+// addResult adds result of a single DNSBL check, safe for concurrent use
+func (req *DNSBLRequest) addResult(result *DNSBLResult) {
+	req.checker.set(utils.AddrIP(req.addr), result)
+
+	req.mu.Lock()
+	defer req.mu.Unlock()
+	req.results = append(req.results, result)
+}
+
 func (req *DNSBLRequest) checkRBL(rbl string, signals []string) {
 	defer req.wg.Done()
 
-	ctx, cancel := context.WithTimeout(req.ctx, req.ttl)
+	if cached := req.checker.get(utils.AddrIP(req.addr), rbl); cached != nil {
+		req.log.Debug().Str("rbl", rbl).Bool("listed", cached.Listed).Msg("cached")
+		req.mu.Lock()
+		req.results = append(req.results, cached)
+		req.mu.Unlock()
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.ctx, req.checker.timeout)
 	defer cancel()
 
 	host := req.getHost(rbl)
@@ -121,38 +207,40 @@ func (req *DNSBLRequest) checkRBL(rbl string, signals []string) {
 	log.Debug().Msg("checking")
 
 	// first, check if the host is mentioned in the RBL ("listed" status can be set _only_ if the signal will match)
-	ips, err := net.DefaultResolver.LookupHost(ctx, host)
+	start := time.Now()
+	ips, err := req.checker.resolver.LookupHost(ctx, host)
+	metrics.DNSBLLookup(rbl, start)
 	var dnsErr *net.DNSError
 	if err != nil {
 		// not found = not listed
 		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
-			req.results = append(req.results, &DNSBLResult{RBL: rbl, Listed: false})
+			req.addResult(&DNSBLResult{RBL: rbl, Listed: false})
 			return
 		}
 		// other errors = unknown status
-		req.results = append(req.results, &DNSBLResult{RBL: rbl, Error: true})
+		req.addResult(&DNSBLResult{RBL: rbl, Error: true})
 		return
 	}
 	// if the host is resolved, check if there is any signal in the response
 	// if not = not listed
 	if len(ips) == 0 {
-		req.results = append(req.results, &DNSBLResult{RBL: rbl, Listed: false})
+		req.addResult(&DNSBLResult{RBL: rbl, Listed: false})
 		return
 	}
 
 	// if there is a DNS entry, check if it matches any of the signals
 	for _, ip := range ips {
 		// if the signal is found = listed
-		if slices.Contains(signals, ip) {
+		if matchSignal(signals, ip) {
 			// get TXT records for the host, just additional information
-			txts, _ := net.DefaultResolver.LookupTXT(ctx, host) //nolint:errcheck // the host is listed for sure, just no information about it
-			req.results = append(req.results, &DNSBLResult{RBL: rbl, Listed: true, Reasons: strings.Join(txts, "; ")})
+			txts, _ := req.checker.resolver.LookupTXT(ctx, host) //nolint:errcheck // the host is listed for sure, just no information about it
+			req.addResult(&DNSBLResult{RBL: rbl, Listed: true, Reasons: strings.Join(txts, "; ")})
 			log.Debug().Str("signal", ip).Msg("listed")
 			return
 		}
 	}
 	// if no signal is found = not listed, despite the host being resolved
-	req.results = append(req.results, &DNSBLResult{RBL: rbl, Listed: false})
+	req.addResult(&DNSBLResult{RBL: rbl, Listed: false})
 }
 
 // getHost returns the host name for the given DNSBL list
@@ -191,11 +279,32 @@ func (req *DNSBLRequest) getHost(list string) string {
 	return b.String()
 }
 
-// decision returns a decision based on the DNSBL results
-func (req *DNSBLRequest) decision(total, listed, unlisted int) bool {
+// decision returns a decision based on the DNSBL results.
+// If threshold is set, the total weight of listing zones is compared with it
+func (req *DNSBLRequest) decision(total, listed, unlisted, weight, threshold int) bool {
 	if total == 0 || listed == 0 {
 		return false
 	}
+	if threshold > 0 {
+		return weight >= threshold
+	}
 
 	return listed > 1 || listed > unlisted
 }
+
+// matchSignal checks if the DNSBL response matches any of the signals (IP or CIDR)
+func matchSignal(signals []string, response string) bool {
+	ip := net.ParseIP(response)
+	for _, signal := range signals {
+		if signal == response {
+			return true
+		}
+		if !strings.Contains(signal, "/") || ip == nil {
+			continue
+		}
+		if _, network, err := net.ParseCIDR(signal); err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
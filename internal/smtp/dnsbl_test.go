@@ -0,0 +1,167 @@
+package smtp
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"github.com/etkecc/postmoogle/internal/email"
+)
+
+// fakeDNSBLResolver is an offline DNSBL resolver, counting lookups
+type fakeDNSBLResolver struct {
+	hosts   map[string][]string
+	lookups atomic.Int32
+}
+
+func (r *fakeDNSBLResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	r.lookups.Add(1)
+	ips, ok := r.hosts[strings.TrimSuffix(host, ".")]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return ips, nil
+}
+
+func (r *fakeDNSBLResolver) LookupTXT(_ context.Context, host string) ([]string, error) {
+	return []string{"listed in " + strings.TrimSuffix(host, ".")}, nil
+}
+
+func newTestDNSBLChecker(hosts map[string][]string) (*DNSBLChecker, *fakeDNSBLResolver) {
+	resolver := &fakeDNSBLResolver{hosts: hosts}
+	checker := NewDNSBLChecker(DNSBLCacheTTL)
+	checker.resolver = resolver
+	return checker, resolver
+}
+
+func TestDNSBLCheck(t *testing.T) {
+	log := zerolog.Nop()
+	addr := &net.TCPAddr{IP: net.ParseIP("192.0.2.1")}
+	hosts := map[string][]string{
+		"1.2.0.192.a.example": {"127.0.0.2"},
+		"1.2.0.192.b.example": {"127.0.0.3"},
+		"1.2.0.192.c.example": {"127.0.1.10"},
+	}
+	zones := func(weights map[string]int, signals ...string) []*email.DNSBLZone {
+		list := []*email.DNSBLZone{}
+		for _, name := range []string{"a.example", "b.example", "c.example", "d.example"} {
+			if weight, ok := weights[name]; ok {
+				list = append(list, &email.DNSBLZone{Name: name, Weight: weight, Signals: signals})
+			}
+		}
+		return list
+	}
+
+	tests := map[string]struct {
+		options *email.DNSBLOptions
+		blocked bool
+		reasons int
+	}{
+		"no options": {nil, false, 0},
+		"single zone, legacy": {
+			options: &email.DNSBLOptions{Zones: zones(map[string]int{"a.example": 1, "d.example": 1}, "127.0.0.2")},
+			blocked: false, reasons: 1,
+		},
+		"most zones, legacy": {
+			options: &email.DNSBLOptions{Zones: zones(map[string]int{"a.example": 1}, "127.0.0.2")},
+			blocked: true, reasons: 1,
+		},
+		"signal mismatch": {
+			options: &email.DNSBLOptions{Zones: zones(map[string]int{"b.example": 1}, "127.0.0.2")},
+			blocked: false, reasons: 0,
+		},
+		"cidr signal": {
+			options: &email.DNSBLOptions{Zones: zones(map[string]int{"c.example": 1}, "127.0.1.0/24")},
+			blocked: true, reasons: 1,
+		},
+		"weight below threshold": {
+			options: &email.DNSBLOptions{Zones: zones(map[string]int{"a.example": 2, "b.example": 2, "d.example": 5}, "127.0.0.0/24"), Threshold: 5},
+			blocked: false, reasons: 2,
+		},
+		"weight reaches threshold": {
+			options: &email.DNSBLOptions{Zones: zones(map[string]int{"a.example": 3, "b.example": 2, "d.example": 5}, "127.0.0.0/24"), Threshold: 5},
+			blocked: true, reasons: 2,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			checker, _ := newTestDNSBLChecker(hosts)
+			blocked, reasons := checker.Check(context.Background(), &log, addr, test.options)
+			if blocked != test.blocked {
+				t.Error(test.blocked, "!=", blocked)
+			}
+			if len(reasons) != test.reasons {
+				t.Error(test.reasons, "!=", len(reasons), reasons)
+			}
+		})
+	}
+}
+
+func TestDNSBLCache(t *testing.T) {
+	log := zerolog.Nop()
+	checker, resolver := newTestDNSBLChecker(map[string][]string{"1.2.0.192.a.example": {"127.0.0.2"}})
+	options := &email.DNSBLOptions{Zones: []*email.DNSBLZone{
+		{Name: "a.example", Weight: 1, Signals: []string{"127.0.0.2"}},
+		{Name: "b.example", Weight: 1, Signals: []string{"127.0.0.2"}},
+	}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addr := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1000 + i}
+			if blocked, _ := checker.Check(context.Background(), &log, addr, options); blocked {
+				t.Error("host listed in 1 of 2 zones must not be blocked")
+			}
+		}()
+	}
+	wg.Wait()
+	before := resolver.lookups.Load()
+
+	addr := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 25}
+	blocked, reasons := checker.Check(context.Background(), &log, addr, options)
+	if blocked {
+		t.Error("host listed in 1 of 2 zones must not be blocked")
+	}
+	if len(reasons) != 1 {
+		t.Error(1, "!=", len(reasons))
+	}
+	if after := resolver.lookups.Load(); after != before {
+		t.Error("cached results must be used,", before, "!=", after)
+	}
+
+	options.Threshold = 1
+	if blocked, _ := checker.Check(context.Background(), &log, addr, options); !blocked {
+		t.Error("threshold change must apply to cached results")
+	}
+}
+
+func TestMatchSignal(t *testing.T) {
+	tests := map[string]struct {
+		signals  []string
+		response string
+		result   bool
+	}{
+		"exact":        {[]string{"127.0.0.2"}, "127.0.0.2", true},
+		"other":        {[]string{"127.0.0.2"}, "127.0.0.3", false},
+		"cidr":         {[]string{"127.0.0.0/29"}, "127.0.0.7", true},
+		"outside cidr": {[]string{"127.0.0.0/29"}, "127.0.0.8", false},
+		"invalid":      {[]string{"127.0.0.0/99"}, "127.0.0.1", false},
+		"empty":        {nil, "127.0.0.2", false},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if actual := matchSignal(test.signals, test.response); actual != test.result {
+				t.Error(test.result, "!=", actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package smtp
+
+import (
+	"slices"
+	"sync"
+)
+
+// domainList is the list of served domains, shared between the SMTP and LMTP servers and updated at runtime
+type domainList struct {
+	mu   sync.RWMutex
+	list []string
+}
+
+func newDomainList(domains []string) *domainList {
+	return &domainList{list: slices.Clone(domains)}
+}
+
+// get returns copy of the list, so it can be used without locking
+func (d *domainList) get() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return slices.Clone(d.list)
+}
+
+// has checks if the domain is served
+func (d *domainList) has(domain string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return slices.Contains(d.list, domain)
+}
+
+// set replaces the list and returns domains that were not served before
+func (d *domainList) set(domains []string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	added := []string{}
+	for _, domain := range domains {
+		if !slices.Contains(d.list, domain) {
+			added = append(added, domain)
+		}
+	}
+	d.list = slices.Clone(domains)
+	return added
+}
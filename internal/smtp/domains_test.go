@@ -0,0 +1,32 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestDomainListSet(t *testing.T) {
+	domains := newDomainList([]string{"example.com"})
+	acme := newACMEManager(&ACMEConfig{}, domains)
+
+	added := domains.set([]string{"example.com", "example.org"})
+	if !slices.Equal(added, []string{"example.org"}) {
+		t.Error("unexpected added domains", added)
+	}
+	if !domains.has("example.org") {
+		t.Error("added domain is not served")
+	}
+	if err := acme.hostPolicy(context.Background(), "Example.org"); err != nil {
+		t.Error("added domain is not allowed by ACME host policy", err)
+	}
+
+	domains.set([]string{"example.com"})
+	if domains.has("example.org") {
+		t.Error("removed domain is still served")
+	}
+	if err := acme.hostPolicy(context.Background(), "example.org"); !errors.Is(err, ErrACMEHost) {
+		t.Error("removed domain is allowed by ACME host policy", err)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"strings"
 
 	"github.com/emersion/go-smtp"
+
+	"github.com/etkecc/postmoogle/internal/email"
 )
 
 const (
@@ -18,6 +20,18 @@ const (
 	RBLCode = 450
 	// AuthRequiredCode SMTP code (RFC 4954)
 	AuthRequiredCode = 530
+	// DMARCCode SMTP code (RFC 7372)
+	DMARCCode = 550
+	// SpamCode SMTP code
+	SpamCode = 550
+	// VirusCode SMTP code
+	VirusCode = 554
+	// AntivirusUnavailableCode SMTP code
+	AntivirusUnavailableCode = 451
+	// TooLargeToScanCode SMTP code
+	TooLargeToScanCode = 552
+	// RejectCode SMTP code (RFC 5429)
+	RejectCode = 550
 )
 
 var (
@@ -61,6 +75,48 @@ var (
 		EnhancedCode: AuthRequiredEnhancedCode,
 		Message:      "authentication required, kupo.",
 	}
+	// DMARCEnhancedCode is DMARCCode in enhanced code notation
+	DMARCEnhancedCode = smtp.EnhancedCode{5, 7, 1}
+	// ErrDMARC returned when the email failed DMARC check and the sender domain's policy is reject
+	ErrDMARC = &smtp.SMTPError{
+		Code:         DMARCCode,
+		EnhancedCode: DMARCEnhancedCode,
+		Message:      "rejected due to DMARC policy of the sender domain, kupo.",
+	}
+	// SpamEnhancedCode is SpamCode in enhanced code notation
+	SpamEnhancedCode = smtp.EnhancedCode{5, 7, 1}
+	// ErrSpam returned when the content scanner score reached the reject threshold
+	ErrSpam = &smtp.SMTPError{
+		Code:         SpamCode,
+		EnhancedCode: SpamEnhancedCode,
+		Message:      "message rejected as spam, kupo.",
+	}
+	// VirusEnhancedCode is VirusCode in enhanced code notation
+	VirusEnhancedCode = smtp.EnhancedCode{5, 7, 1}
+	// ErrVirus returned when the email contains infected files
+	ErrVirus = &smtp.SMTPError{
+		Code:         VirusCode,
+		EnhancedCode: VirusEnhancedCode,
+		Message:      "message contains a virus, kupo.",
+	}
+	// AntivirusUnavailableEnhancedCode is AntivirusUnavailableCode in enhanced code notation
+	AntivirusUnavailableEnhancedCode = smtp.EnhancedCode{4, 3, 0}
+	// ErrAntivirusUnavailable returned when the attachments cannot be scanned (e.g., clamd is down)
+	ErrAntivirusUnavailable = &smtp.SMTPError{
+		Code:         AntivirusUnavailableCode,
+		EnhancedCode: AntivirusUnavailableEnhancedCode,
+		Message:      "cannot scan attachments, try again a bit later.",
+	}
+	// TooLargeToScanEnhancedCode is TooLargeToScanCode in enhanced code notation
+	TooLargeToScanEnhancedCode = smtp.EnhancedCode{5, 3, 4}
+	// ErrTooLargeToScan returned when the attachments exceed the antivirus size limit, so they will never be scanned
+	ErrTooLargeToScan = &smtp.SMTPError{
+		Code:         TooLargeToScanCode,
+		EnhancedCode: TooLargeToScanEnhancedCode,
+		Message:      "attachments are too large to be scanned, kupo.",
+	}
+	// RejectEnhancedCode is RejectCode in enhanced code notation
+	RejectEnhancedCode = smtp.EnhancedCode{5, 7, 1}
 	// ErrInvalidEmail for invalid emails :)
 	ErrInvalidEmail = errors.New("please, provide valid email address")
 )
@@ -73,3 +129,21 @@ func extendErrRBL(reasons []string) *smtp.SMTPError {
 		Message:      "You are blacklisted, kupo. Details: " + strings.Join(reasons, "; "),
 	}
 }
+
+// rejectError converts the bot's rejection (e.g., Sieve `reject` action) into SMTP error with the reason,
+// other errors are returned as is
+func rejectError(err error) error {
+	var rerr *email.RejectError
+	if !errors.As(err, &rerr) {
+		return err
+	}
+	message := strings.Join(strings.Fields(rerr.Reason), " ")
+	if message == "" {
+		message = "message rejected by the recipient, kupo."
+	}
+	return &smtp.SMTPError{
+		Code:         RejectCode,
+		EnhancedCode: RejectEnhancedCode,
+		Message:      message,
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"sync"
 
 	"github.com/rs/zerolog"
+
+	"github.com/etkecc/postmoogle/internal/metrics"
 )
 
 // Listener that rejects connections from banned hosts
@@ -17,11 +19,16 @@ type Listener struct {
 	tlsMu    sync.Mutex
 	listener net.Listener
 	isBanned func(context.Context, net.Addr) bool
+	// proxy protocol is accepted only from trusted proxies
+	proxyProtocol bool
+	isTrusted     func(net.Addr) bool
 }
 
 func NewListener(
 	port string,
 	tlsConfig *tls.Config,
+	proxyProtocol bool,
+	isTrusted func(net.Addr) bool,
 	isBanned func(context.Context, net.Addr) bool,
 	log *zerolog.Logger,
 ) (*Listener, error) {
@@ -36,6 +43,9 @@ func NewListener(
 		tls:      tlsConfig,
 		listener: actual,
 		isBanned: isBanned,
+
+		proxyProtocol: proxyProtocol,
+		isTrusted:     isTrusted,
 	}, nil
 }
 
@@ -58,16 +68,14 @@ func (l *Listener) Accept() (net.Conn, error) {
 				continue
 			}
 		}
-		ctx := context.Background()
-		log := l.log.With().Str("addr", conn.RemoteAddr().String()).Logger()
-		if l.isBanned(ctx, conn.RemoteAddr()) {
+		if l.proxyProtocol && l.isTrusted(conn.RemoteAddr()) {
+			// the header is parsed in the connection's goroutine, so a slow proxy doesn't block other connections
+			conn = newProxyConn(conn, ProxyHeaderTimeout, l.acceptProxied(conn.RemoteAddr()))
+		} else if !l.allow(conn.RemoteAddr()) {
 			conn.Close()
-			log.Info().Msg("rejected connection (already banned)")
 			continue
 		}
 
-		log.Info().Msg("accepted connection")
-
 		if l.tls != nil {
 			return l.acceptTLS(conn)
 		}
@@ -75,6 +83,35 @@ func (l *Listener) Accept() (net.Conn, error) {
 	}
 }
 
+// allow checks if the host is not banned, and counts the connection
+func (l *Listener) allow(addr net.Addr) bool {
+	log := l.log.With().Str("addr", addr.String()).Logger()
+	if l.isBanned(context.Background(), addr) {
+		log.Info().Msg("rejected connection (already banned)")
+		metrics.SMTPRejected(metrics.ReasonBanned)
+		return false
+	}
+
+	log.Info().Msg("accepted connection")
+	metrics.SMTPAccepted()
+	return true
+}
+
+// acceptProxied returns the check of the real client address, received from the trusted proxy
+func (l *Listener) acceptProxied(proxy net.Addr) func(net.Addr, error) error {
+	return func(addr net.Addr, err error) error {
+		if err != nil {
+			l.log.Warn().Err(err).Str("proxy", proxy.String()).Msg("rejected connection (invalid PROXY protocol header)")
+			return err
+		}
+		l.log.Debug().Str("proxy", proxy.String()).Str("addr", addr.String()).Msg("real address (PROXY protocol)")
+		if !l.allow(addr) {
+			return ErrBanned
+		}
+		return nil
+	}
+}
+
 func (l *Listener) acceptTLS(conn net.Conn) (net.Conn, error) {
 	l.tlsMu.Lock()
 	defer l.tlsMu.Unlock()
@@ -3,8 +3,10 @@ package smtp
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"net"
 	"net/url"
+	"os"
 	"sync"
 	"time"
 
@@ -17,20 +19,35 @@ import (
 	"github.com/etkecc/postmoogle/internal/email"
 )
 
+// Listener names
+const (
+	ListenerSMTP  = "smtp"
+	ListenerSMTPS = "smtps"
+	ListenerLMTP  = "lmtp"
+)
+
 type Config struct {
 	Domains []string
 	Port    string
 
+	ProxyProtocol bool
+
+	LMTPAddr string
+
 	TLSCerts    []string
 	TLSKeys     []string
+	TLSDir      string
 	TLSPort     string
 	TLSRequired bool
+	ACME        *ACMEConfig
 
 	Logger  *zerolog.Logger
 	MaxSize int
 	Bot     matrixbot
 	Callers []Caller
 	Relay   *RelayConfig
+	Scanner *ScannerConfig
+	ClamAV  *ClamAV
 }
 
 type TLSConfig struct {
@@ -38,8 +55,11 @@ type TLSConfig struct {
 	Config   *tls.Config
 	Certs    []string
 	Keys     []string
+	Dir      string
 	Port     string
 	Mu       sync.Mutex
+
+	store certStore
 }
 
 type RelayConfig struct {
@@ -50,28 +70,43 @@ type RelayConfig struct {
 }
 
 type Manager struct {
-	log  *zerolog.Logger
-	bot  matrixbot
-	fsw  *fswatcher.Watcher
-	smtp *smtp.Server
-	errs chan error
+	log    *zerolog.Logger
+	bot    matrixbot
+	clamav *ClamAV
+	fsw    *fswatcher.Watcher
+	acme   *acmeManager
+	smtp   *smtp.Server
+	lmtp   *smtp.Server
+	errs   chan error
+
+	port          string
+	lmtpAddr      string
+	domains       *domainList
+	proxyProtocol bool
+	tls           TLSConfig
 
-	port string
-	tls  TLSConfig
+	// listening status of the listeners (smtp, smtps, lmtp): true if bound and serving
+	listeningMu sync.Mutex
+	listening   map[string]bool
 }
 
 type matrixbot interface {
 	AllowAuth(context.Context, string, string) (id.RoomID, bool)
-	IsGreylisted(context.Context, net.Addr) bool
+	IsGreylisted(context.Context, net.Addr, string, []string, []string) bool
+	IsSpamGreylisted(context.Context, net.Addr, string, []string, []string) bool
 	IsBanned(context.Context, net.Addr) bool
 	IsTrusted(net.Addr) bool
 	BanAuto(context.Context, net.Addr)
 	BanAuth(context.Context, net.Addr)
 	GetMapping(context.Context, string) (id.RoomID, bool)
 	GetIFOptions(context.Context, id.RoomID) email.IncomingFilteringOptions
+	GetDNSBLOptions(context.Context) *email.DNSBLOptions
 	IncomingEmail(context.Context, *email.Email) error
-	GetDKIMprivkey(context.Context) string
+	GetDKIMKeys(context.Context, string) []email.DKIMKey
 	GetRelayConfig(context.Context, id.RoomID) *url.URL
+	ReverseSRS(context.Context, string) (string, bool)
+	EmailSent(context.Context, id.RoomID, *email.Email, error)
+	EmailRejected(context.Context, *email.Email, error)
 }
 
 // Caller is Sendmail caller
@@ -81,51 +116,60 @@ type Caller interface {
 
 // NewManager creates new SMTP server manager
 func NewManager(cfg *Config) *Manager {
+	var hostname string
+	if len(cfg.Domains) > 0 {
+		hostname = cfg.Domains[0]
+	}
+	domains := newDomainList(cfg.Domains)
 	mailsrv := &mailServer{
 		log:     cfg.Logger,
 		bot:     cfg.Bot,
-		domains: cfg.Domains,
-		sender:  newClient(cfg.Relay, cfg.Logger),
+		domains: domains,
+		sender:  newClient(cfg.Relay, hostname, cfg.Logger),
+		dnsbl:   NewDNSBLChecker(DNSBLCacheTTL),
 	}
+	scanner, err := NewScanner(cfg.Scanner)
+	if err != nil {
+		cfg.Logger.Error().Err(err).Msg("cannot initialize content scanner")
+	}
+	mailsrv.scanner = scanner
+	mailsrv.clamav = cfg.ClamAV
 	for _, caller := range cfg.Callers {
 		caller.SetSendmail(mailsrv.sender.Send)
 	}
 
-	s := smtp.NewServer(mailsrv)
-	s.ErrorLog = loggerWrapper{func(s string, i ...any) {
-		cfg.Logger.Warn().Msgf(s, i...)
-	}}
-	s.ReadTimeout = 10 * time.Second
-	s.WriteTimeout = 10 * time.Second
-	s.MaxMessageBytes = int64(cfg.MaxSize * 1024 * 1024)
+	s := newServer(cfg, mailsrv)
 	s.AllowInsecureAuth = !cfg.TLSRequired
 	s.EnableREQUIRETLS = cfg.TLSRequired
-	s.EnableSMTPUTF8 = true
-	// set domain in greeting only in single-domain mode
-	if len(cfg.Domains) == 1 {
-		s.Domain = cfg.Domains[0]
-	}
-	loglevel := cfg.Logger.GetLevel()
-	if loglevel == zerolog.InfoLevel || loglevel == zerolog.DebugLevel || loglevel == zerolog.TraceLevel {
-		s.Debug = loggerWriter{func(s string) { cfg.Logger.Info().Msg(s) }}
-	}
 
-	fsw, err := fswatcher.New(append(cfg.TLSCerts, cfg.TLSKeys...), 0)
+	watchlist := append(cfg.TLSCerts, cfg.TLSKeys...) //nolint:gocritic // that's intended
+	watchlist = append(watchlist, tlsDirWatchlist(cfg.TLSDir, cfg.Domains)...)
+	fsw, err := fswatcher.New(watchlist, 0)
 	if err != nil {
 		cfg.Logger.Error().Err(err).Msg("cannot start FS watcher")
 	}
 
 	m := &Manager{
-		smtp: s,
-		bot:  cfg.Bot,
-		log:  cfg.Logger,
-		fsw:  fsw,
-		port: cfg.Port,
+		smtp:     s,
+		bot:      cfg.Bot,
+		log:      cfg.Logger,
+		clamav:   cfg.ClamAV,
+		fsw:      fsw,
+		port:     cfg.Port,
+		lmtp:     newLMTPServer(cfg, mailsrv),
+		lmtpAddr: cfg.LMTPAddr,
+		domains:  domains,
 		tls: TLSConfig{
 			Certs: cfg.TLSCerts,
 			Keys:  cfg.TLSKeys,
+			Dir:   cfg.TLSDir,
 			Port:  cfg.TLSPort,
 		},
+		proxyProtocol: cfg.ProxyProtocol,
+		listening:     map[string]bool{},
+	}
+	if cfg.ACME != nil && cfg.ACME.Enabled {
+		m.acme = newACMEManager(cfg.ACME, domains)
 	}
 
 	m.tls.Mu.Lock()
@@ -137,26 +181,107 @@ func NewManager(cfg *Config) *Manager {
 			m.tls.Mu.Lock()
 			defer m.tls.Mu.Unlock()
 
-			ok := m.loadTLSConfig()
-			if ok {
-				m.tls.Listener.SetTLSConfig(m.tls.Config)
-			}
+			// certificates are selected by SNI from the store, so the TLS config itself stays the same
+			m.loadTLSConfig()
 		})
 	}
 	return m
 }
 
+// CheckFiles scans attachments of the email received earlier (e.g., released from quarantine) with ClamAV,
+// the same way as attachments of incoming emails are scanned
+func (m *Manager) CheckFiles(ctx context.Context, eml *email.Email, roomID id.RoomID) error {
+	return checkFiles(ctx, m.log, m.clamav, m.bot, eml, roomID)
+}
+
+// newServer creates go-smtp server with common settings
+func newServer(cfg *Config, backend smtp.Backend) *smtp.Server {
+	s := smtp.NewServer(backend)
+	s.ErrorLog = loggerWrapper{func(s string, i ...any) {
+		cfg.Logger.Warn().Msgf(s, i...)
+	}}
+	s.ReadTimeout = 10 * time.Second
+	s.WriteTimeout = 10 * time.Second
+	s.MaxMessageBytes = int64(cfg.MaxSize * 1024 * 1024)
+	s.EnableSMTPUTF8 = true
+	// set domain in greeting only in single-domain mode
+	if len(cfg.Domains) == 1 {
+		s.Domain = cfg.Domains[0]
+	}
+	loglevel := cfg.Logger.GetLevel()
+	if loglevel == zerolog.InfoLevel || loglevel == zerolog.DebugLevel || loglevel == zerolog.TraceLevel {
+		s.Debug = loggerWriter{func(s string) { cfg.Logger.Info().Msg(s) }}
+	}
+	return s
+}
+
+// newLMTPServer creates LMTP server (if enabled) to receive emails from a front MTA, e.g. Postfix.
+// Authentication (thus sending) is not available over LMTP.
+// The peer is the front MTA, not the sender, so the sender checks (SPF, RBL, greylisting, bans, etc.)
+// would be applied to the front MTA's address - they are disabled, the front MTA is responsible for them
+func newLMTPServer(cfg *Config, mailsrv *mailServer) *smtp.Server {
+	if cfg.LMTPAddr == "" {
+		return nil
+	}
+	lmtpsrv := &mailServer{
+		log:      mailsrv.log,
+		bot:      mailsrv.bot,
+		domains:  mailsrv.domains,
+		sender:   mailsrv.sender,
+		dnsbl:    mailsrv.dnsbl,
+		scanner:  mailsrv.scanner,
+		clamav:   mailsrv.clamav,
+		lmtp:     true,
+		nochecks: true,
+	}
+	s := newServer(cfg, lmtpsrv)
+	s.LMTP = true
+	s.AllowInsecureAuth = false
+	return s
+}
+
 // Start SMTP server
 func (m *Manager) Start() error {
 	m.errs = make(chan error, 1)
+	if m.acme != nil {
+		m.acme.start(m.errs, func(domain string, err error) {
+			if err != nil {
+				m.log.Error().Err(err).Str("domain", domain).Msg("cannot obtain ACME certificate")
+				return
+			}
+			m.log.Info().Str("domain", domain).Msg("ACME certificate is ready")
+		})
+	}
+	m.setListening(ListenerSMTP, false)
 	go m.listen(m.port, nil)
+	if m.lmtp != nil {
+		m.setListening(ListenerLMTP, false)
+		go m.listenLMTP()
+	}
 	if m.tls.Config != nil {
+		m.setListening(ListenerSMTPS, false)
 		go m.listen(m.tls.Port, m.tls.Config)
 	}
 
 	return <-m.errs
 }
 
+// SetDomains updates the served domains at runtime,
+// certificates of the new domains are issued in advance if ACME is enabled
+func (m *Manager) SetDomains(domains []string) {
+	added := m.domains.set(domains)
+	m.log.Info().Strs("domains", domains).Strs("added", added).Msg("served domains have been updated")
+	if m.acme != nil && len(added) > 0 {
+		go m.acme.issue(added, func(domain string, err error) {
+			if err != nil {
+				m.log.Error().Err(err).Str("domain", domain).Msg("cannot obtain ACME certificate")
+				return
+			}
+			m.log.Info().Str("domain", domain).Msg("ACME certificate is ready")
+		})
+	}
+}
+
 // Stop SMTP server
 func (m *Manager) Stop() {
 	err := m.fsw.Stop()
@@ -164,16 +289,60 @@ func (m *Manager) Stop() {
 		m.log.Error().Err(err).Msg("cannot stop filesystem watcher properly")
 	}
 
+	if m.acme != nil {
+		if err = m.acme.stop(); err != nil {
+			m.log.Error().Err(err).Msg("cannot stop ACME challenge servers properly")
+		}
+	}
+
 	err = m.smtp.Close()
 	if err != nil {
 		m.log.Error().Err(err).Msg("cannot stop SMTP server properly")
 	}
 
+	if m.lmtp != nil {
+		err = m.lmtp.Close()
+		if err != nil {
+			m.log.Error().Err(err).Msg("cannot stop LMTP server properly")
+		}
+	}
+
 	m.log.Info().Msg("SMTP server has been stopped")
 }
 
+// TLSConfig returns TLS config of the SMTPS listener (nil if TLS is not configured), so other servers can use the same certificates
+func (m *Manager) TLSConfig() *tls.Config {
+	m.tls.Mu.Lock()
+	defer m.tls.Mu.Unlock()
+
+	return m.tls.Config
+}
+
+// Listening returns status of the listeners (smtp, smtps, lmtp): true if bound and serving
+func (m *Manager) Listening() map[string]bool {
+	m.listeningMu.Lock()
+	defer m.listeningMu.Unlock()
+
+	listening := make(map[string]bool, len(m.listening))
+	for name, ok := range m.listening {
+		listening[name] = ok
+	}
+	return listening
+}
+
+func (m *Manager) setListening(name string, ok bool) {
+	m.listeningMu.Lock()
+	defer m.listeningMu.Unlock()
+
+	m.listening[name] = ok
+}
+
 func (m *Manager) listen(port string, tlsConfig *tls.Config) {
-	lwrapper, err := NewListener(port, tlsConfig, m.bot.IsBanned, m.log)
+	name := ListenerSMTP
+	if tlsConfig != nil {
+		name = ListenerSMTPS
+	}
+	lwrapper, err := NewListener(port, tlsConfig, m.proxyProtocol, m.bot.IsTrusted, m.bot.IsBanned, m.log)
 	if err != nil {
 		m.log.Error().Err(err).Str("port", port).Msg("cannot start listener")
 		m.errs <- err
@@ -184,7 +353,9 @@ func (m *Manager) listen(port string, tlsConfig *tls.Config) {
 	}
 	m.log.Info().Str("port", port).Msg("Starting SMTP server")
 
+	m.setListening(name, true)
 	err = m.smtp.Serve(lwrapper)
+	m.setListening(name, false)
 	if err != nil {
 		m.log.Error().Str("port", port).Err(err).Msg("cannot start SMTP server")
 		m.errs <- err
@@ -192,28 +363,121 @@ func (m *Manager) listen(port string, tlsConfig *tls.Config) {
 	}
 }
 
+// listenLMTP listens on unix socket (if the address is a path) or TCP address
+func (m *Manager) listenLMTP() {
+	network := socketNetwork(m.lmtpAddr)
+	if network == "unix" {
+		// remove stale socket left after unclean shutdown
+		if err := os.Remove(m.lmtpAddr); err != nil && !errors.Is(err, os.ErrNotExist) {
+			m.log.Warn().Err(err).Str("addr", m.lmtpAddr).Msg("cannot remove LMTP socket")
+		}
+	}
+	listener, err := net.Listen(network, m.lmtpAddr)
+	if err != nil {
+		m.log.Error().Err(err).Str("addr", m.lmtpAddr).Msg("cannot start LMTP listener")
+		m.errs <- err
+		return
+	}
+	m.log.Info().Str("addr", m.lmtpAddr).Msg("Starting LMTP server")
+
+	m.setListening(ListenerLMTP, true)
+	err = m.lmtp.Serve(listener)
+	m.setListening(ListenerLMTP, false)
+	if err != nil {
+		m.log.Error().Str("addr", m.lmtpAddr).Err(err).Msg("cannot start LMTP server")
+		m.errs <- err
+	}
+}
+
 // loadTLSConfig returns true if certs were loaded and false if not
 func (m *Manager) loadTLSConfig() bool {
 	m.log.Info().Msg("(re)loading TLS config")
-	if len(m.tls.Certs) == 0 || len(m.tls.Keys) == 0 {
-		m.log.Warn().Msg("SSL certificates are not provided")
+	if m.acme != nil && m.tls.Config == nil {
+		m.tls.Config = &tls.Config{GetCertificate: m.getCertificate}
+		m.smtp.TLSConfig = m.tls.Config
+	}
+
+	certs, keys := m.tls.Certs, m.tls.Keys
+	if m.tls.Dir != "" {
+		dirCerts, dirKeys, err := tlsDirPairs(m.tls.Dir)
+		if err != nil {
+			m.log.Error().Err(err).Str("dir", m.tls.Dir).Msg("cannot read SSL certificates directory")
+		}
+		certs = append(certs, dirCerts...)
+		keys = append(keys, dirKeys...)
+	}
+	if len(certs) == 0 || len(keys) == 0 {
+		if m.acme == nil {
+			m.log.Warn().Msg("SSL certificates are not provided")
+		}
 		return false
 	}
+	if len(certs) != len(keys) {
+		m.log.Error().Int("certs", len(certs)).Int("keys", len(keys)).Msg("SSL certificates and keys count mismatch")
+	}
 
-	certificates := make([]tls.Certificate, 0, len(m.tls.Certs))
-	for i, path := range m.tls.Certs {
-		tlsCert, err := tls.LoadX509KeyPair(path, m.tls.Keys[i])
+	certificates := make([]*tls.Certificate, 0, len(certs))
+	for i, path := range certs {
+		if i >= len(keys) {
+			break
+		}
+		tlsCert, err := tls.LoadX509KeyPair(path, keys[i])
 		if err != nil {
-			m.log.Error().Err(err).Msg("cannot load SSL certificate")
+			m.log.Error().Err(err).Str("cert", path).Msg("cannot load SSL certificate")
 			continue
 		}
-		certificates = append(certificates, tlsCert)
+		certificates = append(certificates, &tlsCert)
 	}
 	if len(certificates) == 0 {
 		return false
 	}
 
-	m.tls.Config = &tls.Config{Certificates: certificates}
-	m.smtp.TLSConfig = m.tls.Config
+	var defaultDomain string
+	domains := m.domains.get()
+	if len(domains) > 0 {
+		defaultDomain = domains[0]
+	}
+	m.tls.store.set(certificates, defaultDomain)
+	for _, domain := range domains {
+		if m.acme == nil && !m.tls.store.has(domain) {
+			m.log.Warn().Str("domain", domain).Msg("there is no SSL certificate for the domain")
+		}
+	}
+
+	if m.tls.Config == nil {
+		m.tls.Config = &tls.Config{GetCertificate: m.getCertificate}
+		m.smtp.TLSConfig = m.tls.Config
+	}
 	return true
 }
+
+// CertExpiry returns expiry of the TLS certificates of the served domains (ACME or files),
+// domains without a certificate are omitted. Certificates are never issued by this call
+func (m *Manager) CertExpiry(ctx context.Context) map[string]time.Time {
+	expiry := map[string]time.Time{}
+	for _, domain := range m.domains.get() {
+		if m.acme != nil {
+			if notAfter, ok := m.acme.expiry(ctx, domain); ok {
+				expiry[domain] = notAfter
+				continue
+			}
+		}
+		if notAfter, ok := m.tls.store.expiry(domain); ok {
+			expiry[domain] = notAfter
+		}
+	}
+	return expiry
+}
+
+// getCertificate returns ACME certificate (if enabled) or certificate from the files, selected by SNI
+func (m *Manager) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if m.acme != nil {
+		cert, err := m.acme.GetCertificate(hello)
+		if err == nil {
+			return cert, nil
+		}
+		m.log.Debug().Err(err).Str("sni", hello.ServerName).Msg("cannot get ACME certificate, trying SSL certificates from files")
+	}
+
+	return m.tls.store.GetCertificate(hello)
+}
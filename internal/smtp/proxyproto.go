@@ -0,0 +1,213 @@
+package smtp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ProxyHeaderTimeout is the max time to wait for the PROXY protocol header from a trusted proxy
+	ProxyHeaderTimeout = 5 * time.Second
+
+	proxyV1Prefix    = "PROXY "
+	proxyV1MaxLength = 107 // including CRLF, as per spec
+	proxyV2HeaderLen = 16
+)
+
+// proxyV2Signature is the PROXY protocol v2 binary signature
+var proxyV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+var (
+	// ErrProxyHeader returned when the PROXY protocol header is missing or invalid
+	ErrProxyHeader = errors.New("invalid PROXY protocol header")
+	// ErrProxyUnsupported returned when the PROXY protocol header is valid, but describes unsupported protocol
+	ErrProxyUnsupported = errors.New("unsupported PROXY protocol header")
+)
+
+// proxyConn is a net.Conn that reports the real client address, received via PROXY protocol.
+// The header is parsed lazily on the first Read, Write, or RemoteAddr call, so a slow proxy blocks only its own connection
+type proxyConn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+	// accept is called once the header is parsed (or failed to parse), the connection is closed if it returns an error
+	accept func(net.Addr, error) error
+
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+// newProxyConn returns the connection wrapper that reads PROXY protocol (v1 or v2) header from the connection
+// and reports the real client address
+func newProxyConn(conn net.Conn, timeout time.Duration, accept func(net.Addr, error) error) *proxyConn {
+	return &proxyConn{Conn: conn, reader: bufio.NewReader(conn), timeout: timeout, accept: accept}
+}
+
+// init reads the PROXY protocol header, only once
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.remote, c.err = c.readHeader()
+		if c.remote == nil { // invalid header, LOCAL command, or UNKNOWN protocol - keep the proxy address
+			c.remote = c.Conn.RemoteAddr()
+		}
+		if c.accept != nil {
+			c.err = c.accept(c.remote, c.err)
+		}
+		if c.err != nil {
+			c.Conn.Close()
+		}
+	})
+}
+
+func (c *proxyConn) readHeader() (net.Addr, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
+	}
+	remote, err := readProxyHeader(c.reader)
+	if err != nil {
+		return nil, err
+	}
+	return remote, c.Conn.SetReadDeadline(time.Time{})
+}
+
+// Read reads data from the connection, including data buffered during header parsing
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// Write writes data to the connection, nothing is sent before the header is parsed and accepted
+func (c *proxyConn) Write(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.Conn.Write(b)
+}
+
+// RemoteAddr returns the real client address
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	return c.remote
+}
+
+// readProxyHeader parses PROXY protocol header of any supported version,
+// returns nil address if the header is valid, but doesn't contain client address
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	// v1 header is at least 15 bytes long, v2 - 16 bytes, so it's safe to peek
+	sig, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, errors.Join(ErrProxyHeader, err)
+	}
+	if bytes.Equal(sig, proxyV2Signature) {
+		return readProxyV2(r)
+	}
+	if strings.HasPrefix(string(sig), proxyV1Prefix) {
+		return readProxyV1(r)
+	}
+
+	return nil, ErrProxyHeader
+}
+
+// readProxyV1 parses human-readable header, e.g.: PROXY TCP4 192.0.2.1 198.51.100.1 56324 25\r\n
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	line := make([]byte, 0, proxyV1MaxLength)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, errors.Join(ErrProxyHeader, err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= proxyV1MaxLength {
+			return nil, ErrProxyHeader
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, ErrProxyHeader
+	}
+
+	parts := strings.Split(string(line[:len(line)-2]), " ")
+	if len(parts) < 2 {
+		return nil, ErrProxyHeader
+	}
+	switch parts[1] {
+	case "UNKNOWN":
+		return nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, ErrProxyUnsupported
+	}
+	if len(parts) != 6 {
+		return nil, ErrProxyHeader
+	}
+
+	ip := net.ParseIP(parts[2])
+	if ip == nil || (parts[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, ErrProxyHeader
+	}
+	port, err := strconv.ParseUint(parts[4], 10, 16)
+	if err != nil {
+		return nil, errors.Join(ErrProxyHeader, err)
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2 parses binary header
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, proxyV2HeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, errors.Join(ErrProxyHeader, err)
+	}
+	if header[12]>>4 != 2 { // version
+		return nil, ErrProxyHeader
+	}
+	command := header[12] & 0x0F
+	family := header[13]
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, errors.Join(ErrProxyHeader, err)
+	}
+
+	switch command {
+	case 0x00: // LOCAL, e.g. health checks of the proxy itself
+		return nil, nil
+	case 0x01: // PROXY
+	default:
+		return nil, ErrProxyHeader
+	}
+
+	switch family {
+	case 0x11: // TCP over IPv4
+		if length < 12 {
+			return nil, ErrProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case 0x21: // TCP over IPv6
+		if length < 36 {
+			return nil, ErrProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	case 0x00: // UNSPEC
+		return nil, nil
+	default:
+		return nil, ErrProxyUnsupported
+	}
+}
@@ -0,0 +1,217 @@
+package smtp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func proxyV2Header(command, family byte, payload []byte) []byte {
+	header := make([]byte, 0, proxyV2HeaderLen+len(payload))
+	header = append(header, proxyV2Signature...)
+	header = append(header, 0x20|command, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	return append(header, payload...)
+}
+
+func TestReadProxyHeaderV1(t *testing.T) {
+	tests := map[string]string{
+		"PROXY TCP4 192.0.2.1 198.51.100.1 56324 25\r\n":  "192.0.2.1:56324",
+		"PROXY TCP6 2001:db8::1 2001:db8::2 4242 587\r\n": "[2001:db8::1]:4242",
+		"PROXY UNKNOWN\r\n":                     "",
+		"PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n": "",
+	}
+
+	for in, expected := range tests {
+		t.Run(in, func(t *testing.T) {
+			addr, err := readProxyHeader(bufio.NewReader(strings.NewReader(in + "EHLO example.com\r\n")))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var actual string
+			if addr != nil {
+				actual = addr.String()
+			}
+			if actual != expected {
+				t.Error(expected, "!=", actual)
+			}
+		})
+	}
+}
+
+func TestReadProxyHeaderV1Invalid(t *testing.T) {
+	tests := []string{
+		"EHLO example.com\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n",
+		"PROXY TCP4 2001:db8::1 198.51.100.1 56324 25\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.1 99999 25\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.1 56324 25\n",
+		"PROXY TCP4 " + strings.Repeat("1", proxyV1MaxLength) + "\r\n",
+	}
+
+	for _, in := range tests {
+		t.Run(in, func(t *testing.T) {
+			_, err := readProxyHeader(bufio.NewReader(strings.NewReader(in)))
+			if !errors.Is(err, ErrProxyHeader) {
+				t.Fatalf("expected ErrProxyHeader, got %v", err)
+			}
+		})
+	}
+}
+
+func TestReadProxyHeaderV2(t *testing.T) {
+	v4 := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xDC, 0x04, 0x00, 0x19}
+	v6 := make([]byte, 0, 36)
+	v6 = append(v6, net.ParseIP("2001:db8::1")...)
+	v6 = append(v6, net.ParseIP("2001:db8::2")...)
+	v6 = append(v6, 0x10, 0x92, 0x02, 0x4B)
+	tlv := append(append([]byte{}, v4...), 0x04, 0x00, 0x01, 0xFF) // NOOP TLV must be skipped
+
+	tests := map[string]struct {
+		header   []byte
+		expected string
+	}{
+		"tcp4":  {proxyV2Header(0x01, 0x11, v4), "192.0.2.1:56324"},
+		"tcp6":  {proxyV2Header(0x01, 0x21, v6), "[2001:db8::1]:4242"},
+		"tlv":   {proxyV2Header(0x01, 0x11, tlv), "192.0.2.1:56324"},
+		"local": {proxyV2Header(0x00, 0x00, nil), ""},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			reader := bufio.NewReader(bytes.NewReader(append(test.header, []byte("EHLO example.com\r\n")...)))
+			addr, err := readProxyHeader(reader)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var actual string
+			if addr != nil {
+				actual = addr.String()
+			}
+			if actual != test.expected {
+				t.Error(test.expected, "!=", actual)
+			}
+			rest, _ := io.ReadAll(reader) //nolint:errcheck // test
+			if string(rest) != "EHLO example.com\r\n" {
+				t.Errorf("header must be consumed completely, got %q", rest)
+			}
+		})
+	}
+}
+
+func TestReadProxyHeaderV2Invalid(t *testing.T) {
+	tests := map[string]struct {
+		header   []byte
+		expected error
+	}{
+		"short":   {proxyV2Header(0x01, 0x11, []byte{192, 0, 2, 1}), ErrProxyHeader},
+		"command": {proxyV2Header(0x0F, 0x11, make([]byte, 12)), ErrProxyHeader},
+		"udp":     {proxyV2Header(0x01, 0x12, make([]byte, 12)), ErrProxyUnsupported},
+		"unix":    {proxyV2Header(0x01, 0x31, make([]byte, 216)), ErrProxyUnsupported},
+		"length":  {proxyV2Header(0x01, 0x11, make([]byte, 12))[:20], ErrProxyHeader},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := readProxyHeader(bufio.NewReader(bytes.NewReader(test.header)))
+			if !errors.Is(err, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, err)
+			}
+		})
+	}
+}
+
+func TestNewProxyConn(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	go client.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 25\r\nQUIT\r\n")) //nolint:errcheck // test
+
+	conn := newProxyConn(server, ProxyHeaderTimeout, nil)
+	if conn.RemoteAddr().String() != "192.0.2.1:56324" {
+		t.Fatalf("unexpected remote address: %s", conn.RemoteAddr())
+	}
+	buf := make([]byte, 6)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(buf) != "QUIT\r\n" {
+		t.Fatalf("unexpected data: %q", buf)
+	}
+}
+
+func TestNewProxyConnRejected(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	go client.Write([]byte("GET / HTTP/1.1\r\n")) //nolint:errcheck // test
+
+	var acceptErr error
+	conn := newProxyConn(server, ProxyHeaderTimeout, func(_ net.Addr, err error) error {
+		acceptErr = err
+		return err
+	})
+	if _, err := conn.Write([]byte("220 hello\r\n")); !errors.Is(err, ErrProxyHeader) {
+		t.Fatalf("expected %v, got %v", ErrProxyHeader, err)
+	}
+	if !errors.Is(acceptErr, ErrProxyHeader) {
+		t.Fatalf("expected %v, got %v", ErrProxyHeader, acceptErr)
+	}
+	if conn.RemoteAddr() != server.RemoteAddr() {
+		t.Fatalf("unexpected remote address: %s", conn.RemoteAddr())
+	}
+}
+
+func TestListenerSlowProxy(t *testing.T) {
+	log := zerolog.Nop()
+	l, err := NewListener("0", nil, true, func(net.Addr) bool { return true }, func(context.Context, net.Addr) bool { return false }, &log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	silent, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	proxied, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxied.Close()
+	if _, err := proxied.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 25\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Now()
+	conns := make([]net.Conn, 0, 2)
+	for range 2 {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Fatalf("silent proxy blocked accepting connections for %s", elapsed)
+	}
+	silent.Close() // otherwise RemoteAddr of the silent connection waits for the header until timeout
+	addrs := []string{conns[0].RemoteAddr().String(), conns[1].RemoteAddr().String()}
+	if !slices.Contains(addrs, "192.0.2.1:56324") {
+		t.Fatalf("real address is not found: %v", addrs)
+	}
+}
@@ -0,0 +1,267 @@
+package smtp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/etkecc/postmoogle/internal/email"
+)
+
+// ScannerTimeout is the default timeout of content scanner requests
+const ScannerTimeout = 30 * time.Second
+
+// supported content scanners
+const (
+	ScannerRspamd = "rspamd"
+	ScannerSpamd  = "spamd"
+)
+
+// ScannerConfig is the content scanner (rspamd or spamd) configuration
+type ScannerConfig struct {
+	// Type of the scanner, rspamd or spamd
+	Type string
+	// Addr of the scanner: rspamd HTTP URL (e.g. http://127.0.0.1:11333),
+	// or spamd unix socket path (starting with /) or TCP address (e.g. 127.0.0.1:783)
+	Addr string
+	// Password of rspamd (optional)
+	Password string
+	// User of spamd (optional)
+	User string
+	// Timeout of the scan requests
+	Timeout time.Duration
+}
+
+// ScanRequest contains the raw email and its envelope, passed to the content scanner
+type ScanRequest struct {
+	Data []byte
+	IP   string
+	Helo string
+	From string
+	Rcpt []string
+}
+
+// Scanner scans raw emails and returns their spam score
+type Scanner interface {
+	Scan(ctx context.Context, req *ScanRequest) (*email.SpamResult, error)
+}
+
+// NewScanner creates content scanner, returns nil if the scanner is not configured
+func NewScanner(cfg *ScannerConfig) (Scanner, error) {
+	if cfg == nil || cfg.Addr == "" {
+		return nil, nil //nolint:nilnil // scanner is optional
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = ScannerTimeout
+	}
+
+	switch strings.ToLower(cfg.Type) {
+	case ScannerRspamd:
+		return &rspamdScanner{
+			url:      strings.TrimSuffix(cfg.Addr, "/") + "/checkv2",
+			password: cfg.Password,
+			client:   &http.Client{Timeout: timeout},
+		}, nil
+	case ScannerSpamd:
+		return &spamdScanner{
+			addr:    cfg.Addr,
+			user:    cfg.User,
+			timeout: timeout,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported content scanner %q, must be %s or %s", cfg.Type, ScannerRspamd, ScannerSpamd) //nolint:goerr113 // no need for a sentinel error
+	}
+}
+
+// socketNetwork returns network of the address, unix if it's a path and tcp otherwise
+func socketNetwork(addr string) string {
+	if strings.HasPrefix(addr, "/") {
+		return "unix"
+	}
+	return "tcp"
+}
+
+// rspamdScanner uses rspamd HTTP protocol, see https://rspamd.com/doc/developers/protocol.html
+type rspamdScanner struct {
+	url      string
+	password string
+	client   *http.Client
+}
+
+type rspamdResponse struct {
+	Score         float64                  `json:"score"`
+	RequiredScore float64                  `json:"required_score"`
+	Symbols       map[string]*rspamdSymbol `json:"symbols"`
+	Error         string                   `json:"error"`
+}
+
+type rspamdSymbol struct {
+	Name  string  `json:"name"`
+	Score float64 `json:"score"`
+}
+
+// Scan sends the raw email to rspamd
+func (s *rspamdScanner) Scan(ctx context.Context, req *ScanRequest) (*email.SpamResult, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(req.Data))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "message/rfc822")
+	if s.password != "" {
+		httpReq.Header.Set("Password", s.password)
+	}
+	if req.IP != "" {
+		httpReq.Header.Set("IP", req.IP)
+	}
+	if req.Helo != "" {
+		httpReq.Header.Set("Helo", req.Helo)
+	}
+	if req.From != "" {
+		httpReq.Header.Set("From", req.From)
+	}
+	for _, rcpt := range req.Rcpt {
+		httpReq.Header.Add("Rcpt", rcpt)
+	}
+
+	resp, err := s.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rspamd returned HTTP %d", resp.StatusCode) //nolint:goerr113 // no need for a sentinel error
+	}
+
+	var result rspamdResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if result.Error != "" {
+		return nil, fmt.Errorf("rspamd error: %s", result.Error) //nolint:goerr113 // no need for a sentinel error
+	}
+
+	symbols := make([]string, 0, len(result.Symbols))
+	for name, symbol := range result.Symbols {
+		if symbol != nil && symbol.Score > 0 {
+			symbols = append(symbols, name)
+		}
+	}
+	sort.Strings(symbols)
+
+	return &email.SpamResult{
+		Scanner:  ScannerRspamd,
+		Score:    result.Score,
+		Required: result.RequiredScore,
+		Symbols:  symbols,
+	}, nil
+}
+
+// spamdScanner uses SpamAssassin spamd protocol, see https://spamassassin.apache.org/full/4.0.x/doc/spamd.html
+type spamdScanner struct {
+	addr    string
+	user    string
+	timeout time.Duration
+}
+
+// Scan sends the raw email to spamd
+func (s *spamdScanner) Scan(ctx context.Context, req *ScanRequest) (*email.SpamResult, error) {
+	dialer := &net.Dialer{Timeout: s.timeout}
+	conn, err := dialer.DialContext(ctx, socketNetwork(s.addr), s.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(s.timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline) //nolint:errcheck // the request will fail anyway
+
+	var request bytes.Buffer
+	request.WriteString("SYMBOLS SPAMC/1.5\r\n")
+	request.WriteString("Content-length: " + strconv.Itoa(len(req.Data)) + "\r\n")
+	if s.user != "" {
+		request.WriteString("User: " + s.user + "\r\n")
+	}
+	request.WriteString("\r\n")
+	request.Write(req.Data)
+	if _, err = conn.Write(request.Bytes()); err != nil {
+		return nil, err
+	}
+
+	return parseSpamdResponse(conn)
+}
+
+// parseSpamdResponse parses response of the SYMBOLS command, e.g.:
+//
+//	SPAMD/1.1 0 EX_OK
+//	Content-length: 21
+//	Spam: True ; 15.3 / 5.0
+//
+//	SYMBOL_ONE,SYMBOL_TWO
+func parseSpamdResponse(r io.Reader) (*email.SpamResult, error) {
+	reader := bufio.NewReader(r)
+	status, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Fields(status)
+	if len(parts) < 3 || !strings.HasPrefix(parts[0], "SPAMD/") {
+		return nil, fmt.Errorf("invalid spamd response: %q", strings.TrimSpace(status)) //nolint:goerr113 // no need for a sentinel error
+	}
+	if parts[1] != "0" {
+		return nil, fmt.Errorf("spamd error: %s", strings.Join(parts[1:], " ")) //nolint:goerr113 // no need for a sentinel error
+	}
+
+	result := &email.SpamResult{Scanner: ScannerSpamd}
+	var hasSpamHeader bool
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || !strings.EqualFold(name, "Spam") {
+			continue
+		}
+		// True ; 15.3 / 5.0
+		_, scores, _ := strings.Cut(value, ";")
+		score, required, _ := strings.Cut(scores, "/")
+		result.Score, err = strconv.ParseFloat(strings.TrimSpace(score), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid spamd score: %q", value) //nolint:goerr113 // no need for a sentinel error
+		}
+		result.Required, _ = strconv.ParseFloat(strings.TrimSpace(required), 64) //nolint:errcheck // optional
+		hasSpamHeader = true
+	}
+	if !hasSpamHeader {
+		return nil, errors.New("spamd response has no Spam header") //nolint:goerr113 // no need for a sentinel error
+	}
+
+	body, _ := io.ReadAll(reader) //nolint:errcheck // symbols are optional
+	for _, symbol := range strings.Split(strings.TrimSpace(string(body)), ",") {
+		if symbol = strings.TrimSpace(symbol); symbol != "" {
+			result.Symbols = append(result.Symbols, symbol)
+		}
+	}
+
+	return result, nil
+}
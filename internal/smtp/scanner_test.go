@@ -0,0 +1,237 @@
+package smtp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/etkecc/postmoogle/internal/email"
+)
+
+const testScanEmail = "From: spammer@example.com\r\nTo: alice@example.org\r\nSubject: cheap pills\r\n\r\nBuy now!"
+
+func TestRspamdScan(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/checkv2" {
+			t.Error("/checkv2", "!=", r.URL.Path)
+		}
+		if r.Header.Get("Password") != "secret" {
+			t.Error("secret", "!=", r.Header.Get("Password"))
+		}
+		if r.Header.Get("IP") != "192.0.2.1" {
+			t.Error("192.0.2.1", "!=", r.Header.Get("IP"))
+		}
+		if r.Header.Get("From") != "spammer@example.com" {
+			t.Error("spammer@example.com", "!=", r.Header.Get("From"))
+		}
+		if rcpts := r.Header.Values("Rcpt"); !slices.Equal(rcpts, []string{"alice@example.org", "bob@example.org"}) {
+			t.Error([]string{"alice@example.org", "bob@example.org"}, "!=", rcpts)
+		}
+		body, _ := io.ReadAll(r.Body) //nolint:errcheck // that's a test
+		if string(body) != testScanEmail {
+			t.Error(testScanEmail, "!=", string(body))
+		}
+
+		json.NewEncoder(w).Encode(map[string]any{ //nolint:errcheck // that's a test
+			"score":          12.5,
+			"required_score": 15,
+			"action":         "add header",
+			"symbols": map[string]any{
+				"BAYES_SPAM":   map[string]any{"name": "BAYES_SPAM", "score": 5.1},
+				"R_DKIM_ALLOW": map[string]any{"name": "R_DKIM_ALLOW", "score": -0.2},
+				"FUZZY_DENIED": map[string]any{"name": "FUZZY_DENIED", "score": 7.6},
+			},
+		})
+	}))
+	defer srv.Close()
+
+	scanner, err := NewScanner(&ScannerConfig{Type: ScannerRspamd, Addr: srv.URL + "/", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := scanner.Scan(context.Background(), &ScanRequest{
+		Data: []byte(testScanEmail),
+		IP:   "192.0.2.1",
+		From: "spammer@example.com",
+		Rcpt: []string{"alice@example.org", "bob@example.org"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Scanner != ScannerRspamd {
+		t.Error(ScannerRspamd, "!=", result.Scanner)
+	}
+	if result.Score != 12.5 {
+		t.Error(12.5, "!=", result.Score)
+	}
+	if result.Required != 15 {
+		t.Error(15, "!=", result.Required)
+	}
+	if expected := []string{"BAYES_SPAM", "FUZZY_DENIED"}; !slices.Equal(expected, result.Symbols) {
+		t.Error(expected, "!=", result.Symbols)
+	}
+}
+
+func TestRspamdScanError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	scanner, err := NewScanner(&ScannerConfig{Type: ScannerRspamd, Addr: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := scanner.Scan(context.Background(), &ScanRequest{Data: []byte(testScanEmail)}); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+// serveSpamd runs a stub spamd server, answering each connection with the response
+func serveSpamd(t *testing.T, response string) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			reader := bufio.NewReader(conn)
+			var length int
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					conn.Close()
+					return
+				}
+				line = strings.TrimSpace(line)
+				if line == "" {
+					break
+				}
+				if value, ok := strings.CutPrefix(line, "Content-length: "); ok {
+					length, _ = strconv.Atoi(value) //nolint:errcheck // that's a test
+				}
+			}
+			body := make([]byte, length)
+			if _, err := io.ReadFull(reader, body); err != nil || string(body) != testScanEmail {
+				t.Error(testScanEmail, "!=", string(body))
+			}
+			conn.Write([]byte(response)) //nolint:errcheck // that's a test
+			conn.Close()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestSpamdScan(t *testing.T) {
+	addr := serveSpamd(t, "SPAMD/1.1 0 EX_OK\r\nContent-length: 27\r\nSpam: True ; 15.3 / 5.0\r\n\r\nBAYES_99,URIBL_BLACK\r\n")
+
+	scanner, err := NewScanner(&ScannerConfig{Type: ScannerSpamd, Addr: addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := scanner.Scan(context.Background(), &ScanRequest{Data: []byte(testScanEmail)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Scanner != ScannerSpamd {
+		t.Error(ScannerSpamd, "!=", result.Scanner)
+	}
+	if result.Score != 15.3 {
+		t.Error(15.3, "!=", result.Score)
+	}
+	if result.Required != 5 {
+		t.Error(5, "!=", result.Required)
+	}
+	if expected := []string{"BAYES_99", "URIBL_BLACK"}; !slices.Equal(expected, result.Symbols) {
+		t.Error(expected, "!=", result.Symbols)
+	}
+}
+
+func TestParseSpamdResponse(t *testing.T) {
+	tests := map[string]struct {
+		response string
+		score    float64
+		err      bool
+	}{
+		"ham":              {response: "SPAMD/1.1 0 EX_OK\r\nSpam: False ; -1.2 / 5.0\r\n\r\n", score: -1.2},
+		"no symbols":       {response: "SPAMD/1.1 0 EX_OK\r\nSpam: True ; 6 / 5.0\r\n\r\n", score: 6},
+		"error code":       {response: "SPAMD/1.0 76 Bad header line\r\n", err: true},
+		"no spam header":   {response: "SPAMD/1.1 0 EX_OK\r\nContent-length: 0\r\n\r\n", err: true},
+		"invalid score":    {response: "SPAMD/1.1 0 EX_OK\r\nSpam: True ; lots / 5.0\r\n\r\n", err: true},
+		"invalid status":   {response: "HTTP/1.1 200 OK\r\n\r\n", err: true},
+		"empty response":   {response: "", err: true},
+		"no final CRLFs":   {response: "SPAMD/1.1 0 EX_OK\r\nSpam: True ; 7.5 / 5.0", score: 7.5},
+		"lowercase header": {response: "SPAMD/1.1 0 EX_OK\r\nspam: yes ; 8 / 5\r\n\r\n", score: 8},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			result, err := parseSpamdResponse(strings.NewReader(test.response))
+			if test.err {
+				if err == nil {
+					t.Error("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Score != test.score {
+				t.Error(test.score, "!=", result.Score)
+			}
+		})
+	}
+}
+
+func TestSpamThresholdsAction(t *testing.T) {
+	thresholds := &email.SpamThresholds{Greylist: 8, Quarantine: 12, Reject: 20}
+	tests := map[string]struct {
+		thresholds *email.SpamThresholds
+		score      float64
+		expected   string
+	}{
+		"ham":                       {thresholds: thresholds, score: 1, expected: email.SpamActionNone},
+		"flag by scanner threshold": {thresholds: thresholds, score: 5, expected: email.SpamActionFlag},
+		"greylist":                  {thresholds: thresholds, score: 8, expected: email.SpamActionGreylist},
+		"quarantine":                {thresholds: thresholds, score: 15, expected: email.SpamActionQuarantine},
+		"reject":                    {thresholds: thresholds, score: 25, expected: email.SpamActionReject},
+		"room flag threshold":       {thresholds: &email.SpamThresholds{Flag: 3}, score: 4, expected: email.SpamActionFlag},
+		"no thresholds":             {thresholds: nil, score: 25, expected: email.SpamActionFlag},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			action := test.thresholds.Action(&email.SpamResult{Score: test.score, Required: 5})
+			if action != test.expected {
+				t.Error(test.expected, "!=", action)
+			}
+		})
+	}
+}
+
+func TestNewScanner(t *testing.T) {
+	if scanner, err := NewScanner(&ScannerConfig{Type: ScannerRspamd}); scanner != nil || err != nil {
+		t.Error("expected no scanner and no error without address")
+	}
+	if _, err := NewScanner(&ScannerConfig{Type: "clamav", Addr: "127.0.0.1:3310"}); err == nil {
+		t.Error("expected error for unsupported scanner")
+	}
+}
@@ -13,8 +13,14 @@ import (
 type mailServer struct {
 	bot     matrixbot
 	log     *zerolog.Logger
-	domains []string
+	domains *domainList
 	sender  MailSender
+	dnsbl   *DNSBLChecker
+	scanner Scanner
+	clamav  *ClamAV
+
+	lmtp     bool
+	nochecks bool
 }
 
 func (m *mailServer) NewSession(con *smtp.Conn) (smtp.Session, error) {
@@ -22,11 +28,15 @@ func (m *mailServer) NewSession(con *smtp.Conn) (smtp.Session, error) {
 	return &session{
 		log:      m.log,
 		bot:      m.bot,
-		domains:  m.domains,
+		domains:  m.domains.get(),
 		sendmail: m.sender.Send,
+		dnsbl:    m.dnsbl,
+		scanner:  m.scanner,
+		clamav:   m.clamav,
 		conn:     con,
 		ctx:      ctx,
-		privkey:  m.bot.GetDKIMprivkey(ctx),
+		lmtp:     m.lmtp,
+		nochecks: m.nochecks,
 	}, nil
 }
 
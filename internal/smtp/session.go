@@ -3,17 +3,24 @@ package smtp
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io"
 	"net"
 	"net/url"
 	"slices"
 	"strconv"
+	"strings"
 
+	"blitiri.com.ar/go/spf"
+	"github.com/emersion/go-msgauth/authres"
 	"github.com/emersion/go-msgauth/dkim"
+	"github.com/emersion/go-msgauth/dmarc"
 	"github.com/emersion/go-sasl"
 	"github.com/emersion/go-smtp"
 	"github.com/etkecc/go-validator/v2"
 	"github.com/etkecc/postmoogle/internal/email"
+	"github.com/etkecc/postmoogle/internal/metrics"
+	"github.com/etkecc/postmoogle/internal/srs"
 	"github.com/etkecc/postmoogle/internal/utils"
 	"github.com/jhillyerd/enmime/v2"
 	"github.com/rs/zerolog"
@@ -27,8 +34,11 @@ const (
 	Outgoing = "outgoing"
 )
 
-// ensure that session implements smtp.AuthSession
-var _ smtp.AuthSession = (*session)(nil)
+// ensure that session implements smtp.AuthSession and smtp.LMTPSession
+var (
+	_ smtp.AuthSession = (*session)(nil)
+	_ smtp.LMTPSession = (*session)(nil)
+)
 
 type session struct {
 	log      *zerolog.Logger
@@ -37,12 +47,25 @@ type session struct {
 	conn     *smtp.Conn
 	domains  []string
 	sendmail func(string, string, string, *url.URL) error
-
-	dir      string
-	tos      []string
+	dnsbl    *DNSBLChecker
+	scanner  Scanner
+	clamav   *ClamAV
+	// lmtp session, the peer is a front MTA (e.g. Postfix), not the sender
+	lmtp bool
+	// nochecks disables SPF/DKIM/RBL/etc. checks of incoming emails, because the front MTA did them already
+	nochecks bool
+
+	dir string
+	tos []string
+	// quarantine reasons of the current email, for all recipients
+	quarantine []string
+	// quarantine reasons of the recipients, RCPT checks (e.g. RBL) are applied with options of each recipient's mailbox
+	rcptQuarantine map[string][]string
+	// DNS blocklists hits of the recipients, the verdict is deferred to DATA
+	// if the sender matches the allowlist, because the sender is not authenticated at RCPT yet
+	rbl      map[string]*deferredRBL
 	from     string
 	roomID   id.RoomID
-	privkey  string
 	fromRoom id.RoomID
 }
 
@@ -56,7 +79,7 @@ func (s *session) Auth(mech string) (sasl.Server, error) {
 	if !slices.Contains(s.AuthMechanisms(), mech) {
 		s.log.Info().Str("addr", addr.String()).Msg("banning due to invalid auth mechanism")
 		s.bot.BanAuth(s.ctx, addr)
-		return nil, ErrBanned
+		return nil, rejected(metrics.ReasonAuth, ErrBanned)
 	}
 
 	switch mech {
@@ -72,7 +95,7 @@ func (s *session) Auth(mech string) (sasl.Server, error) {
 	default:
 		s.log.Warn().Str("addr", addr.String()).Str("mech", mech).Msg("unsupported auth mechanism")
 		s.bot.BanAuth(s.ctx, addr)
-		return nil, ErrBanned
+		return nil, rejected(metrics.ReasonAuth, ErrBanned)
 	}
 }
 
@@ -81,13 +104,13 @@ func (s *session) authPlain(_, username, password string) error {
 	if !email.AddressValid(username) {
 		s.log.Debug().Str("address", username).Msg("address is invalid")
 		s.bot.BanAuth(s.ctx, addr)
-		return ErrBanned
+		return rejected(metrics.ReasonAuth, ErrBanned)
 	}
 	roomID, allow := s.bot.AllowAuth(s.ctx, username, password)
 	if !allow {
 		s.log.Debug().Str("username", username).Msg("username or password is invalid")
 		s.bot.BanAuth(s.ctx, addr)
-		return ErrBanned
+		return rejected(metrics.ReasonAuth, ErrBanned)
 	}
 
 	s.dir = Outgoing
@@ -102,14 +125,23 @@ func (s *session) Mail(from string, _ *smtp.MailOptions) error {
 	}
 
 	// incoming mail
+	if s.lmtp {
+		return s.lmtpMail(from)
+	}
+	// null sender (bounce), accepted for SRS recipients only
+	if from == "" {
+		s.from = ""
+		s.log.Debug().Msg("incoming mail with null sender")
+		return nil
+	}
 	if !email.AddressValid(from) {
 		s.log.Debug().Str("from", from).Msg("address is invalid")
 		s.bot.BanAuto(s.ctx, s.conn.Conn().RemoteAddr())
-		return ErrBanned
+		return rejected(metrics.ReasonBanned, ErrBanned)
 	}
 	if slices.Contains(s.domains, utils.Hostname(from)) {
 		s.log.Info().Str("from", from).Msg("rejecting unauthenticated sender claiming local domain")
-		return ErrAuthRequired
+		return rejected(metrics.ReasonAuth, ErrAuthRequired)
 	}
 	s.from = email.Address(from)
 	s.log.Debug().Str("from", from).Msg("incoming mail")
@@ -117,29 +149,85 @@ func (s *session) Mail(from string, _ *smtp.MailOptions) error {
 }
 
 func (s *session) Rcpt(to string, _ *smtp.RcptOptions) error {
-	s.tos = append(s.tos, to)
 	s.log.Debug().Str("to", to).Msg("mail")
 	if s.dir == Outgoing {
+		s.tos = append(s.tos, to)
 		return nil
 	}
+	roomID := s.roomID
 	if err := s.validateIncomingRcpt(to); err != nil {
+		s.roomID = roomID
+		return rejected(metrics.ReasonNoUser, err)
+	}
+	if err := s.checkRBL(to); err != nil {
+		s.roomID = roomID
 		return err
 	}
 
-	if s.bot.GetIFOptions(s.ctx, s.roomID).SpamcheckRBL() {
-		s.log.Info().Msg("checking dns blacklists...")
-		if listed, reasons := CheckDNSBLs(s.ctx, s.log, s.conn.Conn().RemoteAddr()); listed {
-			s.log.Info().Strs("reasons", reasons).Msg("rejected incoming email (DNS Blacklist)")
-			if len(reasons) > 0 {
-				return extendErrRBL(reasons)
-			}
-			return ErrRBL
+	// rejected recipients must not be added, LMTP reports status for each accepted recipient
+	s.tos = append(s.tos, to)
+	return nil
+}
+
+// checkRBL checks the sender host against DNS blocklists, if enabled for the recipient mailbox
+func (s *session) checkRBL(to string) error {
+	options := s.options()
+	if s.nochecks || !options.SpamcheckRBL() {
+		return nil
+	}
+
+	s.log.Info().Msg("checking dns blacklists...")
+	listed, reasons := s.dnsbl.Check(s.ctx, s.log, s.conn.Conn().RemoteAddr(), s.bot.GetDNSBLOptions(s.ctx))
+	if !listed {
+		return nil
+	}
+	if utils.MatchPatterns(options.Allowlist(), s.from) {
+		s.log.Info().Strs("reasons", reasons).Msg("sender matches the allowlist, DNS blocklists verdict is deferred until it is authenticated")
+		if s.rbl == nil {
+			s.rbl = map[string]*deferredRBL{}
 		}
+		s.rbl[to] = &deferredRBL{reasons: reasons, options: options}
+		return nil
 	}
+	if s.suspiciousRcpt(to, options, rblReason(reasons)) {
+		return nil
+	}
+	s.log.Info().Strs("reasons", reasons).Msg("rejected incoming email (DNS Blacklist)")
+	err := ErrRBL
+	if len(reasons) > 0 {
+		err = extendErrRBL(reasons)
+	}
+	s.bot.EmailRejected(s.ctx, &email.Email{MailFrom: s.from, RcptTo: to}, err)
+	return rejected(metrics.ReasonRBL, err)
+}
+
+// deferredRBL is the DNS blocklists hit of the recipient, along with the recipient mailbox's options
+type deferredRBL struct {
+	reasons []string
+	options email.IncomingFilteringOptions
+}
 
+// checkDeferredRBL applies the deferred DNS blocklists verdict to the senders that are not allowlisted after all
+func (s *session) checkDeferredRBL(allowlisted bool) error {
+	if allowlisted {
+		return nil
+	}
+	for _, to := range s.tos {
+		deferred, ok := s.rbl[to]
+		if !ok || s.suspiciousRcpt(to, deferred.options, rblReason(deferred.reasons)) {
+			continue
+		}
+		s.log.Info().Strs("reasons", deferred.reasons).Msg("rejected incoming email (DNS Blacklist)")
+		return rejected(metrics.ReasonRBL, extendErrRBL(deferred.reasons))
+	}
 	return nil
 }
 
+// rblReason returns the quarantine reason of the DNS blocklists hit
+func rblReason(reasons []string) string {
+	return "listed in DNS blocklists: " + strings.Join(reasons, "; ")
+}
+
 func (s *session) Data(r io.Reader) error {
 	if s.dir == Outgoing {
 		return s.outgoingData(r)
@@ -147,7 +235,41 @@ func (s *session) Data(r io.Reader) error {
 	return s.incomingData(r)
 }
 
-func (s *session) Reset() {}
+// LMTPData is the LMTP version of Data, it reports delivery status for each recipient separately
+func (s *session) LMTPData(r io.Reader, status smtp.StatusCollector) error {
+	eml, err := s.readIncoming(r)
+	if err != nil {
+		s.notifyRejected(eml, err)
+		return err
+	}
+	if err := s.checkFiles(eml, s.roomID); err != nil {
+		s.notifyRejected(eml, err)
+		return err
+	}
+
+	for _, to := range s.tos {
+		eml.RcptTo = to
+		eml.Quarantine = s.quarantineReasons(to)
+		err := s.bot.IncomingEmail(s.ctx, eml)
+		if err != nil {
+			s.log.Error().Err(err).Str("to", to).Msg("cannot deliver email")
+		}
+		status.SetStatus(to, rejectError(err))
+	}
+	return nil
+}
+
+// Reset discards the envelope of the current message, keeping authentication state
+func (s *session) Reset() {
+	s.tos = nil
+	s.quarantine = nil
+	s.rcptQuarantine = nil
+	s.rbl = nil
+	if s.dir != Outgoing {
+		s.from = ""
+		s.roomID = ""
+	}
+}
 
 func (s *session) Logout() error {
 	return nil
@@ -160,16 +282,24 @@ func (s *session) outgoingData(r io.Reader) error {
 		return err
 	}
 	eml := email.FromEnvelope(s.tos[0], envelope)
+	eml.MailFrom = s.from
+	if err := s.checkFiles(eml, s.fromRoom); err != nil {
+		return err
+	}
 	for _, to := range s.tos {
 		eml.RcptTo = to
 		// local domain: deliver directly to Matrix instead of looping through SMTP
 		if slices.Contains(s.domains, utils.Hostname(to)) {
 			if err := s.bot.IncomingEmail(s.ctx, eml); err != nil {
-				return err
+				return rejectError(err)
 			}
 			continue
 		}
-		err := s.sendmail(eml.From, to, eml.Compose(s.privkey), s.bot.GetRelayConfig(s.ctx, s.fromRoom))
+		data := eml.Compose(s.bot.GetDKIMKeys(s.ctx, utils.Hostname(eml.From))...)
+		err := s.sendmail(eml.From, to, data, s.bot.GetRelayConfig(s.ctx, s.fromRoom))
+		sent := *eml
+		sent.Raw = []byte(data)
+		s.bot.EmailSent(s.ctx, s.fromRoom, &sent, err)
 		if err != nil {
 			return err
 		}
@@ -179,52 +309,376 @@ func (s *session) outgoingData(r io.Reader) error {
 }
 
 func (s *session) incomingData(r io.Reader) error {
+	eml, err := s.readIncoming(r)
+	if err != nil {
+		s.notifyRejected(eml, err)
+		return err
+	}
+	if err := s.checkFiles(eml, s.roomID); err != nil {
+		s.notifyRejected(eml, err)
+		return err
+	}
+
+	for _, to := range s.tos {
+		eml.RcptTo = to
+		eml.Quarantine = s.quarantineReasons(to)
+		err := s.bot.IncomingEmail(s.ctx, eml)
+		if err != nil {
+			return rejectError(err)
+		}
+	}
+	return nil
+}
+
+// readIncoming reads and parses incoming email, runs security checks, authenticates and scans it (unless disabled).
+// The parsed email is returned along with the rejection error, if any
+func (s *session) readIncoming(r io.Reader) (*email.Email, error) {
 	data, err := io.ReadAll(r)
 	if err != nil {
 		s.log.Error().Err(err).Msg("cannot read DATA")
-		return err
+		return nil, err
 	}
 	reader := bytes.NewReader(data)
 	parser := enmime.NewParser()
 	envelope, err := parser.ReadEnvelope(reader)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	eml := email.FromEnvelope(s.tos[0], envelope)
+	eml.Raw = data
+	eml.MailFrom = s.from
+	if s.nochecks {
+		return eml, nil
 	}
+
 	addr := s.getAddr(envelope)
 	reader.Seek(0, io.SeekStart) //nolint:errcheck // becase we're sure that's ok
-	validations := s.bot.GetIFOptions(s.ctx, s.roomID)
-	if !validateIncoming(s.from, envelope.GetHeader("Return-Path"), addr, s.log, validations) {
-		s.bot.BanAuth(s.ctx, addr)
-		return ErrBanned
+	validations := s.options()
+	// SPF and DKIM are evaluated once, for the allowlist, the sender checks, and the Authentication-Results
+	auth := s.authInput(addr, envelope)
+	auth.spf = s.checkSPF(auth)
+	verifications, verr := dkim.Verify(reader)
+	auth.dkim = verifications
+	allowlisted := s.allowlisted(validations, auth)
+	// null reverse-path (bounce) has no sender to validate, the rest of the checks is applied as usual
+	var reason string
+	if s.from != "" {
+		reason = validateIncoming(s.from, envelope.GetHeader("Return-Path"), addr, auth.spf, s.log, validations)
 	}
-	if s.bot.IsGreylisted(s.ctx, addr) {
-		return ErrGreylisted
+	if reason != "" {
+		if allowlisted {
+			s.log.Info().Str("from", s.from).Msg("sender is allowlisted, ignoring failed checks")
+		} else if !s.suspicious(validations, "sender check failed: "+reason) {
+			// in LMTP mode the peer is the front MTA, so it must not be banned
+			if !s.lmtp {
+				s.bot.BanAuth(s.ctx, addr)
+			}
+			return eml, rejected(reason, ErrBanned)
+		}
 	}
-	if validations.SpamcheckDKIM() {
-		results, verr := dkim.Verify(reader)
-		if verr != nil {
-			s.log.Error().Err(verr).Msg("cannot verify DKIM")
-			return verr
+	if err := s.checkDeferredRBL(allowlisted); err != nil {
+		return eml, err
+	}
+	if verr != nil {
+		s.log.Error().Err(verr).Msg("cannot verify DKIM")
+		if validations.SpamcheckDKIM() && !s.suspicious(validations, "cannot verify DKIM: "+verr.Error()) {
+			return eml, rejected(metrics.ReasonDKIM, verr)
 		}
-		for _, result := range results {
+	}
+	if validations.SpamcheckDKIM() {
+		for _, result := range verifications {
 			if result.Err != nil {
 				s.log.Info().Str("domain", result.Domain).Err(result.Err).Msg("DKIM verification failed")
-				return result.Err
+				if !s.suspicious(validations, "DKIM verification failed for "+result.Domain+": "+result.Err.Error()) {
+					return eml, rejected(metrics.ReasonDKIM, result.Err)
+				}
 			}
 		}
 	}
 
-	eml := email.FromEnvelope(s.tos[0], envelope)
+	// greylisting is checked after DKIM, because exempt domains must be authenticated
+	if !allowlisted && s.bot.IsGreylisted(s.ctx, addr, s.from, auth.authenticated(), s.tos) {
+		return eml, rejected(metrics.ReasonGreylisted, ErrGreylisted)
+	}
+	eml.Auth = s.authenticate(auth)
+	// the header is added to the raw email, so forwarded, resent, and archived (IMAP) copies keep it,
+	// and the headers forged by the sender under our authserv-id are removed
+	eml.Raw = slices.Concat([]byte("Authentication-Results: "+eml.Auth.Header+"\r\n"), stripAuthResults(data, s.domains))
+	s.log.Info().Str("spf", eml.Auth.SPF).Str("dkim", eml.Auth.DKIM).Str("dmarc", eml.Auth.DMARC).Str("policy", eml.Auth.Policy).Msg("authentication results")
+	if err := s.enforceDMARC(validations, eml.Auth); err != nil {
+		s.log.Info().Str("from", envelope.GetHeader("From")).Msg("rejected incoming email (DMARC)")
+		return eml, err
+	}
+
+	eml.Spam = s.scan(data, addr, validations.SpamThresholds())
+	if eml.Spam != nil {
+		switch eml.Spam.Action {
+		case email.SpamActionReject:
+			return eml, rejected(metrics.ReasonSpam, ErrSpam)
+		case email.SpamActionGreylist:
+			if s.bot.IsSpamGreylisted(s.ctx, addr, s.from, auth.authenticated(), s.tos) {
+				return eml, rejected(metrics.ReasonGreylisted, ErrGreylisted)
+			}
+		case email.SpamActionQuarantine:
+			s.quarantine = append(s.quarantine, eml.Spam.Summary())
+		case email.SpamActionFlag:
+			// bounces are relayed to the original sender as-is, so there is nobody to show the flag to
+			if s.roomID == "" {
+				return eml, rejected(metrics.ReasonSpam, ErrSpam)
+			}
+		}
+	}
+	eml.Quarantine = s.quarantine
+
+	return eml, nil
+}
+
+// notifyRejected reports the rejected incoming email to the bot, for each recipient mailbox.
+// The email is nil if it cannot be read or parsed, and greylisting and antivirus failures are temporary rejections, so they are not reported
+func (s *session) notifyRejected(eml *email.Email, err error) {
+	if eml == nil || errors.Is(err, ErrGreylisted) || errors.Is(err, ErrAntivirusUnavailable) {
+		return
+	}
 	for _, to := range s.tos {
-		eml.RcptTo = to
-		err := s.bot.IncomingEmail(s.ctx, eml)
-		if err != nil {
-			return err
+		if srs.IsSRS(to) {
+			continue
+		}
+		rejectedEml := *eml
+		rejectedEml.RcptTo = to
+		s.bot.EmailRejected(s.ctx, &rejectedEml, err)
+	}
+}
+
+// rejected counts the rejection of the email by reason, returns the error as is
+func rejected(reason string, err error) error {
+	metrics.SMTPRejected(reason)
+	return err
+}
+
+// suspicious records the quarantine reason of all recipients if quarantine is enabled, returns false if the email must be rejected instead
+func (s *session) suspicious(options email.IncomingFilteringOptions, reason string) bool {
+	if !options.Quarantine() {
+		return false
+	}
+	if !slices.Contains(s.quarantine, reason) {
+		s.quarantine = append(s.quarantine, reason)
+	}
+	s.log.Info().Str("reason", reason).Msg("suspicious incoming email, it will be quarantined")
+	return true
+}
+
+// suspiciousRcpt records the quarantine reason of the recipient if quarantine is enabled in the recipient mailbox's options,
+// returns false if the recipient must be rejected instead
+func (s *session) suspiciousRcpt(to string, options email.IncomingFilteringOptions, reason string) bool {
+	if !options.Quarantine() {
+		return false
+	}
+	if s.rcptQuarantine == nil {
+		s.rcptQuarantine = map[string][]string{}
+	}
+	if !slices.Contains(s.rcptQuarantine[to], reason) {
+		s.rcptQuarantine[to] = append(s.rcptQuarantine[to], reason)
+	}
+	s.log.Info().Str("to", to).Str("reason", reason).Msg("suspicious incoming email, it will be quarantined for the recipient")
+	return true
+}
+
+// quarantineReasons returns quarantine reasons of the email for the recipient
+func (s *session) quarantineReasons(to string) []string {
+	return slices.Concat(s.quarantine, s.rcptQuarantine[to])
+}
+
+// scan sends the incoming email to the content scanner and decides what to do with it,
+// scanner errors are logged and the email is accepted as is
+func (s *session) scan(data []byte, addr net.Addr, thresholds *email.SpamThresholds) *email.SpamResult {
+	if s.scanner == nil {
+		return nil
+	}
+	var helo string
+	if s.conn != nil {
+		helo = s.conn.Hostname()
+	}
+
+	result, err := s.scanner.Scan(s.ctx, &ScanRequest{
+		Data: data,
+		IP:   utils.AddrIP(addr),
+		Helo: helo,
+		From: s.from,
+		Rcpt: s.tos,
+	})
+	if err != nil {
+		s.log.Error().Err(err).Msg("cannot scan email")
+		return nil
+	}
+	result.Action = thresholds.Action(result)
+	s.log.Info().Str("scanner", result.Scanner).Float64("score", result.Score).Strs("symbols", result.Symbols).Str("action", result.Action).Msg("scan results")
+	return result
+}
+
+// enforceDMARC applies the sender domain's policy to the email failed DMARC check:
+// reject - the email is rejected (or quarantined), quarantine - the email is quarantined if the mailbox has quarantine
+func (s *session) enforceDMARC(options email.IncomingFilteringOptions, auth *email.AuthResults) error {
+	if !options.SpamcheckDMARC() || auth.DMARC != authres.ResultFail {
+		return nil
+	}
+	switch auth.Policy {
+	case string(dmarc.PolicyReject):
+		if !s.suspicious(options, "DMARC check failed, the sender domain's policy is reject") {
+			return rejected(metrics.ReasonDMARC, ErrDMARC)
+		}
+	case string(dmarc.PolicyQuarantine):
+		s.suspicious(options, "DMARC check failed, the sender domain's policy is quarantine")
+	}
+	return nil
+}
+
+// authenticate evaluates SPF, DKIM and DMARC of the incoming email
+func (s *session) authenticate(in *authInput) *email.AuthResults {
+	ctx, cancel := context.WithTimeout(s.ctx, AuthTimeout)
+	defer cancel()
+
+	var authservID string
+	if len(s.domains) > 0 {
+		authservID = s.domains[0]
+	}
+	return authenticate(ctx, nil, authservID, in)
+}
+
+// authInput returns everything known about the incoming email to authenticate it, except DKIM verifications
+func (s *session) authInput(addr net.Addr, envelope *enmime.Envelope) *authInput {
+	var helo string
+	if s.conn != nil {
+		helo = s.conn.Hostname()
+	}
+	return &authInput{
+		ip:         net.ParseIP(utils.AddrIP(addr)),
+		helo:       helo,
+		mailFrom:   s.from,
+		headerFrom: email.Address(envelope.GetHeader("From")),
+	}
+}
+
+// checkSPF evaluates SPF of the incoming email
+func (s *session) checkSPF(in *authInput) spf.Result {
+	ctx, cancel := context.WithTimeout(s.ctx, AuthTimeout)
+	defer cancel()
+	return checkSPF(ctx, net.DefaultResolver, in)
+}
+
+// checkFiles scans attachments and inline files of the email with ClamAV, see checkFiles.
+// Incoming emails with files too large to be scanned are quarantined if the mailbox has quarantine, rejected otherwise
+func (s *session) checkFiles(eml *email.Email, roomID id.RoomID) error {
+	err := checkFiles(s.ctx, s.log, s.clamav, s.bot, eml, roomID)
+	if !errors.Is(err, ErrTooLargeToScan) {
+		return err
+	}
+	if s.dir != Outgoing && s.suspicious(s.options(), "files are too large to be scanned by the antivirus") {
+		return nil
+	}
+	return rejected(metrics.ReasonTooLarge, err)
+}
+
+// checkFiles scans attachments and inline files of the email with ClamAV.
+// Infected emails are rejected, unless the room is configured to remove infected files instead.
+// If the files cannot be scanned, the email is rejected temporarily, so the sender will retry later,
+// unless the files exceed clamd's size limit - ErrTooLargeToScan is returned then, because retries won't help
+func checkFiles(ctx context.Context, log *zerolog.Logger, clamav *ClamAV, bot matrixbot, eml *email.Email, roomID id.RoomID) error {
+	if clamav == nil || len(eml.Files)+len(eml.InlineFiles) == 0 {
+		return nil
+	}
+
+	files, infected, err := clamav.ScanFiles(ctx, eml.Files)
+	if err != nil {
+		log.Error().Err(err).Msg("cannot scan files")
+		return scanError(err)
+	}
+	inlines, infectedInlines, err := clamav.ScanFiles(ctx, eml.InlineFiles)
+	if err != nil {
+		log.Error().Err(err).Msg("cannot scan inline files")
+		return scanError(err)
+	}
+	infected = append(infected, infectedInlines...)
+	if len(infected) == 0 {
+		return nil
+	}
+
+	log.Info().Strs("infected", infected).Msg("infected files found")
+	if !bot.GetIFOptions(ctx, roomID).AntivirusStrip() {
+		return rejected(metrics.ReasonVirus, ErrVirus)
+	}
+	removed := slices.Concat(
+		slices.DeleteFunc(slices.Clone(eml.Files), func(file *utils.File) bool { return slices.Contains(files, file) }),
+		slices.DeleteFunc(slices.Clone(eml.InlineFiles), func(file *utils.File) bool { return slices.Contains(inlines, file) }),
+	)
+	eml.Files = files
+	eml.InlineFiles = inlines
+	eml.Infected = infected
+	// raw email is forwarded, quarantined, archived, etc. as is, so the infected parts must be removed from it as well
+	if len(eml.Raw) > 0 {
+		raw, err := email.StripFiles(eml.Raw, removed)
+		if err != nil { // the email will be composed from the clean files instead
+			log.Warn().Err(err).Msg("cannot remove infected files from raw email")
 		}
+		eml.Raw = raw
+	}
+	return nil
+}
+
+// scanError converts the antivirus error into the rejection error
+func scanError(err error) error {
+	if errors.Is(err, ErrClamAVSizeLimit) {
+		return ErrTooLargeToScan
+	}
+	return rejected(metrics.ReasonAntivirus, ErrAntivirusUnavailable)
+}
+
+// lmtpMail handles MAIL FROM of LMTP sessions.
+// The front MTA is responsible for authentication, and it may deliver bounces with null sender
+func (s *session) lmtpMail(from string) error {
+	if from != "" && !email.AddressValid(from) {
+		s.log.Debug().Str("from", from).Msg("address is invalid")
+		return ErrInvalidEmail
 	}
+	s.from = email.Address(from)
+	s.log.Debug().Str("from", from).Msg("incoming mail (LMTP)")
 	return nil
 }
 
+// options returns incoming filtering options of the recipient mailbox.
+// SRS bounces don't belong to any mailbox, so the strict server-wide options are used for them
+func (s *session) options() email.IncomingFilteringOptions {
+	options := s.bot.GetIFOptions(s.ctx, s.roomID)
+	if s.roomID == "" {
+		return &bounceOptions{options}
+	}
+	return options
+}
+
+// bounceOptions enforces RBL, DKIM and DMARC checks of SRS bounces and disables quarantine,
+// because bounces are relayed to the original sender and there is no mailbox to quarantine them in
+type bounceOptions struct {
+	email.IncomingFilteringOptions
+}
+
+func (o *bounceOptions) SpamcheckRBL() bool   { return true }
+func (o *bounceOptions) SpamcheckDKIM() bool  { return true }
+func (o *bounceOptions) SpamcheckDMARC() bool { return true }
+func (o *bounceOptions) AntivirusStrip() bool { return false }
+func (o *bounceOptions) Quarantine() bool     { return false }
+
+// allowlisted checks if the sender is in the room's or server-wide allowlist.
+// MAIL FROM can be forged, so the sender is allowlisted only if its domain is authenticated (SPF pass,
+// or DKIM pass of the domain or its parent domain), and SPF fail is never overridden
+func (s *session) allowlisted(options email.IncomingFilteringOptions, auth *authInput) bool {
+	if s.from == "" || auth.spf == spf.Fail || !utils.MatchPatterns(options.Allowlist(), s.from) {
+		return false
+	}
+	domain := strings.ToLower(utils.Hostname(s.from))
+	return slices.ContainsFunc(auth.authenticated(), func(authDomain string) bool {
+		return domain == authDomain || strings.HasSuffix(domain, "."+authDomain)
+	})
+}
+
 // validateOutgoingMail checks if the sender is allowed to send mail
 func (s *session) validateOutgoingMail(from string) error {
 	if !email.AddressValid(from) {
@@ -235,7 +689,7 @@ func (s *session) validateOutgoingMail(from string) error {
 		return ErrNoUser
 	}
 
-	roomID, ok := s.bot.GetMapping(s.ctx, utils.Mailbox(from))
+	roomID, ok := s.bot.GetMapping(s.ctx, utils.MailboxAddress(from))
 	if !ok {
 		s.log.Debug().Str("from", from).Msg("mapping not found")
 		return ErrNoUser
@@ -254,8 +708,25 @@ func (s *session) validateIncomingRcpt(to string) error {
 		return ErrNoUser
 	}
 
+	if srs.IsSRS(to) {
+		// bounces are sent with null reverse-path (RFC 5321), anything else sent to SRS address is relaying attempt
+		if s.from != "" {
+			s.log.Info().Str("from", s.from).Str("to", to).Msg("rejecting SRS address with non-null sender")
+			return ErrNoUser
+		}
+		if _, ok := s.bot.ReverseSRS(s.ctx, to); !ok {
+			s.log.Debug().Str("to", to).Msg("invalid SRS address")
+			return ErrNoUser
+		}
+		return nil
+	}
+	if s.from == "" && !s.lmtp {
+		s.log.Debug().Str("to", to).Msg("null sender is accepted for SRS addresses only")
+		return ErrNoUser
+	}
+
 	var ok bool
-	s.roomID, ok = s.bot.GetMapping(s.ctx, utils.Mailbox(to))
+	s.roomID, ok = s.bot.GetMapping(s.ctx, utils.MailboxAddress(to))
 	if !ok {
 		s.log.Debug().Str("to", to).Msg("mapping not found")
 		return ErrNoUser
@@ -290,7 +761,14 @@ func (s *session) getAddr(envelope *enmime.Envelope) net.Addr {
 	return realAddr
 }
 
-func validateIncoming(from, returnPath string, senderAddr net.Addr, log *zerolog.Logger, options email.IncomingFilteringOptions) bool {
+// validateIncoming runs the sender checks, SPF is checked using the result evaluated already.
+// Returns the metrics reason of the failed check, or empty string if all checks passed
+func validateIncoming(from, returnPath string, senderAddr net.Addr, spfResult spf.Result, log *zerolog.Logger, options email.IncomingFilteringOptions) string {
+	if options.SpamcheckSPF() && spfResult == spf.Fail {
+		log.Warn().Str("from", from).Msg("SPF check failed")
+		return metrics.ReasonSPF
+	}
+
 	var sender net.IP
 	switch netaddr := senderAddr.(type) {
 	case *net.TCPAddr:
@@ -306,12 +784,24 @@ func validateIncoming(from, returnPath string, senderAddr net.Addr, log *zerolog
 			Enforce:  true,
 			Spamlist: options.Spamlist(),
 			MX:       options.SpamcheckMX(),
-			SPF:      options.SpamcheckSPF(),
+			SPF:      false, // checked above
 			SMTP:     options.SpamcheckSMTP(),
 			From:     from,
 		},
 	}
 	v := validator.New(vcfg)
 
-	return v.Email(from, returnPath, sender)
+	err := v.EmailWithError(from, returnPath, sender)
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, validator.ErrSpamlist):
+		return metrics.ReasonSpamlist
+	case errors.Is(err, validator.ErrNoMX):
+		return metrics.ReasonMX
+	case errors.Is(err, validator.ErrSMTP):
+		return metrics.ReasonSMTP
+	default:
+		return metrics.ReasonSender
+	}
 }
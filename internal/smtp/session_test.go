@@ -5,13 +5,18 @@ import (
 	"errors"
 	"net"
 	"net/url"
+	"slices"
 	"strings"
 	"testing"
 
+	"blitiri.com.ar/go/spf"
+	"github.com/emersion/go-msgauth/dkim"
+	"github.com/emersion/go-smtp"
 	"github.com/rs/zerolog"
 	"maunium.net/go/mautrix/id"
 
 	"github.com/etkecc/postmoogle/internal/email"
+	"github.com/etkecc/postmoogle/internal/metrics"
 )
 
 // fakebot is a test stub implementing matrixbot. Every method panics by
@@ -21,16 +26,23 @@ import (
 type fakebot struct {
 	getMapping    func(context.Context, string) (id.RoomID, bool)
 	incomingEmail func(context.Context, *email.Email) error
+	getIFOptions  func(context.Context, id.RoomID) email.IncomingFilteringOptions
+	reverseSRS    func(context.Context, string) (string, bool)
+	emailRejected func(context.Context, *email.Email, error)
 }
 
 func (f *fakebot) AllowAuth(context.Context, string, string) (id.RoomID, bool) {
 	panic("AllowAuth: unexpected call")
 }
 
-func (f *fakebot) IsGreylisted(context.Context, net.Addr) bool {
+func (f *fakebot) IsGreylisted(context.Context, net.Addr, string, []string, []string) bool {
 	panic("IsGreylisted: unexpected call")
 }
 
+func (f *fakebot) IsSpamGreylisted(context.Context, net.Addr, string, []string, []string) bool {
+	panic("IsSpamGreylisted: unexpected call")
+}
+
 func (f *fakebot) IsBanned(context.Context, net.Addr) bool {
 	panic("IsBanned: unexpected call")
 }
@@ -52,10 +64,17 @@ func (f *fakebot) GetMapping(ctx context.Context, mailbox string) (id.RoomID, bo
 	panic("GetMapping: unexpected call")
 }
 
-func (f *fakebot) GetIFOptions(context.Context, id.RoomID) email.IncomingFilteringOptions {
+func (f *fakebot) GetIFOptions(ctx context.Context, roomID id.RoomID) email.IncomingFilteringOptions {
+	if f.getIFOptions != nil {
+		return f.getIFOptions(ctx, roomID)
+	}
 	panic("GetIFOptions: unexpected call")
 }
 
+func (f *fakebot) GetDNSBLOptions(context.Context) *email.DNSBLOptions {
+	panic("GetDNSBLOptions: unexpected call")
+}
+
 func (f *fakebot) IncomingEmail(ctx context.Context, eml *email.Email) error {
 	if f.incomingEmail != nil {
 		return f.incomingEmail(ctx, eml)
@@ -63,14 +82,53 @@ func (f *fakebot) IncomingEmail(ctx context.Context, eml *email.Email) error {
 	panic("IncomingEmail: unexpected call")
 }
 
-func (f *fakebot) GetDKIMprivkey(context.Context) string {
-	panic("GetDKIMprivkey: unexpected call")
+func (f *fakebot) GetDKIMKeys(context.Context, string) []email.DKIMKey {
+	panic("GetDKIMKeys: unexpected call")
 }
 
 func (f *fakebot) GetRelayConfig(context.Context, id.RoomID) *url.URL {
 	panic("GetRelayConfig: unexpected call")
 }
 
+func (f *fakebot) ReverseSRS(ctx context.Context, address string) (string, bool) {
+	if f.reverseSRS != nil {
+		return f.reverseSRS(ctx, address)
+	}
+	panic("ReverseSRS: unexpected call")
+}
+
+func (f *fakebot) EmailSent(context.Context, id.RoomID, *email.Email, error) {
+	panic("EmailSent: unexpected call")
+}
+
+func (f *fakebot) EmailRejected(ctx context.Context, eml *email.Email, err error) {
+	if f.emailRejected != nil {
+		f.emailRejected(ctx, eml, err)
+		return
+	}
+	panic("EmailRejected: unexpected call")
+}
+
+// fakeIFOptions is a test stub implementing email.IncomingFilteringOptions, all checks are disabled
+type fakeIFOptions struct {
+	antivirusStrip bool
+	quarantine     bool
+	allowlist      []string
+	spamlist       []string
+}
+
+func (o *fakeIFOptions) SpamcheckDKIM() bool                   { return false }
+func (o *fakeIFOptions) SpamcheckSMTP() bool                   { return false }
+func (o *fakeIFOptions) SpamcheckSPF() bool                    { return false }
+func (o *fakeIFOptions) SpamcheckRBL() bool                    { return false }
+func (o *fakeIFOptions) SpamcheckMX() bool                     { return false }
+func (o *fakeIFOptions) SpamcheckDMARC() bool                  { return false }
+func (o *fakeIFOptions) Spamlist() []string                    { return o.spamlist }
+func (o *fakeIFOptions) Allowlist() []string                   { return o.allowlist }
+func (o *fakeIFOptions) SpamThresholds() *email.SpamThresholds { return nil }
+func (o *fakeIFOptions) AntivirusStrip() bool                  { return o.antivirusStrip }
+func (o *fakeIFOptions) Quarantine() bool                      { return o.quarantine }
+
 // newTestSession builds a session without a live *smtp.Conn. Callers
 // must avoid Mail() code paths that dereference s.conn (invalid-format
 // branch).
@@ -89,7 +147,7 @@ func TestMailAuthenticatedLocalDomainDelegatesToOutgoingValidation(t *testing.T)
 	room := id.RoomID("!room:example.com")
 	bot := &fakebot{
 		getMapping: func(_ context.Context, mb string) (id.RoomID, bool) {
-			if mb != "alice" {
+			if mb != "alice@example.com" {
 				t.Fatalf("unexpected mailbox: %q", mb)
 			}
 			return room, true
@@ -168,3 +226,407 @@ func TestOutgoingDataLocalDomainErrorPropagated(t *testing.T) {
 		t.Fatalf("expected sentinel error, got %v", err)
 	}
 }
+
+// statusCollector records per-recipient LMTP statuses
+type statusCollector map[string]error
+
+func (c statusCollector) SetStatus(rcptTo string, err error) {
+	c[rcptTo] = err
+}
+
+func TestLMTPDataPerRecipientStatus(t *testing.T) {
+	sentinel := errors.New("delivery failed")
+	bot := &fakebot{
+		incomingEmail: func(_ context.Context, eml *email.Email) error {
+			if eml.RcptTo == "bob@example.com" {
+				return sentinel
+			}
+			return nil
+		},
+	}
+	s := newTestSession(bot, []string{"example.com"}, "")
+	s.lmtp = true
+	s.nochecks = true
+	s.from = "someone@external.org"
+	s.tos = []string{"alice@example.com", "bob@example.com"}
+
+	status := statusCollector{}
+	body := "From: someone@external.org\r\nTo: alice@example.com, bob@example.com\r\nSubject: test\r\n\r\nHello"
+	if err := s.LMTPData(strings.NewReader(body), status); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err, ok := status["alice@example.com"]; !ok || err != nil {
+		t.Fatalf("expected success for alice, got %v", err)
+	}
+	if err := status["bob@example.com"]; !errors.Is(err, sentinel) {
+		t.Fatalf("expected sentinel error for bob, got %v", err)
+	}
+}
+
+func TestLMTPDataRejectedByRecipient(t *testing.T) {
+	bot := &fakebot{
+		incomingEmail: func(context.Context, *email.Email) error {
+			return &email.RejectError{Reason: "no invoices,\n  please"}
+		},
+	}
+	s := newTestSession(bot, []string{"example.com"}, "")
+	s.lmtp = true
+	s.nochecks = true
+	s.from = "someone@external.org"
+	s.tos = []string{"bob@example.com"}
+
+	status := statusCollector{}
+	body := "From: someone@external.org\r\nTo: bob@example.com\r\nSubject: test\r\n\r\nHello"
+	if err := s.LMTPData(strings.NewReader(body), status); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var serr *smtp.SMTPError
+	if !errors.As(status["bob@example.com"], &serr) {
+		t.Fatalf("expected SMTP error, got %v", status["bob@example.com"])
+	}
+	if serr.Code != RejectCode || serr.Message != "no invoices, please" {
+		t.Fatalf("unexpected SMTP error: %d %q", serr.Code, serr.Message)
+	}
+}
+
+func TestLMTPMailLocalDomainAccepted(t *testing.T) {
+	s := newTestSession(&fakebot{}, []string{"example.com"}, "")
+	s.lmtp = true
+
+	if err := s.Mail("admin@example.com", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.Mail("", nil); err != nil {
+		t.Fatalf("null sender must be accepted, got %v", err)
+	}
+}
+
+func TestRcptRejectedNotAdded(t *testing.T) {
+	bot := &fakebot{
+		getMapping: func(context.Context, string) (id.RoomID, bool) { return "", false },
+	}
+	s := newTestSession(bot, []string{"example.com"}, "")
+	s.nochecks = true
+
+	if err := s.Rcpt("nobody@example.com", nil); !errors.Is(err, ErrNoUser) {
+		t.Fatalf("expected ErrNoUser, got %v", err)
+	}
+	if len(s.tos) != 0 {
+		t.Fatalf("rejected recipient must not be added, got %v", s.tos)
+	}
+}
+
+func TestResetKeepsAuthentication(t *testing.T) {
+	s := newTestSession(&fakebot{}, []string{"example.com"}, Outgoing)
+	s.from = "alice@example.com"
+	s.tos = []string{"bob@example.org"}
+	s.Reset()
+	if len(s.tos) != 0 || s.from != "alice@example.com" {
+		t.Fatalf("unexpected state after reset: %q %v", s.from, s.tos)
+	}
+
+	s = newTestSession(&fakebot{}, []string{"example.com"}, "")
+	s.from = "someone@external.org"
+	s.tos = []string{"alice@example.com"}
+	s.Reset()
+	if len(s.tos) != 0 || s.from != "" {
+		t.Fatalf("unexpected state after reset: %q %v", s.from, s.tos)
+	}
+}
+
+func TestSuspicious(t *testing.T) {
+	s := newTestSession(&fakebot{}, []string{"example.com"}, "")
+	if s.suspicious(&fakeIFOptions{}, "test") {
+		t.Error("email must be rejected when quarantine is disabled")
+	}
+	if len(s.quarantine) != 0 {
+		t.Error("reason must not be recorded when quarantine is disabled, got", s.quarantine)
+	}
+
+	options := &fakeIFOptions{quarantine: true}
+	if !s.suspicious(options, "test") || !s.suspicious(options, "test") || !s.suspicious(options, "other") {
+		t.Error("email must be quarantined when quarantine is enabled")
+	}
+	if expected := []string{"test", "other"}; !slices.Equal(expected, s.quarantine) {
+		t.Error(expected, "!=", s.quarantine)
+	}
+
+	s.Reset()
+	if len(s.quarantine) != 0 {
+		t.Error("reasons must be cleared on reset, got", s.quarantine)
+	}
+}
+
+func TestSRSBounce(t *testing.T) {
+	var delivered []string
+	bot := &fakebot{
+		reverseSRS: func(_ context.Context, address string) (string, bool) {
+			return "alice@example.org", strings.HasPrefix(address, "SRS0=VALID")
+		},
+		incomingEmail: func(_ context.Context, eml *email.Email) error {
+			delivered = append(delivered, eml.RcptTo)
+			return nil
+		},
+		getIFOptions: func(context.Context, id.RoomID) email.IncomingFilteringOptions { return &fakeIFOptions{} },
+	}
+	s := newTestSession(bot, []string{"example.com"}, "")
+	s.nochecks = true // checks need a live connection, see TestBounceOptions
+
+	// SRS address with non-null sender is a relaying attempt
+	if err := s.Mail("spammer@example.org", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.Rcpt("SRS0=VALID=AA=example.org=alice@example.com", nil); !errors.Is(err, ErrNoUser) {
+		t.Fatalf("expected ErrNoUser for non-null sender to SRS address, got %v", err)
+	}
+	s.Reset()
+
+	if err := s.Mail("", nil); err != nil {
+		t.Fatalf("null sender must be accepted, got %v", err)
+	}
+	if err := s.Rcpt("bob@example.com", nil); !errors.Is(err, ErrNoUser) {
+		t.Fatalf("expected ErrNoUser for null sender to a regular mailbox, got %v", err)
+	}
+	if err := s.Rcpt("SRS0=FAKE=AA=example.org=alice@example.com", nil); !errors.Is(err, ErrNoUser) {
+		t.Fatalf("expected ErrNoUser for invalid SRS address, got %v", err)
+	}
+	if err := s.Rcpt("SRS0=VALID=AA=example.org=alice@example.com", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	body := "From: MAILER-DAEMON@example.net\r\nTo: SRS0=VALID=AA=example.org=alice@example.com\r\nSubject: Undelivered Mail\r\n\r\nBounce"
+	if err := s.Data(strings.NewReader(body)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(delivered, []string{"SRS0=VALID=AA=example.org=alice@example.com"}) {
+		t.Fatalf("unexpected deliveries: %v", delivered)
+	}
+}
+
+func TestBounceOptions(t *testing.T) {
+	bot := &fakebot{
+		getIFOptions: func(context.Context, id.RoomID) email.IncomingFilteringOptions {
+			return &fakeIFOptions{antivirusStrip: true, quarantine: true}
+		},
+	}
+	s := newTestSession(bot, []string{"example.com"}, "")
+
+	options := s.options()
+	if !options.SpamcheckRBL() || !options.SpamcheckDKIM() || !options.SpamcheckDMARC() {
+		t.Error("RBL, DKIM, and DMARC checks must be enforced for bounces")
+	}
+	if options.Quarantine() || options.AntivirusStrip() {
+		t.Error("bounces must be rejected instead of quarantined or stripped")
+	}
+
+	s.roomID = "!room:example.com"
+	options = s.options()
+	if options.SpamcheckRBL() || !options.Quarantine() {
+		t.Error("mailbox options must be used as is")
+	}
+}
+
+func TestNotifyRejected(t *testing.T) {
+	var rejected []string
+	bot := &fakebot{
+		emailRejected: func(_ context.Context, eml *email.Email, err error) {
+			if !errors.Is(err, ErrSpam) || eml.Subject != "test" {
+				t.Fatalf("unexpected rejection: %+v %v", eml, err)
+			}
+			rejected = append(rejected, eml.RcptTo)
+		},
+	}
+	s := newTestSession(bot, []string{"example.com"}, "")
+	s.tos = []string{"alice@example.com", "SRS0=VALID=AA=example.org=alice@example.com", "bob@example.com"}
+	eml := &email.Email{Subject: "test", RcptTo: "alice@example.com"}
+
+	s.notifyRejected(eml, ErrSpam)
+	if !slices.Equal(rejected, []string{"alice@example.com", "bob@example.com"}) {
+		t.Fatalf("unexpected rejections: %v", rejected)
+	}
+	if eml.RcptTo != "alice@example.com" {
+		t.Fatalf("original email must not be modified, got %q", eml.RcptTo)
+	}
+
+	// temporary rejections and unparsed emails are not reported
+	s.notifyRejected(eml, ErrGreylisted)
+	s.notifyRejected(eml, ErrAntivirusUnavailable)
+	s.notifyRejected(nil, ErrSpam)
+	if len(rejected) != 2 {
+		t.Fatalf("unexpected rejections: %v", rejected)
+	}
+}
+
+// strictIFOptions enables SPF and DMARC checks on top of the fakeIFOptions
+type strictIFOptions struct {
+	fakeIFOptions
+}
+
+func (o *strictIFOptions) SpamcheckSPF() bool   { return true }
+func (o *strictIFOptions) SpamcheckDMARC() bool { return true }
+
+func TestEnforceDMARC(t *testing.T) {
+	tests := map[string]struct {
+		options    email.IncomingFilteringOptions
+		auth       *email.AuthResults
+		err        error
+		quarantine bool
+	}{
+		"pass":                   {&strictIFOptions{}, &email.AuthResults{DMARC: "pass", Policy: "reject"}, nil, false},
+		"disabled":               {&fakeIFOptions{quarantine: true}, &email.AuthResults{DMARC: "fail", Policy: "reject"}, nil, false},
+		"reject":                 {&strictIFOptions{}, &email.AuthResults{DMARC: "fail", Policy: "reject"}, ErrDMARC, false},
+		"reject quarantined":     {&strictIFOptions{fakeIFOptions{quarantine: true}}, &email.AuthResults{DMARC: "fail", Policy: "reject"}, nil, true},
+		"quarantine":             {&strictIFOptions{}, &email.AuthResults{DMARC: "fail", Policy: "quarantine"}, nil, false},
+		"quarantine quarantined": {&strictIFOptions{fakeIFOptions{quarantine: true}}, &email.AuthResults{DMARC: "fail", Policy: "quarantine"}, nil, true},
+		"none":                   {&strictIFOptions{fakeIFOptions{quarantine: true}}, &email.AuthResults{DMARC: "fail", Policy: "none"}, nil, false},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := newTestSession(&fakebot{}, []string{"example.com"}, "")
+			if err := s.enforceDMARC(test.options, test.auth); !errors.Is(err, test.err) {
+				t.Error(test.err, "!=", err)
+			}
+			if quarantined := len(s.quarantine) > 0; quarantined != test.quarantine {
+				t.Error("quarantined:", test.quarantine, "!=", quarantined)
+			}
+		})
+	}
+}
+
+func TestValidateIncoming(t *testing.T) {
+	log := zerolog.Nop()
+	addr := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 25}
+	tests := map[string]struct {
+		from     string
+		spf      spf.Result
+		options  email.IncomingFilteringOptions
+		expected string
+	}{
+		"spf disabled":   {"alice@example.org", spf.Fail, &fakeIFOptions{}, ""},
+		"spf fail":       {"alice@example.org", spf.Fail, &strictIFOptions{}, metrics.ReasonSPF},
+		"spf softfail":   {"alice@example.org", spf.SoftFail, &strictIFOptions{}, ""},
+		"spamlist":       {"spammer@example.org", spf.None, &fakeIFOptions{spamlist: []string{"spammer@*"}}, metrics.ReasonSpamlist},
+		"invalid sender": {"alice", spf.None, &fakeIFOptions{}, metrics.ReasonSender},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			reason := validateIncoming(test.from, "", addr, test.spf, &log, test.options)
+			if reason != test.expected {
+				t.Error(test.expected, "!=", reason)
+			}
+		})
+	}
+}
+
+func TestAllowlisted(t *testing.T) {
+	signed := func(domain string) []*dkim.Verification { return []*dkim.Verification{{Domain: domain}} }
+	tests := map[string]struct {
+		from     string
+		auth     *authInput
+		expected bool
+	}{
+		"spf pass":           {"friend@partner.org", &authInput{mailFrom: "friend@partner.org", spf: spf.Pass}, true},
+		"dkim pass":          {"friend@partner.org", &authInput{mailFrom: "friend@partner.org", spf: spf.None, dkim: signed("partner.org")}, true},
+		"dkim parent domain": {"friend@mail.partner.org", &authInput{mailFrom: "friend@mail.partner.org", spf: spf.None, dkim: signed("partner.org")}, true},
+		"not allowlisted":    {"friend@example.com", &authInput{mailFrom: "friend@example.com", spf: spf.Pass}, false},
+		"unauthenticated":    {"friend@partner.org", &authInput{mailFrom: "friend@partner.org", spf: spf.SoftFail}, false},
+		"dkim other domain":  {"friend@partner.org", &authInput{mailFrom: "friend@partner.org", spf: spf.None, dkim: signed("attacker.com")}, false},
+		"dkim failed":        {"friend@partner.org", &authInput{mailFrom: "friend@partner.org", spf: spf.None, dkim: []*dkim.Verification{{Domain: "partner.org", Err: errors.New("bad signature")}}}, false},
+		"spf fail":           {"friend@partner.org", &authInput{mailFrom: "friend@partner.org", spf: spf.Fail, dkim: signed("partner.org")}, false},
+		"null sender":        {"", &authInput{spf: spf.Pass, dkim: signed("partner.org")}, false},
+	}
+	options := &fakeIFOptions{allowlist: []string{"*@partner.org", "*@mail.partner.org"}}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := newTestSession(&fakebot{}, []string{"example.com"}, Incoming)
+			s.from = test.from
+			if actual := s.allowlisted(options, test.auth); actual != test.expected {
+				t.Error(test.expected, "!=", actual)
+			}
+		})
+	}
+}
+
+func TestCheckDeferredRBL(t *testing.T) {
+	reasons := []string{"zen.spamhaus.org"}
+	tests := map[string]struct {
+		rbl         map[string]*deferredRBL
+		allowlisted bool
+		err         bool
+		quarantine  map[string][]string
+	}{
+		"not listed":  {nil, false, false, nil},
+		"allowlisted": {map[string]*deferredRBL{"a@example.com": {reasons, &fakeIFOptions{}}}, true, false, nil},
+		"rejected":    {map[string]*deferredRBL{"a@example.com": {reasons, &fakeIFOptions{}}}, false, true, nil},
+		"quarantined": {
+			map[string]*deferredRBL{"a@example.com": {reasons, &fakeIFOptions{quarantine: true}}},
+			false,
+			false,
+			map[string][]string{"a@example.com": {rblReason(reasons)}},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := newTestSession(&fakebot{}, []string{"example.com"}, Incoming)
+			s.tos = []string{"a@example.com", "b@example.com"}
+			s.rbl = test.rbl
+			err := s.checkDeferredRBL(test.allowlisted)
+			if (err != nil) != test.err {
+				t.Error(test.err, "!=", err)
+			}
+			if len(test.quarantine) != len(s.rcptQuarantine) {
+				t.Error(test.quarantine, "!=", s.rcptQuarantine)
+			}
+			for to, expected := range test.quarantine {
+				if !slices.Equal(expected, s.rcptQuarantine[to]) {
+					t.Error(expected, "!=", s.rcptQuarantine[to])
+				}
+			}
+		})
+	}
+}
+
+func TestQuarantineReasons(t *testing.T) {
+	s := newTestSession(&fakebot{}, []string{"example.com"}, Incoming)
+	s.suspicious(&fakeIFOptions{quarantine: true}, "sender checks failed")
+	if !s.suspiciousRcpt("a@example.com", &fakeIFOptions{quarantine: true}, "listed in DNS blocklists") {
+		t.Error("recipient must be quarantined when quarantine is enabled")
+	}
+	if s.suspiciousRcpt("b@example.com", &fakeIFOptions{}, "listed in DNS blocklists") {
+		t.Error("recipient must be rejected when quarantine is disabled")
+	}
+
+	tests := map[string][]string{
+		"a@example.com": {"sender checks failed", "listed in DNS blocklists"},
+		"b@example.com": {"sender checks failed"},
+		"c@example.com": {"sender checks failed"},
+	}
+	for to, expected := range tests {
+		t.Run(to, func(t *testing.T) {
+			if actual := s.quarantineReasons(to); !slices.Equal(expected, actual) {
+				t.Error(expected, "!=", actual)
+			}
+		})
+	}
+
+	s.Reset()
+	if len(s.quarantineReasons("a@example.com")) != 0 {
+		t.Error("reasons must be cleared on reset")
+	}
+}
+
+func TestNewLMTPServerNoChecks(t *testing.T) {
+	log := zerolog.Nop()
+	srv := newLMTPServer(&Config{LMTPAddr: "/tmp/lmtp.sock", Logger: &log}, &mailServer{log: &log})
+	backend, ok := srv.Backend.(*mailServer)
+	if !ok || !backend.lmtp || !backend.nochecks {
+		t.Error("LMTP server must skip the sender checks of the front MTA", backend)
+	}
+	if newLMTPServer(&Config{Logger: &log}, &mailServer{log: &log}) != nil {
+		t.Error("LMTP server must be disabled without address")
+	}
+}
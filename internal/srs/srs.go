@@ -0,0 +1,156 @@
+// Package srs implements Sender Rewriting Scheme (SRS) - rewriting of the envelope sender of forwarded emails,
+// so SPF checks pass downstream and bounces can be routed back to the original sender.
+// Addresses use the common SRS0/SRS1 format, but the hash is base32-encoded (instead of base64),
+// because local parts may be lowercased by the remote servers and must not contain '+' (subaddressing delimiter)
+package srs
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // that's what SRS uses, the hash is truncated anyway
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxAge is the maximum age of an SRS address to be accepted
+	MaxAge = 21 * 24 * time.Hour
+
+	prefix0    = "SRS0"
+	prefix1    = "SRS1"
+	separator  = "="
+	hashLength = 4
+	// timestamps are days (since epoch) modulo 1024, encoded as 2 base32 chars
+	timestampPrecision = 24 * time.Hour
+	timestampSlots     = 1024
+	base32Chars        = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+)
+
+var (
+	// ErrInvalid returned when the address is not a valid SRS address
+	ErrInvalid = errors.New("invalid SRS address")
+	// ErrHash returned when the hash of the SRS address doesn't match
+	ErrHash = errors.New("SRS hash mismatch")
+	// ErrExpired returned when the SRS address is too old
+	ErrExpired = errors.New("SRS address expired")
+)
+
+// SRS rewrites and reverses addresses
+type SRS struct {
+	secret []byte
+	now    func() time.Time
+}
+
+// New SRS with the secret
+func New(secret string) *SRS {
+	return &SRS{secret: []byte(secret), now: time.Now}
+}
+
+// IsSRS checks if the address is an SRS address (doesn't validate it)
+func IsSRS(address string) bool {
+	localpart := strings.ToUpper(address)
+	return strings.HasPrefix(localpart, prefix0+separator) || strings.HasPrefix(localpart, prefix1+separator)
+}
+
+// Forward rewrites the address to the SRS address within the domain,
+// addresses of the domain itself and empty (null sender) addresses are returned as is
+func (s *SRS) Forward(address, domain string) string {
+	localpart, host, ok := split(address)
+	if !ok || strings.EqualFold(host, domain) {
+		return address
+	}
+
+	upper := strings.ToUpper(localpart)
+	switch {
+	case strings.HasPrefix(upper, prefix0+separator):
+		// SRS0=HHHH=TT=orig.com=user@fwd1 -> SRS1=HHHH=fwd1==HHHH=TT=orig.com=user@domain
+		rest := localpart[len(prefix0):]
+		return prefix1 + separator + s.hash(host, rest) + separator + host + separator + rest + "@" + domain
+	case strings.HasPrefix(upper, prefix1+separator):
+		// SRS1=HHHH=fwd1==HHHH=TT=orig.com=user@fwd2 -> SRS1=HHHH=fwd1==HHHH=TT=orig.com=user@domain
+		parts := strings.SplitN(localpart, separator, 4)
+		if len(parts) == 4 {
+			return prefix1 + separator + s.hash(parts[2], parts[3]) + separator + parts[2] + separator + parts[3] + "@" + domain
+		}
+	}
+
+	ts := s.timestamp()
+	return prefix0 + separator + s.hash(ts, host, localpart) + separator + ts + separator + host + separator + localpart + "@" + domain
+}
+
+// Reverse decodes the SRS address to the original one (or to the SRS0 address of the previous forwarder)
+func (s *SRS) Reverse(address string) (string, error) {
+	localpart, _, ok := split(address)
+	if !ok || !IsSRS(localpart) {
+		return "", ErrInvalid
+	}
+
+	if strings.EqualFold(localpart[:len(prefix1)], prefix1) {
+		parts := strings.SplitN(localpart, separator, 4)
+		if len(parts) != 4 || parts[2] == "" || parts[3] == "" {
+			return "", ErrInvalid
+		}
+		if !s.valid(parts[1], parts[2], parts[3]) {
+			return "", ErrHash
+		}
+		return prefix0 + parts[3] + "@" + parts[2], nil
+	}
+
+	parts := strings.SplitN(localpart, separator, 5)
+	if len(parts) != 5 || parts[3] == "" || parts[4] == "" {
+		return "", ErrInvalid
+	}
+	if !s.valid(parts[1], parts[2], parts[3], parts[4]) {
+		return "", ErrHash
+	}
+	if !s.fresh(parts[2]) {
+		return "", ErrExpired
+	}
+	return parts[4] + "@" + parts[3], nil
+}
+
+// hash returns truncated base32-encoded HMAC-SHA1 of the (lowercased) values
+func (s *SRS) hash(values ...string) string {
+	mac := hmac.New(sha1.New, s.secret)
+	for _, value := range values {
+		mac.Write([]byte(strings.ToLower(value)))
+	}
+	return base32.StdEncoding.EncodeToString(mac.Sum(nil))[:hashLength]
+}
+
+// valid checks the hash in constant time, case-insensitively
+func (s *SRS) valid(hash string, values ...string) bool {
+	return hmac.Equal([]byte(strings.ToUpper(hash)), []byte(s.hash(values...)))
+}
+
+// timestamp returns the current timestamp, encoded as 2 base32 chars
+func (s *SRS) timestamp() string {
+	days := s.now().Unix() / int64(timestampPrecision.Seconds()) % timestampSlots
+	return string([]byte{base32Chars[days>>5], base32Chars[days&31]})
+}
+
+// fresh checks if the timestamp is not older than MaxAge
+func (s *SRS) fresh(ts string) bool {
+	ts = strings.ToUpper(ts)
+	if len(ts) != 2 {
+		return false
+	}
+	high, low := strings.IndexByte(base32Chars, ts[0]), strings.IndexByte(base32Chars, ts[1])
+	if high == -1 || low == -1 {
+		return false
+	}
+	then := int64(high<<5 | low)
+	today := s.now().Unix() / int64(timestampPrecision.Seconds()) % timestampSlots
+	age := (today - then + timestampSlots) % timestampSlots
+	return time.Duration(age)*timestampPrecision <= MaxAge
+}
+
+// split splits the address into local part and host
+func split(address string) (localpart, host string, ok bool) {
+	idx := strings.LastIndex(address, "@")
+	if idx <= 0 || idx == len(address)-1 {
+		return "", "", false
+	}
+	return address[:idx], address[idx+1:], true
+}
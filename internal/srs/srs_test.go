@@ -0,0 +1,123 @@
+package srs
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSRS(now time.Time) *SRS {
+	s := New("secret")
+	s.now = func() time.Time { return now }
+	return s
+}
+
+func TestForwardReverse(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	s := newTestSRS(now)
+
+	tests := map[string]struct {
+		address  string
+		domain   string
+		prefix   string
+		expected string
+	}{
+		"plain":       {"alice@example.com", "example.org", "SRS0=", "alice@example.com"},
+		"subaddress":  {"alice+news@example.com", "example.org", "SRS0=", "alice+news@example.com"},
+		"own domain":  {"bob@example.org", "example.org", "bob@example.org", ""},
+		"null sender": {"", "example.org", "", ""},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			rewritten := s.Forward(test.address, test.domain)
+			if !strings.HasPrefix(rewritten, test.prefix) {
+				t.Fatal("unexpected address", rewritten)
+			}
+			if test.expected == "" {
+				return
+			}
+			if !strings.HasSuffix(rewritten, "@"+test.domain) {
+				t.Fatal("unexpected domain", rewritten)
+			}
+			// remote servers may change the case of the local part
+			original, err := s.Reverse(strings.ToLower(rewritten))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if original != test.expected {
+				t.Error(test.expected, "!=", original)
+			}
+		})
+	}
+}
+
+func TestForwardChain(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	first := New("first")
+	first.now = func() time.Time { return now }
+	second := newTestSRS(now)
+
+	srs0 := first.Forward("alice@example.com", "fwd1.example.net")
+	srs1 := second.Forward(srs0, "example.org")
+	if !strings.HasPrefix(srs1, "SRS1=") || !strings.Contains(srs1, "=fwd1.example.net==") {
+		t.Fatal("unexpected SRS1 address", srs1)
+	}
+	again := second.Forward(srs1, "example.org")
+	if again != srs1 {
+		t.Error(srs1, "!=", again)
+	}
+
+	previous, err := second.Reverse(srs1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if previous != srs0 {
+		t.Error(srs0, "!=", previous)
+	}
+	original, err := first.Reverse(previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if original != "alice@example.com" {
+		t.Error("alice@example.com", "!=", original)
+	}
+}
+
+func TestReverseErrors(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	s := newTestSRS(now)
+	valid := s.Forward("alice@example.com", "example.org")
+	tampered := strings.Replace(valid, "=alice@", "=mallory@", 1)
+	expired := newTestSRS(now.Add(-MaxAge-48*time.Hour)).Forward("alice@example.com", "example.org")
+
+	tests := map[string]struct {
+		address  string
+		expected error
+	}{
+		"not srs":   {"alice@example.org", ErrInvalid},
+		"truncated": {"SRS0=AAAA=AA@example.org", ErrInvalid},
+		"tampered":  {tampered, ErrHash},
+		"secret":    {New("another").Forward("alice@example.com", "example.org"), ErrHash},
+		"expired":   {expired, ErrExpired},
+		"srs1":      {"SRS1=AAAA=fwd.example.net==BBBB=AA=example.com=alice@example.org", ErrHash},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := s.Reverse(test.address); !errors.Is(err, test.expected) {
+				t.Error(test.expected, "!=", err)
+			}
+		})
+	}
+}
+
+func TestIsSRS(t *testing.T) {
+	if !IsSRS("srs0=AAAA=AA=example.com=alice@example.org") || !IsSRS("SRS1=x@example.org") {
+		t.Error("SRS addresses are not detected")
+	}
+	if IsSRS("alice@example.org") || IsSRS("srs2=x@example.org") {
+		t.Error("regular address detected as SRS")
+	}
+}
@@ -26,3 +26,17 @@ func ParseSend(commandSlice []string) (to, subject, body string, err error) {
 
 	return to, subject, body, nil
 }
+
+// UnwrapCodeBlock returns the content of the markdown code block (```), or the trimmed text as is
+func UnwrapCodeBlock(text string) string {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "```") || !strings.HasSuffix(text, "```") || len(text) < 6 {
+		return text
+	}
+	text = strings.TrimSuffix(text, "```")
+	_, content, ok := strings.Cut(text, "\n") // the first line is ``` with an optional language
+	if !ok {
+		return strings.TrimSpace(strings.TrimPrefix(text, "```"))
+	}
+	return strings.TrimSpace(content)
+}
@@ -12,6 +12,16 @@ func Mailbox(email string) string {
 	return mailbox
 }
 
+// MailboxAddress returns email address without subaddress (mailbox@hostname),
+// or just the mailbox if there is no hostname
+func MailboxAddress(email string) string {
+	mailbox, _, hostname := EmailParts(email)
+	if !strings.Contains(email, "@") {
+		return mailbox
+	}
+	return mailbox + "@" + hostname
+}
+
 // Subaddress returns sub address part form email address
 func Subaddress(email string) string {
 	_, sub, _ := EmailParts(email)
@@ -52,10 +62,15 @@ func EmailParts(email string) (mailbox, sub, hostname string) {
 	return mailbox, sub, hostname
 }
 
-// EmailsList returns human-readable list of mailbox's emails for all available domains
+// EmailsList returns human-readable list of mailbox's emails for all available domains,
+// domain-scoped mailboxes (`localpart@domain`) are returned as is
 func EmailsList(mailbox, domain string) string {
+	if strings.Contains(mailbox, "@") {
+		return mailbox
+	}
 	var msg strings.Builder
 	domain = SanitizeDomain(domain)
+	domains := Domains()
 	msg.WriteString(mailbox)
 	msg.WriteString("@")
 	msg.WriteString(domain)
@@ -75,3 +90,48 @@ func EmailsList(mailbox, domain string) string {
 
 	return msg.String()
 }
+
+// MatchPatterns checks if email address matches any of the patterns with `*` wildcards.
+// Patterns with `@` are matched against the whole address, patterns without it - against the hostname
+func MatchPatterns(patterns []string, email string) bool {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return false
+	}
+	hostname := email[strings.LastIndex(email, "@")+1:]
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "" {
+			continue
+		}
+		value := email
+		if !strings.Contains(pattern, "@") {
+			value = hostname
+		}
+		if matchWildcard(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchWildcard matches value against the pattern with `*` wildcards
+func matchWildcard(pattern, value string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == value
+	}
+	if !strings.HasPrefix(value, parts[0]) {
+		return false
+	}
+	value = value[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		idx := strings.Index(value, part)
+		if idx < 0 {
+			return false
+		}
+		value = value[idx+len(part):]
+	}
+	return len(value) >= len(last) && strings.HasSuffix(value, last)
+}
@@ -42,6 +42,24 @@ func TestSubaddress(t *testing.T) {
 	}
 }
 
+func TestMailboxAddress(t *testing.T) {
+	tests := map[string]string{
+		"mailbox@example.com":     "mailbox@example.com",
+		"Mailbox+sub@Example.com": "mailbox@example.com",
+		"mailbox+sub":             "mailbox",
+		"mailbox":                 "mailbox",
+	}
+
+	for in, expected := range tests {
+		t.Run(in, func(t *testing.T) {
+			output := MailboxAddress(in)
+			if output != expected {
+				t.Error(expected, "!=", output)
+			}
+		})
+	}
+}
+
 func TestHostname(t *testing.T) {
 	tests := map[string]string{
 		"mailbox@example.com":  "example.com",
@@ -67,4 +85,35 @@ func TestEmailList(t *testing.T) {
 	if actual != expected {
 		t.Error(expected, "!=", actual)
 	}
+
+	actual = EmailsList("test@example.com", "example.org")
+	if actual != "test@example.com" {
+		t.Error("test@example.com", "!=", actual)
+	}
+}
+
+func TestMatchPatterns(t *testing.T) {
+	patterns := []string{"partner@example.com", "*@trusted.org", "news*@*.example.net", "*.bank.com", "mail.example.org"}
+	tests := map[string]bool{
+		"partner@example.com":          true,
+		"Partner@Example.com":          true,
+		"other@example.com":            false,
+		"anyone@trusted.org":           true,
+		"anyone@sub.trusted.org":       false,
+		"newsletter@lists.example.net": true,
+		"newsletter@example.net":       false,
+		"alerts@secure.bank.com":       true,
+		"alerts@bank.com":              false,
+		"someone@mail.example.org":     true,
+		"someone@example.org":          false,
+		"":                             false,
+	}
+
+	for in, expected := range tests {
+		t.Run(in, func(t *testing.T) {
+			if actual := MatchPatterns(patterns, in); actual != expected {
+				t.Error(expected, "!=", actual)
+			}
+		})
+	}
 }
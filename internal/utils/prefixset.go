@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// PrefixSet is a set of IPs and CIDR ranges with values.
+// Match does a single map lookup per distinct prefix length, so it stays fast for big sets
+type PrefixSet[V any] struct {
+	v4 prefixTable[V]
+	v6 prefixTable[V]
+}
+
+// prefixTable contains prefixes of a single address family, grouped by prefix length
+type prefixTable[V any] struct {
+	prefixes map[int]map[netip.Prefix]V
+	lengths  []int // sorted from the longest (most specific) to the shortest
+}
+
+// NewPrefixSet creates an empty set
+func NewPrefixSet[V any]() *PrefixSet[V] {
+	return &PrefixSet[V]{
+		v4: prefixTable[V]{prefixes: map[int]map[netip.Prefix]V{}},
+		v6: prefixTable[V]{prefixes: map[int]map[netip.Prefix]V{}},
+	}
+}
+
+// ParsePrefix parses IP or CIDR into a prefix, IP is converted to a single address prefix (/32 or /128)
+func ParsePrefix(str string) (netip.Prefix, error) {
+	str = strings.TrimSpace(str)
+	if !strings.Contains(str, "/") {
+		ip, err := netip.ParseAddr(str)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		ip = ip.Unmap()
+		return netip.PrefixFrom(ip, ip.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(str)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	return prefix.Masked(), nil
+}
+
+// AddrNetIP returns IP from a network address, nil if it's not an IP address
+func AddrNetIP(addr net.Addr) (netip.Addr, bool) {
+	ip, err := netip.ParseAddr(AddrIP(addr))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap(), true
+}
+
+// Add a prefix with value to the set, existing value is replaced
+func (s *PrefixSet[V]) Add(prefix netip.Prefix, value V) {
+	prefix = prefix.Masked()
+	s.table(prefix.Addr()).add(prefix, value)
+}
+
+// Len returns amount of prefixes in the set
+func (s *PrefixSet[V]) Len() int {
+	return s.v4.len() + s.v6.len()
+}
+
+// Match returns value of the most specific prefix containing the IP
+func (s *PrefixSet[V]) Match(ip netip.Addr) (value V, ok bool) {
+	return s.MatchFunc(ip, nil)
+}
+
+// MatchFunc returns value of the most specific prefix containing the IP that is accepted by the func,
+// rejected values are skipped in favor of shorter prefixes. Nil func accepts any value
+func (s *PrefixSet[V]) MatchFunc(ip netip.Addr, accept func(V) bool) (value V, ok bool) {
+	ip = ip.Unmap()
+	return s.table(ip).match(ip, accept)
+}
+
+func (s *PrefixSet[V]) table(ip netip.Addr) *prefixTable[V] {
+	if ip.Is4() {
+		return &s.v4
+	}
+	return &s.v6
+}
+
+func (t *prefixTable[V]) add(prefix netip.Prefix, value V) {
+	bits := prefix.Bits()
+	if _, ok := t.prefixes[bits]; !ok {
+		t.prefixes[bits] = map[netip.Prefix]V{}
+		t.lengths = append(t.lengths, bits)
+		slices.SortFunc(t.lengths, func(a, b int) int { return b - a })
+	}
+	t.prefixes[bits][prefix] = value
+}
+
+func (t *prefixTable[V]) len() int {
+	var size int
+	for _, prefixes := range t.prefixes {
+		size += len(prefixes)
+	}
+	return size
+}
+
+func (t *prefixTable[V]) match(ip netip.Addr, accept func(V) bool) (value V, ok bool) {
+	for _, bits := range t.lengths {
+		prefix, err := ip.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		if found, ok := t.prefixes[bits][prefix]; ok && (accept == nil || accept(found)) {
+			return found, true
+		}
+	}
+	return value, false
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestParsePrefix(t *testing.T) {
+	tests := map[string]struct {
+		input    string
+		expected string
+		err      bool
+	}{
+		"ipv4":          {"192.0.2.1", "192.0.2.1/32", false},
+		"ipv6":          {"2001:db8::1", "2001:db8::1/128", false},
+		"ipv4-mapped":   {"::ffff:192.0.2.1", "192.0.2.1/32", false},
+		"cidr":          {"192.0.2.1/24", "192.0.2.0/24", false},
+		"cidr6":         {"2001:db8::1/64", "2001:db8::/64", false},
+		"cidr mapped":   {"::ffff:192.0.2.0/120", "192.0.2.0/24", false},
+		"invalid":       {"example.com", "", true},
+		"invalid range": {"192.0.2.0/33", "", true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			prefix, err := ParsePrefix(test.input)
+			if (err != nil) != test.err {
+				t.Error("error:", test.err, "!=", err)
+			}
+			if err == nil && prefix.String() != test.expected {
+				t.Error(test.expected, "!=", prefix.String())
+			}
+		})
+	}
+}
+
+func TestPrefixSet(t *testing.T) {
+	set := NewPrefixSet[string]()
+	for _, item := range []string{"192.0.2.0/24", "192.0.2.128/25", "198.51.100.7", "2001:db8::/32", "0.0.0.0/8"} {
+		prefix, err := ParsePrefix(item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		set.Add(prefix, item)
+	}
+	if set.Len() != 5 {
+		t.Error(5, "!=", set.Len())
+	}
+
+	tests := map[string]struct {
+		ip       string
+		expected string
+		ok       bool
+	}{
+		"cidr":            {"192.0.2.1", "192.0.2.0/24", true},
+		"most specific":   {"192.0.2.200", "192.0.2.128/25", true},
+		"single ip":       {"198.51.100.7", "198.51.100.7", true},
+		"other ip":        {"198.51.100.8", "", false},
+		"ipv6":            {"2001:db8:1::1", "2001:db8::/32", true},
+		"ipv6 not listed": {"2001:db9::1", "", false},
+		"ipv4-mapped":     {"::ffff:192.0.2.1", "192.0.2.0/24", true},
+		"v6 zero prefix":  {"::1", "", false},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			value, ok := set.Match(netip.MustParseAddr(test.ip))
+			if ok != test.ok {
+				t.Error(test.ok, "!=", ok)
+			}
+			if value != test.expected {
+				t.Error(test.expected, "!=", value)
+			}
+		})
+	}
+}
+
+func TestPrefixSetMatchFunc(t *testing.T) {
+	set := NewPrefixSet[string]()
+	for _, item := range []string{"192.0.2.0/24", "192.0.2.1", "198.51.100.7"} {
+		prefix, err := ParsePrefix(item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		set.Add(prefix, item)
+	}
+	// single IPs are "expired"
+	accept := func(value string) bool { return value == "192.0.2.0/24" }
+
+	tests := map[string]struct {
+		ip       string
+		expected string
+		ok       bool
+	}{
+		"fallback to shorter prefix": {"192.0.2.1", "192.0.2.0/24", true},
+		"cidr":                       {"192.0.2.2", "192.0.2.0/24", true},
+		"rejected":                   {"198.51.100.7", "", false},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			value, ok := set.MatchFunc(netip.MustParseAddr(test.ip), accept)
+			if ok != test.ok {
+				t.Error(test.ok, "!=", ok)
+			}
+			if value != test.expected {
+				t.Error(test.expected, "!=", value)
+			}
+		})
+	}
+}
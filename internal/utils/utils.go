@@ -1,20 +1,37 @@
 package utils
 
 import (
+	"fmt"
 	"net"
 	"net/url"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
-var domains []string
+var (
+	domains   []string
+	domainsMu sync.RWMutex
+)
 
-// SetDomains for later use
+// SetDomains for later use, may be called at runtime when domains are added or removed
 func SetDomains(slice []string) {
+	domainsMu.Lock()
+	defer domainsMu.Unlock()
+
 	domains = slice
 }
 
+// Domains returns copy of the current domains list
+func Domains() []string {
+	domainsMu.RLock()
+	defer domainsMu.RUnlock()
+
+	return append([]string(nil), domains...)
+}
+
 // AddrIP returns IP from a network address
 func AddrIP(addr net.Addr) string {
 	key := addr.String()
@@ -25,8 +42,23 @@ func AddrIP(addr net.Addr) string {
 	return key
 }
 
+// AddrNetwork returns network of the IP from a network address: /24 for IPv4 and /64 for IPv6
+func AddrNetwork(addr net.Addr) string {
+	ip := net.ParseIP(AddrIP(addr))
+	if ip == nil {
+		return AddrIP(addr)
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+}
+
 // SanitizeDomain checks that input domain is available for use
 func SanitizeDomain(domain string) string {
+	domainsMu.RLock()
+	defer domainsMu.RUnlock()
+
 	domain = strings.TrimSpace(domain)
 	if domain == "" {
 		return domains[0]
@@ -97,6 +129,50 @@ func SanitizeIntString(str string) string {
 	return strconv.Itoa(Int(str))
 }
 
+// Float converts string to float
+func Float(str string) float64 {
+	if str == "" {
+		return 0
+	}
+
+	f, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return 0
+	}
+	return f
+}
+
+// SanitizeFloatString converts string to float and back to string
+func SanitizeFloatString(str string) string {
+	return strconv.FormatFloat(Float(str), 'f', -1, 64)
+}
+
+// Duration parses duration string, in addition to time.ParseDuration units, days are supported (e.g. 7d)
+func Duration(str string) (time.Duration, error) {
+	str = strings.TrimSpace(strings.ToLower(str))
+	if days, ok := strings.CutSuffix(str, "d"); ok {
+		value, err := strconv.Atoi(days)
+		if err != nil || value < 0 {
+			return 0, fmt.Errorf("invalid duration %q", str) //nolint:goerr113 // no need for a sentinel error
+		}
+		return time.Duration(value) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(str)
+}
+
+// Minutes parses amount of minutes, either a plain number or a duration string (e.g. 90, 12h, 7d)
+func Minutes(str string) (int, error) {
+	str = strings.TrimSpace(str)
+	if minutes, err := strconv.Atoi(str); err == nil {
+		return minutes, nil
+	}
+	duration, err := Duration(str)
+	if err != nil {
+		return 0, err
+	}
+	return int(duration / time.Minute), nil
+}
+
 // SliceString converts slice into comma-separated string
 func SliceString(strs []string) string {
 	res := []string{}
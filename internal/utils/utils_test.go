@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAddrNetwork(t *testing.T) {
+	tests := map[string]struct {
+		addr     net.Addr
+		expected string
+	}{
+		"ipv4":        {&net.TCPAddr{IP: net.ParseIP("192.0.2.123"), Port: 25}, "192.0.2.0/24"},
+		"ipv4-mapped": {&net.TCPAddr{IP: net.ParseIP("::ffff:192.0.2.1"), Port: 25}, "192.0.2.0/24"},
+		"ipv6":        {&net.TCPAddr{IP: net.ParseIP("2001:db8:1:2:3:4:5:6"), Port: 25}, "2001:db8:1:2::/64"},
+		"ip addr":     {&net.IPAddr{IP: net.ParseIP("198.51.100.7")}, "198.51.100.0/24"},
+		"not an ip":   {&net.UnixAddr{Name: "/run/postmoogle.sock", Net: "unix"}, "/run/postmoogle.sock"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if actual := AddrNetwork(test.addr); actual != test.expected {
+				t.Error(test.expected, "!=", actual)
+			}
+		})
+	}
+}
+
+func TestDuration(t *testing.T) {
+	tests := map[string]struct {
+		input    string
+		expected time.Duration
+		err      bool
+	}{
+		"minutes":  {"30m", 30 * time.Minute, false},
+		"hours":    {"12h", 12 * time.Hour, false},
+		"days":     {"7d", 7 * 24 * time.Hour, false},
+		"combined": {"1h30m", 90 * time.Minute, false},
+		"invalid":  {"forever", 0, true},
+		"negative": {"-1d", 0, true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			actual, err := Duration(test.input)
+			if (err != nil) != test.err {
+				t.Error("error:", test.err, "!=", err)
+			}
+			if actual != test.expected {
+				t.Error(test.expected, "!=", actual)
+			}
+		})
+	}
+}
+
+func TestMinutes(t *testing.T) {
+	tests := map[string]struct {
+		input    string
+		expected int
+		err      bool
+	}{
+		"number":  {"90", 90, false},
+		"zero":    {"0", 0, false},
+		"minutes": {"30m", 30, false},
+		"hours":   {"12h", 720, false},
+		"days":    {"7d", 10080, false},
+		"invalid": {"forever", 0, true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			actual, err := Minutes(test.input)
+			if (err != nil) != test.err {
+				t.Error("error:", test.err, "!=", err)
+			}
+			if actual != test.expected {
+				t.Error(test.expected, "!=", actual)
+			}
+		})
+	}
+}
+
+func TestUnwrapCodeBlock(t *testing.T) {
+	tests := map[string]struct {
+		input    string
+		expected string
+	}{
+		"plain":         {"  keep;  ", "keep;"},
+		"code block":    {"```\nkeep;\n```", "keep;"},
+		"with language": {"```sieve\nrequire \"fileinto\";\nfileinto \"x\";\n```", "require \"fileinto\";\nfileinto \"x\";"},
+		"single line":   {"```keep;```", "keep;"},
+		"unclosed":      {"```\nkeep;", "```\nkeep;"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if actual := UnwrapCodeBlock(test.input); actual != test.expected {
+				t.Error(test.expected, "!=", actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,422 @@
+// Package webhook delivers mail events (received, sent, queued, failed, rejected) to the mailbox webhooks,
+// signed with HMAC-SHA256 and retried with exponential backoff
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/etkecc/go-secgen"
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/etkecc/postmoogle/internal/email"
+	"github.com/etkecc/postmoogle/internal/metrics"
+)
+
+// Events
+const (
+	// EventReceived - incoming email has been delivered to the mailbox
+	EventReceived = "received"
+	// EventSent - email has been sent from the mailbox
+	EventSent = "sent"
+	// EventQueued - email from the mailbox has been queued for re-delivery
+	EventQueued = "queued"
+	// EventFailed - email from the mailbox cannot be delivered (permanently, or after the max queue retries)
+	EventFailed = "failed"
+	// EventRejected - incoming email to the mailbox has been rejected
+	EventRejected = "rejected"
+)
+
+// HTTP headers of the webhook request
+const (
+	HeaderEvent     = "X-Postmoogle-Event"
+	HeaderDelivery  = "X-Postmoogle-Delivery"
+	HeaderTimestamp = "X-Postmoogle-Timestamp"
+	HeaderSignature = "X-Postmoogle-Signature"
+)
+
+const (
+	// DefaultRetries is the max count of delivery retries
+	DefaultRetries = 5
+	// DefaultBackoff is the delay before the first retry, it's doubled on each retry
+	DefaultBackoff = 5 * time.Second
+	// DefaultTimeout of a single delivery attempt
+	DefaultTimeout = 10 * time.Second
+	// DefaultWorkers is the amount of concurrent delivery attempts, retries wait for their turn outside of the workers
+	DefaultWorkers = 10
+	// DefaultQueueSize is the max amount of queued deliveries, new ones are dropped when the queue is full
+	DefaultQueueSize = 1000
+)
+
+// Payload of the webhook
+type Payload struct {
+	// ID of the delivery, the same for all retries
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	Timestamp time.Time `json:"timestamp"`
+	Mailbox   string    `json:"mailbox"`
+	RoomID    id.RoomID `json:"room_id"`
+	// Error of failed and rejected events
+	Error string `json:"error,omitempty"`
+	Email *Email `json:"email"`
+}
+
+// Email metadata
+type Email struct {
+	MessageID  string   `json:"message_id,omitempty"`
+	InReplyTo  string   `json:"in_reply_to,omitempty"`
+	References string   `json:"references,omitempty"`
+	Date       string   `json:"date,omitempty"`
+	MailFrom   string   `json:"mail_from,omitempty"`
+	RcptTo     string   `json:"rcpt_to"`
+	From       string   `json:"from,omitempty"`
+	To         string   `json:"to,omitempty"`
+	CC         []string `json:"cc,omitempty"`
+	Subject    string   `json:"subject,omitempty"`
+	Text       string   `json:"text,omitempty"`
+	HTML       string   `json:"html,omitempty"`
+	Files      []*File  `json:"files,omitempty"`
+	Auth       *Auth    `json:"auth,omitempty"`
+	Spam       *Spam    `json:"spam,omitempty"`
+	Infected   []string `json:"infected,omitempty"`
+	Quarantine []string `json:"quarantine,omitempty"`
+	// Raw MIME message (base64), if enabled in the mailbox
+	Raw []byte `json:"raw,omitempty"`
+}
+
+// File (attachment or inline) of the email, without content
+type File struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Size   int    `json:"size"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+// Auth results of incoming email
+type Auth struct {
+	SPF    string `json:"spf"`
+	DKIM   string `json:"dkim"`
+	DMARC  string `json:"dmarc"`
+	Policy string `json:"policy,omitempty"`
+}
+
+// Spam scan result of incoming email
+type Spam struct {
+	Scanner string   `json:"scanner"`
+	Score   float64  `json:"score"`
+	Action  string   `json:"action,omitempty"`
+	Symbols []string `json:"symbols,omitempty"`
+}
+
+// NewEmail converts the email into webhook metadata, the raw MIME message is included if withRaw is set
+func NewEmail(eml *email.Email, withRaw bool) *Email {
+	metadata := &Email{
+		MessageID:  eml.MessageID,
+		InReplyTo:  eml.InReplyTo,
+		References: eml.References,
+		Date:       eml.Date,
+		MailFrom:   eml.MailFrom,
+		RcptTo:     eml.RcptTo,
+		From:       eml.From,
+		To:         eml.To,
+		CC:         eml.CC,
+		Subject:    eml.Subject,
+		Text:       eml.Text,
+		HTML:       eml.HTML,
+		Infected:   eml.Infected,
+		Quarantine: eml.Quarantine,
+	}
+	for _, file := range eml.Files {
+		metadata.Files = append(metadata.Files, &File{Name: file.Name, Type: file.Type, Size: file.Length})
+	}
+	for _, file := range eml.InlineFiles {
+		metadata.Files = append(metadata.Files, &File{Name: file.Name, Type: file.Type, Size: file.Length, Inline: true})
+	}
+	if eml.Auth != nil {
+		metadata.Auth = &Auth{SPF: eml.Auth.SPF, DKIM: eml.Auth.DKIM, DMARC: eml.Auth.DMARC, Policy: eml.Auth.Policy}
+	}
+	if eml.Spam != nil {
+		metadata.Spam = &Spam{Scanner: eml.Spam.Scanner, Score: eml.Spam.Score, Action: eml.Spam.Action, Symbols: eml.Spam.Symbols}
+	}
+	if withRaw {
+		metadata.Raw = eml.Raw
+	}
+	return metadata
+}
+
+// Sign returns signature of the request body: hex-encoded HMAC-SHA256 of the `timestamp.body` string
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of the request body
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// ErrForbiddenAddress returned when the webhook points to a loopback, private, or link-local address
+var ErrForbiddenAddress = errors.New("webhook address is not allowed")
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), not covered by netip.Addr.IsPrivate
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// AllowedIP checks if webhooks may be delivered to the IP: only public unicast addresses are allowed,
+// so webhooks can't reach internal services (e.g. rspamd, clamd, cloud metadata, or the postmoogle API)
+func AllowedIP(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
+
+// AllowedHost checks if the webhook URL host (without port) is not a forbidden IP address or localhost.
+// Hostnames are checked on delivery, after they are resolved
+func AllowedHost(host string) bool {
+	if ip, err := netip.ParseAddr(strings.Trim(host, "[]")); err == nil {
+		return AllowedIP(ip)
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	return host != "localhost" && !strings.HasSuffix(host, ".localhost")
+}
+
+// safeControl rejects connections to the forbidden addresses, it's called after the hostname is resolved,
+// so it can't be bypassed with DNS records pointing to internal addresses
+func safeControl(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !AllowedIP(ip) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, ip)
+	}
+	return nil
+}
+
+// newClient returns HTTP client that doesn't follow redirects, doesn't use proxies from env,
+// and connects only to the addresses allowed by the control func
+func newClient(control func(string, string, syscall.RawConn) error) *http.Client {
+	dialer := &net.Dialer{Timeout: DefaultTimeout, Control: control}
+	return &http.Client{
+		Timeout: DefaultTimeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: DefaultTimeout,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     time.Minute,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// delivery is a pending webhook request
+type delivery struct {
+	url    string
+	secret string
+	event  string
+	id     string
+	body   []byte
+	// attempts made already
+	attempts int
+}
+
+// Sender delivers webhooks with a bounded pool of workers
+type Sender struct {
+	log     *zerolog.Logger
+	client  *http.Client
+	retries int
+	backoff time.Duration
+
+	ctx    context.Context //nolint:containedctx // cancels pending retries on shutdown
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	// pending deliveries: queued, being delivered, or waiting for retry
+	pending sync.WaitGroup
+	mu      sync.RWMutex
+	stopped bool
+	queue   chan *delivery
+}
+
+// New webhook sender, retries and backoff are optional
+func New(log *zerolog.Logger, retries int, backoff time.Duration) *Sender {
+	if retries <= 0 {
+		retries = DefaultRetries
+	}
+	if backoff <= 0 {
+		backoff = DefaultBackoff
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Sender{
+		log:     log,
+		client:  newClient(safeControl),
+		retries: retries,
+		backoff: backoff,
+		ctx:     ctx,
+		cancel:  cancel,
+		queue:   make(chan *delivery, DefaultQueueSize),
+	}
+	s.wg.Add(DefaultWorkers)
+	for range DefaultWorkers {
+		go s.worker()
+	}
+	return s
+}
+
+// worker delivers webhooks from the queue until it's closed
+func (s *Sender) worker() {
+	defer s.wg.Done()
+	for d := range s.queue {
+		s.attempt(d)
+	}
+}
+
+// attempt makes a single delivery attempt, failed deliveries are retried with exponential backoff.
+// Retries wait outside of the workers, so slow or dead endpoints don't block deliveries to other webhooks
+func (s *Sender) attempt(d *delivery) {
+	retry, err := s.deliver(s.ctx, d.url, d.secret, d.event, d.id, d.body)
+	d.attempts++
+	switch {
+	case err == nil:
+		metrics.Webhook(metrics.WebhookDelivered)
+	case retry && d.attempts <= s.retries && s.ctx.Err() == nil:
+		s.log.Debug().Err(err).Str("url", d.url).Str("id", d.id).Int("attempt", d.attempts).Msg("webhook delivery failed, retrying")
+		go s.retry(d)
+		return
+	default:
+		s.log.Warn().Err(err).Str("url", d.url).Str("event", d.event).Str("id", d.id).Msg("cannot deliver webhook")
+		metrics.Webhook(metrics.WebhookFailed)
+	}
+	s.pending.Done()
+}
+
+// retry puts the delivery back to the queue after the backoff, doubled on each retry
+func (s *Sender) retry(d *delivery) {
+	timer := time.NewTimer(s.backoff << (d.attempts - 1))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		select {
+		case s.queue <- d:
+			return
+		case <-s.ctx.Done():
+		}
+	case <-s.ctx.Done():
+	}
+	s.log.Warn().Str("url", d.url).Str("event", d.event).Str("id", d.id).Msg("webhook sender is stopped, retry is dropped")
+	metrics.Webhook(metrics.WebhookDropped)
+	s.pending.Done()
+}
+
+// Send the payload to the webhooks in background
+func (s *Sender) Send(urls []string, secret string, payload *Payload) {
+	if len(urls) == 0 {
+		return
+	}
+	payload.ID = secgen.HexBytes(16)
+	if payload.Timestamp.IsZero() {
+		payload.Timestamp = time.Now().UTC()
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		s.log.Error().Err(err).Str("event", payload.Event).Msg("cannot marshal webhook payload")
+		return
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.stopped {
+		s.log.Warn().Str("event", payload.Event).Str("id", payload.ID).Msg("webhook sender is stopped, delivery is dropped")
+		for range urls {
+			metrics.Webhook(metrics.WebhookDropped)
+		}
+		return
+	}
+	for _, url := range urls {
+		s.pending.Add(1)
+		select {
+		case s.queue <- &delivery{url: url, secret: secret, event: payload.Event, id: payload.ID, body: body}:
+		default:
+			s.log.Warn().Str("url", url).Str("event", payload.Event).Str("id", payload.ID).Msg("webhook queue is full, delivery is dropped")
+			metrics.Webhook(metrics.WebhookDropped)
+			s.pending.Done()
+		}
+	}
+}
+
+// Stop accepting new deliveries and wait for the pending ones until the context is done,
+// after that pending retries are cancelled
+func (s *Sender) Stop(ctx context.Context) {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+	s.stopped = true
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.log.Warn().Int("queued", len(s.queue)).Msg("webhook deliveries are not finished, cancelling them")
+		s.cancel()
+		<-done
+	}
+	s.cancel()
+	// nothing is pending, so nothing can be put into the queue anymore
+	close(s.queue)
+	s.wg.Wait()
+}
+
+// deliver makes a single delivery attempt, returns true if it should be retried:
+// network errors, 408, 429 and 5xx responses are retried
+func (s *Sender) deliver(ctx context.Context, url, secret, event, deliveryID string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Postmoogle")
+	req.Header.Set(HeaderEvent, event)
+	req.Header.Set(HeaderDelivery, deliveryID)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(secret, timestamp, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return !errors.Is(err, ErrForbiddenAddress), err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("webhook responded with %s", resp.Status)
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests
+	return retry, err
+}
@@ -0,0 +1,304 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/etkecc/postmoogle/internal/email"
+	"github.com/etkecc/postmoogle/internal/utils"
+)
+
+type receiver struct {
+	calls    atomic.Int32
+	statuses []int
+	received chan *Payload
+	t        *testing.T
+}
+
+func newReceiver(t *testing.T, statuses ...int) (*receiver, *httptest.Server) {
+	t.Helper()
+	rcv := &receiver{statuses: statuses, received: make(chan *Payload, 10), t: t}
+	ts := httptest.NewServer(rcv)
+	t.Cleanup(ts.Close)
+	return rcv, ts
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	call := int(r.calls.Add(1)) - 1
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		r.t.Error(err)
+	}
+	timestamp, err := strconv.ParseInt(req.Header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		r.t.Error(err)
+	}
+	if !Verify("secret", timestamp, body, req.Header.Get(HeaderSignature)) {
+		r.t.Error("invalid signature")
+	}
+	if call < len(r.statuses) {
+		w.WriteHeader(r.statuses[call])
+		return
+	}
+	var payload *Payload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		r.t.Error(err)
+	}
+	if req.Header.Get(HeaderEvent) != payload.Event || req.Header.Get(HeaderDelivery) != payload.ID {
+		r.t.Errorf("headers do not match the payload: %v", req.Header)
+	}
+	r.received <- payload
+}
+
+// newSender returns sender that is allowed to deliver webhooks to the local test servers
+func newSender() *Sender {
+	log := zerolog.Nop()
+	sender := New(&log, 3, time.Millisecond)
+	sender.client = newClient(nil)
+	return sender
+}
+
+func TestSign(t *testing.T) {
+	signature := Sign("secret", 1700000000, []byte(`{"event":"sent"}`))
+	if !strings.HasPrefix(signature, "sha256=") || len(signature) != len("sha256=")+64 {
+		t.Errorf("unexpected signature: %s", signature)
+	}
+	if !Verify("secret", 1700000000, []byte(`{"event":"sent"}`), signature) {
+		t.Error("signature is not verified")
+	}
+	if Verify("other", 1700000000, []byte(`{"event":"sent"}`), signature) {
+		t.Error("signature is verified with another secret")
+	}
+	if Verify("secret", 1700000001, []byte(`{"event":"sent"}`), signature) {
+		t.Error("signature is verified with another timestamp")
+	}
+}
+
+func TestSend(t *testing.T) {
+	rcv, ts := newReceiver(t)
+	eml := email.New("<id@example.com>", "", "", "Subject", "from@example.com", "to@example.com", "to@example.com", "", "text", "", []*utils.File{utils.NewFile("file.txt", []byte("content"))}, nil)
+	eml.Raw = []byte("raw")
+
+	newSender().Send([]string{ts.URL}, "secret", &Payload{
+		Event:   EventReceived,
+		Mailbox: "to",
+		RoomID:  "!room:example.com",
+		Email:   NewEmail(eml, false),
+	})
+
+	select {
+	case payload := <-rcv.received:
+		if payload.ID == "" || payload.Timestamp.IsZero() {
+			t.Errorf("id and timestamp are not set: %+v", payload)
+		}
+		if payload.Event != EventReceived || payload.Mailbox != "to" || payload.RoomID != "!room:example.com" {
+			t.Errorf("unexpected payload: %+v", payload)
+		}
+		if payload.Email.MessageID != "<id@example.com>" || payload.Email.Subject != "Subject" || payload.Email.RcptTo != "to@example.com" {
+			t.Errorf("unexpected email: %+v", payload.Email)
+		}
+		if len(payload.Email.Files) != 1 || payload.Email.Files[0].Name != "file.txt" || payload.Email.Files[0].Size != 7 {
+			t.Errorf("unexpected files: %+v", payload.Email.Files)
+		}
+		if payload.Email.Raw != nil {
+			t.Error("raw MIME is included")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook is not delivered")
+	}
+}
+
+func TestNewEmailRaw(t *testing.T) {
+	eml := &email.Email{RcptTo: "to@example.com", Raw: []byte("raw")}
+	if string(NewEmail(eml, true).Raw) != "raw" {
+		t.Error("raw MIME is not included")
+	}
+}
+
+// sendAndStop sends the webhook and waits until it's delivered or failed after retries
+func sendAndStop(t *testing.T, sender *Sender, urls ...string) {
+	t.Helper()
+	sender.Send(urls, "secret", &Payload{Event: EventSent, Email: &Email{RcptTo: "to@example.com"}})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sender.Stop(ctx)
+}
+
+func TestSendRetry(t *testing.T) {
+	rcv, ts := newReceiver(t, http.StatusInternalServerError, http.StatusTooManyRequests)
+	sendAndStop(t, newSender(), ts.URL)
+	if len(rcv.received) != 1 {
+		t.Error("webhook is not delivered")
+	}
+	if calls := rcv.calls.Load(); calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+}
+
+func TestSendNoRetry(t *testing.T) {
+	rcv, ts := newReceiver(t, http.StatusBadRequest)
+	sendAndStop(t, newSender(), ts.URL)
+	if calls := rcv.calls.Load(); calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+}
+
+func TestSendMaxRetries(t *testing.T) {
+	rcv, ts := newReceiver(t, 502, 502, 502, 502, 502)
+	sendAndStop(t, newSender(), ts.URL)
+	if calls := rcv.calls.Load(); calls != 4 {
+		t.Errorf("expected 4 calls (1 + 3 retries), got %d", calls)
+	}
+}
+
+func TestSendDeadEndpoint(t *testing.T) {
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	t.Cleanup(dead.Close)
+	rcv, ts := newReceiver(t)
+	sender := newSender()
+	sender.backoff = time.Hour
+	for range DefaultWorkers * 2 {
+		sender.Send([]string{dead.URL}, "secret", &Payload{Event: EventSent, Email: &Email{RcptTo: "to@example.com"}})
+	}
+	sender.Send([]string{ts.URL}, "secret", &Payload{Event: EventSent, Email: &Email{RcptTo: "to@example.com"}})
+
+	select {
+	case <-rcv.received:
+	case <-time.After(5 * time.Second):
+		t.Error("webhook is blocked by retries of the dead endpoint")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	sender.Stop(ctx)
+}
+
+func TestAllowedIP(t *testing.T) {
+	tests := map[string]bool{
+		"93.184.215.14":        true,
+		"2606:2800:21f:cb07::": true,
+		"127.0.0.1":            false,
+		"::1":                  false,
+		"10.0.0.1":             false,
+		"172.16.0.1":           false,
+		"192.168.1.1":          false,
+		"100.64.0.1":           false,
+		"169.254.169.254":      false,
+		"fe80::1":              false,
+		"fd00::1":              false,
+		"0.0.0.0":              false,
+		"::":                   false,
+		"224.0.0.1":            false,
+		"::ffff:127.0.0.1":     false,
+		"::ffff:93.184.215.14": true,
+	}
+	for ip, expected := range tests {
+		t.Run(ip, func(t *testing.T) {
+			if allowed := AllowedIP(netip.MustParseAddr(ip)); allowed != expected {
+				t.Error(expected, "!=", allowed)
+			}
+		})
+	}
+}
+
+func TestAllowedHost(t *testing.T) {
+	tests := map[string]bool{
+		"example.com":       true,
+		"localhost":         false,
+		"LOCALHOST.":        false,
+		"api.localhost":     false,
+		"127.0.0.1":         false,
+		"[::1]":             false,
+		"169.254.169.254":   false,
+		"93.184.215.14":     true,
+		"localhost.com":     true,
+		"[2606:2800:21f::]": true,
+	}
+	for host, expected := range tests {
+		t.Run(host, func(t *testing.T) {
+			if allowed := AllowedHost(host); allowed != expected {
+				t.Error(expected, "!=", allowed)
+			}
+		})
+	}
+}
+
+func TestDeliverForbidden(t *testing.T) {
+	rcv, ts := newReceiver(t)
+	sender := newSender()
+	sender.client = newClient(safeControl)
+	retry, err := sender.deliver(context.Background(), ts.URL, "secret", EventSent, "id", []byte(`{}`))
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Fatal(ErrForbiddenAddress, "!=", err)
+	}
+	if retry {
+		t.Error("forbidden address must not be retried")
+	}
+	if calls := rcv.calls.Load(); calls != 0 {
+		t.Errorf("expected no calls, got %d", calls)
+	}
+}
+
+func TestDeliverNoRedirect(t *testing.T) {
+	rcv, ts := newReceiver(t)
+	redirect := httptest.NewServer(http.RedirectHandler(ts.URL, http.StatusTemporaryRedirect))
+	t.Cleanup(redirect.Close)
+
+	_, err := newSender().deliver(context.Background(), redirect.URL, "secret", EventSent, "id", []byte(`{}`))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if calls := rcv.calls.Load(); calls != 0 {
+		t.Errorf("redirect is followed, %d calls", calls)
+	}
+}
+
+func TestStopDrain(t *testing.T) {
+	rcv, ts := newReceiver(t)
+	sender := newSender()
+	sender.Send([]string{ts.URL, ts.URL}, "secret", &Payload{Event: EventSent, Email: &Email{RcptTo: "to@example.com"}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sender.Stop(ctx)
+	if len(rcv.received) != 2 {
+		t.Errorf("pending deliveries are not drained, received %d", len(rcv.received))
+	}
+
+	sender.Send([]string{ts.URL}, "secret", &Payload{Event: EventSent, Email: &Email{RcptTo: "to@example.com"}})
+	sender.Stop(ctx)
+	if calls := rcv.calls.Load(); calls != 2 {
+		t.Errorf("delivery is sent after stop, %d calls", calls)
+	}
+}
+
+func TestStopCancel(t *testing.T) {
+	rcv, ts := newReceiver(t, 502, 502, 502, 502, 502)
+	sender := newSender()
+	sender.backoff = time.Hour
+	sender.Send([]string{ts.URL}, "secret", &Payload{Event: EventSent, Email: &Email{RcptTo: "to@example.com"}})
+	for rcv.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	started := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	sender.Stop(ctx)
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("pending retries are not cancelled, stopped in %s", elapsed)
+	}
+}